@@ -20,6 +20,10 @@ func Assemble(instr []Instr) ([]byte, error) {
 	for _, ins := range instr {
 		body.WriteByte(ins.Op.Code)
 		switch op := ins.Op.Code; op {
+		case ops.MiscPrefix:
+			for _, imm := range ins.Immediates {
+				leb128.WriteVarUint32(body, uint32(imm.(uint8)))
+			}
 		case ops.Block, ops.Loop, ops.If:
 			leb128.WriteVarint64(body, int64(ins.Immediates[0].(wasm.BlockType)))
 		case ops.Br, ops.BrIf:
@@ -54,6 +58,9 @@ func Assemble(instr []Instr) ([]byte, error) {
 			body.Write(b[:])
 		case ops.I32Load, ops.I64Load, ops.F32Load, ops.F64Load, ops.I32Load8s, ops.I32Load8u, ops.I32Load16s, ops.I32Load16u, ops.I64Load8s, ops.I64Load8u, ops.I64Load16s, ops.I64Load16u, ops.I64Load32s, ops.I64Load32u, ops.I32Store, ops.I64Store, ops.F32Store, ops.F64Store, ops.I32Store8, ops.I32Store16, ops.I64Store8, ops.I64Store16, ops.I64Store32:
 			leb128.WriteVarUint32(body, ins.Immediates[0].(uint32))
+			if len(ins.Immediates) > 2 {
+				leb128.WriteVarUint32(body, ins.Immediates[2].(uint32))
+			}
 			leb128.WriteVarUint32(body, ins.Immediates[1].(uint32))
 		case ops.CurrentMemory, ops.GrowMemory:
 			leb128.WriteVarUint32(body, uint32(ins.Immediates[0].(uint8)))
@@ -8,10 +8,12 @@ import (
 	"bytes"
 	"io/ioutil"
 	"path/filepath"
+	"reflect"
 	"testing"
 
 	"github.com/go-interpreter/wagon/disasm"
 	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
 )
 
 var testPaths = []string{
@@ -59,3 +61,23 @@ func TestAssemble(t *testing.T) {
 		}
 	}
 }
+
+func TestAssembleMemoryIndex(t *testing.T) {
+	// i32.load of memory 1, at offset 4
+	code := []byte{ops.I32Const, 0x00, ops.I32Load, 0x42, 0x01, 0x04, ops.Drop, ops.End}
+	d, err := disasm.Disassemble(code)
+	if err != nil {
+		t.Fatalf("disassemble failed: %v", err)
+	}
+	want := []interface{}{uint32(0x42), uint32(4), uint32(1)}
+	if got := d[1].Immediates; !reflect.DeepEqual(got, want) {
+		t.Errorf("got immediates %v, want %v", got, want)
+	}
+	asm, err := disasm.Assemble(d)
+	if err != nil {
+		t.Fatalf("assemble failed: %v", err)
+	}
+	if !bytes.Equal(asm, code) {
+		t.Errorf("got code % x, want % x", asm, code)
+	}
+}
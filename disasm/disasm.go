@@ -18,6 +18,12 @@ import (
 	ops "github.com/go-interpreter/wagon/wasm/operators"
 )
 
+// MemoryIndexFlag is set in the alignment flags of the memory_immediate of
+// a load or store when it's followed by the index of the memory accessed,
+// as with the multi-memory proposal. The index is kept as the instruction's
+// third immediate, after the flags and the offset.
+const MemoryIndexFlag = 0x40
+
 // Instr describes an instruction, consisting of an operator, with its
 // appropriate immediate value(s).
 type Instr struct {
@@ -340,9 +346,10 @@ func NewDisassembly(fn wasm.Function, module *wasm.Module) (*Disassembly, error)
 				} else {
 					// TODO: This is probably temporary, fixable by adding appropriate host function resolving piece
 					foo := module.GetFunction(int(index))
-					if foo != nil {
-						sig = foo.Sig
+					if foo == nil {
+						return nil, wasm.InvalidFunctionIndexError(index)
 					}
+					sig = foo.Sig
 				}
 				top -= len(sig.ParamTypes)
 				top += len(sig.ReturnTypes)
@@ -395,7 +402,17 @@ func Disassemble(code []byte) ([]Instr, error) {
 			return nil, err
 		}
 
-		opStr, err := ops.New(op)
+		var opStr ops.Op
+		var miscCode uint32
+		if op == ops.MiscPrefix {
+			miscCode, err = leb128.ReadVarUint32(reader)
+			if err != nil {
+				return nil, err
+			}
+			opStr, err = ops.NewMisc(miscCode)
+		} else {
+			opStr, err = ops.New(op)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -404,6 +421,25 @@ func Disassemble(code []byte) ([]Instr, error) {
 		}
 
 		switch op {
+		case ops.MiscPrefix:
+			// The opcode following the prefix is kept as the first immediate
+			instr.Immediates = append(instr.Immediates, uint8(miscCode))
+			switch byte(miscCode) {
+			case ops.MemoryCopy, ops.MemoryFill:
+				// read the reserved memory indices, one for each
+				// memory accessed
+				n := 1
+				if byte(miscCode) == ops.MemoryCopy {
+					n = 2
+				}
+				for i := 0; i < n; i++ {
+					res, err := leb128.ReadVarUint32(reader)
+					if err != nil {
+						return nil, err
+					}
+					instr.Immediates = append(instr.Immediates, uint8(res))
+				}
+			}
 		case ops.Block, ops.Loop, ops.If:
 			sig, err := leb128.ReadVarint32(reader)
 			if err != nil {
@@ -488,11 +524,22 @@ func Disassemble(code []byte) ([]Instr, error) {
 			}
 			instr.Immediates = append(instr.Immediates, flags)
 
+			var memIndex uint32
+			if flags&MemoryIndexFlag != 0 {
+				memIndex, err = leb128.ReadVarUint32(reader)
+				if err != nil {
+					return nil, err
+				}
+			}
+
 			offset, err := leb128.ReadVarUint32(reader)
 			if err != nil {
 				return nil, err
 			}
 			instr.Immediates = append(instr.Immediates, offset)
+			if flags&MemoryIndexFlag != 0 {
+				instr.Immediates = append(instr.Immediates, memIndex)
+			}
 		case ops.CurrentMemory, ops.GrowMemory:
 			res, err := leb128.ReadVarUint32(reader)
 			if err != nil {
@@ -0,0 +1,133 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// ArgI32 packs an i32 argument for ExecCode.
+func ArgI32(v int32) uint64 {
+	return uint64(uint32(v))
+}
+
+// ArgI64 packs an i64 argument for ExecCode.
+func ArgI64(v int64) uint64 {
+	return uint64(v)
+}
+
+// ArgF32 packs an f32 argument for ExecCode.
+func ArgF32(v float32) uint64 {
+	return uint64(math.Float32bits(v))
+}
+
+// ArgF64 packs an f64 argument for ExecCode.
+func ArgF64(v float64) uint64 {
+	return math.Float64bits(v)
+}
+
+// WasmValue is a value of one of the WebAssembly value types, as the Go type
+// matching it: int32 or uint32 for i32, int64 or uint64 for i64, float32 for
+// f32 and float64 for f64, as returned by ExecCode.
+type WasmValue interface{}
+
+// ArgumentTypeError is returned by ExecCodeTyped when an argument's Go type
+// doesn't match the type of the function's parameter.
+type ArgumentTypeError struct {
+	Index int            // Index of the argument
+	Want  wasm.ValueType // Type of the parameter
+	Got   WasmValue      // Argument given
+}
+
+func (e ArgumentTypeError) Error() string {
+	return fmt.Sprintf("exec: argument %d is of Go type %T, not matching its %v parameter", e.Index, e.Got, e.Want)
+}
+
+// ArgumentValueError is returned by ExecCode and ExecCodeMulti, with
+// WithStrictArgs, for an argument which can't have been packed from a value
+// of its parameter's type.
+type ArgumentValueError struct {
+	Index int            // Index of the argument
+	Want  wasm.ValueType // Type of the parameter
+	Got   uint64         // Argument given
+}
+
+func (e ArgumentValueError) Error() string {
+	return fmt.Sprintf("exec: argument %d, %#x, isn't a packed value of its %v parameter", e.Index, e.Got, e.Want)
+}
+
+// WithStrictArgs makes ExecCode and ExecCodeMulti check the arguments given
+// to them could have been packed from values of their parameters' types,
+// such as with ArgI32 and ArgF32, failing with an ArgumentValueError
+// otherwise. Any 64 bits are a valid i64 or f64 value, so that only rejects
+// the arguments of i32 and f32 parameters with any of the upper 32 bits
+// set, as when passing an i64 or f64 value for them, which would otherwise
+// be cut down to the lower 32 bits unnoticed. ExecCodeTyped checks the
+// types of its arguments regardless.
+func WithStrictArgs(v bool) VMOption {
+	return func(c *config) {
+		c.StrictArgs = v
+	}
+}
+
+// checkArgs checks the packed args could be values of the given parameter
+// types, as set out for WithStrictArgs.
+func checkArgs(params []wasm.ValueType, args []uint64) error {
+	for i, arg := range args {
+		switch params[i] {
+		case wasm.ValueTypeI32, wasm.ValueTypeF32:
+			if arg>>32 != 0 {
+				return ArgumentValueError{Index: i, Want: params[i], Got: arg}
+			}
+		}
+	}
+	return nil
+}
+
+// packArg packs an argument of the given parameter type, returning false
+// when its Go type doesn't match.
+func packArg(typ wasm.ValueType, arg WasmValue) (uint64, bool) {
+	switch v := arg.(type) {
+	case int32:
+		return ArgI32(v), typ == wasm.ValueTypeI32
+	case uint32:
+		return uint64(v), typ == wasm.ValueTypeI32
+	case int64:
+		return ArgI64(v), typ == wasm.ValueTypeI64
+	case uint64:
+		return v, typ == wasm.ValueTypeI64
+	case float32:
+		return ArgF32(v), typ == wasm.ValueTypeF32
+	case float64:
+		return ArgF64(v), typ == wasm.ValueTypeF64
+	}
+	return 0, false
+}
+
+// ExecCodeTyped calls the function with the given index, as ExecCode does,
+// with the arguments given as the Go values matching the function's
+// parameter types, rather than packed by hand. An argument of the wrong
+// type is rejected with an ArgumentTypeError, before calling the function.
+func (vm *VM) ExecCodeTyped(fnIndex int64, args ...WasmValue) (interface{}, error) {
+	if fnIndex < 0 || fnIndex >= int64(len(vm.funcs)) {
+		return nil, InvalidFunctionIndexError(fnIndex)
+	}
+	params := vm.module.GetFunction(int(fnIndex)).Sig.ParamTypes
+	if len(params) != len(args) {
+		return nil, ErrInvalidArgumentCount
+	}
+	packed := make([]uint64, len(args))
+	for i, arg := range args {
+		v, ok := packArg(params[i], arg)
+		if !ok {
+			return nil, ArgumentTypeError{Index: i, Want: params[i], Got: arg}
+		}
+		packed[i] = v
+	}
+	return vm.ExecCode(fnIndex, packed...)
+}
@@ -0,0 +1,88 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestArgPacking(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		got  uint64
+		want uint64
+	}{
+		{"i32", ArgI32(-2), 0xfffffffe},
+		{"i64", ArgI64(-2), 0xfffffffffffffffe},
+		{"f32", ArgF32(1.5), 0x3fc00000},
+		{"f64", ArgF64(-0.5), 0xbfe0000000000000},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %#x, want %#x", tc.name, tc.got, tc.want)
+		}
+	}
+}
+
+func TestExecCodeTyped(t *testing.T) {
+	// The f64 result of (i32 + i64) * f32 / f64
+	m := buildTestModule(testFunc{
+		params:  []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI64, wasm.ValueTypeF32, wasm.ValueTypeF64},
+		results: []wasm.ValueType{wasm.ValueTypeF64},
+		code: []byte{
+			ops.GetLocal, 0x00, ops.I64ExtendSI32,
+			ops.GetLocal, 0x01, ops.I64Add,
+			ops.F64ConvertSI64,
+			ops.GetLocal, 0x02, ops.F64PromoteF32, ops.F64Mul,
+			ops.GetLocal, 0x03, ops.F64Div,
+		},
+	})
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+
+	res, err := vm.ExecCodeTyped(0, int32(-3), int64(10), float32(1.5), float64(0.25))
+	if err != nil || res != float64(42) {
+		t.Errorf("got %v and error %v, want 42", res, err)
+	}
+	if res, err := vm.ExecCode(0, ArgI32(-3), ArgI64(10), ArgF32(1.5), ArgF64(0.25)); err != nil || res != float64(42) {
+		t.Errorf("got %v and error %v from ExecCode, want 42", res, err)
+	}
+
+	_, err = vm.ExecCodeTyped(0, int32(-3), int64(10), float64(1.5), float64(0.25))
+	want := ArgumentTypeError{Index: 2, Want: wasm.ValueTypeF32, Got: float64(1.5)}
+	if err != want {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+	if _, err = vm.ExecCodeTyped(0, int32(1)); err != ErrInvalidArgumentCount {
+		t.Errorf("got error %v for too few arguments, want %v", err, ErrInvalidArgumentCount)
+	}
+}
+
+func TestStrictArgs(t *testing.T) {
+	params := []wasm.ValueType{wasm.ValueTypeI64, wasm.ValueTypeI32, wasm.ValueTypeF32}
+	m := buildTestModule(testFunc{params: params})
+	args := []uint64{ArgI64(-1), ArgI32(-1), ArgF64(1.5)} // An f64 for the f32
+	for _, strict := range []bool{false, true} {
+		vm, err := NewVM(m, WithStrictArgs(strict))
+		if err != nil {
+			t.Fatalf("could not create VM: %v", err)
+		}
+		var want error
+		if strict {
+			want = ArgumentValueError{Index: 2, Want: wasm.ValueTypeF32, Got: ArgF64(1.5)}
+		}
+		if _, err := vm.ExecCode(0, args...); err != want {
+			t.Errorf("strict %v: got error %v, want %v", strict, err, want)
+		}
+		if _, err := vm.ExecCode(0, ArgI64(-1), ArgI32(-1), ArgF32(1.5)); err != nil {
+			t.Errorf("strict %v: got error %v for packed arguments", strict, err)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrBreakpointHit is returned by ExecCode and the other methods running
+// code in the VM when execution stops at a breakpoint set with
+// SetBreakpoint. The VM is left as it was before executing the instruction
+// at the breakpoint, to be inspected with CallStack, Stack and the like,
+// and then carried on with Resume.
+var ErrBreakpointHit = errors.New("exec: breakpoint hit")
+
+// SetBreakpoint sets a breakpoint at the instruction at pc in the function
+// at index fnIndex, stopping execution right before it's executed.
+//
+// The pc is the offset of the instruction in the code the function was
+// compiled to for the interpreter, not in the module's bytecode, as the
+// compiled code has different instructions, such as jumps in place of
+// blocks, and immediates of a different size. It's the program counter
+// found in the frames returned by CallStack, passed to the function set
+// with WithOpHook, and listed by ExportCoverage, so breakpoints are best
+// worked out from one of those. An error is returned when there's no
+// instruction starting at pc.
+//
+// Instructions run as native code (see EnableAOT) don't stop at
+// breakpoints.
+func (vm *VM) SetBreakpoint(fnIndex int64, pc int64) error {
+	if fnIndex < 0 || fnIndex >= int64(len(vm.funcs)) {
+		return InvalidFunctionIndexError(fnIndex)
+	}
+	if err := vm.compileLazy(fnIndex); err != nil {
+		return err
+	}
+	compiled, ok := vm.funcs[fnIndex].(compiledFunction)
+	if !ok {
+		return fmt.Errorf("exec: function at index %d is a host function", fnIndex)
+	}
+	found := false
+	for _, instr := range compiled.codeMeta.Instructions {
+		if int64(instr.Start) == pc {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("exec: no instruction at %d in the compiled code of function %d", pc, fnIndex)
+	}
+
+	if vm.breakpoints == nil {
+		vm.breakpoints = make(map[Frame]bool)
+	}
+	vm.breakpoints[Frame{FuncIndex: fnIndex, PC: pc}] = true
+	return nil
+}
+
+// ClearBreakpoint removes the breakpoint set at pc in the function at index
+// fnIndex, if any.
+func (vm *VM) ClearBreakpoint(fnIndex int64, pc int64) {
+	delete(vm.breakpoints, Frame{FuncIndex: fnIndex, PC: pc})
+	if len(vm.breakpoints) == 0 {
+		vm.breakpoints = nil
+	}
+}
+
+// atBreakpoint returns whether execution stops at a breakpoint before the
+// instruction at the program counter.
+func (vm *VM) atBreakpoint() bool {
+	if vm.resuming {
+		// Carry on past the breakpoint execution stopped at
+		vm.resuming = false
+		return false
+	}
+	return vm.breakpoints[Frame{FuncIndex: vm.ctx.curFunc, PC: vm.ctx.pc}]
+}
+
+// Resume carries on executing the function stopped at a breakpoint, until
+// it returns or the next breakpoint is hit, returning as ExecCode does.
+func (vm *VM) Resume() (rtrn interface{}, err error) {
+	defer vm.beginRun()()
+	if !vm.breakpointHit {
+		return nil, errors.New("exec: not stopped at a breakpoint")
+	}
+	if vm.RecoverPanic {
+		defer vm.recoverPanic(&err)
+	}
+	if vm.timeout > 0 {
+		defer vm.startTimeout()()
+	}
+	vm.breakpointHit = false
+	vm.resuming = true
+
+	var res uint64
+	var compiled compiledFunction
+	for {
+		compiled = vm.funcs[vm.ctx.curFunc].(compiledFunction)
+		res = vm.execCode(compiled)
+		if vm.abort || len(vm.callers) == 0 {
+			break
+		}
+
+		// Return to the caller, as compiledFunction.call does
+		vm.ctx = vm.callers[len(vm.callers)-1]
+		vm.callers = vm.callers[:len(vm.callers)-1]
+		if compiled.returns {
+			vm.pushUint64(res)
+		}
+	}
+	if err = vm.finishRun(); err != nil {
+		return nil, err
+	}
+
+	if compiled.returns {
+		rtrnType := vm.module.GetFunction(int(vm.ctx.curFunc)).Sig.ReturnTypes[0]
+		return returnValue(rtrnType, res)
+	}
+	return nil, nil
+}
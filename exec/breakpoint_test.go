@@ -0,0 +1,76 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestBreakpoint(t *testing.T) {
+	m := buildTestModule(
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code:    []byte{ops.I32Const, 0x02, ops.Call, 0x01, ops.I32Add},
+		},
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code:    []byte{ops.I32Const, 0x03, ops.I32Const, 0x04, ops.I32Mul},
+		},
+	)
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	// In the compiled code, i32.const and call both take 5 bytes, with
+	// their 4 byte immediates, so the last instructions are at 10
+	if err = vm.SetBreakpoint(1, 10); err != nil {
+		t.Fatalf("SetBreakpoint(1, 10): unexpected error: %v", err)
+	}
+	if err = vm.SetBreakpoint(0, 10); err != nil {
+		t.Fatalf("SetBreakpoint(0, 10): unexpected error: %v", err)
+	}
+	if err = vm.SetBreakpoint(0, 3); err == nil {
+		t.Error("SetBreakpoint(0, 3): expected an error in the middle of an instruction")
+	}
+
+	checkHit := func(err error, frames []Frame, stack []uint64) {
+		t.Helper()
+		if err != ErrBreakpointHit {
+			t.Fatalf("got error %v, want %v", err, ErrBreakpointHit)
+		}
+		if got := vm.CallStack(); !reflect.DeepEqual(got, frames) {
+			t.Errorf("stopped at %v, want %v", got, frames)
+		}
+		if got := vm.Stack(); !reflect.DeepEqual(got, stack) {
+			t.Errorf("got stack %v at the breakpoint, want %v", got, stack)
+		}
+	}
+	_, err = vm.ExecCode(0)
+	checkHit(err, []Frame{{FuncIndex: 0, PC: 10}, {FuncIndex: 1, PC: 10, SP: 1}}, []uint64{3, 4})
+	_, err = vm.Resume()
+	checkHit(err, []Frame{{FuncIndex: 0, PC: 10}}, []uint64{2, 12})
+
+	res, err := vm.Resume()
+	if err != nil {
+		t.Fatalf("Resume: unexpected error: %v", err)
+	}
+	if res != uint32(14) {
+		t.Errorf("got result %v, want 14", res)
+	}
+	if _, err = vm.Resume(); err == nil {
+		t.Error("Resume: expected an error once the function returned")
+	}
+
+	vm.ClearBreakpoint(0, 10)
+	vm.ClearBreakpoint(1, 10)
+	if res, err = vm.ExecCode(0); err != nil || res != uint32(14) {
+		t.Errorf("got %v and error %v without breakpoints, want 14", res, err)
+	}
+}
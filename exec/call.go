@@ -5,7 +5,6 @@
 package exec
 
 import (
-	"errors"
 	"strings"
 )
 
@@ -13,53 +12,105 @@ var (
 	// ErrSignatureMismatch is the error value used while trapping the VM when
 	// a signature mismatch between the table entry and the type entry is found
 	// in a call_indirect operation.
-	ErrSignatureMismatch = errors.New("exec: signature mismatch in call_indirect")
+	ErrSignatureMismatch = newTrap(TrapSignatureMismatch, "exec: signature mismatch in call_indirect")
 	// ErrUndefinedElementIndex is the error value used while trapping the VM when
 	// an invalid index to the module's table space is used as an operand to
 	// call_indirect
-	ErrUndefinedElementIndex = errors.New("exec: undefined element index")
+	ErrUndefinedElementIndex = newTrap(TrapUndefinedElementIndex, "exec: undefined element index")
+	// ErrCallLimitExceeded is the error value used while trapping the VM when
+	// a run makes more calls than allowed with the WithMaxCalls option.
+	ErrCallLimitExceeded = newTrap(TrapCallLimitExceeded, "exec: call limit exceeded")
+	// ErrCallStackExhausted is the error value used while trapping the VM when
+	// a call nests deeper than allowed with the WithMaxCallDepth option, such
+	// as with runaway recursion.
+	ErrCallStackExhausted = newTrap(TrapCallStackExhausted, "exec: call stack exhausted")
 )
 
+// defaultMaxCallDepth is how deep calls can nest when WithMaxCallDepth
+// isn't used, well short of running out of Go stack.
+const defaultMaxCallDepth = 10000
+
+// countCall counts a call made by the running code, trapping once there
+// are more than allowed.
+func (vm *VM) countCall() {
+	if vm.maxCalls == 0 {
+		return
+	}
+	vm.calls++
+	if vm.calls > vm.maxCalls {
+		panic(ErrCallLimitExceeded)
+	}
+}
+
+// checkCallDepth traps when a call would nest deeper than allowed, counting
+// the calls of the runs the current one is nested in too.
+func (vm *VM) checkCallDepth() {
+	if vm.maxCallDepth <= 0 {
+		return
+	}
+	// The calling function and the ones below it, plus the one being called
+	depth := vm.outerDepth + len(vm.callers) + 2
+	if depth > vm.maxCallDepth {
+		panic(ErrCallStackExhausted)
+	}
+}
+
 func (vm *VM) call() {
 	stackStart := vm.ctx.stack
 
 	// Fetch the number of the function to call
 	index := vm.fetchUint32()
+	if uint64(index) >= uint64(len(vm.funcs)) {
+		panic(indexTrap{kind: TrapInvalidFunctionIndex, err: InvalidFunctionIndexError(index)})
+	}
 
 	// Log the start of this operation
 	fName := vm.module.FunctionIndexSpace[index].Name
-	opFields := []string{"program_counter", "function_id", "function_name", "stack_start"}
-	opData := []interface{}{vm.ctx.pc, index, fName, stackStart}
-	if strings.HasPrefix(fName, "syscall/js") {
-		opFields = append(opFields, "mem_image")
-		opData = append(opData, vm.memory)
+	if vm.logger != nil {
+		opFields := []string{"program_counter", "function_id", "function_name", "stack_start"}
+		opData := logData(vm.ctx.pc, index, fName, stackStart)
+		if strings.HasPrefix(fName, "syscall/js") {
+			opFields = append(opFields, "mem_image")
+			opData = append(opData, vm.memory)
+		}
+		opLog(vm, 0x10, "Call function start", opFields, opData)
 	}
-	opLog(vm, 0x10, "Call function start", opFields, opData)
 
 	// Do the call
+	vm.countCall()
+	vm.checkCallDepth()
+	vm.traceEnter(int64(index))
 	vm.funcs[index].call(vm, int64(index))
+	vm.traceReturn()
 
 	// Log the end of this operation
-	opFields = []string{"program_counter", "function_id", "function_name", "stack_finish"}
-	opData = []interface{}{vm.ctx.pc, index, fName, vm.ctx.stack}
-	if strings.HasPrefix(fName, "syscall/js") {
-		opFields = append(opFields, "mem_image")
-		opData = append(opData, vm.memory)
+	if vm.logger != nil {
+		opFields := []string{"program_counter", "function_id", "function_name", "stack_finish"}
+		opData := logData(vm.ctx.pc, index, fName, vm.ctx.stack)
+		if strings.HasPrefix(fName, "syscall/js") {
+			opFields = append(opFields, "mem_image")
+			opData = append(opData, vm.memory)
+		}
+		opLog(vm, 0x10, "Call function end", opFields, opData)
 	}
-	opLog(vm, 0x10, "Call function end", opFields, opData)
 }
 
 func (vm *VM) callIndirect() {
 	stackStart := vm.ctx.stack
 
 	index := vm.fetchUint32()
+	if vm.module.Types == nil || uint64(index) >= uint64(len(vm.module.Types.Entries)) {
+		panic(indexTrap{kind: TrapInvalidTypeIndex, err: InvalidTypeIndexError(index)})
+	}
 	fnExpect := vm.module.Types.Entries[index]
-	_ = vm.fetchUint32() // reserved (https://github.com/WebAssembly/design/blob/27ac254c854994103c24834a994be16f74f54186/BinaryEncoding.md#call-operators-described-here)
+	// The table to call through, reserved and always 0 before the multiple
+	// tables proposal (https://github.com/WebAssembly/design/blob/27ac254c854994103c24834a994be16f74f54186/BinaryEncoding.md#call-operators-described-here)
+	table := vm.fetchUint32()
 	tableIndex := vm.popUint32()
-	if int(tableIndex) >= len(vm.module.TableIndexSpace[0]) {
+	if int(table) >= len(vm.module.TableIndexSpace) || int(tableIndex) >= len(vm.module.TableIndexSpace[table]) {
 		panic(ErrUndefinedElementIndex)
 	}
-	elemIndex := vm.module.TableIndexSpace[0][tableIndex]
+	elemIndex := vm.module.TableIndexSpace[table][tableIndex]
 	fnActual := vm.module.FunctionIndexSpace[elemIndex]
 
 	if len(fnExpect.ParamTypes) != len(fnActual.Sig.ParamTypes) {
@@ -82,12 +133,20 @@ func (vm *VM) callIndirect() {
 	}
 
 	// Log the start of this operation
-	opLog(vm, 0x11, "Call indirect function start", []string{"program_counter", "function_id", "stack_start"},
-		[]interface{}{vm.ctx.pc, index, stackStart})
+	if vm.logger != nil {
+		opLog(vm, 0x11, "Call indirect function start", []string{"program_counter", "function_id", "stack_start"},
+			logData(vm.ctx.pc, index, stackStart))
+	}
 
+	vm.countCall()
+	vm.checkCallDepth()
+	vm.traceEnter(int64(elemIndex))
 	vm.funcs[elemIndex].call(vm, int64(elemIndex))
+	vm.traceReturn()
 
 	// Log the end of this operation
-	opLog(vm, 0x11, "Call indirect function end", []string{"program_counter", "function_id", "stack_finish"},
-		[]interface{}{vm.ctx.pc, index, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x11, "Call indirect function end", []string{"program_counter", "function_id", "stack_finish"},
+			logData(vm.ctx.pc, index, vm.ctx.stack))
+	}
 }
@@ -6,10 +6,12 @@ package exec
 
 import (
 	"bytes"
+	"errors"
 	"reflect"
 	"testing"
 
 	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
 )
 
 func TestHostCall(t *testing.T) {
@@ -237,3 +239,415 @@ func TestHostTerminate(t *testing.T) {
 		t.Fatalf("Terminate did not abort execution: abort=%v, pc=%#x", vm.abort, vm.ctx.pc)
 	}
 }
+
+func TestHostFail(t *testing.T) {
+	errFailed := errors.New("host function failed")
+	m := buildTestModule(
+		testFunc{
+			name:    "main",
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			// Nothing past the failing call is run
+			code: []byte{ops.Call, 0x01, ops.Unreachable},
+		},
+		testFunc{
+			name:    "fail",
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			host: func(proc *Process) int32 {
+				proc.Fail(errFailed)
+				proc.Fail(errors.New("second failure"))
+				return 0
+			},
+		},
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code:    []byte{ops.I32Const, 0x07},
+		},
+	)
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	if _, err := vm.ExecCode(0); err != errFailed {
+		t.Fatalf("got error %v, want %v", err, errFailed)
+	}
+
+	// The error is only returned by the failed run
+	if err := vm.Restart(); err != nil {
+		t.Fatalf("Restart: unexpected error: %v", err)
+	}
+	if res, err := vm.ExecCode(2); err != nil || res != uint32(7) {
+		t.Fatalf("got %v and error %v after restarting, want 7", res, err)
+	}
+}
+
+func TestCallIndirectTable(t *testing.T) {
+	caller := func(table byte) testFunc {
+		return testFunc{
+			params:  []wasm.ValueType{wasm.ValueTypeI32},
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code:    []byte{ops.GetLocal, 0x00, ops.CallIndirect, 0x00, table},
+		}
+	}
+	m := buildTestModule(
+		testFunc{results: []wasm.ValueType{wasm.ValueTypeI32}, code: []byte{ops.I32Const, 0x07}},
+		testFunc{results: []wasm.ValueType{wasm.ValueTypeI32}, code: []byte{ops.I32Const, 0x08}},
+		caller(0),
+		caller(1),
+		caller(2),
+	)
+	m.TableIndexSpace = [][]uint32{{0}, {0, 1}}
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+
+	for _, tc := range []struct {
+		name  string
+		fn    int64
+		elem  uint64
+		want  interface{}
+		error error
+	}{
+		{"first table", 2, 0, uint32(7), nil},
+		{"second table", 3, 1, uint32(8), nil},
+		{"past the second table", 3, 2, nil, ErrUndefinedElementIndex},
+		{"undefined table", 4, 0, nil, ErrUndefinedElementIndex},
+	} {
+		res, err := vm.ExecCode(tc.fn, tc.elem)
+		if trapCause(err) != tc.error {
+			t.Errorf("%s: got error %v, want %v", tc.name, err, tc.error)
+		}
+		if res != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, res, tc.want)
+		}
+	}
+}
+
+func TestCallInvalidIndex(t *testing.T) {
+	vm, err := NewVM(buildTestModule(
+		testFunc{code: []byte{ops.Call, 0x01}},
+		testFunc{},
+	))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+
+	// Corrupt the compiled call's immediate, as it wouldn't get past compiling,
+	// so it calls function 5, in a module of two functions
+	endianess.PutUint32(vm.funcs[0].(compiledFunction).code[1:], 5)
+	_, err = vm.ExecCode(0)
+	if trapCause(err) != InvalidFunctionIndexError(5) {
+		t.Fatalf("got error %v, want %v", err, InvalidFunctionIndexError(5))
+	}
+	if te, ok := err.(*TrapError); !ok || te.OpName != "call" || te.TrapKind() != TrapInvalidFunctionIndex {
+		t.Errorf("got error %v, want a trap of kind %v at call", err, TrapInvalidFunctionIndex)
+	}
+	var index InvalidFunctionIndexError
+	if !errors.As(err, &index) || index != 5 {
+		t.Errorf("got error %v, want it to wrap %v", err, InvalidFunctionIndexError(5))
+	}
+}
+
+func TestCallIndirectInvalidType(t *testing.T) {
+	m := buildTestModule(
+		testFunc{code: []byte{ops.I32Const, 0x00, ops.CallIndirect, 0x00, 0x00}},
+	)
+	m.TableIndexSpace = [][]uint32{{0}}
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+
+	// Corrupt the compiled call_indirect's type immediate, after the
+	// i32.const, so it expects type 3, in a module of one type
+	endianess.PutUint32(vm.funcs[0].(compiledFunction).code[6:], 3)
+	_, err = vm.ExecCode(0)
+	if trapCause(err) != InvalidTypeIndexError(3) {
+		t.Fatalf("got error %v, want %v", err, InvalidTypeIndexError(3))
+	}
+	if te, ok := err.(*TrapError); !ok || te.OpName != "call_indirect" || te.TrapKind() != TrapInvalidTypeIndex {
+		t.Errorf("got error %v, want a trap of kind %v at call_indirect", err, TrapInvalidTypeIndex)
+	}
+	var index InvalidTypeIndexError
+	if !errors.As(err, &index) || index != 3 {
+		t.Errorf("got error %v, want it to wrap %v", err, InvalidTypeIndexError(3))
+	}
+}
+
+func TestMaxCalls(t *testing.T) {
+	m := buildTestModule(testFunc{
+		// Calls itself n times, counting n down to 0
+		params: []wasm.ValueType{wasm.ValueTypeI32},
+		code: []byte{
+			ops.GetLocal, 0x00,
+			ops.If, 0x40, // No result
+			ops.GetLocal, 0x00,
+			ops.I32Const, 0x01,
+			ops.I32Sub,
+			ops.Call, 0x00,
+			ops.End,
+		},
+	})
+	for _, tc := range []struct {
+		max uint64
+		err error
+	}{
+		{0, nil},
+		{5, nil},
+		{4, ErrCallLimitExceeded},
+		{1, ErrCallLimitExceeded},
+	} {
+		vm, err := NewVM(m, WithMaxCalls(tc.max))
+		if err != nil {
+			t.Fatalf("could not create VM: %v", err)
+		}
+		vm.RecoverPanic = true
+		_, err = vm.ExecCode(0, 5)
+		if trapCause(err) != tc.err {
+			t.Errorf("at most %d calls: got error %v, want %v", tc.max, err, tc.err)
+		}
+		if te, ok := err.(*TrapError); tc.err != nil && (!ok || te.TrapKind() != TrapCallLimitExceeded) {
+			t.Errorf("at most %d calls: got error %v, want a trap of kind %v", tc.max, err, TrapCallLimitExceeded)
+		}
+
+		// The calls are counted for each run
+		if tc.err == nil {
+			if _, err = vm.ExecCode(0, 5); err != nil {
+				t.Errorf("at most %d calls: unexpected error running again: %v", tc.max, err)
+			}
+		}
+	}
+}
+
+func TestUnboundImport(t *testing.T) {
+	m := buildTestModule(
+		testFunc{
+			params:  []wasm.ValueType{wasm.ValueTypeI32},
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			host:    (func(*Process, int32) int32)(nil),
+		},
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code:    []byte{ops.I32Const, 0x01, ops.Call, 0x00},
+		},
+	)
+	m.Import = &wasm.SectionImports{Entries: []wasm.ImportEntry{
+		{ModuleName: "env", FieldName: "double", Type: wasm.FuncImport{Type: 0}},
+	}}
+	want := ErrUnboundImport{Module: "env", Field: "double"}
+
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	_, err = vm.ExecCode(1)
+	if te, ok := err.(*TrapError); !ok || te.Err != want || te.TrapKind() != TrapUnboundImport {
+		t.Errorf("got error %v calling the import, want a trap with %v", err, want)
+	}
+
+	if _, err = NewVM(m, WithStrictImports(true)); err != want {
+		t.Errorf("got error %v with WithStrictImports, want %v", err, want)
+	}
+}
+
+func TestNestedExecCode(t *testing.T) {
+	var vm *VM
+	m := buildTestModule(
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code: []byte{
+				ops.I32Const, 0x32, // 50, left on the stack across the host call
+				ops.I32Const, 0x0a,
+				ops.Call, 0x01,
+				ops.I32Add,
+			},
+		},
+		testFunc{
+			params:  []wasm.ValueType{wasm.ValueTypeI32},
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			host: func(proc *Process, v int32) int32 {
+				res, err := vm.ExecCode(2, uint64(v))
+				if err != nil {
+					t.Errorf("error executing the nested function: %v", err)
+					return 0
+				}
+				return int32(res.(uint32))
+			},
+		},
+		testFunc{
+			params:  []wasm.ValueType{wasm.ValueTypeI32},
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code: []byte{
+				ops.I32Const, 0x07,
+				ops.I32Const, 0x07,
+				ops.I32Mul,
+				ops.Drop,
+				ops.GetLocal, 0x00,
+				ops.I32Const, 0x03,
+				ops.I32Mul,
+			},
+		},
+	)
+	var err error
+	vm, err = NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+
+	res, err := vm.ExecCode(0)
+	if err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	// The host function returns 10*3 from the nested call
+	if res != uint32(80) {
+		t.Errorf("got result %v, want 80", res)
+	}
+}
+
+// callHeavyModule returns a module whose first function takes a count of
+// iterations, each making nested calls.
+func callHeavyModule() *wasm.Module {
+	i32 := []wasm.ValueType{wasm.ValueTypeI32}
+	return buildTestModule(
+		testFunc{
+			params: i32,
+			code: []byte{
+				ops.Loop, 0x40,
+				ops.GetLocal, 0x00,
+				ops.Call, 0x01,
+				ops.Drop,
+				ops.GetLocal, 0x00,
+				ops.I32Const, 0x01,
+				ops.I32Sub,
+				ops.TeeLocal, 0x00,
+				ops.BrIf, 0x00,
+				ops.End,
+			},
+		},
+		testFunc{
+			params:  i32,
+			results: i32,
+			code:    []byte{ops.GetLocal, 0x00, ops.Call, 0x02, ops.Call, 0x02},
+		},
+		testFunc{
+			params:  i32,
+			results: i32,
+			code:    []byte{ops.GetLocal, 0x00, ops.I32Const, 0x01, ops.I32Add},
+		},
+	)
+}
+
+func TestStackCapacity(t *testing.T) {
+	// Room for the loop and a call, but not the nested one
+	for _, n := range []int{1, 4, 1024} {
+		vm, err := NewVM(callHeavyModule(), WithStackCapacity(n))
+		if err != nil {
+			t.Fatalf("could not create VM: %v", err)
+		}
+		if _, err = vm.ExecCode(0, 10); err != nil {
+			t.Fatalf("capacity %d: error executing function: %v", n, err)
+		}
+		if res, err := vm.ExecCode(1, 5); err != nil || res != uint32(7) {
+			t.Errorf("capacity %d: got %v and error %v, want 7", n, res, err)
+		}
+	}
+}
+
+func TestStackGrowth(t *testing.T) {
+	i32 := []wasm.ValueType{wasm.ValueTypeI32}
+	// Adds 50 to the sum of eight ones, pushed before adding them
+	sum := []byte{}
+	for i := 0; i < 8; i++ {
+		sum = append(sum, ops.I32Const, 0x01)
+	}
+	for i := 0; i < 7; i++ {
+		sum = append(sum, ops.I32Add)
+	}
+	m := buildTestModule(
+		testFunc{results: i32, code: []byte{ops.I32Const, 0x32, ops.Call, 0x01, ops.I32Add}},
+		testFunc{results: i32, code: sum},
+	)
+
+	for _, tc := range []struct {
+		name string
+		opts []VMOption
+	}{
+		{"default", nil},
+		{"preallocated", []VMOption{WithStackCapacity(64)}},
+	} {
+		vm, err := NewVM(m, tc.opts...)
+		if err != nil {
+			t.Fatalf("could not create VM: %v", err)
+		}
+		vm.RecoverPanic = true
+		// Underestimate the depths, so the stacks have to grow
+		for i, fn := range vm.funcs {
+			fn := fn.(compiledFunction)
+			fn.maxDepth = 0
+			vm.funcs[i] = fn
+		}
+		if res, err := vm.ExecCode(0); err != nil || res != uint32(58) {
+			t.Errorf("%s: got %v and error %v, want 58", tc.name, res, err)
+		}
+	}
+}
+
+func BenchmarkStackCapacity(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		opts []VMOption
+	}{
+		{"default", nil},
+		{"preallocated", []VMOption{WithStackCapacity(64)}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			vm, err := NewVM(callHeavyModule(), bench.opts...)
+			if err != nil {
+				b.Fatalf("could not create VM: %v", err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err = vm.ExecCode(0, 100); err != nil {
+					b.Fatalf("error executing function: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestMaxCallDepth(t *testing.T) {
+	// Calls itself until the depth runs out
+	recurse := testFunc{code: []byte{ops.Call, 0x00}}
+
+	for _, tc := range []struct {
+		name  string
+		opts  []VMOption
+		depth int
+	}{
+		{"default", nil, defaultMaxCallDepth},
+		{"capped", []VMOption{WithMaxCallDepth(50)}, 50},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			vm, err := NewVM(buildTestModule(recurse), tc.opts...)
+			if err != nil {
+				t.Fatalf("could not create VM: %v", err)
+			}
+			vm.RecoverPanic = true
+			_, err = vm.ExecCode(0)
+			te, ok := err.(*TrapError)
+			if !ok || te.Err != ErrCallStackExhausted {
+				t.Fatalf("got error %v, want a trap with %v", err, ErrCallStackExhausted)
+			}
+			if got := len(te.Frames); got != tc.depth {
+				t.Errorf("trapped %d calls deep, want %d", got, tc.depth)
+			}
+		})
+	}
+}
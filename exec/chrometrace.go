@@ -0,0 +1,102 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// traceEvent is a single event of the Chrome Trace Event Format, as loaded
+// by chrome://tracing.
+type traceEvent struct {
+	Name string  `json:"name"`
+	Cat  string  `json:"cat"`
+	Ph   string  `json:"ph"`
+	Ts   float64 `json:"ts"` // Microseconds since the VM was created
+	Pid  int     `json:"pid"`
+	Tid  int     `json:"tid"`
+}
+
+// chromeTracer writes the calls made by the VM as Chrome trace events, one
+// begin ("B") and end ("E") pair for each call. The events are written as
+// a JSON array, which is closed by closing the VM.
+type chromeTracer struct {
+	w     *bufio.Writer
+	start time.Time
+	open  []traceEvent // Begin events of the calls not yet returned from
+	wrote bool         // Whether the array has been opened
+	err   error        // First error writing the events
+}
+
+func newChromeTracer(w io.Writer) *chromeTracer {
+	return &chromeTracer{w: bufio.NewWriter(w), start: time.Now()}
+}
+
+// begin records entering the function with the given name.
+func (t *chromeTracer) begin(name, cat string) {
+	ev := traceEvent{Name: name, Cat: cat, Ph: "B", Pid: 1, Tid: 1}
+	t.write(&ev)
+	t.open = append(t.open, ev)
+}
+
+// end records returning from the innermost function entered.
+func (t *chromeTracer) end() {
+	if len(t.open) == 0 {
+		return
+	}
+	ev := t.open[len(t.open)-1]
+	t.open = t.open[:len(t.open)-1]
+	ev.Ph = "E"
+	t.write(&ev)
+}
+
+// endTo records returning from the functions entered, until depth of them
+// are left, such as when a trap unwinds the calls, and flushes the events.
+func (t *chromeTracer) endTo(depth int) {
+	for len(t.open) > depth {
+		t.end()
+	}
+	if err := t.w.Flush(); err != nil && t.err == nil {
+		t.err = err
+	}
+}
+
+func (t *chromeTracer) write(ev *traceEvent) {
+	if t.err != nil {
+		return
+	}
+	ev.Ts = float64(time.Since(t.start).Nanoseconds()) / 1e3
+	b, err := json.Marshal(ev)
+	if err != nil {
+		t.err = err
+		return
+	}
+	sep := ",\n"
+	if !t.wrote {
+		sep, t.wrote = "[\n", true
+	}
+	if _, err = t.w.WriteString(sep); err == nil {
+		_, err = t.w.Write(b)
+	}
+	t.err = err
+}
+
+// close ends the calls still open and the JSON array, returning the first
+// error writing the trace.
+func (t *chromeTracer) close() error {
+	t.endTo(0)
+	if t.err != nil {
+		return t.err
+	}
+	if !t.wrote {
+		t.w.WriteString("[")
+	}
+	t.w.WriteString("\n]\n")
+	t.err = t.w.Flush()
+	return t.err
+}
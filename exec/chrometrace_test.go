@@ -0,0 +1,109 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestChromeTrace(t *testing.T) {
+	i32 := []wasm.ValueType{wasm.ValueTypeI32}
+	m := buildTestModule(
+		testFunc{
+			name:    "main",
+			params:  i32,
+			results: i32,
+			code: []byte{
+				ops.GetLocal, 0x00, ops.Call, 0x01,
+				ops.GetLocal, 0x00, ops.Call, 0x01,
+				ops.I32Add,
+			},
+		},
+		testFunc{
+			name:    "double",
+			params:  i32,
+			results: i32,
+			code:    []byte{ops.GetLocal, 0x00, ops.Call, 0x02, ops.I32Const, 0x02, ops.I32Mul},
+		},
+		testFunc{
+			name:    "check",
+			params:  i32,
+			results: i32,
+			host: func(proc *Process, v int32) int32 {
+				return v
+			},
+		},
+		testFunc{
+			name: "trap",
+			code: []byte{ops.I32Const, 0x01, ops.Call, 0x01, ops.Unreachable},
+		},
+	)
+
+	var buf bytes.Buffer
+	vm, err := NewVM(m, WithChromeTrace(&buf))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	if res, err := vm.ExecCode(0, 3); err != nil || res != uint32(12) {
+		t.Fatalf("got %v and error %v, want 12", res, err)
+	}
+	// The calls cut short by the trap still end
+	if _, err = vm.ExecCode(3); trapCause(err) != ErrUnreachable {
+		t.Fatalf("got error %v, want %v", err, ErrUnreachable)
+	}
+	if err = vm.Close(); err != nil {
+		t.Fatalf("Close: unexpected error: %v", err)
+	}
+
+	var events []traceEvent
+	if err = json.Unmarshal(buf.Bytes(), &events); err != nil {
+		t.Fatalf("could not decode the trace: %v\n%s", err, buf.Bytes())
+	}
+
+	// Check the begin and end events pair up, and count the calls
+	var open []string
+	calls := map[string]int{}
+	var last float64
+	for i, ev := range events {
+		if ev.Ts < last {
+			t.Errorf("event %d goes back in time", i)
+		}
+		last = ev.Ts
+		switch ev.Ph {
+		case "B":
+			open = append(open, ev.Name)
+			calls[ev.Name]++
+			wantCat := "wasm"
+			if ev.Name == "check" {
+				wantCat = "host"
+			}
+			if ev.Cat != wantCat {
+				t.Errorf("got category %q for %s, want %q", ev.Cat, ev.Name, wantCat)
+			}
+		case "E":
+			if len(open) == 0 || open[len(open)-1] != ev.Name {
+				t.Fatalf("event %d ends %s, with %v begun", i, ev.Name, open)
+			}
+			open = open[:len(open)-1]
+		default:
+			t.Errorf("event %d has phase %q", i, ev.Ph)
+		}
+	}
+	if len(open) != 0 {
+		t.Errorf("calls never ended: %v", open)
+	}
+	want := map[string]int{"main": 1, "double": 3, "check": 3, "trap": 1}
+	for name, n := range want {
+		if calls[name] != n {
+			t.Errorf("got %d calls to %s, want %d", calls[name], name, n)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+// Clone returns a new VM running the same module, which shares the VM's
+// compiled code, but has its own stack, linear memory and globals, so the
+// two can run concurrently, such as for a server handling requests in
+// parallel without compiling the module for each. The memory and globals
+// are copied, rather than shared, so the clone starts from their current
+// values, and changes made by either VM afterwards aren't seen by the other,
+// even when the memory is shared with other VMs by a Linker.
+//
+// The clone has the same options as the VM, except for those recording or
+// replaying runs, which aren't safe for concurrent use: it doesn't log
+// operations, record or replay host calls, record coverage or stack
+// high-water marks, nor trace calls. Breakpoints are copied. The op hook, if any, is shared,
+// so has to be safe for concurrent use, as do the host functions. The
+// counters set up with WithMetrics are shared too. A VM mustn't be cloned
+// while it's running.
+func (vm *VM) Clone() *VM {
+	c := *vm
+	c.ctx = context{}
+	c.callers = nil
+	c.memory = append([]byte(nil), vm.memory...)
+	c.memShare = nil
+	c.memories = copyMemories(vm.memories)
+	c.globals = append([]uint64(nil), vm.globals...)
+	// The functions compiled lazily are compiled into the clone's own table
+	c.funcs = append([]function(nil), vm.funcs...)
+	if vm.stackSlab != nil {
+		c.stackSlab, c.stackTop = make([]uint64, len(vm.stackSlab)), 0
+	}
+	// The function tables hold method values bound to the VM
+	c.newFuncTable()
+
+	if vm.breakpoints != nil {
+		c.breakpoints = make(map[Frame]bool, len(vm.breakpoints))
+		for f := range vm.breakpoints {
+			c.breakpoints[f] = true
+		}
+	}
+	c.abort, c.paused, c.stepping, c.running, c.breakpointHit, c.resuming = false, false, false, false, false, false
+	c.nested = 0
+	c.outerDepth = 0
+	c.floatFlags = 0
+	c.expired = nil
+
+	c.hostRecorder, c.hostReplay = nil, nil
+	c.hostErr = nil
+	c.pg, c.PgTx, c.logger, c.logErr = nil, nil, nil, nil
+	c.logMu, c.flushSignals, c.flushStop = nil, nil, nil
+	c.memProfile = nil
+	c.coverage, c.highWater = nil, nil
+	c.tracer, c.spans, c.spanBase, c.traceCtx = nil, nil, 0, nil
+	if vm.metrics != nil {
+		c.metrics = vm.metrics.clone()
+	}
+	return &c
+}
@@ -11,8 +11,10 @@ func (vm *VM) i32Const() {
 	vm.pushUint32(z)
 
 	stackFinish := vm.ctx.stack
-	opLog(vm, 0x41, "i32 constant", []string{"program_counter", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, z, stackStart, stackFinish})
+	if vm.logger != nil {
+		opLog(vm, 0x41, "i32 constant", valueOpFields,
+			logData(vm.ctx.pc, z, stackStart, stackFinish))
+	}
 }
 
 func (vm *VM) i64Const() {
@@ -21,8 +23,10 @@ func (vm *VM) i64Const() {
 	z := vm.fetchUint64()
 	vm.pushUint64(z)
 
-	opLog(vm, 0x42, "i64 constant", []string{"program_counter", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, z, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x42, "i64 constant", valueOpFields,
+			logData(vm.ctx.pc, z, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Const() {
@@ -31,8 +35,10 @@ func (vm *VM) f32Const() {
 	z := vm.fetchFloat32()
 	vm.pushFloat32(z)
 
-	opLog(vm, 0x43, "f32 constant", []string{"program_counter", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, z, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x43, "f32 constant", valueOpFields,
+			logData(vm.ctx.pc, z, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Const() {
@@ -41,6 +47,8 @@ func (vm *VM) f64Const() {
 	z := vm.fetchFloat64()
 	vm.pushFloat64(z)
 
-	opLog(vm, 0x44, "f64 constant", []string{"program_counter", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, z, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x44, "f64 constant", valueOpFields,
+			logData(vm.ctx.pc, z, stackStart, vm.ctx.stack))
+	}
 }
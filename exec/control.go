@@ -4,22 +4,24 @@
 
 package exec
 
-import "errors"
-
 // ErrUnreachable is the error value used while trapping the VM when
 // an unreachable operator is reached during execution.
-var ErrUnreachable = errors.New("exec: reached unreachable")
+var ErrUnreachable = newTrap(TrapUnreachable, "exec: reached unreachable")
 
 func (vm *VM) unreachable() {
 	// Log this operation
-	opLog(vm, 0x0, "Unreachable", []string{"program_counter", "stack_start"},
-		[]interface{}{vm.ctx.pc, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x0, "Unreachable", controlOpFields,
+			logData(vm.ctx.pc, vm.ctx.stack))
+	}
 
 	panic(ErrUnreachable)
 }
 
 func (vm *VM) nop() {
 	// Log this operation
-	opLog(vm, 0x1, "Nop", []string{"program_counter", "stack_start"},
-		[]interface{}{vm.ctx.pc, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x1, "Nop", controlOpFields,
+			logData(vm.ctx.pc, vm.ctx.stack))
+	}
 }
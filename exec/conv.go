@@ -17,8 +17,10 @@ func (vm *VM) i32Wrapi64() {
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0xA7, "i32 Wrap i64", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xA7, "i32 Wrap i64", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32TruncSF32() {
@@ -30,8 +32,10 @@ func (vm *VM) i32TruncSF32() {
 	vm.pushInt32(val)
 
 	// Log this operation
-	opLog(vm, 0xA8, "i32 Truncate f32 signed", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xA8, "i32 Truncate f32 signed", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32TruncUF32() {
@@ -43,8 +47,10 @@ func (vm *VM) i32TruncUF32() {
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0xA9, "i32 Truncate f32 unsigned", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xA9, "i32 Truncate f32 unsigned", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32TruncSF64() {
@@ -56,8 +62,10 @@ func (vm *VM) i32TruncSF64() {
 	vm.pushInt32(val)
 
 	// Log this operation
-	opLog(vm, 0xAA, "i32 Truncate f64 signed", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xAA, "i32 Truncate f64 signed", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32TruncUF64() {
@@ -69,8 +77,10 @@ func (vm *VM) i32TruncUF64() {
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0xAB, "i32 Truncate f64 unsigned", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xAB, "i32 Truncate f64 unsigned", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64ExtendSI32() {
@@ -82,8 +92,10 @@ func (vm *VM) i64ExtendSI32() {
 	vm.pushInt64(val)
 
 	// Log this operation
-	opLog(vm, 0xAC, "i64 Extend i32 signed", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xAC, "i64 Extend i32 signed", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64ExtendUI32() {
@@ -95,8 +107,10 @@ func (vm *VM) i64ExtendUI32() {
 	vm.pushUint64(val)
 
 	// Log this operation
-	opLog(vm, 0xAD, "i64 Extend i32 Unsigned", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xAD, "i64 Extend i32 Unsigned", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64TruncSF32() {
@@ -108,8 +122,10 @@ func (vm *VM) i64TruncSF32() {
 	vm.pushInt64(val)
 
 	// Log this operation
-	opLog(vm, 0xAE, "i64 Truncate f32 signed", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xAE, "i64 Truncate f32 signed", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64TruncUF32() {
@@ -121,8 +137,10 @@ func (vm *VM) i64TruncUF32() {
 	vm.pushUint64(val)
 
 	// Log this operation
-	opLog(vm, 0xAF, "i64 Truncate f32 unsigned", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xAF, "i64 Truncate f32 unsigned", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64TruncSF64() {
@@ -134,8 +152,10 @@ func (vm *VM) i64TruncSF64() {
 	vm.pushInt64(val)
 
 	// Log this operation
-	opLog(vm, 0xB0, "i64 Truncate f64 signed", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xB0, "i64 Truncate f64 signed", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64TruncUF64() {
@@ -147,8 +167,10 @@ func (vm *VM) i64TruncUF64() {
 	vm.pushUint64(val)
 
 	// Log this operation
-	opLog(vm, 0xB1, "i64 Truncate f64 unsigned", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xB1, "i64 Truncate f64 unsigned", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32ConvertSI32() {
@@ -157,11 +179,14 @@ func (vm *VM) f32ConvertSI32() {
 	// The operation we're logging
 	v1 := vm.popInt32()
 	val := float32(v1)
+	vm.trackConvert(int64(v1), float64(val))
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0xB2, "f32 Convert i32 signed", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xB2, "f32 Convert i32 signed", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32ConvertUI32() {
@@ -170,11 +195,14 @@ func (vm *VM) f32ConvertUI32() {
 	// The operation we're logging
 	v1 := vm.popUint32()
 	val := float32(v1)
+	vm.trackConvertU(uint64(v1), float64(val))
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0xB3, "f32 Convert i32 unsigned", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xB3, "f32 Convert i32 unsigned", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32ConvertSI64() {
@@ -183,11 +211,14 @@ func (vm *VM) f32ConvertSI64() {
 	// The operation we're logging
 	v1 := vm.popInt64()
 	val := float32(v1)
+	vm.trackConvert(v1, float64(val))
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0xB4, "f32 Convert i64 signed", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xB4, "f32 Convert i64 signed", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32ConvertUI64() {
@@ -196,11 +227,14 @@ func (vm *VM) f32ConvertUI64() {
 	// The operation we're logging
 	v1 := vm.popUint64()
 	val := float32(v1)
+	vm.trackConvertU(v1, float64(val))
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0xB5, "f32 Convert i64 unsigned", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xB5, "f32 Convert i64 unsigned", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32DemoteF64() {
@@ -209,11 +243,15 @@ func (vm *VM) f32DemoteF64() {
 	// The operation we're logging
 	v1 := vm.popFloat64()
 	val := float32(v1)
+	vm.trackDemote(v1, val)
+	val = vm.canonicalF32(val)
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0xB6, "f32 Demote f64", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xB6, "f32 Demote f64", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64ConvertSI32() {
@@ -225,8 +263,10 @@ func (vm *VM) f64ConvertSI32() {
 	vm.pushFloat64(val)
 
 	// Log this operation
-	opLog(vm, 0xB7, "f64 Convert i32 signed", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xB7, "f64 Convert i32 signed", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64ConvertUI32() {
@@ -238,8 +278,10 @@ func (vm *VM) f64ConvertUI32() {
 	vm.pushFloat64(val)
 
 	// Log this operation
-	opLog(vm, 0xB8, "f64 Convert i32 unsigned", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xB8, "f64 Convert i32 unsigned", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64ConvertSI64() {
@@ -248,11 +290,14 @@ func (vm *VM) f64ConvertSI64() {
 	// The operation we're logging
 	v1 := vm.popInt64()
 	val := float64(v1)
+	vm.trackConvert(v1, val)
 	vm.pushFloat64(val)
 
 	// Log this operation
-	opLog(vm, 0xB9, "f64 Convert i64 signed", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xB9, "f64 Convert i64 signed", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64ConvertUI64() {
@@ -261,11 +306,14 @@ func (vm *VM) f64ConvertUI64() {
 	// The operation we're logging
 	v1 := vm.popUint64()
 	val := float64(v1)
+	vm.trackConvertU(v1, val)
 	vm.pushFloat64(val)
 
 	// Log this operation
-	opLog(vm, 0xBA, "f64 Convert i64 unsigned", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xBA, "f64 Convert i64 unsigned", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64PromoteF32() {
@@ -274,9 +322,194 @@ func (vm *VM) f64PromoteF32() {
 	// The operation we're logging
 	v1 := vm.popFloat32()
 	val := float64(v1)
+	vm.trackSignaling(isSignaling32(v1))
+	val = vm.canonicalF64(val)
 	vm.pushFloat64(val)
 
 	// Log this operation
-	opLog(vm, 0xBB, "f64 Promote f32", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0xBB, "f64 Promote f32", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
+}
+
+// miscOp runs the operator following the MiscPrefix opcode, which is given
+// by the next byte of the compiled code.
+func (vm *VM) miscOp() {
+	vm.miscOpCode = byte(vm.fetchInt8())
+	vm.miscFuncTable[vm.miscOpCode]()
+}
+
+func (vm *VM) i32TruncSatSF32() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat32()
+	val := truncSatI32(float64(v1))
+	vm.pushInt32(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xFC, "i32 Truncate saturating f32 signed", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
+}
+
+func (vm *VM) i32TruncSatUF32() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat32()
+	val := truncSatU32(float64(v1))
+	vm.pushUint32(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xFC, "i32 Truncate saturating f32 unsigned", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
+}
+
+func (vm *VM) i32TruncSatSF64() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat64()
+	val := truncSatI32(v1)
+	vm.pushInt32(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xFC, "i32 Truncate saturating f64 signed", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
+}
+
+func (vm *VM) i32TruncSatUF64() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat64()
+	val := truncSatU32(v1)
+	vm.pushUint32(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xFC, "i32 Truncate saturating f64 unsigned", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
+}
+
+func (vm *VM) i64TruncSatSF32() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat32()
+	val := truncSatI64(float64(v1))
+	vm.pushInt64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xFC, "i64 Truncate saturating f32 signed", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
+}
+
+func (vm *VM) i64TruncSatUF32() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat32()
+	val := truncSatU64(float64(v1))
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xFC, "i64 Truncate saturating f32 unsigned", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
+}
+
+func (vm *VM) i64TruncSatSF64() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat64()
+	val := truncSatI64(v1)
+	vm.pushInt64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xFC, "i64 Truncate saturating f64 signed", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
+}
+
+func (vm *VM) i64TruncSatUF64() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat64()
+	val := truncSatU64(v1)
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xFC, "i64 Truncate saturating f64 unsigned", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
+}
+
+// truncSatI32 truncates v to an int32, converting NaN to 0 and clamping
+// values out of range to the smallest or largest int32.
+func truncSatI32(v float64) int32 {
+	v = math.Trunc(v)
+	switch {
+	case math.IsNaN(v):
+		return 0
+	case v < math.MinInt32:
+		return math.MinInt32
+	case v > math.MaxInt32:
+		return math.MaxInt32
+	}
+	return int32(v)
+}
+
+// truncSatU32 is the unsigned version of truncSatI32.
+func truncSatU32(v float64) uint32 {
+	v = math.Trunc(v)
+	switch {
+	case math.IsNaN(v), v < 0:
+		return 0
+	case v > math.MaxUint32:
+		return math.MaxUint32
+	}
+	return uint32(v)
+}
+
+// truncSatI64 truncates v to an int64, converting NaN to 0 and clamping
+// values out of range to the smallest or largest int64.
+func truncSatI64(v float64) int64 {
+	v = math.Trunc(v)
+	switch {
+	case math.IsNaN(v):
+		return 0
+	case v < math.MinInt64:
+		return math.MinInt64
+	case v >= 1<<63: // math.MaxInt64 isn't representable as a float64
+		return math.MaxInt64
+	}
+	return int64(v)
+}
+
+// truncSatU64 is the unsigned version of truncSatI64.
+func truncSatU64(v float64) uint64 {
+	v = math.Trunc(v)
+	switch {
+	case math.IsNaN(v), v < 0:
+		return 0
+	case v >= 1<<64: // math.MaxUint64 isn't representable as a float64
+		return math.MaxUint64
+	}
+	return uint64(v)
 }
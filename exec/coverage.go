@@ -0,0 +1,69 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// markCovered records the instruction at the current program counter as
+// executed.
+func (vm *VM) markCovered() {
+	cov := vm.coverage[vm.ctx.curFunc]
+	if cov == nil {
+		cov = make([]bool, len(vm.ctx.code))
+		vm.coverage[vm.ctx.curFunc] = cov
+	}
+	cov[vm.ctx.pc] = true
+}
+
+// ExportCoverage writes out the instructions executed since the VM was
+// created, as recorded with the WithCoverage option. The output is text,
+// starting with a "wagon coverage v1" header line, followed by a line for
+// each function defined in the module (imported host functions are left
+// out):
+//
+//	func <index> <covered>/<total>: <offset> <offset> ...
+//
+// where index is the function's index in the function index space, covered
+// and total are the numbers of executed and of all instructions in the
+// function, and the offsets are those of the executed instructions, in
+// ascending order. The offsets, and the instructions counted, are those of
+// the code the function was compiled to for the interpreter, rather than
+// of the module's bytecode, so they're good for working out the percentage
+// covered rather than for pointing at the source.
+//
+// Instructions run as native code (see EnableAOT) aren't recorded.
+func (vm *VM) ExportCoverage(w io.Writer) error {
+	if vm.coverage == nil {
+		return errors.New("exec: coverage recording isn't enabled")
+	}
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("wagon coverage v1\n")
+	for i, fn := range vm.funcs {
+		compiled, ok := fn.(compiledFunction)
+		if !ok {
+			continue
+		}
+		cov := vm.coverage[i]
+		var covered []int
+		for _, instr := range compiled.codeMeta.Instructions {
+			if instr.Start < len(cov) && cov[instr.Start] {
+				covered = append(covered, instr.Start)
+			}
+		}
+
+		fmt.Fprintf(bw, "func %d %d/%d:", i, len(covered), len(compiled.codeMeta.Instructions))
+		for _, offset := range covered {
+			fmt.Fprintf(bw, " %d", offset)
+		}
+		bw.WriteString("\n")
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,96 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestExportCoverage(t *testing.T) {
+	m := buildTestModule(
+		testFunc{
+			name:    "pick",
+			params:  []wasm.ValueType{wasm.ValueTypeI32},
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code: []byte{
+				ops.GetLocal, 0x00,
+				ops.If, 0x7f,
+				ops.I32Const, 0x01,
+				ops.Else,
+				ops.I32Const, 0x02,
+				ops.End,
+			},
+		},
+		testFunc{
+			name: "unused",
+			code: []byte{ops.Nop},
+		},
+	)
+	vm, err := NewVM(m, WithCoverage(true))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0, 1); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	// Parses the coverage lines, checking the format
+	export := func() map[int][2]int {
+		var buf bytes.Buffer
+		if err := vm.ExportCoverage(&buf); err != nil {
+			t.Fatalf("could not export coverage: %v", err)
+		}
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		if lines[0] != "wagon coverage v1" {
+			t.Fatalf("got header %q, want \"wagon coverage v1\"", lines[0])
+		}
+		counts := make(map[int][2]int)
+		for _, line := range lines[1:] {
+			var index, covered, total int
+			if _, err := fmt.Sscanf(line, "func %d %d/%d:", &index, &covered, &total); err != nil {
+				t.Fatalf("could not parse coverage line %q: %v", line, err)
+			}
+			if offsets := strings.Fields(line[strings.Index(line, ":")+1:]); len(offsets) != covered {
+				t.Errorf("coverage line %q lists %d offsets, want %d", line, len(offsets), covered)
+			}
+			counts[index] = [2]int{covered, total}
+		}
+		return counts
+	}
+
+	counts := export()
+	if len(counts) != 2 {
+		t.Fatalf("got coverage for %d functions, want 2", len(counts))
+	}
+	pick := counts[0]
+	if pick[0] == 0 || pick[0] >= pick[1] {
+		t.Errorf("got %d/%d instructions covered taking one branch, want some but not all", pick[0], pick[1])
+	}
+	if counts[1][0] != 0 {
+		t.Errorf("got %d instructions covered in the unused function, want 0", counts[1][0])
+	}
+
+	// Taking the other branch too covers the whole function
+	if _, err = vm.ExecCode(0, 0); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if pick = export()[0]; pick[0] != pick[1] {
+		t.Errorf("got %d/%d instructions covered taking both branches, want all", pick[0], pick[1])
+	}
+
+	vm, err = NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if err = vm.ExportCoverage(new(bytes.Buffer)); err == nil {
+		t.Error("exporting coverage without recording it didn't fail")
+	}
+}
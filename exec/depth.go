@@ -0,0 +1,63 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+// A function's stack depth estimate is flagged as over-provisioned when
+// it's more than overProvisionFactor times the depth reached, and at least
+// overProvisionSlack values more.
+const (
+	overProvisionFactor = 4
+	overProvisionSlack  = 16
+)
+
+// DepthReport compares the stack depth estimated for a function when
+// compiling it with the depth it reached when run.
+type DepthReport struct {
+	FuncIndex int64
+	MaxDepth  int  // Deepest the stack can get, as estimated when compiling
+	HighWater int  // Deepest the stack got in the runs of the VM
+	Executed  bool // Whether the function pushed anything in the runs of the VM
+
+	// Exceeded is set when the stack got deeper than estimated, which
+	// points to a bug in the estimate.
+	Exceeded bool
+
+	// OverProvisioned is set when the estimate is well over the depth
+	// reached, at more than 4 times the high-water mark, and at least 16
+	// values more. Only functions which were executed are flagged, and
+	// their runs may not have taken the deepest path through them.
+	OverProvisioned bool
+}
+
+// DepthEstimateReport returns a report for each function defined in the
+// module, in function index order, comparing its estimated stack depth
+// with the high-water mark tracked with WithStackHighWater. It returns nil
+// when the high-water mark isn't tracked.
+//
+// Values pushed by instructions run as native code (see EnableAOT) aren't
+// tracked.
+func (vm *VM) DepthEstimateReport() []DepthReport {
+	if vm.highWater == nil {
+		return nil
+	}
+	var reports []DepthReport
+	for i, fn := range vm.funcs {
+		compiled, ok := fn.(compiledFunction)
+		if !ok {
+			continue
+		}
+		r := DepthReport{
+			FuncIndex: int64(i),
+			MaxDepth:  compiled.maxDepth,
+			HighWater: vm.highWater[i],
+			Executed:  vm.highWater[i] > 0,
+		}
+		r.Exceeded = r.HighWater > r.MaxDepth
+		r.OverProvisioned = r.Executed && r.MaxDepth > overProvisionFactor*r.HighWater &&
+			r.MaxDepth-r.HighWater >= overProvisionSlack
+		reports = append(reports, r)
+	}
+	return reports
+}
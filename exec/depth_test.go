@@ -0,0 +1,81 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestDepthEstimateReport(t *testing.T) {
+	// deep only goes deep when its argument isn't zero
+	deep := []byte{ops.GetLocal, 0x00, ops.If, 0x40}
+	for i := 0; i < 20; i++ {
+		deep = append(deep, ops.I32Const, 0x01)
+	}
+	for i := 0; i < 20; i++ {
+		deep = append(deep, ops.Drop)
+	}
+	deep = append(deep, ops.End)
+
+	m := buildTestModule(
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code: []byte{
+				ops.I32Const, 0x01,
+				ops.I32Const, 0x02,
+				ops.I32Const, 0x03,
+				ops.I32Add,
+				ops.I32Add,
+			},
+		},
+		testFunc{
+			params: []wasm.ValueType{wasm.ValueTypeI32},
+			code:   deep,
+		},
+		testFunc{code: []byte{ops.I32Const, 0x01, ops.Drop}},
+		testFunc{host: func(proc *Process) {}},
+	)
+
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if r := vm.DepthEstimateReport(); r != nil {
+		t.Errorf("got report %v without WithStackHighWater, want nil", r)
+	}
+
+	vm, err = NewVM(m, WithStackHighWater(true))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0); err != nil {
+		t.Fatalf("error executing function 0: %v", err)
+	}
+	if _, err = vm.ExecCode(1, 0); err != nil {
+		t.Fatalf("error executing function 1: %v", err)
+	}
+
+	want := []DepthReport{
+		{FuncIndex: 0, MaxDepth: 3, HighWater: 3, Executed: true},
+		{FuncIndex: 1, MaxDepth: 20, HighWater: 1, Executed: true, OverProvisioned: true},
+		{FuncIndex: 2, MaxDepth: 1},
+	}
+	if got := vm.DepthEstimateReport(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got report %+v, want %+v", got, want)
+	}
+
+	// Going deep is no longer over-provisioned
+	if _, err = vm.ExecCode(1, 1); err != nil {
+		t.Fatalf("error executing function 1: %v", err)
+	}
+	want[1].HighWater, want[1].OverProvisioned = 20, false
+	if got := vm.DepthEstimateReport(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got report %+v after going deep, want %+v", got, want)
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+// AssertDeterministic runs the function at index fnIndex twice with the
+// given arguments, tracing each operation in memory, and returns an error
+// when the two runs differ. This is a handy self-test for host functions,
+// which can bring in nondeterminism such as from the time of day or map
+// iteration order.
+//
+// The linear memory and globals are put back the way they were before the
+// first run for the second one, so the runs start from the same state. The
+// first difference found is returned as a ReplayDivergenceError, pointing
+// at the operation that diverged. Operation logging configured for the VM
+// is suspended during both runs.
+func (vm *VM) AssertDeterministic(fnIndex int64, args ...uint64) error {
+	mem := append([]byte(nil), vm.memory...)
+	mems := copyMemories(vm.memories)
+	globals := append([]uint64(nil), vm.globals...)
+
+	trace := &MemoryOpLogger{}
+	logger, sampleRate := vm.logger, vm.logSampleRate
+	vm.logger, vm.logSampleRate = trace, 0
+	_, err := vm.ExecCode(fnIndex, args...)
+	vm.logger, vm.logSampleRate = logger, sampleRate
+	if err != nil {
+		return err
+	}
+
+	vm.memory = append(vm.memory[:0], mem...)
+	vm.syncMemory()
+	vm.memories = mems
+	copy(vm.globals, globals)
+	return vm.Replay(trace, fnIndex, args...)
+}
@@ -0,0 +1,45 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestAssertDeterministic(t *testing.T) {
+	vm := replayTestVM(t)
+	if err := vm.AssertDeterministic(0, 20); err != nil {
+		t.Errorf("deterministic function reported as nondeterministic: %v", err)
+	}
+
+	m := buildTestModule(
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI64},
+			host: func(proc *Process) int64 {
+				return time.Now().UnixNano()
+			},
+		},
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI64},
+			code:    []byte{ops.Call, 0x00},
+		},
+	)
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	err = vm.AssertDeterministic(1)
+	div, ok := err.(ReplayDivergenceError)
+	if !ok {
+		t.Fatalf("got error %v, want a ReplayDivergenceError", err)
+	}
+	if div.OpCode != ops.Call {
+		t.Errorf("divergence reported for opcode %#x, want the call (%#x)", div.OpCode, ops.Call)
+	}
+}
@@ -6,6 +6,7 @@ package exec_test
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
@@ -243,17 +244,26 @@ func fnString(fn string, args []string) string {
 	return fmt.Sprintf("%s(%v)", fn, args)
 }
 
-func panics(fn func()) (panicked bool, msg string) {
+func panics(fn func()) (panicked bool, val interface{}) {
 	defer func() {
-		r := recover()
-		panicked = r != nil
-		msg = fmt.Sprint(r)
+		val = recover()
+		panicked = val != nil
 	}()
 
 	fn()
 	return
 }
 
+// trapMatches returns whether the value a function panicked with is the trap
+// with the message want. Out of bounds memory accesses are reported along
+// with the address accessed, so they're matched by the error they wrap.
+func trapMatches(val interface{}, want string) bool {
+	if err, ok := val.(error); ok && want == exec.ErrOutOfBoundsMemoryAccess.Error() && errors.Is(err, exec.ErrOutOfBoundsMemoryAccess) {
+		return true
+	}
+	return fmt.Sprint(val) == want
+}
+
 func runTest(fileName string, testCases []testCase, t testing.TB, nativeBackend bool, repeat bool) {
 	file, err := os.Open(fileName)
 	if err != nil {
@@ -293,8 +303,8 @@ func runTest(fileName string, testCases []testCase, t testing.TB, nativeBackend
 					t.Fatalf("%s, %s: %v", fileName, testCase.Function, err)
 				}
 			}
-			if p, msg := panics(fn); p && msg != testCase.Trap {
-				t.Errorf("%s, %s: unexpected trap message: got=%s, want=%s", fileName, fnString(testCase.Function, testCase.Args), msg, testCase.Trap)
+			if p, val := panics(fn); p && !trapMatches(val, testCase.Trap) {
+				t.Errorf("%s, %s: unexpected trap message: got=%v, want=%s", fileName, fnString(testCase.Function, testCase.Args), val, testCase.Trap)
 			}
 			continue
 		}
@@ -318,7 +328,10 @@ func runTest(fileName string, testCases []testCase, t testing.TB, nativeBackend
 		for i := 0; i < times; i++ {
 			res, err = vm.ExecCode(int64(index), args...)
 			if repeat {
-				vm.Restart()
+				// The start function sets up memory, which Restart resets
+				if err := vm.RestartWithOptions(exec.RestartOptions{RerunStart: true}); err != nil {
+					t.Fatalf("%s: error restarting the VM: %v", fileName, err)
+				}
 			}
 		}
 		if ok {
@@ -0,0 +1,230 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"math"
+	"math/big"
+	"strings"
+)
+
+// FloatFlagSet holds the sticky floating point exception flags set while
+// executing, when enabled with the WithFloatFlagTracking option. They are a
+// coarse take on the IEEE 754 exception flags, worked out from the operands
+// and results of the float arithmetic, rounding, min and max operators, and
+// of the conversions to floats. The other float operators, such as abs, neg
+// and copysign, never raise any.
+type FloatFlagSet uint8
+
+const (
+	// FloatInvalid is set when an operation without NaN operands produced
+	// a NaN, such as 0/0 or the square root of a negative number.
+	FloatInvalid FloatFlagSet = 1 << iota
+	// FloatDivideByZero is set when a finite number was divided by zero.
+	FloatDivideByZero
+	// FloatOverflow is set when a result too large to represent was
+	// rounded to infinity.
+	FloatOverflow
+	// FloatUnderflow is set when a result was subnormal, or was rounded
+	// to zero.
+	FloatUnderflow
+	// FloatInexact is set when a result had to be rounded.
+	FloatInexact
+)
+
+var floatFlagNames = []string{"invalid", "divide-by-zero", "overflow", "underflow", "inexact"}
+
+func (f FloatFlagSet) String() string {
+	var names []string
+	for i, name := range floatFlagNames {
+		if f&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, "|")
+}
+
+// The smallest positive normal numbers
+const (
+	minNormal32 = 1.17549435082228750796873653722224568e-38
+	minNormal64 = 2.2250738585072013830902327173324040642192159804623318306e-308
+)
+
+type floatArith int
+
+const (
+	floatAdd floatArith = iota
+	floatSub
+	floatMul
+	floatDiv
+	floatSqrt   // Only uses the first operand
+	floatDemote // Only uses the first operand
+)
+
+// Masks of the quiet bit of NaNs, which is clear for signaling ones
+const (
+	quietBit32 = 1 << 22
+	quietBit64 = 1 << 51
+)
+
+func isSignaling32(f float32) bool {
+	return f != f && math.Float32bits(f)&quietBit32 == 0
+}
+
+func isSignaling64(f float64) bool {
+	return math.IsNaN(f) && math.Float64bits(f)&quietBit64 == 0
+}
+
+// FloatFlags returns the floating point exception flags set since the VM
+// was created, or the flags were last cleared.
+func (vm *VM) FloatFlags() FloatFlagSet {
+	return vm.floatFlags
+}
+
+// ClearFloatFlags clears the floating point exception flags.
+func (vm *VM) ClearFloatFlags() {
+	vm.floatFlags = 0
+}
+
+// trackF32 updates the floating point exception flags for an f32
+// operation, when tracking them.
+func (vm *VM) trackF32(op floatArith, a, b, res float32) {
+	if !vm.trackFloatFlags {
+		return
+	}
+
+	// Work out the exact result, or near enough, in double precision
+	x, y := float64(a), float64(b)
+	var exact float64
+	switch op {
+	case floatAdd:
+		exact = x + y
+	case floatSub:
+		exact = x - y
+	case floatMul:
+		exact = x * y
+	case floatDiv:
+		exact = x / y
+	case floatSqrt:
+		exact = math.Sqrt(x)
+	}
+	vm.floatFlags |= floatFlags(op, x, y, float64(res), float64(res) != exact, minNormal32)
+}
+
+// trackF64 updates the floating point exception flags for an f64
+// operation, when tracking them.
+func (vm *VM) trackF64(op floatArith, a, b, res float64) {
+	if !vm.trackFloatFlags {
+		return
+	}
+
+	// Work out whether the result was rounded, for finite operands and
+	// results as the others can't be
+	var inexact bool
+	if isFinite(a) && isFinite(b) && isFinite(res) {
+		switch op {
+		case floatAdd, floatSub:
+			if op == floatSub {
+				b = -b
+			}
+			bb := res - a
+			inexact = (a-(res-bb))+(b-bb) != 0
+		case floatMul:
+			inexact = !isProduct(a, b, res)
+		case floatDiv:
+			inexact = !isProduct(res, b, a)
+		case floatSqrt:
+			inexact = !isProduct(res, res, a)
+		}
+	}
+	vm.floatFlags |= floatFlags(op, a, b, res, inexact, minNormal64)
+}
+
+// trackRound updates the floating point exception flags for rounding a to
+// the integer res, as with the ceil, floor, trunc and nearest operators,
+// when tracking them.
+func (vm *VM) trackRound(a, res float64) {
+	if vm.trackFloatFlags && isFinite(a) && a != res {
+		vm.floatFlags |= FloatInexact
+	}
+}
+
+// trackSignaling updates the floating point exception flags for an
+// operation which is only invalid with a signaling NaN operand, such as min,
+// max and promote, when tracking them.
+func (vm *VM) trackSignaling(signaling bool) {
+	if vm.trackFloatFlags && signaling {
+		vm.floatFlags |= FloatInvalid
+	}
+}
+
+// trackDemote updates the floating point exception flags for demoting a to
+// the f32 res, when tracking them.
+func (vm *VM) trackDemote(a float64, res float32) {
+	if !vm.trackFloatFlags {
+		return
+	}
+	vm.trackSignaling(isSignaling64(a))
+	inexact := isFinite(a) && float64(res) != a
+	vm.floatFlags |= floatFlags(floatDemote, a, 0, float64(res), inexact, minNormal32)
+}
+
+// trackConvert updates the floating point exception flags for converting
+// the signed integer v to the float res, when tracking them. Integers are
+// never too large for a float, but might be rounded.
+func (vm *VM) trackConvert(v int64, res float64) {
+	if vm.trackFloatFlags && new(big.Float).SetInt64(v).Cmp(big.NewFloat(res)) != 0 {
+		vm.floatFlags |= FloatInexact
+	}
+}
+
+// trackConvertU is trackConvert for the unsigned integer v.
+func (vm *VM) trackConvertU(v uint64, res float64) {
+	if vm.trackFloatFlags && new(big.Float).SetUint64(v).Cmp(big.NewFloat(res)) != 0 {
+		vm.floatFlags |= FloatInexact
+	}
+}
+
+func isFinite(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0)
+}
+
+// isProduct reports whether x*y is exactly z. The product of two float64
+// values needs up to 106 bits of mantissa.
+func isProduct(x, y, z float64) bool {
+	p := new(big.Float).SetPrec(106).Mul(big.NewFloat(x), big.NewFloat(y))
+	return p.Cmp(big.NewFloat(z)) == 0
+}
+
+// floatFlags works out the exception flags for an operation result.
+func floatFlags(op floatArith, a, b, res float64, inexact bool, minNormal float64) FloatFlagSet {
+	var flags FloatFlagSet
+	switch {
+	case math.IsNaN(res):
+		if !math.IsNaN(a) && !math.IsNaN(b) {
+			flags |= FloatInvalid
+		}
+	case math.IsInf(res, 0):
+		if math.IsInf(a, 0) || math.IsInf(b, 0) {
+			break
+		}
+		if op == floatDiv && b == 0 {
+			flags |= FloatDivideByZero
+		} else {
+			flags |= FloatOverflow | FloatInexact
+		}
+	default:
+		if inexact {
+			flags |= FloatInexact
+		}
+		if (res != 0 && math.Abs(res) < minNormal) || (res == 0 && inexact) {
+			flags |= FloatUnderflow
+		}
+	}
+	return flags
+}
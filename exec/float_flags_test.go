@@ -0,0 +1,122 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// f64Binop returns the body of a function applying op to two f64 constants.
+func f64Binop(op byte, v1, v2 float64) []byte {
+	code := []byte{ops.F64Const}
+	code = append(code, make([]byte, 8)...)
+	endianess.PutUint64(code[1:], math.Float64bits(v1))
+	code = append(code, ops.F64Const)
+	code = append(code, make([]byte, 8)...)
+	endianess.PutUint64(code[10:], math.Float64bits(v2))
+	return append(code, op)
+}
+
+// f32Binop returns the body of a function applying op to two f32 constants.
+func f32Binop(op byte, v1, v2 float32) []byte {
+	code := []byte{ops.F32Const, 0, 0, 0, 0, ops.F32Const, 0, 0, 0, 0, op}
+	endianess.PutUint32(code[1:], math.Float32bits(v1))
+	endianess.PutUint32(code[6:], math.Float32bits(v2))
+	return code
+}
+
+// f64Unop returns the body of a function applying op to an f64 constant.
+func f64Unop(op byte, v float64) []byte {
+	code := []byte{ops.F64Const, 0, 0, 0, 0, 0, 0, 0, 0, op}
+	endianess.PutUint64(code[1:], math.Float64bits(v))
+	return code
+}
+
+func TestFloatFlags(t *testing.T) {
+	signalingNaN := math.Float64frombits(0x7ff0000000000001)
+
+	for _, tc := range []struct {
+		name   string
+		result wasm.ValueType
+		code   []byte
+		want   FloatFlagSet
+	}{
+		{"f64 exact", wasm.ValueTypeF64, f64Binop(ops.F64Add, 1, 2), 0},
+		{"f64 0/0", wasm.ValueTypeF64, f64Binop(ops.F64Div, 0, 0), FloatInvalid},
+		{"f64 1/0", wasm.ValueTypeF64, f64Binop(ops.F64Div, 1, 0), FloatDivideByZero},
+		{"f64 large multiply", wasm.ValueTypeF64, f64Binop(ops.F64Mul, 1e300, 1e300), FloatOverflow | FloatInexact},
+		{"f64 tiny multiply", wasm.ValueTypeF64, f64Binop(ops.F64Mul, 1e-300, 1e-300), FloatUnderflow | FloatInexact},
+		{"f64 rounded", wasm.ValueTypeF64, f64Binop(ops.F64Div, 1, 3), FloatInexact},
+		{"f64 inf - inf", wasm.ValueTypeF64, f64Binop(ops.F64Sub, math.Inf(1), math.Inf(1)), FloatInvalid},
+		{"f64 NaN operand", wasm.ValueTypeF64, f64Binop(ops.F64Add, math.NaN(), 1), 0},
+		{"f32 exact", wasm.ValueTypeF32, f32Binop(ops.F32Mul, 1.5, 2), 0},
+		{"f32 0/0", wasm.ValueTypeF32, f32Binop(ops.F32Div, 0, 0), FloatInvalid},
+		{"f32 large multiply", wasm.ValueTypeF32, f32Binop(ops.F32Mul, 1e30, 1e30), FloatOverflow | FloatInexact},
+		{"f32 rounded", wasm.ValueTypeF32, f32Binop(ops.F32Add, 1, 1e-10), FloatInexact},
+		{"f32 demote overflow", wasm.ValueTypeF32, f64Unop(ops.F32DemoteF64, 1e300), FloatOverflow | FloatInexact},
+		{"f32 demote underflow", wasm.ValueTypeF32, f64Unop(ops.F32DemoteF64, 1e-300), FloatUnderflow | FloatInexact},
+		{"f32 demote rounded", wasm.ValueTypeF32, f64Unop(ops.F32DemoteF64, 0.1), FloatInexact},
+		{"f32 demote exact", wasm.ValueTypeF32, f64Unop(ops.F32DemoteF64, 0.5), 0},
+		{"f64 nearest rounded", wasm.ValueTypeF64, f64Unop(ops.F64Nearest, 2.5), FloatInexact},
+		{"f64 floor exact", wasm.ValueTypeF64, f64Unop(ops.F64Floor, 2), 0},
+		{"f64 min signaling NaN", wasm.ValueTypeF64, f64Binop(ops.F64Min, signalingNaN, 1), FloatInvalid},
+		{"f64 max quiet NaN", wasm.ValueTypeF64, f64Binop(ops.F64Max, math.NaN(), 1), 0},
+		{"f64 convert rounded", wasm.ValueTypeF64, append(append([]byte{ops.I64Const}, sleb128(1<<53+1)...), ops.F64ConvertSI64), FloatInexact},
+		{"f32 convert exact", wasm.ValueTypeF32, append(append([]byte{ops.I32Const}, sleb128(-16)...), ops.F32ConvertSI32), 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := buildTestModule(testFunc{
+				name:    "op",
+				results: []wasm.ValueType{tc.result},
+				code:    tc.code,
+			})
+			vm, err := NewVM(m, WithFloatFlagTracking(true))
+			if err != nil {
+				t.Fatalf("could not create VM: %v", err)
+			}
+			if _, err = vm.ExecCode(0); err != nil {
+				t.Fatalf("error executing function: %v", err)
+			}
+			if got := vm.FloatFlags(); got != tc.want {
+				t.Errorf("got flags %v, want %v", got, tc.want)
+			}
+
+			// The flags are only cleared on request
+			vm.Restart()
+			if _, err = vm.ExecCode(0); err != nil {
+				t.Fatalf("error executing function: %v", err)
+			}
+			if got := vm.FloatFlags(); got != tc.want {
+				t.Errorf("got flags %v after another run, want %v", got, tc.want)
+			}
+			vm.ClearFloatFlags()
+			if got := vm.FloatFlags(); got != 0 {
+				t.Errorf("got flags %v after clearing them", got)
+			}
+		})
+	}
+}
+
+func TestFloatFlagsDisabled(t *testing.T) {
+	m := buildTestModule(testFunc{
+		name:    "op",
+		results: []wasm.ValueType{wasm.ValueTypeF64},
+		code:    f64Binop(ops.F64Div, 0, 0),
+	})
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if got := vm.FloatFlags(); got != 0 {
+		t.Errorf("got flags %v without tracking enabled", got)
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// raiseSignal sends sig to the process again, once the operation log is
+// flushed, so it's handled as it would have been. It's a variable for the
+// tests to replace.
+var raiseSignal = func(sig os.Signal) {
+	p, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = p.Signal(sig)
+	}
+	if err != nil {
+		// Such as for os.Interrupt on Windows, which can't be sent
+		log.Printf("Exiting, as %v couldn't be raised again: %v", sig, err)
+		os.Exit(1)
+	}
+}
+
+// watchSignals starts flushing the operation log when the process receives
+// one of the given signals, as set with WithFlushOnSignal.
+func (vm *VM) watchSignals(sigs []os.Signal) {
+	vm.logMu = new(sync.Mutex)
+	vm.flushSignals = make(chan os.Signal, 1)
+	vm.flushStop = make(chan struct{})
+	signal.Notify(vm.flushSignals, sigs...)
+	go vm.flushOnSignal(vm.flushSignals, vm.flushStop)
+}
+
+// flushOnSignal commits the operation log once a signal is received on
+// sigs, and then raises it again, unless stop is closed first.
+func (vm *VM) flushOnSignal(sigs chan os.Signal, stop chan struct{}) {
+	select {
+	case sig := <-sigs:
+		// The log stays locked while the signal is handled, so the run
+		// doesn't carry on logging past the flush
+		vm.logMu.Lock()
+		if vm.logger != nil {
+			if err := vm.logger.Commit(); err != nil {
+				log.Printf("Flushing the operation log on %v failed: %v", sig, err)
+			}
+		}
+		signal.Stop(sigs)
+		raiseSignal(sig)
+		vm.logMu.Unlock()
+	case <-stop:
+		signal.Stop(sigs)
+	}
+}
+
+// stopWatchingSignals stops flushing the operation log on signals.
+func (vm *VM) stopWatchingSignals() {
+	if vm.flushStop != nil {
+		close(vm.flushStop)
+		vm.flushStop = nil
+	}
+}
+
+// lockLog keeps the operation log from being flushed on a signal while it's
+// written to, when flushing on signals.
+func (vm *VM) lockLog() {
+	if vm.logMu != nil {
+		vm.logMu.Lock()
+	}
+}
+
+func (vm *VM) unlockLog() {
+	if vm.logMu != nil {
+		vm.logMu.Unlock()
+	}
+}
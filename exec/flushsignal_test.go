@@ -0,0 +1,69 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"os"
+	"testing"
+
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// bufferingLogger holds the operations logged until they're committed.
+type bufferingLogger struct {
+	pending, committed int
+}
+
+func (l *bufferingLogger) LogOp(rec OpRecord) error {
+	l.pending++
+	return nil
+}
+
+func (l *bufferingLogger) Commit() error {
+	l.committed += l.pending
+	l.pending = 0
+	return nil
+}
+
+func TestFlushOnSignal(t *testing.T) {
+	raised := make(chan os.Signal, 1)
+	defer func(raise func(os.Signal)) { raiseSignal = raise }(raiseSignal)
+	raiseSignal = func(sig os.Signal) { raised <- sig }
+
+	var vm *VM
+	var pending, committed int
+	logger := &bufferingLogger{}
+	m := buildTestModule(
+		testFunc{code: []byte{
+			ops.I32Const, 0x01,
+			ops.I32Const, 0x02,
+			ops.I32Add,
+			ops.Drop,
+			ops.Call, 0x01,
+		}},
+		testFunc{host: func(proc *Process) {
+			// Simulate Ctrl+C in the middle of the run
+			vm.flushSignals <- os.Interrupt
+			if sig := <-raised; sig != os.Interrupt {
+				t.Errorf("raised %v again, want %v", sig, os.Interrupt)
+			}
+			pending, committed = logger.pending, logger.committed
+		}},
+	)
+
+	var err error
+	vm, err = NewVM(m, WithOpLogger(logger), WithFlushOnSignal())
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	defer vm.Close()
+	if _, err = vm.ExecCode(0); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	if pending != 0 || committed == 0 {
+		t.Errorf("got %d operations committed and %d pending on the signal, want all of them committed", committed, pending)
+	}
+}
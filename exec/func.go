@@ -10,6 +10,7 @@ import (
 	"reflect"
 
 	"github.com/go-interpreter/wagon/exec/internal/compile"
+	"github.com/go-interpreter/wagon/wasm"
 )
 
 type function interface {
@@ -20,6 +21,7 @@ type compiledFunction struct {
 	code           []byte
 	codeMeta       *compile.BytecodeMetadata
 	branchTables   []*compile.BranchTable
+	memoryIndexes  bool // whether the loads and stores give the index of the memory accessed
 	maxDepth       int  // maximum stack depth reached while executing the function body
 	totalLocalVars int  // number of local variables used by the function
 	args           int  // number of arguments the function accepts
@@ -33,6 +35,8 @@ type asmBlock struct {
 	nativeUnit compile.NativeCodeUnit
 	// where in the instruction stream to resume after native execution.
 	resumePC uint
+	// most values the native code pushes onto the stack.
+	stackWrites uint
 }
 
 type goFunction struct {
@@ -40,10 +44,61 @@ type goFunction struct {
 	typ reflect.Type
 }
 
+// ErrUnboundImport is the error value used while trapping the VM when a
+// module calls an imported host function which was never bound to a Go
+// function, being a nil func value. With WithStrictImports, it's returned
+// by NewVM instead, for the first such import.
+type ErrUnboundImport struct {
+	Module, Field string // Names the function was imported with
+}
+
+func (e ErrUnboundImport) Error() string {
+	return fmt.Sprintf("exec: imported function %s.%s is not bound to a host function", e.Module, e.Field)
+}
+
+// TrapKind returns TrapUnboundImport.
+func (e ErrUnboundImport) TrapKind() TrapKind {
+	return TrapUnboundImport
+}
+
+// bound returns whether the host function has a Go function to call.
+func (fn goFunction) bound() bool {
+	return fn.val.Kind() != reflect.Func || !fn.val.IsNil()
+}
+
+// unboundImport returns the error for the host function at index fnIndex
+// not being bound, with the names it's imported with.
+func (vm *VM) unboundImport(fnIndex int64) ErrUnboundImport {
+	var err ErrUnboundImport
+	if vm.module.Import == nil {
+		return err
+	}
+	// Imported functions come first in the function index space
+	n := int64(0)
+	for _, entry := range vm.module.Import.Entries {
+		if entry.Type.Kind() != wasm.ExternalFunction {
+			continue
+		}
+		if n == fnIndex {
+			err.Module, err.Field = entry.ModuleName, entry.FieldName
+			break
+		}
+		n++
+	}
+	return err
+}
+
 func (fn goFunction) call(vm *VM, index int64) {
 	// numIn = # of call inputs + vm, as the function expects
 	// an additional *VM argument
 	numIn := fn.typ.NumIn()
+	if !fn.bound() {
+		panic(vm.unboundImport(index))
+	}
+	if vm.hostReplay != nil {
+		vm.replayHostCall(index, numIn-1)
+		return
+	}
 	args := make([]reflect.Value, numIn)
 	proc := NewProcess(vm)
 
@@ -54,14 +109,23 @@ func (fn goFunction) call(vm *VM, index int64) {
 	}
 	args[0] = reflect.ValueOf(proc)
 
+	var rec *HostCall
+	if vm.hostRecorder != nil {
+		rec = &HostCall{FuncIndex: index, Args: make([]uint64, numIn-1)}
+	}
 	for i := numIn - 1; i >= 1; i-- {
 		val := reflect.New(fn.typ.In(i)).Elem()
 		raw := vm.popUint64()
+		if rec != nil {
+			rec.Args[i-1] = raw
+		}
 		kind := fn.typ.In(i).Kind()
 
 		switch kind {
-		case reflect.Float64, reflect.Float32:
+		case reflect.Float64:
 			val.SetFloat(math.Float64frombits(raw))
+		case reflect.Float32:
+			val.SetFloat(float64(math.Float32frombits(uint32(raw))))
 		case reflect.Uint32, reflect.Uint64:
 			val.SetUint(raw)
 		case reflect.Int32, reflect.Int64:
@@ -77,8 +141,10 @@ func (fn goFunction) call(vm *VM, index int64) {
 	for i, out := range rtrns {
 		kind := out.Kind()
 		switch kind {
-		case reflect.Float64, reflect.Float32:
+		case reflect.Float64:
 			vm.pushFloat64(out.Float())
+		case reflect.Float32:
+			vm.pushFloat32(float32(out.Float()))
 		case reflect.Uint32, reflect.Uint64:
 			vm.pushUint64(out.Uint())
 		case reflect.Int32, reflect.Int64:
@@ -87,12 +153,17 @@ func (fn goFunction) call(vm *VM, index int64) {
 			panic(fmt.Sprintf("exec: return value %d invalid kind=%v", i, kind))
 		}
 	}
+	if rec != nil {
+		rec.Results = append(rec.Results, vm.ctx.stack[len(vm.ctx.stack)-len(rtrns):]...)
+		vm.hostRecorder.Calls = append(vm.hostRecorder.Calls, *rec)
+	}
 }
 
 func (compiled compiledFunction) call(vm *VM, index int64) {
 	// Make space on the stack for all intermediate values and
 	// a possible return value.
-	newStack := make([]uint64, 0, compiled.maxDepth+1)
+	stackTop := vm.stackTop
+	newStack := vm.allocStack(compiled.maxDepth + 1)
 	locals := make([]uint64, compiled.totalLocalVars)
 
 	for i := compiled.args - 1; i >= 0; i-- {
@@ -101,20 +172,30 @@ func (compiled compiledFunction) call(vm *VM, index int64) {
 
 	//save execution context
 	prevCtxt := vm.ctx
+	vm.callers = append(vm.callers, prevCtxt)
 
 	vm.ctx = context{
-		stack:   newStack,
-		locals:  locals,
-		code:    compiled.code,
-		asm:     compiled.asm,
-		pc:      0,
-		curFunc: index,
+		stack:         newStack,
+		locals:        locals,
+		code:          compiled.code,
+		asm:           compiled.asm,
+		branchTables:  compiled.branchTables,
+		memoryIndexes: compiled.memoryIndexes,
+		pc:            0,
+		curFunc:       index,
+		stackBase:     prevCtxt.stackBase + len(prevCtxt.stack),
 	}
 
 	rtrn := vm.execCode(compiled)
+	if vm.paused {
+		// Leave the call in place, for inspecting where ExecNOps stopped
+		return
+	}
 
 	//restore execution context
 	vm.ctx = prevCtxt
+	vm.callers = vm.callers[:len(vm.callers)-1]
+	vm.stackTop = stackTop
 
 	if compiled.returns {
 		vm.pushUint64(rtrn)
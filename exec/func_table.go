@@ -143,6 +143,18 @@ func (vm *VM) newFuncTable() {
 	vm.funcTable[ops.F64ConvertUI64] = vm.f64ConvertUI64
 	vm.funcTable[ops.F64PromoteF32] = vm.f64PromoteF32
 
+	vm.funcTable[ops.MiscPrefix] = vm.miscOp
+	vm.miscFuncTable[ops.I32TruncSatF32S] = vm.i32TruncSatSF32
+	vm.miscFuncTable[ops.I32TruncSatF32U] = vm.i32TruncSatUF32
+	vm.miscFuncTable[ops.I32TruncSatF64S] = vm.i32TruncSatSF64
+	vm.miscFuncTable[ops.I32TruncSatF64U] = vm.i32TruncSatUF64
+	vm.miscFuncTable[ops.I64TruncSatF32S] = vm.i64TruncSatSF32
+	vm.miscFuncTable[ops.I64TruncSatF32U] = vm.i64TruncSatUF32
+	vm.miscFuncTable[ops.I64TruncSatF64S] = vm.i64TruncSatSF64
+	vm.miscFuncTable[ops.I64TruncSatF64U] = vm.i64TruncSatUF64
+	vm.miscFuncTable[ops.MemoryCopy] = vm.memoryCopy
+	vm.miscFuncTable[ops.MemoryFill] = vm.memoryFill
+
 	vm.funcTable[ops.I32Load] = vm.i32Load
 	vm.funcTable[ops.I64Load] = vm.i64Load
 	vm.funcTable[ops.F32Load] = vm.f32Load
@@ -0,0 +1,93 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// exportedGlobal returns the index of the global exported with the given
+// name.
+func (vm *VM) exportedGlobal(name string) (uint32, error) {
+	var export wasm.ExportEntry
+	ok := false
+	if vm.module.Export != nil {
+		export, ok = vm.module.Export.Entries[name]
+	}
+	if !ok || export.Kind != wasm.ExternalGlobal || int(export.Index) >= len(vm.globals) {
+		return 0, fmt.Errorf("exec: no exported global named %q", name)
+	}
+	return export.Index, nil
+}
+
+// GlobalByName returns the value of the global exported with the given
+// name, as a uint32, uint64, float32 or float64, for an i32, i64, f32 or
+// f64 global, as ExecCode returns values.
+func (vm *VM) GlobalByName(name string) (interface{}, error) {
+	index, err := vm.exportedGlobal(name)
+	if err != nil {
+		return nil, err
+	}
+	typ := vm.module.GlobalIndexSpace[index].Type.Type
+	return returnValue(typ, vm.globals[index])
+}
+
+// SetGlobalByName sets the global exported with the given name to v, such
+// as for seeding configuration into the module before running it. The
+// value has to be of the global's type: an int32 or uint32 for an i32
+// global, an int64 or uint64 for an i64, a float32 for an f32, and a
+// float64 for an f64. An error is returned for a global which isn't
+// mutable.
+func (vm *VM) SetGlobalByName(name string, v interface{}) error {
+	index, err := vm.exportedGlobal(name)
+	if err != nil {
+		return err
+	}
+	global := vm.module.GlobalIndexSpace[index].Type
+	if !global.Mutable {
+		return fmt.Errorf("exec: exported global %q is immutable", name)
+	}
+
+	var val uint64
+	ok := true
+	switch global.Type {
+	case wasm.ValueTypeI32:
+		switch v := v.(type) {
+		case int32:
+			val = uint64(v)
+		case uint32:
+			val = uint64(v)
+		default:
+			ok = false
+		}
+	case wasm.ValueTypeI64:
+		switch v := v.(type) {
+		case int64:
+			val = uint64(v)
+		case uint64:
+			val = v
+		default:
+			ok = false
+		}
+	case wasm.ValueTypeF32:
+		var f float32
+		f, ok = v.(float32)
+		val = uint64(math.Float32bits(f))
+	case wasm.ValueTypeF64:
+		var f float64
+		f, ok = v.(float64)
+		val = math.Float64bits(f)
+	default:
+		return InvalidReturnTypeError(global.Type)
+	}
+	if !ok {
+		return fmt.Errorf("exec: can't set the %v global %q to a %T", global.Type, name, v)
+	}
+	vm.globals[index] = val
+	return nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestGlobalByName(t *testing.T) {
+	m := buildTestModule(
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code:    []byte{ops.GetGlobal, 0x00, ops.I32Const, 0x02, ops.I32Mul},
+		},
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeF64},
+			code:    []byte{ops.GetGlobal, 0x01, ops.GetGlobal, 0x01, ops.F64Add},
+		},
+	)
+	m.GlobalIndexSpace = []wasm.GlobalEntry{
+		{
+			Type: wasm.GlobalVar{Type: wasm.ValueTypeI32, Mutable: true},
+			Init: []byte{ops.I32Const, 0x07, ops.End},
+		},
+		{
+			Type: wasm.GlobalVar{Type: wasm.ValueTypeF64, Mutable: true},
+			Init: append(f64Binop(ops.F64Add, 0.5, 0)[:9:9], ops.End), // f64.const 0.5
+		},
+		{
+			Type: wasm.GlobalVar{Type: wasm.ValueTypeI32},
+			Init: []byte{ops.I32Const, 0x01, ops.End},
+		},
+	}
+	for i, name := range []string{"count", "scale", "version"} {
+		m.Export.Entries[name] = wasm.ExportEntry{FieldStr: name, Kind: wasm.ExternalGlobal, Index: uint32(i)}
+	}
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	if v, err := vm.GlobalByName("count"); err != nil || v != uint32(7) {
+		t.Errorf("got count %v and error %v, want 7", v, err)
+	}
+	if err = vm.SetGlobalByName("count", int32(-5)); err != nil {
+		t.Fatalf("SetGlobalByName(count): unexpected error: %v", err)
+	}
+	if v, _ := vm.GlobalByName("count"); v != uint32(0xfffffffb) {
+		t.Errorf("got count %v after setting it to -5, want %v", v, uint32(0xfffffffb))
+	}
+	if res, _ := vm.ExecCode(0); res != uint32(0xfffffff6) {
+		t.Errorf("function got count times two as %v, want %v", res, uint32(0xfffffff6))
+	}
+
+	if v, err := vm.GlobalByName("scale"); err != nil || v != 0.5 {
+		t.Errorf("got scale %v and error %v, want 0.5", v, err)
+	}
+	if err = vm.SetGlobalByName("scale", 2.25); err != nil {
+		t.Fatalf("SetGlobalByName(scale): unexpected error: %v", err)
+	}
+	if v, _ := vm.GlobalByName("scale"); v != 2.25 {
+		t.Errorf("got scale %v after setting it to 2.25, want 2.25", v)
+	}
+	if res, _ := vm.ExecCode(1); res != 4.5 {
+		t.Errorf("function got scale doubled as %v, want 4.5", res)
+	}
+
+	for _, tc := range []struct {
+		name string
+		v    interface{}
+	}{
+		{"version", int32(2)}, // immutable
+		{"count", 1.5},        // wrong type
+		{"missing", int32(1)}, // not exported
+		{"scale", float32(1)}, // wrong size
+	} {
+		if err = vm.SetGlobalByName(tc.name, tc.v); err == nil {
+			t.Errorf("SetGlobalByName(%s, %#v): expected an error", tc.name, tc.v)
+		}
+	}
+	if v, _ := vm.GlobalByName("version"); v != uint32(1) {
+		t.Errorf("got version %v, want it left at 1", v)
+	}
+}
+
+func TestResetGlobalsCached(t *testing.T) {
+	m := buildTestModule(testFunc{code: []byte{}})
+	m.GlobalIndexSpace = []wasm.GlobalEntry{
+		{
+			Type: wasm.GlobalVar{Type: wasm.ValueTypeI32, Mutable: true},
+			Init: []byte{ops.I32Const, 0x7f, ops.End}, // -1
+		},
+		{
+			Type: wasm.GlobalVar{Type: wasm.ValueTypeI64, Mutable: true},
+			Init: []byte{ops.I64Const, 0x2a, ops.End},
+		},
+		{
+			Type: wasm.GlobalVar{Type: wasm.ValueTypeF64, Mutable: true},
+			Init: append(f64Binop(ops.F64Add, 0.5, 0)[:9:9], ops.End), // f64.const 0.5
+		},
+	}
+	want := []uint64{0xffffffffffffffff, 42, math.Float64bits(0.5)}
+
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if !reflect.DeepEqual(vm.globals, want) {
+		t.Fatalf("got initial globals %#x, want %#x", vm.globals, want)
+	}
+
+	// The init expressions aren't evaluated again, so breaking them doesn't
+	// stop the resets from working
+	for i := range m.GlobalIndexSpace {
+		m.GlobalIndexSpace[i].Init = []byte{ops.Unreachable}
+	}
+	for run := 0; run < 2; run++ {
+		for i := range vm.globals {
+			vm.globals[i] = 0xdead
+		}
+		if err = vm.Restart(); err != nil {
+			t.Fatalf("Restart: unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(vm.globals, want) {
+			t.Errorf("got globals %#x after restart %d, want %#x", vm.globals, run+1, want)
+		}
+	}
+}
+
+func BenchmarkResetGlobals(b *testing.B) {
+	m := buildTestModule(testFunc{code: []byte{}})
+	m.GlobalIndexSpace = make([]wasm.GlobalEntry, 500)
+	for i := range m.GlobalIndexSpace {
+		m.GlobalIndexSpace[i] = wasm.GlobalEntry{
+			Type: wasm.GlobalVar{Type: wasm.ValueTypeI64, Mutable: true},
+			Init: []byte{ops.I64Const, byte(i % 64), ops.End},
+		}
+	}
+	vm, err := NewVM(m)
+	if err != nil {
+		b.Fatalf("could not create VM: %v", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err = vm.Restart(); err != nil {
+			b.Fatalf("Restart: unexpected error: %v", err)
+		}
+	}
+}
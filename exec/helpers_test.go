@@ -0,0 +1,94 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"reflect"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// testFunc describes a single function of a module built by buildTestModule.
+type testFunc struct {
+	name    string
+	params  []wasm.ValueType
+	results []wasm.ValueType
+	locals  []wasm.LocalEntry
+	code    []byte      // function body, without the final end opcode (as ReadModule strips it)
+	host    interface{} // when non nil, the function is a host function
+}
+
+// buildTestModule emulates what wasm.ReadModule would produce for a module
+// holding the given functions, so tests can run hand assembled bytecode.
+// Each function is exported under its name, when it has one.
+func buildTestModule(funcs ...testFunc) *wasm.Module {
+	m := wasm.NewModule()
+	m.Start = nil
+	m.Types = &wasm.SectionTypes{Entries: make([]wasm.FunctionSig, len(funcs))}
+	m.Function = &wasm.SectionFunctions{}
+	m.Code = &wasm.SectionCode{}
+	m.Export = &wasm.SectionExports{Entries: map[string]wasm.ExportEntry{}}
+	m.FunctionIndexSpace = make([]wasm.Function, len(funcs))
+
+	for i, f := range funcs {
+		m.Types.Entries[i] = wasm.FunctionSig{
+			Form:        0x60,
+			ParamTypes:  f.params,
+			ReturnTypes: f.results,
+		}
+		fn := wasm.Function{
+			Sig:  &m.Types.Entries[i],
+			Name: f.name,
+		}
+		if f.host != nil {
+			fn.Host = reflect.ValueOf(f.host)
+			fn.Body = &wasm.FunctionBody{}
+		} else {
+			fn.Body = &wasm.FunctionBody{
+				Module: m,
+				Locals: f.locals,
+				Code:   f.code,
+			}
+			m.Function.Types = append(m.Function.Types, uint32(i))
+			m.Code.Bodies = append(m.Code.Bodies, *fn.Body)
+		}
+		m.FunctionIndexSpace[i] = fn
+		if f.name != "" {
+			m.Export.Entries[f.name] = wasm.ExportEntry{
+				FieldStr: f.name,
+				Kind:     wasm.ExternalFunction,
+				Index:    uint32(i),
+			}
+		}
+	}
+	return m
+}
+
+// countdownLoop is the body of a (func (param i32)) counting its argument
+// down to zero. Each iteration executes 5 operations.
+var countdownLoop = []byte{
+	ops.Loop, 0x40,
+	ops.GetLocal, 0x00,
+	ops.I32Const, 0x01,
+	ops.I32Sub,
+	ops.TeeLocal, 0x00,
+	ops.BrIf, 0x00,
+	ops.End,
+}
+
+// trapCause returns the trap error value err wraps, when it's a TrapError,
+// for comparing the errors returned by runs with RecoverPanic set against.
+// The trap error values wrapping another, such as an OutOfBoundsError, are
+// unwrapped too.
+func trapCause(err error) error {
+	for {
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return err
+		}
+		err = u.Unwrap()
+	}
+}
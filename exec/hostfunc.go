@@ -0,0 +1,135 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// UnknownImportError is returned by RegisterHostFunc when the module
+// doesn't import a host function with the given names.
+type UnknownImportError struct {
+	Module, Field string // Names the function was to be registered with
+}
+
+func (e UnknownImportError) Error() string {
+	return fmt.Sprintf("exec: no host function imported as %s.%s", e.Module, e.Field)
+}
+
+// HostFuncTypeError is returned by RegisterHostFunc when the Go function
+// doesn't have the signature the module imports the host function with.
+type HostFuncTypeError struct {
+	Module, Field string            // Names the function was imported with
+	Sig           *wasm.FunctionSig // Signature the function was imported with
+	Type          reflect.Type      // Type of the Go function, or nil when it's not a func
+}
+
+func (e HostFuncTypeError) Error() string {
+	return fmt.Sprintf("exec: host function %s.%s of type %v doesn't match its signature %v", e.Module, e.Field, e.Type, e.Sig)
+}
+
+// processType is the type of the first argument of the host functions.
+var processType = reflect.TypeOf((*Process)(nil))
+
+// RegisterHostFunc binds the host function the VM's module imports as
+// module.name to the Go function fn, which is called with a *Process and
+// the function's arguments, returning its results, as for the host
+// functions the imports are resolved to when reading the module. Each
+// argument and result is an int32 or uint32 for an i32, an int64 or uint64
+// for an i64, a float32 for an f32 and a float64 for an f64.
+//
+// The import must have been resolved to a host function when reading the
+// module, such as to one which isn't bound to a Go function, being a nil
+// func value (see ErrUnboundImport), which RegisterHostFunc binds for this
+// VM only. It's to be called before running the functions calling it, so
+// not by the start function, which NewVM runs. An UnknownImportError is
+// returned when the module imports no such host function, and a
+// HostFuncTypeError when fn doesn't have its signature.
+func (vm *VM) RegisterHostFunc(module, name string, fn interface{}) error {
+	index := vm.importedFuncIndex(module, name)
+	if index < 0 {
+		return UnknownImportError{Module: module, Field: name}
+	}
+	if _, ok := vm.funcs[index].(goFunction); !ok {
+		return UnknownImportError{Module: module, Field: name}
+	}
+
+	sig := vm.module.FunctionIndexSpace[index].Sig
+	val := reflect.ValueOf(fn)
+	if val.Kind() != reflect.Func || val.IsNil() || !hostFuncMatches(val.Type(), sig) {
+		err := HostFuncTypeError{Module: module, Field: name, Sig: sig}
+		if fn != nil {
+			err.Type = val.Type()
+		}
+		return err
+	}
+
+	vm.funcs[index] = goFunction{val: val, typ: val.Type()}
+	return nil
+}
+
+// importedFuncIndex returns the index in the function index space of the
+// function imported as module.name, or -1 when there's none.
+func (vm *VM) importedFuncIndex(module, name string) int {
+	if vm.module.Import == nil {
+		return -1
+	}
+	// Imported functions come first in the function index space
+	n := 0
+	for _, entry := range vm.module.Import.Entries {
+		if entry.Type.Kind() != wasm.ExternalFunction {
+			continue
+		}
+		if entry.ModuleName == module && entry.FieldName == name {
+			if n >= len(vm.funcs) {
+				return -1
+			}
+			return n
+		}
+		n++
+	}
+	return -1
+}
+
+// hostFuncMatches returns whether a Go function of type typ can be called
+// as a host function with the signature sig.
+func hostFuncMatches(typ reflect.Type, sig *wasm.FunctionSig) bool {
+	if typ.IsVariadic() || typ.NumIn() != len(sig.ParamTypes)+1 || typ.NumOut() != len(sig.ReturnTypes) {
+		return false
+	}
+	if typ.In(0) != processType {
+		return false
+	}
+	for i, t := range sig.ParamTypes {
+		if !kindMatches(typ.In(i+1).Kind(), t) {
+			return false
+		}
+	}
+	for i, t := range sig.ReturnTypes {
+		if !kindMatches(typ.Out(i).Kind(), t) {
+			return false
+		}
+	}
+	return true
+}
+
+// kindMatches returns whether a Go value of the given kind can hold a
+// value of the wasm type t, as an argument or result of a host function.
+func kindMatches(kind reflect.Kind, t wasm.ValueType) bool {
+	switch t {
+	case wasm.ValueTypeI32:
+		return kind == reflect.Int32 || kind == reflect.Uint32
+	case wasm.ValueTypeI64:
+		return kind == reflect.Int64 || kind == reflect.Uint64
+	case wasm.ValueTypeF32:
+		return kind == reflect.Float32
+	case wasm.ValueTypeF64:
+		return kind == reflect.Float64
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestRegisterHostFunc(t *testing.T) {
+	m := buildTestModule(
+		testFunc{
+			params:  []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32},
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			host:    (func(*Process, int32, int32) int32)(nil),
+		},
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code:    []byte{ops.I32Const, 0x02, ops.I32Const, 0x03, ops.Call, 0x00},
+		},
+	)
+	m.Import = &wasm.SectionImports{Entries: []wasm.ImportEntry{
+		{ModuleName: "env", FieldName: "add", Type: wasm.FuncImport{Type: 0}},
+	}}
+
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+
+	for _, tc := range []struct {
+		name          string
+		module, field string
+		fn            interface{}
+		err           error
+	}{
+		{"unknown import", "env", "sub", func(*Process, int32, int32) int32 { return 0 }, UnknownImportError{Module: "env", Field: "sub"}},
+		{"not a func", "env", "add", 1, HostFuncTypeError{Module: "env", Field: "add", Sig: m.FunctionIndexSpace[0].Sig, Type: reflect.TypeOf(1)}},
+		{"nil", "env", "add", nil, HostFuncTypeError{Module: "env", Field: "add", Sig: m.FunctionIndexSpace[0].Sig}},
+		{"no process", "env", "add", func(int32, int32) int32 { return 0 }, HostFuncTypeError{Module: "env", Field: "add", Sig: m.FunctionIndexSpace[0].Sig, Type: reflect.TypeOf(func(int32, int32) int32 { return 0 })}},
+		{"wrong result", "env", "add", func(*Process, int32, int32) int64 { return 0 }, HostFuncTypeError{Module: "env", Field: "add", Sig: m.FunctionIndexSpace[0].Sig, Type: reflect.TypeOf(func(*Process, int32, int32) int64 { return 0 })}},
+	} {
+		if err := vm.RegisterHostFunc(tc.module, tc.field, tc.fn); err != tc.err {
+			t.Errorf("%s: got error %v, want %v", tc.name, err, tc.err)
+		}
+	}
+	if _, err = vm.ExecCode(1); trapCause(err) != (ErrUnboundImport{Module: "env", Field: "add"}) {
+		t.Errorf("got error %v calling the import before registering it, want it unbound", err)
+	}
+
+	add := func(proc *Process, a, b int32) int32 { return a + b }
+	if err = vm.RegisterHostFunc("env", "add", add); err != nil {
+		t.Fatalf("could not register host function: %v", err)
+	}
+	res, err := vm.ExecCode(1)
+	if err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if res != uint32(5) {
+		t.Errorf("got %v, want 5", res)
+	}
+
+	// Only this VM's import is bound
+	other, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	other.RecoverPanic = true
+	if _, err = other.ExecCode(1); trapCause(err) != (ErrUnboundImport{Module: "env", Field: "add"}) {
+		t.Errorf("got error %v calling the import of another VM, want it unbound", err)
+	}
+}
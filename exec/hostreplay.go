@@ -0,0 +1,77 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import "fmt"
+
+// HostCall is a call to a host function recorded by a HostCallRecorder. The
+// arguments and results are the raw values on the VM's stack.
+type HostCall struct {
+	FuncIndex int64 // Index of the host function in the function index space
+	Args      []uint64
+	Results   []uint64
+}
+
+// HostCallRecorder records the calls the VM makes to host functions, set up
+// with WithHostCallRecorder. The recording can then be used to replay the
+// calls in a later run, with WithHostReplay, so host functions returning
+// something different each time, such as the time of day, return the same
+// values again.
+//
+// A HostCallRecorder isn't safe for concurrent use, so it shouldn't be
+// shared by VMs running at the same time.
+type HostCallRecorder struct {
+	Calls []HostCall
+
+	next int // Index of the next call to replay
+}
+
+// HostReplayError is the error value used while trapping the VM when a
+// replayed run calls a host function other than the one recorded, with
+// other arguments, or after all of the recorded calls have been replayed.
+type HostReplayError struct {
+	Call      int   // Index of the call in the recording
+	FuncIndex int64 // Host function called by the replayed run
+	Args      []uint64
+}
+
+func (e HostReplayError) Error() string {
+	return fmt.Sprintf("exec: host call %d, to function %d with arguments %v, doesn't match the recording", e.Call, e.FuncIndex, e.Args)
+}
+
+// TrapKind returns TrapHostReplayMismatch.
+func (e HostReplayError) TrapKind() TrapKind {
+	return TrapHostReplayMismatch
+}
+
+// replayHostCall pops the arguments of a call to the host function at index
+// fnIndex, and pushes the recorded results in place of calling it.
+func (vm *VM) replayHostCall(fnIndex int64, numArgs int) {
+	args := make([]uint64, numArgs)
+	for i := numArgs - 1; i >= 0; i-- {
+		args[i] = vm.popUint64()
+	}
+
+	rec := vm.hostReplay
+	if rec.next >= len(rec.Calls) || !rec.Calls[rec.next].matches(fnIndex, args) {
+		panic(HostReplayError{Call: rec.next, FuncIndex: fnIndex, Args: args})
+	}
+	for _, res := range rec.Calls[rec.next].Results {
+		vm.pushUint64(res)
+	}
+	rec.next++
+}
+
+func (c HostCall) matches(fnIndex int64, args []uint64) bool {
+	if c.FuncIndex != fnIndex || len(c.Args) != len(args) {
+		return false
+	}
+	for i := range args {
+		if c.Args[i] != args[i] {
+			return false
+		}
+	}
+	return true
+}
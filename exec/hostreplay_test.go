@@ -0,0 +1,72 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestHostReplay(t *testing.T) {
+	var calls int32
+	m := buildTestModule(
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code:    []byte{ops.I32Const, 0x05, ops.Call, 0x01},
+		},
+		testFunc{
+			params:  []wasm.ValueType{wasm.ValueTypeI32},
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			host: func(proc *Process, v int32) int32 {
+				// Something different on each call
+				calls++
+				return v * calls
+			},
+		},
+	)
+
+	rec := &HostCallRecorder{}
+	vm, err := NewVM(m, WithHostCallRecorder(rec))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	res, err := vm.ExecCode(0)
+	if err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if res != uint32(5) {
+		t.Fatalf("got result %v when recording, want 5", res)
+	}
+	if len(rec.Calls) != 1 || rec.Calls[0].FuncIndex != 1 {
+		t.Fatalf("got recorded calls %v, want a call to function 1", rec.Calls)
+	}
+
+	vm, err = NewVM(m, WithHostReplay(rec))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	res, err = vm.ExecCode(0)
+	if err != nil {
+		t.Fatalf("error replaying function: %v", err)
+	}
+	if res != uint32(5) {
+		t.Errorf("got result %v when replaying, want the recorded 5", res)
+	}
+	if calls != 1 {
+		t.Errorf("host function called %d times, want it called only when recording", calls)
+	}
+
+	// There's no recorded call left for another run
+	if _, err = vm.ExecCode(0); err == nil {
+		t.Error("expected an error replaying more calls than recorded")
+	} else if te, ok := err.(*TrapError); !ok || te.TrapKind() != TrapHostReplayMismatch {
+		t.Errorf("got error %v, want a trap with a HostReplayError", err)
+	} else if _, ok := te.Err.(HostReplayError); !ok || te.FuncIndex != 0 {
+		t.Errorf("got trap %v in function %d, want a HostReplayError in function 0", te.Err, te.FuncIndex)
+	}
+}
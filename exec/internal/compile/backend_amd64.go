@@ -96,6 +96,10 @@ type currentInstruction struct {
 func (b *AMD64Backend) Scanner() *scanner {
 	if b.s == nil {
 		b.s = &scanner{
+			// The signed remainders are left to the interpreter, as
+			// IDIV traps on the minimum integer % -1, which is 0. So
+			// are the float min and max, as MINSD and friends don't
+			// propagate NaNs, or order -0 before +0.
 			supportedOpcodes: map[byte]bool{
 				ops.Drop:              true,
 				ops.Select:            true,
@@ -129,8 +133,6 @@ func (b *AMD64Backend) Scanner() *scanner {
 				ops.I32DivS:           true,
 				ops.I64RemU:           true,
 				ops.I32RemU:           true,
-				ops.I64RemS:           true,
-				ops.I32RemS:           true,
 				ops.GetLocal:          true,
 				ops.SetLocal:          true,
 				ops.GetGlobal:         true,
@@ -153,10 +155,6 @@ func (b *AMD64Backend) Scanner() *scanner {
 				ops.F32Div:            true,
 				ops.F64Mul:            true,
 				ops.F32Mul:            true,
-				ops.F64Min:            true,
-				ops.F32Min:            true,
-				ops.F64Max:            true,
-				ops.F32Max:            true,
 				ops.F64Eq:             true,
 				ops.F32Eq:             true,
 				ops.F64Ne:             true,
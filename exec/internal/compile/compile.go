@@ -153,6 +153,11 @@ type BytecodeMetadata struct {
 	// avoid generating native code which has an inbound
 	// jump target somewhere deep inside.
 	InboundTargets map[int64]struct{}
+
+	// MemoryIndexes is set when the function accesses memories other than
+	// the first, in which case the offset immediate of each load and store
+	// is followed by the (uint32) index of the memory accessed.
+	MemoryIndexes bool
 }
 
 // Compile rewrites WebAssembly bytecode from its disassembly.
@@ -176,6 +181,8 @@ func Compile(disassembly []disasm.Instr) ([]byte, *BytecodeMetadata) {
 		})
 	}
 
+	memoryIndexes := accessesOtherMemories(disassembly)
+
 	blocks[-1] = &block{}
 	for _, instr := range disassembly {
 		if instr.Unreachable {
@@ -185,8 +192,17 @@ func Compile(disassembly []disasm.Instr) ([]byte, *BytecodeMetadata) {
 		case ops.I32Load, ops.I64Load, ops.F32Load, ops.F64Load, ops.I32Load8s, ops.I32Load8u, ops.I32Load16s, ops.I32Load16u, ops.I64Load8s, ops.I64Load8u, ops.I64Load16s, ops.I64Load16u, ops.I64Load32s, ops.I64Load32u, ops.I32Store, ops.I64Store, ops.F32Store, ops.F64Store, ops.I32Store8, ops.I32Store16, ops.I64Store8, ops.I64Store16, ops.I64Store32:
 			// memory_immediate has two fields, the alignment and the offset.
 			// The former is simply an optimization hint and can be safely
-			// discarded.
-			instr.Immediates = []interface{}{instr.Immediates[1].(uint32)}
+			// discarded. The index of the memory accessed, if any, is only
+			// kept when the function accesses memories other than the first.
+			imms := []interface{}{instr.Immediates[1].(uint32)}
+			if memoryIndexes {
+				var index uint32
+				if len(instr.Immediates) > 2 {
+					index = instr.Immediates[2].(uint32)
+				}
+				imms = append(imms, index)
+			}
+			instr.Immediates = imms
 		case ops.If:
 			curBlockDepth++
 			emitMetadata(OpJmpZ, buffer.Len(), instAndInt64Len)
@@ -383,9 +399,23 @@ func Compile(disassembly []disasm.Instr) ([]byte, *BytecodeMetadata) {
 		BranchTables:   branchTables,
 		Instructions:   metadata,
 		InboundTargets: inboundTargets,
+		MemoryIndexes:  memoryIndexes,
 	}
 }
 
+// accessesOtherMemories returns whether any of the loads and stores of the
+// disassembly access a memory other than the first.
+func accessesOtherMemories(disassembly []disasm.Instr) bool {
+	for _, instr := range disassembly {
+		if op := instr.Op.Code; op >= ops.I32Load && op <= ops.I64Store32 && len(instr.Immediates) > 2 {
+			if instr.Immediates[2].(uint32) != 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // replace the address starting at start with addr
 func patchOffset(code []byte, start int64, addr int64, inboundTargets map[int64]struct{}) *bytes.Buffer {
 	inboundTargets[addr] = struct{}{}
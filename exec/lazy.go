@@ -0,0 +1,45 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+// WithLazyCompile makes NewVM leave compiling each function of the module
+// until it's first called, whether by ExecCode, a call or a call_indirect,
+// rather than compiling them all up front, which is wasted on the functions
+// of large modules that never run. The compiled functions are kept for the
+// later calls. A function failing to compile then fails the call to it, as
+// a trap would, rather than NewVM, so ValidateModule is worth calling first
+// for modules which can't be trusted. The functions compiled lazily aren't
+// compiled to native code with EnableAOT. It has no effect on the VMs
+// instantiated from a CompiledModule, which has its functions compiled.
+func WithLazyCompile(v bool) VMOption {
+	return func(c *config) {
+		c.LazyCompile = v
+	}
+}
+
+// lazyFunction stands for a function of the module which is still to be
+// compiled, with WithLazyCompile.
+type lazyFunction struct{}
+
+func (lazyFunction) call(vm *VM, index int64) {
+	if err := vm.compileLazy(index); err != nil {
+		panic(err)
+	}
+	vm.funcs[index].call(vm, index)
+}
+
+// compileLazy compiles the function at the given index, when it's still to
+// be compiled, with WithLazyCompile.
+func (vm *VM) compileLazy(index int64) error {
+	if _, ok := vm.funcs[index].(lazyFunction); !ok {
+		return nil
+	}
+	compiled, err := compileFunction(vm.module, vm.module.FunctionIndexSpace[index], memoryCount(vm.module))
+	if err != nil {
+		return err
+	}
+	vm.funcs[index] = compiled
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestLazyCompile(t *testing.T) {
+	i32 := []wasm.ValueType{wasm.ValueTypeI32}
+	m := buildTestModule(
+		// Adds the results of calling function 1, and function 2 through
+		// the table
+		testFunc{results: i32, code: []byte{
+			ops.Call, 0x01,
+			ops.I32Const, 0x00, ops.CallIndirect, 0x01, 0x00,
+			ops.I32Add,
+		}},
+		testFunc{results: i32, code: []byte{ops.I32Const, 0x02}},
+		testFunc{results: i32, code: []byte{ops.I32Const, 0x28}},
+		// Never called, and doesn't compile, calling a missing function
+		testFunc{code: []byte{ops.Call, 0x09}},
+	)
+	m.TableIndexSpace = [][]uint32{{2}}
+
+	vm, err := NewVM(m, WithLazyCompile(true))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	for i, fn := range vm.funcs {
+		if _, ok := fn.(lazyFunction); !ok {
+			t.Errorf("function %d compiled before being called", i)
+		}
+	}
+
+	// Run twice, the second time with the functions compiled
+	for run := 0; run < 2; run++ {
+		if res, err := vm.ExecCode(0); err != nil || res != uint32(42) {
+			t.Fatalf("run %d: got %v and error %v, want 42", run, res, err)
+		}
+	}
+	for i, fn := range vm.funcs {
+		_, compiled := fn.(compiledFunction)
+		if want := i != 3; compiled != want {
+			t.Errorf("function %d compiled: %v, want %v", i, compiled, want)
+		}
+	}
+
+	if _, err := vm.ExecCode(3); err != wasm.InvalidFunctionIndexError(9) {
+		t.Errorf("got error %v calling a function which doesn't compile, want %v", err, wasm.InvalidFunctionIndexError(9))
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"math"
+	"reflect"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// Linker links modules together, resolving the imports of the modules it
+// instantiates against the exports of the VMs defined in it, under the
+// module names they're imported from.
+//
+// An imported function is called as a host function, which runs the
+// exported function on the VM exporting it, with ExecCode, failing the run
+// calling it with the error, as Process.Fail does, when that fails. An
+// imported global gets the value the exported one has when the importing
+// module is instantiated.
+//
+// An imported memory is shared with the VM exporting it, so what either VM
+// stores in it is seen by the other. The importing module's data segments
+// are copied into it when it's instantiated, and it's at least as large as
+// it was when exported. Replacing the memory of one of the VMs sharing it,
+// by growing it, with SwapMemory, Restore or Restart, replaces it for all of
+// them. As for the VMs calling each other, the VMs sharing a memory mustn't
+// be run concurrently. A clone of one of them gets a copy of the memory.
+type Linker struct {
+	instances map[string]*VM
+}
+
+// NewLinker returns a Linker with no VMs defined in it.
+func NewLinker() *Linker {
+	return &Linker{instances: make(map[string]*VM)}
+}
+
+// Define makes the exports of vm importable from the module name name,
+// replacing any VM defined under that name already.
+func (l *Linker) Define(name string, vm *VM) {
+	l.instances[name] = vm
+}
+
+// Instantiate creates a new VM from the given module and options, as NewVM
+// does, with its imports resolved against the VMs defined in the linker,
+// and defines it under name, so the modules instantiated later can import
+// its exports. Options given with WithImportResolver are overridden.
+func (l *Linker) Instantiate(name string, module *wasm.Module, opts ...VMOption) (*VM, error) {
+	opts = append(opts[:len(opts):len(opts)], WithImportResolver(l.resolve))
+	if exporter := l.memoryExporter(module); exporter != nil {
+		opts = append(opts, func(c *config) {
+			c.SharedMemory = exporter
+		})
+	}
+	vm, err := NewVM(module, opts...)
+	if err != nil {
+		return nil, err
+	}
+	l.Define(name, vm)
+	return vm, nil
+}
+
+// resolve resolves an import against the exports of the VMs defined in the
+// linker, as for WithImportResolver.
+func (l *Linker) resolve(module, field string) (interface{}, bool) {
+	vm, ok := l.instances[module]
+	if !ok || vm.module.Export == nil {
+		return nil, false
+	}
+	export, ok := vm.module.Export.Entries[field]
+	if !ok {
+		return nil, false
+	}
+
+	index := int(export.Index)
+	switch export.Kind {
+	case wasm.ExternalFunction:
+		if fn := vm.module.GetFunction(index); fn != nil {
+			return vm.linkedFunc(int64(index), fn.Sig), true
+		}
+	case wasm.ExternalGlobal:
+		if global := vm.module.GetGlobal(index); global != nil && index < len(vm.globals) {
+			return globalValue(global.Type.Type, vm.globals[index])
+		}
+	case wasm.ExternalMemory:
+		if index == 0 && vm.memory != nil {
+			return vm.memory, true
+		}
+	}
+	return nil, false
+}
+
+// memoryExporter returns the VM defined in the linker exporting the memory
+// the module imports, if any.
+func (l *Linker) memoryExporter(module *wasm.Module) *VM {
+	if module.Import == nil {
+		return nil
+	}
+	for _, entry := range module.Import.Entries {
+		if entry.Type.Kind() == wasm.ExternalMemory {
+			return l.instances[entry.ModuleName]
+		}
+	}
+	return nil
+}
+
+// memoryShare is a group of VMs sharing their linear memory, the first one
+// of modules with more than one, as linked by a Linker.
+type memoryShare struct {
+	vms []*VM
+}
+
+// shareMemory makes the VM share its linear memory with exporter, the VM
+// exporting the memory it imports, which uses the VM's memory from then on.
+func (vm *VM) shareMemory(exporter *VM) {
+	if exporter.memShare == nil {
+		exporter.memShare = &memoryShare{vms: []*VM{exporter}}
+	}
+	vm.memShare = exporter.memShare
+	vm.memShare.vms = append(vm.memShare.vms, vm)
+	vm.syncMemory()
+}
+
+// unshareMemory takes the VM out of the group of VMs sharing its linear
+// memory, such as when it fails to be instantiated.
+func (vm *VM) unshareMemory() {
+	if vm.memShare == nil {
+		return
+	}
+	vms := vm.memShare.vms
+	for i, other := range vms {
+		if other == vm {
+			vm.memShare.vms = append(vms[:i:i], vms[i+1:]...)
+			break
+		}
+	}
+	vm.memShare = nil
+}
+
+// syncMemory hands the linear memory of the VM on to the VMs sharing it,
+// once it's been replaced, such as by growing it.
+func (vm *VM) syncMemory() {
+	if vm.memShare == nil {
+		return
+	}
+	for _, other := range vm.memShare.vms {
+		if other != vm {
+			other.memory = vm.memory
+			other.recordMemoryPeak()
+		}
+	}
+}
+
+// linkedFunc returns a Go function, to be called as a host function with
+// the signature sig, running the function at index on the VM.
+func (vm *VM) linkedFunc(index int64, sig *wasm.FunctionSig) interface{} {
+	in := []reflect.Type{processType}
+	for _, t := range sig.ParamTypes {
+		in = append(in, goValueType(t))
+	}
+	out := make([]reflect.Type, len(sig.ReturnTypes))
+	for i, t := range sig.ReturnTypes {
+		out[i] = goValueType(t)
+	}
+
+	return reflect.MakeFunc(reflect.FuncOf(in, out, false), func(args []reflect.Value) []reflect.Value {
+		raw := make([]uint64, len(args)-1)
+		for i, arg := range args[1:] {
+			switch arg.Kind() {
+			case reflect.Int32:
+				raw[i] = uint64(uint32(arg.Int()))
+			case reflect.Int64:
+				raw[i] = uint64(arg.Int())
+			case reflect.Float32:
+				raw[i] = uint64(math.Float32bits(float32(arg.Float())))
+			case reflect.Float64:
+				raw[i] = math.Float64bits(arg.Float())
+			}
+		}
+
+		results := make([]reflect.Value, len(out))
+		rtrns, err := vm.ExecCodeMulti(index, raw...)
+		if err != nil {
+			args[0].Interface().(*Process).Fail(err)
+			for i, t := range out {
+				results[i] = reflect.Zero(t)
+			}
+			return results
+		}
+		for i, t := range out {
+			results[i] = reflect.ValueOf(rtrns[i]).Convert(t)
+		}
+		return results
+	}).Interface()
+}
+
+// goValueType returns the Go type of the values of the wasm type t, as
+// arguments and results of the host functions linked by a Linker.
+func goValueType(t wasm.ValueType) reflect.Type {
+	switch t {
+	case wasm.ValueTypeI64:
+		return reflect.TypeOf(int64(0))
+	case wasm.ValueTypeF32:
+		return reflect.TypeOf(float32(0))
+	case wasm.ValueTypeF64:
+		return reflect.TypeOf(float64(0))
+	}
+	return reflect.TypeOf(int32(0))
+}
+
+// globalValue returns the value of a global of type t, from its raw value,
+// as imported with WithImportResolver.
+func globalValue(t wasm.ValueType, raw uint64) (interface{}, bool) {
+	switch t {
+	case wasm.ValueTypeI32:
+		return int32(raw), true
+	case wasm.ValueTypeI64:
+		return int64(raw), true
+	case wasm.ValueTypeF32:
+		return math.Float32frombits(uint32(raw)), true
+	case wasm.ValueTypeF64:
+		return math.Float64frombits(raw), true
+	}
+	return nil, false
+}
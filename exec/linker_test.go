@@ -0,0 +1,159 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestLinker(t *testing.T) {
+	runtime := buildTestModule(
+		testFunc{
+			name:    "square",
+			params:  []wasm.ValueType{wasm.ValueTypeI32},
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code:    []byte{ops.GetLocal, 0x00, ops.GetLocal, 0x00, ops.I32Mul},
+		},
+		testFunc{
+			name:    "half",
+			params:  []wasm.ValueType{wasm.ValueTypeF32},
+			results: []wasm.ValueType{wasm.ValueTypeF32},
+			code:    []byte{ops.GetLocal, 0x00, ops.F32Const, 0x00, 0x00, 0x00, 0x3f, ops.F32Mul}, // * 0.5
+		},
+		testFunc{
+			name: "fail",
+			code: []byte{ops.Unreachable},
+		},
+	)
+
+	i32 := []wasm.ValueType{wasm.ValueTypeI32}
+	f32 := []wasm.ValueType{wasm.ValueTypeF32}
+	app := buildTestModule(
+		testFunc{params: i32, results: i32, host: (func(*Process, int32) int32)(nil)},
+		testFunc{params: f32, results: f32, host: (func(*Process, float32) float32)(nil)},
+		testFunc{host: (func(*Process))(nil)},
+		testFunc{
+			results: i32,
+			code: []byte{
+				ops.I32Const, 0x07,
+				ops.Call, 0x00,
+				ops.I32Const, 0x01,
+				ops.I32Add,
+			},
+		},
+		testFunc{
+			results: f32,
+			code:    []byte{ops.F32Const, 0x00, 0x00, 0x40, 0x40, ops.Call, 0x01}, // 3.0
+		},
+		testFunc{code: []byte{ops.Call, 0x02}},
+	)
+	app.Import = &wasm.SectionImports{Entries: []wasm.ImportEntry{
+		{ModuleName: "runtime", FieldName: "square", Type: wasm.FuncImport{Type: 0}},
+		{ModuleName: "runtime", FieldName: "half", Type: wasm.FuncImport{Type: 1}},
+		{ModuleName: "runtime", FieldName: "fail", Type: wasm.FuncImport{Type: 2}},
+	}}
+
+	l := NewLinker()
+	if _, err := l.Instantiate("app", app); err == nil {
+		t.Errorf("instantiated the app before the runtime it imports from")
+	}
+	if _, err := l.Instantiate("runtime", runtime, WithRecoverPanic(true)); err != nil {
+		t.Fatalf("could not instantiate the runtime: %v", err)
+	}
+	vm, err := l.Instantiate("app", app, WithRecoverPanic(true))
+	if err != nil {
+		t.Fatalf("could not instantiate the app: %v", err)
+	}
+
+	res, err := vm.ExecCode(3)
+	if err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if res != uint32(50) {
+		t.Errorf("got %v, want 50", res)
+	}
+	if res, err = vm.ExecCode(4); err != nil || res != float32(1.5) {
+		t.Errorf("got %v with error %v, want 1.5", res, err)
+	}
+	if _, err = vm.ExecCode(5); trapCause(err) != ErrUnreachable {
+		t.Errorf("got error %v calling a trapping import, want %v", err, ErrUnreachable)
+	}
+}
+
+func TestLinkerSharedMemory(t *testing.T) {
+	i32 := []wasm.ValueType{wasm.ValueTypeI32}
+	runtime := buildTestModule(
+		testFunc{name: "load", results: i32, code: []byte{ops.I32Const, 0x00, ops.I32Load, 0x02, 0x00}},
+		testFunc{name: "size", results: i32, code: []byte{ops.CurrentMemory, 0x00}},
+	)
+	runtime.Memory = &wasm.SectionMemories{Entries: []wasm.Memory{{Limits: wasm.ResizableLimits{Initial: 1}}}}
+	runtime.LinearMemoryIndexSpace = [][]byte{nil}
+	runtime.Export.Entries["memory"] = wasm.ExportEntry{FieldStr: "memory", Kind: wasm.ExternalMemory}
+
+	app := buildTestModule(
+		testFunc{params: i32, code: []byte{ops.I32Const, 0x00, ops.GetLocal, 0x00, ops.I32Store, 0x02, 0x00}},
+		testFunc{results: i32, code: []byte{ops.I32Const, 0x01, ops.GrowMemory, 0x00}},
+	)
+	app.Import = &wasm.SectionImports{Entries: []wasm.ImportEntry{
+		{ModuleName: "runtime", FieldName: "memory", Type: wasm.MemoryImport{Type: wasm.Memory{Limits: wasm.ResizableLimits{Initial: 1}}}},
+	}}
+	app.Data = &wasm.SectionData{Entries: []wasm.DataSegment{
+		{Offset: []byte{ops.I32Const, 0x08, ops.End}, Data: []byte{0x2a}},
+	}}
+	app.LinearMemoryIndexSpace = [][]byte{nil}
+
+	l := NewLinker()
+	rt, err := l.Instantiate("runtime", runtime)
+	if err != nil {
+		t.Fatalf("could not instantiate the runtime: %v", err)
+	}
+	vm, err := l.Instantiate("app", app)
+	if err != nil {
+		t.Fatalf("could not instantiate the app: %v", err)
+	}
+	if got := rt.Memory()[8]; got != 0x2a {
+		t.Errorf("got %#x at 8 in the runtime's memory, want the app's data segment 0x2a", got)
+	}
+
+	load := func() interface{} {
+		res, err := rt.ExecCode(0)
+		if err != nil {
+			t.Fatalf("error executing function: %v", err)
+		}
+		return res
+	}
+	if _, err = vm.ExecCode(0, 0x1234); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if res := load(); res != uint32(0x1234) {
+		t.Errorf("got %#x stored by the app, want 0x1234", res)
+	}
+
+	// Growing the memory from the app grows it for the runtime too
+	if res, err := vm.ExecCode(1); err != nil || res != uint32(1) {
+		t.Fatalf("got %v with error %v growing the memory, want 1", res, err)
+	}
+	if res, err := rt.ExecCode(1); err != nil || res != uint32(2) {
+		t.Errorf("got %v with error %v as the runtime's memory size, want 2", res, err)
+	}
+	if _, err = vm.ExecCode(0, 0x5678); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if res := load(); res != uint32(0x5678) {
+		t.Errorf("got %#x stored by the app after growing the memory, want 0x5678", res)
+	}
+
+	// A clone has a copy of the memory
+	c := rt.Clone()
+	if _, err = vm.ExecCode(0, 0x9abc); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if res, err := c.ExecCode(0); err != nil || res != uint32(0x5678) {
+		t.Errorf("got %v with error %v from the clone, want 0x5678", res, err)
+	}
+}
@@ -5,29 +5,142 @@
 package exec
 
 import (
-	"errors"
+	"fmt"
 	"math"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+var (
+	// ErrOutOfBoundsMemoryAccess is the error value used while trapping the VM
+	// when it detects an out of bounds access to the linear memory.
+	ErrOutOfBoundsMemoryAccess = newTrap(TrapOutOfBoundsMemoryAccess, "exec: out of bounds memory access")
+	// ErrReservedByteNotZero is the error value used while trapping the VM,
+	// when enabled with the StrictReservedBytes option, when a reserved
+	// byte of a current_memory, grow_memory, memory.copy or memory.fill
+	// operator isn't zero.
+	ErrReservedByteNotZero = newTrap(TrapReservedByteNotZero, "exec: reserved byte not zero")
 )
 
-// ErrOutOfBoundsMemoryAccess is the error value used while trapping the VM
-// when it detects an out of bounds access to the linear memory.
-var ErrOutOfBoundsMemoryAccess = errors.New("exec: out of bounds memory access")
+// OutOfBoundsError is the error value used while trapping the VM when a
+// load or store accesses the linear memory out of bounds, giving the access
+// that faulted. It's a Trap of the same kind as ErrOutOfBoundsMemoryAccess,
+// which Unwrap returns.
+type OutOfBoundsError struct {
+	Addr    uint64 // Effective address accessed, from the offset and the base address
+	Size    int    // Width of the access, in bytes
+	MemSize int    // Length of the linear memory accessed, in bytes
+}
+
+func (e OutOfBoundsError) Error() string {
+	return fmt.Sprintf("%v, of %d bytes at %#x in %d bytes of memory", ErrOutOfBoundsMemoryAccess, e.Size, e.Addr, e.MemSize)
+}
+
+// Unwrap returns ErrOutOfBoundsMemoryAccess.
+func (e OutOfBoundsError) Unwrap() error {
+	return ErrOutOfBoundsMemoryAccess
+}
+
+// TrapKind returns TrapOutOfBoundsMemoryAccess.
+func (e OutOfBoundsError) TrapKind() TrapKind {
+	return TrapOutOfBoundsMemoryAccess
+}
+
+// memoryAt returns the linear memory with the given index. The first
+// memory is vm.memory, and the others, of modules with more than one, are
+// kept in vm.memories.
+func (vm *VM) memoryAt(index uint32) []byte {
+	if index == 0 {
+		return vm.memory
+	}
+	return vm.memories[index-1]
+}
+
+// copyMemories returns a copy of the given linear memories, such as those
+// in vm.memories.
+func copyMemories(mems [][]byte) [][]byte {
+	if mems == nil {
+		return nil
+	}
+	c := make([][]byte, len(mems))
+	for i, mem := range mems {
+		c[i] = append([]byte(nil), mem...)
+	}
+	return c
+}
 
-func (vm *VM) fetchBaseAddr() int {
-	return int(vm.fetchUint32() + uint32(vm.popInt32()))
+// accessedMemory returns the linear memory accessed by the load or store
+// whose memory immediate is at the program counter, without fetching it.
+func (vm *VM) accessedMemory() []byte {
+	if !vm.ctx.memoryIndexes {
+		return vm.memory
+	}
+	return vm.memoryAt(endianess.Uint32(vm.ctx.code[vm.ctx.pc+4:]))
+}
+
+// fetchBaseAddr fetches the memory immediate of a load or store, returning
+// the linear memory it accesses, and the effective address accessed, from
+// the offset and the base address on the top of the stack.
+func (vm *VM) fetchBaseAddr() ([]byte, int) {
+	offset := vm.fetchUint32()
+	mem := vm.memory
+	if vm.ctx.memoryIndexes {
+		mem = vm.memoryAt(vm.fetchUint32())
+	}
+	return mem, int(uint64(offset) + uint64(vm.popUint32()))
 }
 
 // inBounds returns true when the next vm.fetchBaseAddr() + offset
-// indices are in bounds accesses to the linear memory.
+// indices are in bounds accesses to the linear memory accessed. As every
+// load and store checks its bounds, the accesses are also recorded here
+// when profiling memory use.
 func (vm *VM) inBounds(offset int) bool {
-	addr := endianess.Uint32(vm.ctx.code[vm.ctx.pc:]) + uint32(vm.ctx.stack[len(vm.ctx.stack)-1])
-	return int(addr)+offset < len(vm.memory)
+	addr := vm.accessedAddr()
+	ok := addr+uint64(offset) < uint64(len(vm.accessedMemory()))
+	if ok && vm.memProfile != nil {
+		vm.memProfile.record(vm.ctx.code[vm.ctx.pc-1], addr, uint64(offset+1))
+	}
+	return ok
+}
+
+// accessedAddr returns the effective address accessed by the load or store
+// whose memory immediate is at the program counter, without fetching it.
+func (vm *VM) accessedAddr() uint64 {
+	// The effective address is 33 bits wide, so it can't wrap around
+	return uint64(endianess.Uint32(vm.ctx.code[vm.ctx.pc:])) + uint64(uint32(vm.ctx.stack[len(vm.ctx.stack)-1]))
+}
+
+// outOfBounds returns the error to trap with when the next
+// vm.fetchBaseAddr() + offset indices aren't in bounds, as inBounds found.
+func (vm *VM) outOfBounds(offset int) error {
+	return OutOfBoundsError{Addr: vm.accessedAddr(), Size: offset + 1, MemSize: len(vm.accessedMemory())}
+}
+
+// fetchMemoryIndex fetches the byte of the current_memory, grow_memory,
+// memory.copy and memory.fill operators giving the index of the linear
+// memory operated on. For modules with more than one memory, an index past
+// them traps. For the others, it's a reserved byte which must be zero, and
+// is ignored when it isn't unless trapping with ErrReservedByteNotZero under
+// StrictReservedBytes.
+// (https://github.com/WebAssembly/design/blob/27ac254c854994103c24834a994be16f74f54186/BinaryEncoding.md#memory-related-operators-described-here)
+func (vm *VM) fetchMemoryIndex() uint32 {
+	index := uint32(uint8(vm.fetchInt8()))
+	switch {
+	case index == 0 || int(index) <= len(vm.memories):
+		return index
+	case vm.memories != nil:
+		panic(indexTrap{kind: TrapInvalidMemoryIndex, err: wasm.InvalidLinearMemoryIndexError(index)})
+	case vm.strictReserved:
+		panic(ErrReservedByteNotZero)
+	}
+	return 0
 }
 
 // curMem returns a slice to the memory segment pointed to by
 // the current base address on the bytecode stream.
 func (vm *VM) curMem() []byte {
-	return vm.memory[vm.fetchBaseAddr():]
+	mem, addr := vm.fetchBaseAddr()
+	return mem[addr:]
 }
 
 func (vm *VM) i32Load() {
@@ -35,15 +148,17 @@ func (vm *VM) i32Load() {
 
 	// The operation we're logging
 	if !vm.inBounds(3) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(3))
 	}
-	addr := vm.fetchBaseAddr()
-	val := endianess.Uint32(vm.memory[addr:])
+	mem, addr := vm.fetchBaseAddr()
+	val := endianess.Uint32(mem[addr:])
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x28, "i32 load", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x28, "i32 load", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Load8s() {
@@ -51,15 +166,17 @@ func (vm *VM) i32Load8s() {
 
 	// The operation we're logging
 	if !vm.inBounds(0) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(0))
 	}
-	addr := vm.fetchBaseAddr()
-	val := int32(int8(vm.memory[addr]))
+	mem, addr := vm.fetchBaseAddr()
+	val := int32(int8(mem[addr]))
 	vm.pushInt32(val)
 
 	// Log this operation
-	opLog(vm, 0x2C, "i32 load 8-bit signed", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x2C, "i32 load 8-bit signed", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Load8u() {
@@ -67,15 +184,17 @@ func (vm *VM) i32Load8u() {
 
 	// The operation we're logging
 	if !vm.inBounds(0) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(0))
 	}
-	addr := vm.fetchBaseAddr()
-	val := uint32(uint8(vm.memory[addr]))
+	mem, addr := vm.fetchBaseAddr()
+	val := uint32(uint8(mem[addr]))
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x2D, "i32 load 8-bit unsigned", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x2D, "i32 load 8-bit unsigned", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Load16s() {
@@ -83,15 +202,17 @@ func (vm *VM) i32Load16s() {
 
 	// The operation we're logging
 	if !vm.inBounds(1) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(1))
 	}
 	addr := vm.curMem()
 	val := int32(int16(endianess.Uint16(addr)))
 	vm.pushInt32(val)
 
 	// Log this operation
-	opLog(vm, 0x2E, "i32 load 16-bit signed", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x2E, "i32 load 16-bit signed", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Load16u() {
@@ -99,15 +220,17 @@ func (vm *VM) i32Load16u() {
 
 	// The operation we're logging
 	if !vm.inBounds(1) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(1))
 	}
 	addr := vm.curMem()
 	val := uint32(endianess.Uint16(addr))
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x2F, "i32 load 16-bit unsigned", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x2F, "i32 load 16-bit unsigned", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Load() {
@@ -115,15 +238,17 @@ func (vm *VM) i64Load() {
 
 	// The operation we're logging
 	if !vm.inBounds(7) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(7))
 	}
-	addr := vm.fetchBaseAddr()
-	val := endianess.Uint64(vm.memory[addr:])
+	mem, addr := vm.fetchBaseAddr()
+	val := endianess.Uint64(mem[addr:])
 	vm.pushUint64(val)
 
 	// Log this operation
-	opLog(vm, 0x29, "i64 load", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x29, "i64 load", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Load8s() {
@@ -131,15 +256,17 @@ func (vm *VM) i64Load8s() {
 
 	// The operation we're logging
 	if !vm.inBounds(0) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(0))
 	}
-	addr := vm.fetchBaseAddr()
-	val := int64(int8(vm.memory[addr]))
+	mem, addr := vm.fetchBaseAddr()
+	val := int64(int8(mem[addr]))
 	vm.pushInt64(val)
 
 	// Log this operation
-	opLog(vm, 0x30, "i64 load 8-bit signed", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x30, "i64 load 8-bit signed", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Load8u() {
@@ -147,15 +274,17 @@ func (vm *VM) i64Load8u() {
 
 	// The operation we're logging
 	if !vm.inBounds(0) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(0))
 	}
-	addr := vm.fetchBaseAddr()
-	val := uint64(uint8(vm.memory[addr]))
+	mem, addr := vm.fetchBaseAddr()
+	val := uint64(uint8(mem[addr]))
 	vm.pushUint64(val)
 
 	// Log this operation
-	opLog(vm, 0x31, "i64 load 8-bit unsigned", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x31, "i64 load 8-bit unsigned", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Load16s() {
@@ -163,15 +292,17 @@ func (vm *VM) i64Load16s() {
 
 	// The operation we're logging
 	if !vm.inBounds(1) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(1))
 	}
-	addr := vm.fetchBaseAddr()
-	val := int64(int16(endianess.Uint16(vm.memory[addr:])))
+	mem, addr := vm.fetchBaseAddr()
+	val := int64(int16(endianess.Uint16(mem[addr:])))
 	vm.pushInt64(val)
 
 	// Log this operation
-	opLog(vm, 0x32, "i64 load 16-bit signed", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x32, "i64 load 16-bit signed", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Load16u() {
@@ -179,15 +310,17 @@ func (vm *VM) i64Load16u() {
 
 	// The operation we're logging
 	if !vm.inBounds(1) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(1))
 	}
-	addr := vm.fetchBaseAddr()
-	val := uint64(endianess.Uint16(vm.memory[addr:]))
+	mem, addr := vm.fetchBaseAddr()
+	val := uint64(endianess.Uint16(mem[addr:]))
 	vm.pushUint64(val)
 
 	// Log this operation
-	opLog(vm, 0x33, "i64 load 16-bit unsigned", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x33, "i64 load 16-bit unsigned", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Load32s() {
@@ -195,15 +328,17 @@ func (vm *VM) i64Load32s() {
 
 	// The operation we're logging
 	if !vm.inBounds(3) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(3))
 	}
-	addr := vm.fetchBaseAddr()
-	val := int64(int32(endianess.Uint32(vm.memory[addr:])))
+	mem, addr := vm.fetchBaseAddr()
+	val := int64(int32(endianess.Uint32(mem[addr:])))
 	vm.pushInt64(val)
 
 	// Log this operation
-	opLog(vm, 0x34, "i64 load 32-bit signed", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x34, "i64 load 32-bit signed", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Load32u() {
@@ -211,31 +346,35 @@ func (vm *VM) i64Load32u() {
 
 	// The operation we're logging
 	if !vm.inBounds(3) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(3))
 	}
-	addr := vm.fetchBaseAddr()
-	val := uint64(endianess.Uint32(vm.memory[addr:]))
+	mem, addr := vm.fetchBaseAddr()
+	val := uint64(endianess.Uint32(mem[addr:]))
 	vm.pushUint64(val)
 
 	// Log this operation
-	opLog(vm, 0x35, "i64 load 32-bit signed", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x35, "i64 load 32-bit signed", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Store() {
 	stackStart := vm.ctx.stack
 
 	// The operation we're logging
-	val := math.Float32bits(vm.popFloat32())
+	val := vm.popFloat32()
 	if !vm.inBounds(3) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(3))
 	}
-	addr := vm.fetchBaseAddr()
-	endianess.PutUint32(vm.memory[addr:], val)
+	mem, addr := vm.fetchBaseAddr()
+	endianess.PutUint32(mem[addr:], math.Float32bits(val))
 
 	// Log this operation
-	opLog(vm, 0x38, "f32 store", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x38, "f32 store", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Load() {
@@ -243,31 +382,35 @@ func (vm *VM) f32Load() {
 
 	// The operation we're logging
 	if !vm.inBounds(3) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(3))
 	}
-	addr := vm.fetchBaseAddr()
-	val := math.Float32frombits(endianess.Uint32(vm.memory[addr:]))
+	mem, addr := vm.fetchBaseAddr()
+	val := math.Float32frombits(endianess.Uint32(mem[addr:]))
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x2A, "f32 load", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x2A, "f32 load", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Store() {
 	stackStart := vm.ctx.stack
 
 	// The operation we're logging
-	v := math.Float64bits(vm.popFloat64())
+	val := vm.popFloat64()
 	if !vm.inBounds(7) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(7))
 	}
-	addr := vm.fetchBaseAddr()
-	endianess.PutUint64(vm.memory[addr:], v)
+	mem, addr := vm.fetchBaseAddr()
+	endianess.PutUint64(mem[addr:], math.Float64bits(val))
 
 	// Log this operation
-	opLog(vm, 0x38, "f64 store", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, v, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x39, "f64 store", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Load() {
@@ -275,15 +418,17 @@ func (vm *VM) f64Load() {
 
 	// The operation we're logging
 	if !vm.inBounds(7) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(7))
 	}
-	addr := vm.fetchBaseAddr()
-	val := math.Float64frombits(endianess.Uint64(vm.memory[addr:]))
+	mem, addr := vm.fetchBaseAddr()
+	val := math.Float64frombits(endianess.Uint64(mem[addr:]))
 	vm.pushFloat64(val)
 
 	// Log this operation
-	opLog(vm, 0x2B, "f64 load", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x2B, "f64 load", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Store() {
@@ -292,14 +437,16 @@ func (vm *VM) i32Store() {
 	// The operation we're logging
 	val := vm.popUint32()
 	if !vm.inBounds(3) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(3))
 	}
-	addr := vm.fetchBaseAddr()
-	endianess.PutUint32(vm.memory[addr:], val)
+	mem, addr := vm.fetchBaseAddr()
+	endianess.PutUint32(mem[addr:], val)
 
 	// Log this operation
-	opLog(vm, 0x36, "i32 store", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x36, "i32 store", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Store8() {
@@ -308,14 +455,16 @@ func (vm *VM) i32Store8() {
 	// The operation we're logging
 	val := byte(uint8(vm.popUint32()))
 	if !vm.inBounds(0) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(0))
 	}
-	addr := vm.fetchBaseAddr()
-	vm.memory[addr] = val
+	mem, addr := vm.fetchBaseAddr()
+	mem[addr] = val
 
 	// Log this operation
-	opLog(vm, 0x3A, "i32 store 8-bit", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x3A, "i32 store 8-bit", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Store16() {
@@ -324,14 +473,16 @@ func (vm *VM) i32Store16() {
 	// The operation we're logging
 	val := uint16(vm.popUint32())
 	if !vm.inBounds(1) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(1))
 	}
-	addr := vm.fetchBaseAddr()
-	endianess.PutUint16(vm.memory[addr:], val)
+	mem, addr := vm.fetchBaseAddr()
+	endianess.PutUint16(mem[addr:], val)
 
 	// Log this operation
-	opLog(vm, 0x3B, "i32 store 16-bit", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x3B, "i32 store 16-bit", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Store() {
@@ -340,14 +491,16 @@ func (vm *VM) i64Store() {
 	// The operation we're logging
 	val := vm.popUint64()
 	if !vm.inBounds(7) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(7))
 	}
-	addr := vm.fetchBaseAddr()
-	endianess.PutUint64(vm.memory[addr:], val)
+	mem, addr := vm.fetchBaseAddr()
+	endianess.PutUint64(mem[addr:], val)
 
 	// Log this operation
-	opLog(vm, 0x37, "i64 store", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x37, "i64 store", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Store8() {
@@ -356,14 +509,16 @@ func (vm *VM) i64Store8() {
 	// The operation we're logging
 	val := byte(uint8(vm.popUint64()))
 	if !vm.inBounds(0) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(0))
 	}
-	addr := vm.fetchBaseAddr()
-	vm.memory[addr] = val
+	mem, addr := vm.fetchBaseAddr()
+	mem[addr] = val
 
 	// Log this operation
-	opLog(vm, 0x3C, "i64 store 8-bit", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x3C, "i64 store 8-bit", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Store16() {
@@ -372,14 +527,16 @@ func (vm *VM) i64Store16() {
 	// The operation we're logging
 	val := uint16(vm.popUint64())
 	if !vm.inBounds(1) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(1))
 	}
-	addr := vm.fetchBaseAddr()
-	endianess.PutUint16(vm.memory[addr:], val)
+	mem, addr := vm.fetchBaseAddr()
+	endianess.PutUint16(mem[addr:], val)
 
 	// Log this operation
-	opLog(vm, 0x3D, "i64 store 16-bit", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x3D, "i64 store 16-bit", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Store32() {
@@ -388,40 +545,137 @@ func (vm *VM) i64Store32() {
 	// The operation we're logging
 	val := uint32(vm.popUint64())
 	if !vm.inBounds(3) {
-		panic(ErrOutOfBoundsMemoryAccess)
+		panic(vm.outOfBounds(3))
 	}
-	addr := vm.fetchBaseAddr()
-	endianess.PutUint32(vm.memory[addr:], val)
+	mem, addr := vm.fetchBaseAddr()
+	endianess.PutUint32(mem[addr:], val)
 
 	// Log this operation
-	opLog(vm, 0x3E, "i64 store 32-bit", []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, addr, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x3E, "i64 store 32-bit", memoryOpFields,
+			logData(vm.ctx.pc, addr, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) currentMemory() {
 	stackStart := vm.ctx.stack
 
 	// The operation we're logging
-	_ = vm.fetchInt8() // reserved (https://github.com/WebAssembly/design/blob/27ac254c854994103c24834a994be16f74f54186/BinaryEncoding.md#memory-related-operators-described-here)
-	val := int32(len(vm.memory) / wasmPageSize)
+	val := int32(len(vm.memoryAt(vm.fetchMemoryIndex())) / wasmPageSize)
 	vm.pushInt32(val)
 
 	// Log this operation
-	opLog(vm, 0x3F, "current memory size", []string{"program_counter", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x3F, "current memory size", valueOpFields,
+			logData(vm.ctx.pc, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) growMemory() {
 	stackStart := vm.ctx.stack
 
 	// The operation we're logging
-	_ = vm.fetchInt8() // reserved (https://github.com/WebAssembly/design/blob/27ac254c854994103c24834a994be16f74f54186/BinaryEncoding.md#memory-related-operators-described-here)
-	curLen := len(vm.memory) / wasmPageSize
+	index := vm.fetchMemoryIndex()
 	n := vm.popInt32()
-	vm.memory = append(vm.memory, make([]byte, n*wasmPageSize)...)
-	vm.pushInt32(int32(curLen))
+	vm.pushInt32(vm.growMemoryAt(index, n))
 
 	// Log this operation
-	opLog(vm, 0x40, "grow memory", []string{"program_counter", "modifier_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, n, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x40, "grow memory", []string{"program_counter", "modifier_value", "stack_start", "stack_finish"},
+			logData(vm.ctx.pc, n, stackStart, vm.ctx.stack))
+	}
+}
+
+// growMemoryBy grows the linear memory by n pages, returning its previous
+// size in pages, or -1 when it can't grow that much.
+func (vm *VM) growMemoryBy(n int32) int32 {
+	curLen := len(vm.memory) / wasmPageSize
+	if n < 0 || int64(curLen)+int64(n) > vm.memMaxPages {
+		// Growing past the maximum fails, leaving the memory as it is. As
+		// the page count is unsigned, a negative one is past it too.
+		return -1
+	}
+	vm.memory = append(vm.memory, make([]byte, int(n)*wasmPageSize)...)
+	vm.recordMemoryPeak()
+	vm.syncMemory()
+	return int32(curLen)
+}
+
+// growMemoryAt grows the linear memory with the given index by n pages, as
+// growMemoryBy does for the first one. The others can't grow past their
+// maximum in the module, nor the cap set with WithMaxMemoryPages.
+func (vm *VM) growMemoryAt(index uint32, n int32) int32 {
+	if index == 0 {
+		return vm.growMemoryBy(n)
+	}
+	maxPages := int64(maxMemoryPages)
+	if limits := vm.module.Memory.Entries[index].Limits; limits.Flags&0x1 != 0 {
+		maxPages = int64(limits.Maximum)
+	}
+	if vm.memCap != 0 && vm.memCap < maxPages {
+		maxPages = vm.memCap
+	}
+	mem := vm.memories[index-1]
+	curLen := len(mem) / wasmPageSize
+	if n < 0 || int64(curLen)+int64(n) > maxPages {
+		return -1
+	}
+	vm.memories[index-1] = append(mem, make([]byte, int(n)*wasmPageSize)...)
+	return int32(curLen)
+}
+
+// memoryRange checks that the n bytes of the linear memory mem from addr are
+// in bounds, recording them when profiling memory use.
+func (vm *VM) memoryRange(mem []byte, addr, n uint32, write bool) {
+	if uint64(addr)+uint64(n) > uint64(len(mem)) {
+		panic(ErrOutOfBoundsMemoryAccess)
+	}
+	if vm.memProfile != nil && n != 0 {
+		if write {
+			vm.memProfile.writes.add(uint64(addr), uint64(n))
+		} else {
+			vm.memProfile.reads.add(uint64(addr), uint64(n))
+		}
+	}
+}
+
+func (vm *VM) memoryCopy() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	dstMem := vm.memoryAt(vm.fetchMemoryIndex())
+	srcMem := vm.memoryAt(vm.fetchMemoryIndex())
+	n := vm.popUint32()
+	src := vm.popUint32()
+	dst := vm.popUint32()
+	vm.memoryRange(srcMem, src, n, false)
+	vm.memoryRange(dstMem, dst, n, true)
+	copy(dstMem[dst:dst+n], srcMem[src:src+n]) // copy handles overlapping ranges
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xFC, "memory copy", []string{"program_counter", "memory_address", "source_address", "length", "stack_start", "stack_finish"},
+			logData(vm.ctx.pc, dst, src, n, stackStart, vm.ctx.stack))
+	}
+}
+
+func (vm *VM) memoryFill() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	mem := vm.memoryAt(vm.fetchMemoryIndex())
+	n := vm.popUint32()
+	val := byte(vm.popUint32())
+	dst := vm.popUint32()
+	vm.memoryRange(mem, dst, n, true)
+	mem = mem[dst : dst+n]
+	for i := range mem {
+		mem[i] = val
+	}
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xFC, "memory fill", []string{"program_counter", "memory_address", "value", "length", "stack_start", "stack_finish"},
+			logData(vm.ctx.pc, dst, val, n, stackStart, vm.ctx.stack))
+	}
 }
@@ -0,0 +1,579 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// memoryTestModule returns a module with a single page of memory, holding
+// a function with the given body.
+func memoryTestModule(f testFunc) *wasm.Module {
+	m := buildTestModule(f)
+	m.Memory = &wasm.SectionMemories{Entries: []wasm.Memory{{Limits: wasm.ResizableLimits{Initial: 1}}}}
+	m.LinearMemoryIndexSpace = [][]byte{nil}
+	return m
+}
+
+func TestStrictReservedBytes(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		code []byte
+	}{
+		{"current_memory", []byte{ops.CurrentMemory, 0x01}},
+		{"grow_memory", []byte{ops.I32Const, 0x00, ops.GrowMemory, 0x01}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := memoryTestModule(testFunc{
+				results: []wasm.ValueType{wasm.ValueTypeI32},
+				code:    tc.code,
+			})
+
+			vm, err := NewVM(m)
+			if err != nil {
+				t.Fatalf("could not create VM: %v", err)
+			}
+			vm.RecoverPanic = true
+			if res, err := vm.ExecCode(0); err != nil || res != uint32(1) {
+				t.Errorf("got %v and error %v without strict checking, want 1", res, err)
+			}
+
+			vm, err = NewVM(m, StrictReservedBytes(true))
+			if err != nil {
+				t.Fatalf("could not create VM: %v", err)
+			}
+			vm.RecoverPanic = true
+			if _, err = vm.ExecCode(0); trapCause(err) != ErrReservedByteNotZero {
+				t.Errorf("got error %v, want %v", err, ErrReservedByteNotZero)
+			}
+		})
+	}
+}
+
+func TestSwapMemory(t *testing.T) {
+	m := memoryTestModule(testFunc{
+		results: []wasm.ValueType{wasm.ValueTypeI32},
+		code:    []byte{ops.I32Const, 0x00, ops.I32Load, 0x02, 0x08},
+	})
+	m.Memory.Entries[0].Limits = wasm.ResizableLimits{Flags: 1, Initial: 1, Maximum: 2}
+
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	orig := vm.Memory()
+
+	mem := make([]byte, 2*wasmPageSize)
+	binary.LittleEndian.PutUint32(mem[8:], 0xdeadbeef)
+	old, err := vm.SwapMemory(mem)
+	if err != nil {
+		t.Fatalf("could not swap memory: %v", err)
+	}
+	if &old[0] != &orig[0] {
+		t.Error("the previous memory wasn't returned")
+	}
+	res, err := vm.ExecCode(0)
+	if err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if res != uint32(0xdeadbeef) {
+		t.Errorf("read %#x from the swapped in memory, want 0xdeadbeef", res)
+	}
+
+	for _, size := range []int{0, wasmPageSize + 1, 3 * wasmPageSize} {
+		if _, err = vm.SwapMemory(make([]byte, size)); err == nil {
+			t.Errorf("swapping in %d bytes of memory didn't fail", size)
+		}
+	}
+	if &vm.Memory()[0] != &mem[0] {
+		t.Error("a failed swap replaced the memory")
+	}
+
+	// The cap set with WithMaxMemoryPages applies as well
+	m.Memory.Entries[0].Limits = wasm.ResizableLimits{Flags: 1, Initial: 1, Maximum: 4}
+	vm, err = NewVM(m, WithMaxMemoryPages(2))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.SwapMemory(make([]byte, 3*wasmPageSize)); err == nil {
+		t.Error("swapping in more memory than WithMaxMemoryPages allows didn't fail")
+	}
+	if _, err = vm.SwapMemory(make([]byte, 2*wasmPageSize)); err != nil {
+		t.Errorf("could not swap in memory within WithMaxMemoryPages: %v", err)
+	}
+}
+
+func TestOpLogStoreOpcodes(t *testing.T) {
+	// The value stored by each operator, as the code pushing it
+	values := map[byte][]byte{
+		ops.I32Store:   {ops.I32Const, 0x07},
+		ops.I64Store:   {ops.I64Const, 0x07},
+		ops.F32Store:   {ops.F32Const, 0x00, 0x00, 0x80, 0x3f},
+		ops.F64Store:   {ops.F64Const, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f},
+		ops.I32Store8:  {ops.I32Const, 0x07},
+		ops.I32Store16: {ops.I32Const, 0x07},
+		ops.I64Store8:  {ops.I64Const, 0x07},
+		ops.I64Store16: {ops.I64Const, 0x07},
+		ops.I64Store32: {ops.I64Const, 0x07},
+	}
+	var code []byte
+	for op := ops.I32Store; op <= ops.I64Store32; op++ {
+		code = append(append(append(code, ops.I32Const, 0x08), values[op]...), op, 0x00, 0x00)
+	}
+	logger := &MemoryOpLogger{}
+	vm, err := NewVM(memoryTestModule(testFunc{code: code}), WithOpLogger(logger))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	// The stores are logged in order, each after the constants it uses
+	recs, _ := logger.Ops()
+	want := ops.I32Store
+	for _, rec := range recs {
+		if rec.OpCode == OpInitialState || rec.OpCode == ops.I32Const || rec.OpCode == ops.I64Const ||
+			rec.OpCode == ops.F32Const || rec.OpCode == ops.F64Const || rec.OpCode == ops.Nop {
+			continue
+		}
+		if rec.OpCode != want {
+			t.Errorf("%q logged opcode %#x, want %#x", rec.OpName, rec.OpCode, want)
+		}
+		want++
+	}
+	if want != ops.I64Store32+1 {
+		t.Errorf("logged %d store operations, want %d", want-ops.I32Store, ops.I64Store32-ops.I32Store+1)
+	}
+}
+
+func TestGrowMemoryMaximum(t *testing.T) {
+	m := memoryTestModule(testFunc{
+		params:  []wasm.ValueType{wasm.ValueTypeI32},
+		results: []wasm.ValueType{wasm.ValueTypeI32},
+		code:    []byte{ops.GetLocal, 0x00, ops.GrowMemory, 0x00},
+	})
+	m.Memory.Entries[0].Limits = wasm.ResizableLimits{Flags: 1, Initial: 1, Maximum: 3}
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		n     uint64
+		want  uint32
+		pages int
+	}{
+		{"over the maximum", 3, 0xffffffff, 1},
+		{"under the maximum", 1, 1, 2},
+		{"up to the maximum", 1, 2, 3},
+		{"past the maximum", 1, 0xffffffff, 3},
+		{"by nothing at the maximum", 0, 3, 3},
+	} {
+		res, err := vm.ExecCode(0, tc.n)
+		if err != nil {
+			t.Fatalf("%s: error executing function: %v", tc.name, err)
+		}
+		if res != tc.want {
+			t.Errorf("%s: growing by %d pages gave %#x, want %#x", tc.name, tc.n, res, tc.want)
+		}
+		if pages := len(vm.Memory()) / wasmPageSize; pages != tc.pages {
+			t.Errorf("%s: memory has %d pages, want %d", tc.name, pages, tc.pages)
+		}
+	}
+
+	// Without a maximum, the memory just grows
+	m.Memory.Entries[0].Limits = wasm.ResizableLimits{Initial: 1}
+	if vm, err = NewVM(m); err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if res, err := vm.ExecCode(0, 4); err != nil || res != uint32(1) {
+		t.Errorf("growing memory without a maximum gave %v and error %v, want 1", res, err)
+	}
+}
+
+func TestGrowMemoryCap(t *testing.T) {
+	m := memoryTestModule(testFunc{
+		params:  []wasm.ValueType{wasm.ValueTypeI32},
+		results: []wasm.ValueType{wasm.ValueTypeI32},
+		code:    []byte{ops.GetLocal, 0x00, ops.GrowMemory, 0x00},
+	})
+	vm, err := NewVM(m, WithMaxMemoryPages(4))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		n     uint64
+		want  uint32
+		pages int
+	}{
+		{"negative", 0xffffffff, 0xffffffff, 1},
+		{"most negative", 0x80000000, 0xffffffff, 1},
+		{"over the cap", 4, 0xffffffff, 1},
+		{"up to the cap", 3, 1, 4},
+		{"past the cap", 1, 0xffffffff, 4},
+	} {
+		res, err := vm.ExecCode(0, tc.n)
+		if err != nil {
+			t.Fatalf("%s: error executing function: %v", tc.name, err)
+		}
+		if res != tc.want {
+			t.Errorf("%s: growing by %#x pages gave %#x, want %#x", tc.name, tc.n, res, tc.want)
+		}
+		if pages := len(vm.Memory()) / wasmPageSize; pages != tc.pages {
+			t.Errorf("%s: memory has %d pages, want %d", tc.name, pages, tc.pages)
+		}
+	}
+}
+
+func TestMaxMemoryPages(t *testing.T) {
+	m := memoryTestModule(testFunc{
+		params:  []wasm.ValueType{wasm.ValueTypeI32},
+		results: []wasm.ValueType{wasm.ValueTypeI32},
+		code:    []byte{ops.GetLocal, 0x00, ops.GrowMemory, 0x00},
+	})
+	vm, err := NewVM(m, WithMaxMemoryPages(8))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if got := vm.MaxMemoryPages(); got != 1 {
+		t.Errorf("got %d pages before growing the memory, want 1", got)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		restart bool
+		n       uint64
+		want    int
+	}{
+		{"growing", false, 2, 3},
+		{"growing again", false, 1, 4},
+		{"not growing", false, 0, 4},
+		{"growing past the cap", false, 5, 4},
+		{"growing after restarting", true, 2, 4},
+		{"growing past the mark", false, 3, 6},
+	} {
+		if tc.restart {
+			if err = vm.Restart(); err != nil {
+				t.Fatalf("%s: could not restart: %v", tc.name, err)
+			}
+		}
+		if _, err = vm.ExecCode(0, tc.n); err != nil {
+			t.Fatalf("%s: error executing function: %v", tc.name, err)
+		}
+		if got := vm.MaxMemoryPages(); got != tc.want {
+			t.Errorf("%s: got %d pages, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestOutOfBoundsWraparound(t *testing.T) {
+	// 0xfffffff0 + 0x20 wraps around to 0x10 in 32 bits
+	base := []byte{ops.I32Const, 0x70}
+	for _, tc := range []struct {
+		name    string
+		results []wasm.ValueType
+		code    []byte
+	}{
+		{"load", []wasm.ValueType{wasm.ValueTypeI32}, append(base, ops.I32Load, 0x02, 0x20)},
+		{"store", nil, append(base, ops.I32Const, 0x01, ops.I32Store, 0x02, 0x20)},
+	} {
+		vm, err := NewVM(memoryTestModule(testFunc{results: tc.results, code: tc.code}))
+		if err != nil {
+			t.Fatalf("could not create VM: %v", err)
+		}
+		vm.RecoverPanic = true
+		if _, err = vm.ExecCode(0); trapCause(err) != ErrOutOfBoundsMemoryAccess {
+			t.Errorf("%s: got error %v, want %v", tc.name, err, ErrOutOfBoundsMemoryAccess)
+		}
+		if vm.Memory()[0x10] != 0 {
+			t.Errorf("%s: memory written at the wrapped around address", tc.name)
+		}
+	}
+}
+
+func TestOutOfBoundsError(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		results []wasm.ValueType
+		code    []byte
+		want    OutOfBoundsError
+	}{
+		{
+			"i32.load",
+			[]wasm.ValueType{wasm.ValueTypeI32},
+			[]byte{ops.I32Const, 0xfe, 0xff, 0x03, ops.I32Load, 0x02, 0x00},
+			OutOfBoundsError{Addr: 0xfffe, Size: 4, MemSize: wasmPageSize},
+		},
+		{
+			"i64.store8",
+			nil,
+			[]byte{ops.I32Const, 0xfe, 0xff, 0x03, ops.I64Const, 0x01, ops.I64Store8, 0x00, 0x02},
+			OutOfBoundsError{Addr: 0x10000, Size: 1, MemSize: wasmPageSize},
+		},
+		{
+			"f64.load",
+			[]wasm.ValueType{wasm.ValueTypeF64},
+			[]byte{ops.I32Const, 0x70, ops.F64Load, 0x03, 0x20},
+			OutOfBoundsError{Addr: 0x100000010, Size: 8, MemSize: wasmPageSize},
+		},
+	} {
+		vm, err := NewVM(memoryTestModule(testFunc{results: tc.results, code: tc.code}))
+		if err != nil {
+			t.Fatalf("could not create VM: %v", err)
+		}
+		vm.RecoverPanic = true
+		_, err = vm.ExecCode(0)
+		te, ok := err.(*TrapError)
+		if !ok {
+			t.Fatalf("%s: got error %v (%T), want a *TrapError", tc.name, err, err)
+		}
+		if te.Err != tc.want {
+			t.Errorf("%s: got trap %#v, want %#v", tc.name, te.Err, tc.want)
+		}
+		if trapCause(err) != ErrOutOfBoundsMemoryAccess || te.TrapKind() != TrapOutOfBoundsMemoryAccess {
+			t.Errorf("%s: got trap %v of kind %v, want %v", tc.name, trapCause(err), te.TrapKind(), ErrOutOfBoundsMemoryAccess)
+		}
+	}
+}
+
+func TestMemoryImageSize(t *testing.T) {
+	m := memoryTestModule(testFunc{code: []byte{ops.Nop}})
+	m.LinearMemoryIndexSpace = [][]byte{make([]byte, wasmPageSize+1)}
+	_, err := NewVM(m)
+	want := MemoryImageSizeError{ImageSize: wasmPageSize + 1, MemorySize: wasmPageSize}
+	if err != want {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+
+	m.LinearMemoryIndexSpace = [][]byte{make([]byte, wasmPageSize)}
+	if _, err = NewVM(m); err != nil {
+		t.Errorf("unexpected error for a memory image filling the memory: %v", err)
+	}
+}
+
+// bulkMemoryCode returns the code running a bulk memory operator with the
+// given i32 operands.
+func bulkMemoryCode(op byte, args ...int64) []byte {
+	var code []byte
+	for _, arg := range args {
+		code = append(append(code, ops.I32Const), sleb128(arg)...)
+	}
+	code = append(code, ops.MiscPrefix, op, 0x00)
+	if op == ops.MemoryCopy {
+		code = append(code, 0x00)
+	}
+	return code
+}
+
+func TestMemoryCopy(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		dst, src, len int64
+		want          string
+	}{
+		{"forward overlap", 2, 0, 5, "0101234789"},
+		{"backward overlap", 0, 2, 5, "2345656789"},
+		{"disjoint", 6, 0, 3, "0123450129"},
+		{"empty", 3, 7, 0, "0123456789"},
+	} {
+		vm, err := NewVM(memoryTestModule(testFunc{code: bulkMemoryCode(ops.MemoryCopy, tc.dst, tc.src, tc.len)}))
+		if err != nil {
+			t.Fatalf("could not create VM: %v", err)
+		}
+		copy(vm.Memory(), "0123456789")
+		if _, err = vm.ExecCode(0); err != nil {
+			t.Fatalf("%s: error executing function: %v", tc.name, err)
+		}
+		if got := string(vm.Memory()[:10]); got != tc.want {
+			t.Errorf("%s: got memory %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMemoryBulkOutOfBounds(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		code    []byte
+		err     error
+		written int // Number of bytes set by the operator
+	}{
+		{"fill to the end", bulkMemoryCode(ops.MemoryFill, wasmPageSize-6, 0xaa, 6), nil, 6},
+		{"empty fill at the end", bulkMemoryCode(ops.MemoryFill, wasmPageSize, 0xaa, 0), nil, 0},
+		{"fill past the end", bulkMemoryCode(ops.MemoryFill, wasmPageSize-6, 0xaa, 10), ErrOutOfBoundsMemoryAccess, 0},
+		{"fill wrapping around", bulkMemoryCode(ops.MemoryFill, 1, 0xaa, -1), ErrOutOfBoundsMemoryAccess, 0},
+		{"copy from past the end", bulkMemoryCode(ops.MemoryCopy, 0, wasmPageSize-2, 4), ErrOutOfBoundsMemoryAccess, 0},
+		{"copy to past the end", bulkMemoryCode(ops.MemoryCopy, wasmPageSize-2, 0, 4), ErrOutOfBoundsMemoryAccess, 0},
+	} {
+		vm, err := NewVM(memoryTestModule(testFunc{code: tc.code}))
+		if err != nil {
+			t.Fatalf("could not create VM: %v", err)
+		}
+		vm.RecoverPanic = true
+		if _, err = vm.ExecCode(0); trapCause(err) != tc.err {
+			t.Errorf("%s: got error %v, want %v", tc.name, err, tc.err)
+		}
+
+		// Nothing is written by a trapping operator
+		var written int
+		for _, b := range vm.Memory() {
+			if b != 0 {
+				written++
+			}
+		}
+		if written != tc.written {
+			t.Errorf("%s: got %d bytes written, want %d", tc.name, written, tc.written)
+		}
+	}
+}
+
+func TestProcessGrow(t *testing.T) {
+	host := func(proc *Process) int32 {
+		size := proc.MemSize()
+		if prev := proc.Grow(1); prev != int32(size/wasmPageSize) {
+			t.Errorf("Grow returned %d, want %d", prev, size/wasmPageSize)
+		}
+		if got := proc.MemSize(); got != size+wasmPageSize {
+			t.Errorf("got a memory size of %d after growing, want %d", got, size+wasmPageSize)
+		}
+		// Growing past the maximum of 2 pages leaves the memory as it is
+		if prev := proc.Grow(1); prev != -1 {
+			t.Errorf("Grow past the maximum returned %d, want -1", prev)
+		}
+		if prev := proc.Grow(-1); prev != -1 {
+			t.Errorf("Grow by -1 returned %d, want -1", prev)
+		}
+		if _, err := proc.WriteString(int32(size), "wasm"); err != nil {
+			t.Errorf("could not write into the grown memory: %v", err)
+		}
+		return int32(size)
+	}
+	m := buildTestModule(
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code:    []byte{ops.Call, 0x01, ops.I32Load, 0x02, 0x00},
+		},
+		testFunc{results: []wasm.ValueType{wasm.ValueTypeI32}, host: host},
+	)
+	m.Memory = &wasm.SectionMemories{Entries: []wasm.Memory{{Limits: wasm.ResizableLimits{Flags: 1, Initial: 1, Maximum: 2}}}}
+	m.LinearMemoryIndexSpace = [][]byte{nil}
+
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	res, err := vm.ExecCode(0)
+	if err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if want := binary.LittleEndian.Uint32([]byte("wasm")); res != want {
+		t.Errorf("loaded %#x from the grown memory, want %#x", res, want)
+	}
+	if got := len(vm.Memory()); got != 2*wasmPageSize {
+		t.Errorf("got a memory of %d bytes, want %d", got, 2*wasmPageSize)
+	}
+}
+
+func TestMultipleMemories(t *testing.T) {
+	// The loads and stores set disasm.MemoryIndexFlag in their alignment
+	// flags to give the index of the memory accessed
+	m := buildTestModule(testFunc{
+		results: []wasm.ValueType{wasm.ValueTypeI32},
+		code: []byte{
+			ops.I32Const, 0x08, ops.I32Const, 0x2a, ops.I32Store, 0x42, 0x01, 0x00,
+			ops.I32Const, 0x08, ops.I32Load, 0x42, 0x01, 0x00,
+		},
+	})
+	m.Memory = &wasm.SectionMemories{Entries: []wasm.Memory{
+		{Limits: wasm.ResizableLimits{Initial: 1}},
+		{Limits: wasm.ResizableLimits{Initial: 2}},
+	}}
+	m.LinearMemoryIndexSpace = [][]byte{nil, nil}
+
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	if res, err := vm.ExecCode(0); err != nil || res != uint32(42) {
+		t.Fatalf("got %v and error %v, want 42", res, err)
+	}
+	if len(vm.memories) != 1 || len(vm.memories[0]) != 2*wasmPageSize {
+		t.Fatalf("got %d memories past the first, want one of 2 pages", len(vm.memories))
+	}
+	if got := vm.memories[0][8]; got != 42 {
+		t.Errorf("got %d stored in memory 1, want 42", got)
+	}
+	if got := vm.Memory()[8]; got != 0 {
+		t.Errorf("got %d stored in memory 0, want it left alone", got)
+	}
+
+	// Memory indexes past the memories of the module are rejected
+	m.Memory.Entries = m.Memory.Entries[:1]
+	m.LinearMemoryIndexSpace = m.LinearMemoryIndexSpace[:1]
+	if _, err := NewVM(m); err != wasm.InvalidLinearMemoryIndexError(1) {
+		t.Errorf("got error %v for a module with one memory, want %v", err, wasm.InvalidLinearMemoryIndexError(1))
+	}
+}
+
+func TestMemoryOperatorsIndex(t *testing.T) {
+	m := buildTestModule(
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code: []byte{
+				ops.I32Const, 0x01, ops.GrowMemory, 0x01, ops.Drop,
+				ops.I32Const, 0x08, ops.I32Const, 0x2a, ops.I32Const, 0x04, ops.MiscPrefix, ops.MemoryFill, 0x01,
+				ops.I32Const, 0x10, ops.I32Const, 0x08, ops.I32Const, 0x04, ops.MiscPrefix, ops.MemoryCopy, 0x01, 0x01,
+				ops.CurrentMemory, 0x01,
+			},
+		},
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code:    []byte{ops.CurrentMemory, 0x02},
+		},
+	)
+	m.Memory = &wasm.SectionMemories{Entries: []wasm.Memory{
+		{Limits: wasm.ResizableLimits{Initial: 1}},
+		{Limits: wasm.ResizableLimits{Initial: 2}},
+	}}
+	m.LinearMemoryIndexSpace = [][]byte{nil, nil}
+
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	if res, err := vm.ExecCode(0); err != nil || res != uint32(3) {
+		t.Fatalf("got %v and error %v, want memory 1 grown to 3 pages", res, err)
+	}
+	if got := len(vm.memories[0]); got != 3*wasmPageSize {
+		t.Errorf("got memory 1 of %d bytes, want %d", got, 3*wasmPageSize)
+	}
+	if got := vm.memories[0][19]; got != 42 {
+		t.Errorf("got %d copied in memory 1, want 42", got)
+	}
+	if got := len(vm.Memory()); got != wasmPageSize {
+		t.Errorf("got memory 0 of %d bytes, want it left at %d", got, wasmPageSize)
+	}
+	if got := vm.Memory()[8]; got != 0 {
+		t.Errorf("got %d filled in memory 0, want it left alone", got)
+	}
+
+	// Memory indexes past the memories of the module trap
+	_, err = vm.ExecCode(1)
+	var index wasm.InvalidLinearMemoryIndexError
+	if te, ok := err.(*TrapError); !ok || te.TrapKind() != TrapInvalidMemoryIndex || !errors.As(err, &index) || index != 2 {
+		t.Errorf("got error %v, want a trap for memory index 2", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"sort"
+
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// ByteRange is a range of linear memory addresses, from Start up to but not
+// including End.
+type ByteRange struct {
+	Start, End uint64
+}
+
+// rangeSet collects byte ranges, coalescing them once done.
+type rangeSet []ByteRange
+
+func (s *rangeSet) add(start, n uint64) {
+	end := start + n
+
+	// Extend the last range for sequential accesses, so looping over an
+	// array doesn't record every element separately
+	if l := len(*s); l != 0 {
+		last := &(*s)[l-1]
+		if start <= last.End && end >= last.Start {
+			if start < last.Start {
+				last.Start = start
+			}
+			if end > last.End {
+				last.End = end
+			}
+			return
+		}
+	}
+	*s = append(*s, ByteRange{Start: start, End: end})
+}
+
+// coalesced returns the ranges sorted by address, with overlapping and
+// adjacent ranges merged.
+func (s rangeSet) coalesced() []ByteRange {
+	if len(s) == 0 {
+		return nil
+	}
+	sorted := append([]ByteRange(nil), s...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+
+	merged := sorted[:1]
+	for _, r := range sorted[1:] {
+		last := &merged[len(merged)-1]
+		if r.Start <= last.End {
+			if r.End > last.End {
+				last.End = r.End
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// memProfile records the linear memory accesses during a run.
+type memProfile struct {
+	reads, writes rangeSet
+}
+
+// record notes an access of n bytes at addr by the current operator.
+func (p *memProfile) record(op byte, addr, n uint64) {
+	if op >= ops.I32Store && op <= ops.I64Store32 {
+		p.writes.add(addr, n)
+	} else {
+		p.reads.add(addr, n)
+	}
+}
+
+// ExecWithMemProfile calls the function with the given index and arguments,
+// as ExecCode does, also returning the ranges of linear memory read and
+// written by the load, store and bulk memory operators during the call. The
+// ranges are sorted, with overlapping and adjacent ranges merged. When the
+// call fails, the ranges accessed up to that point are returned with the
+// error.
+//
+// Accesses made by AOT compiled code (see EnableAOT) aren't included.
+func (vm *VM) ExecWithMemProfile(fnIndex int64, args ...uint64) (result interface{}, reads, writes []ByteRange, err error) {
+	p := &memProfile{}
+	vm.memProfile = p
+	defer func() {
+		vm.memProfile = nil
+	}()
+
+	result, err = vm.ExecCode(fnIndex, args...)
+	return result, p.reads.coalesced(), p.writes.coalesced(), err
+}
@@ -0,0 +1,64 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestExecWithMemProfile(t *testing.T) {
+	// Copy the four i32 array elements at 0 to 32, reading the byte at
+	// 60 along the way
+	var code []byte
+	for i := byte(0); i < 4; i++ {
+		code = append(code,
+			ops.I32Const, 32+4*i,
+			ops.I32Const, 4*i,
+			ops.I32Load, 0x02, 0x00,
+			ops.I32Store, 0x02, 0x00,
+		)
+		if i == 1 {
+			code = append(code, ops.I32Const, 60, ops.I32Load8u, 0x00, 0x00, ops.Drop)
+		}
+	}
+	m := buildTestModule(testFunc{name: "copy", code: code})
+	m.Memory = &wasm.SectionMemories{Entries: []wasm.Memory{{Limits: wasm.ResizableLimits{Initial: 1}}}}
+	m.LinearMemoryIndexSpace = [][]byte{nil}
+
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	_, reads, writes, err := vm.ExecWithMemProfile(0)
+	if err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if want := []ByteRange{{0, 16}, {60, 61}}; !reflect.DeepEqual(reads, want) {
+		t.Errorf("got reads %v, want %v", reads, want)
+	}
+	if want := []ByteRange{{32, 48}}; !reflect.DeepEqual(writes, want) {
+		t.Errorf("got writes %v, want %v", writes, want)
+	}
+
+	// Profiling stops with the run
+	if vm.memProfile != nil {
+		t.Error("memory still being profiled after the run")
+	}
+}
+
+func TestRangeSetCoalesced(t *testing.T) {
+	var s rangeSet
+	for _, r := range []ByteRange{{40, 44}, {0, 4}, {8, 12}, {4, 8}, {42, 50}, {100, 101}, {12, 16}} {
+		s.add(r.Start, r.End-r.Start)
+	}
+	want := []ByteRange{{0, 16}, {40, 50}, {100, 101}}
+	if got := s.coalesced(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
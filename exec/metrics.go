@@ -0,0 +1,121 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.19
+// +build go1.19
+
+package exec
+
+import (
+	"fmt"
+
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WithMetrics registers Prometheus counters with registry, which the VM
+// updates as it runs: wagon_ops_total, counting the operations executed,
+// labeled by the opcode's mnemonic, and wagon_traps_total, counting the
+// runs ended by a trap (with RecoverPanic set), labeled by the kind of trap,
+// or the error's Go type for other errors. Registering the counters with a
+// registry they are registered with already, such as by another VM, shares
+// them. It's only available when built with Go 1.19 or later.
+func WithMetrics(registry *prometheus.Registry) VMOption {
+	return func(c *config) {
+		c.Metrics = func() (opCounter, error) {
+			return newMetrics(registry)
+		}
+	}
+}
+
+// metrics holds the Prometheus counters updated by the VM, set up with
+// WithMetrics.
+type metrics struct {
+	ops   *prometheus.CounterVec // Operations executed, by opcode name
+	traps *prometheus.CounterVec // Traps, by error type
+
+	// Counters of the opcodes executed so far, so looking them up by label
+	// is only done once for each opcode
+	opCounters [256]prometheus.Counter
+}
+
+// newMetrics registers the VM's counters with registry, reusing the ones
+// registered by another VM already.
+func newMetrics(registry *prometheus.Registry) (*metrics, error) {
+	opsVec, err := registerCounterVec(registry, prometheus.CounterOpts{
+		Namespace: "wagon",
+		Name:      "ops_total",
+		Help:      "Number of WebAssembly operations executed, by opcode.",
+	}, "opcode")
+	if err != nil {
+		return nil, err
+	}
+	trapsVec, err := registerCounterVec(registry, prometheus.CounterOpts{
+		Namespace: "wagon",
+		Name:      "traps_total",
+		Help:      "Number of runs ended by a trap or other error, by error type.",
+	}, "error")
+	if err != nil {
+		return nil, err
+	}
+	return &metrics{ops: opsVec, traps: trapsVec}, nil
+}
+
+func registerCounterVec(registry *prometheus.Registry, opts prometheus.CounterOpts, label string) (*prometheus.CounterVec, error) {
+	vec := prometheus.NewCounterVec(opts, []string{label})
+	if err := registry.Register(vec); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			if existing, ok := are.ExistingCollector.(*prometheus.CounterVec); ok {
+				return existing, nil
+			}
+		}
+		return nil, err
+	}
+	return vec, nil
+}
+
+// clone returns metrics updating the same counters, for a clone of the VM.
+func (m *metrics) clone() opCounter {
+	return &metrics{ops: m.ops, traps: m.traps}
+}
+
+// countOp counts executing the operator with the given opcode.
+func (m *metrics) countOp(op byte) {
+	c := m.opCounters[op]
+	if c == nil {
+		c = m.ops.WithLabelValues(metricOpName(op))
+		m.opCounters[op] = c
+	}
+	c.Inc()
+}
+
+// countTrap counts a run ending with err. Traps are counted by their kind,
+// such as "unreachable", and the other errors by their Go type.
+func (m *metrics) countTrap(err error) {
+	if pe, ok := err.(*PanicError); ok {
+		// The other errors are counted by the type of the one panicked with
+		err = pe.Err
+	}
+	label := fmt.Sprintf("%T", err)
+	if t, ok := err.(Trap); ok {
+		label = t.TrapKind().String()
+	}
+	m.traps.WithLabelValues(label).Inc()
+}
+
+// metricOpName returns the name the operator with the given opcode is
+// counted under, which is its mnemonic, such as "i32.add". The operators
+// prefixed with ops.MiscPrefix are counted together, under "misc".
+func metricOpName(op byte) string {
+	if name, ok := compiledOpNames[op]; ok {
+		return name
+	}
+	if op == ops.MiscPrefix {
+		return "misc"
+	}
+	if o, err := ops.New(op); err == nil {
+		return o.Name
+	}
+	return fmt.Sprintf("0x%02x", op)
+}
@@ -0,0 +1,72 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.19
+// +build go1.19
+
+package exec
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	i32 := []wasm.ValueType{wasm.ValueTypeI32}
+	m := buildTestModule(
+		testFunc{
+			name:    "double",
+			params:  i32,
+			results: i32,
+			code:    []byte{ops.GetLocal, 0x00, ops.I32Const, 0x02, ops.I32Mul},
+		},
+		testFunc{code: []byte{ops.Unreachable}},
+	)
+
+	registry := prometheus.NewRegistry()
+	vm, err := NewVM(m, WithMetrics(registry))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	for i := 0; i < 2; i++ {
+		if res, err := vm.ExecCode(0, 3); err != nil || res != uint32(6) {
+			t.Fatalf("got %v and error %v, want 6", res, err)
+		}
+	}
+	if _, err = vm.ExecCode(1); trapCause(err) != ErrUnreachable {
+		t.Fatalf("got error %v, want %v", err, ErrUnreachable)
+	}
+
+	// A second VM shares the counters
+	vm2, err := NewVM(m, WithMetrics(registry))
+	if err != nil {
+		t.Fatalf("could not create a second VM: %v", err)
+	}
+	if res, err := vm2.Clone().ExecCode(0, 3); err != nil || res != uint32(6) {
+		t.Fatalf("got %v and error %v from the second VM, want 6", res, err)
+	}
+
+	// Each function ends with a nop, which the ends of its blocks jump to
+	const want = `
+# HELP wagon_ops_total Number of WebAssembly operations executed, by opcode.
+# TYPE wagon_ops_total counter
+wagon_ops_total{opcode="get_local"} 3
+wagon_ops_total{opcode="i32.const"} 3
+wagon_ops_total{opcode="i32.mul"} 3
+wagon_ops_total{opcode="nop"} 3
+wagon_ops_total{opcode="unreachable"} 1
+# HELP wagon_traps_total Number of runs ended by a trap or other error, by error type.
+# TYPE wagon_traps_total counter
+wagon_traps_total{error="unreachable"} 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
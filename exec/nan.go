@@ -0,0 +1,45 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import "math"
+
+// The canonical quiet NaNs, with only the most significant bit of the
+// mantissa set, as defined by the spec.
+const (
+	canonicalNaN32 = 0x7fc00000
+	canonicalNaN64 = 0x7ff8000000000000
+)
+
+// WithCanonicalNaN makes the VM replace the NaNs produced by the float
+// arithmetic operators, including rounding, min, max, promote and demote,
+// with the positive canonical quiet NaN. The sign and payload bits of such
+// NaNs otherwise depend on the operands and the platform, so this keeps
+// the results, and the values logged, the same across runs and platforms.
+// The operators only changing the sign, such as f32.neg, keep the NaN
+// given to them, as the spec has them do.
+func WithCanonicalNaN(v bool) VMOption {
+	return func(c *config) {
+		c.CanonicalNaN = v
+	}
+}
+
+// canonicalF32 returns the canonical NaN in place of a NaN v, when
+// canonicalizing NaNs, or v otherwise.
+func (vm *VM) canonicalF32(v float32) float32 {
+	if vm.canonicalNaN && v != v {
+		return math.Float32frombits(canonicalNaN32)
+	}
+	return v
+}
+
+// canonicalF64 returns the canonical NaN in place of a NaN v, when
+// canonicalizing NaNs, or v otherwise.
+func (vm *VM) canonicalF64(v float64) float64 {
+	if vm.canonicalNaN && v != v {
+		return math.Float64frombits(canonicalNaN64)
+	}
+	return v
+}
@@ -0,0 +1,68 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestCanonicalNaN(t *testing.T) {
+	// Worked out at run time, rather than folded by the compiler, giving the
+	// platform's NaNs
+	payload, one, zero := math.Float32frombits(0x7fc00123), float32(1), 0.0
+	nativeAdd := uint64(math.Float32bits(payload + one))
+	nativeDiv := math.Float64bits(zero / zero)
+
+	m := buildTestModule(
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeF32},
+			code: []byte{
+				ops.F32Const, 0x23, 0x01, 0xc0, 0x7f, // A NaN with a payload
+				ops.F32Const, 0x00, 0x00, 0x80, 0x3f, // 1
+				ops.F32Add,
+			},
+		},
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeF64},
+			code: []byte{
+				ops.F64Const, 0, 0, 0, 0, 0, 0, 0, 0,
+				ops.F64Const, 0, 0, 0, 0, 0, 0, 0, 0,
+				ops.F64Div,
+			},
+		},
+	)
+	for _, tc := range []struct {
+		canonical bool
+		want      [2]uint64
+	}{
+		{false, [2]uint64{nativeAdd, nativeDiv}},
+		{true, [2]uint64{canonicalNaN32, canonicalNaN64}},
+	} {
+		vm, err := NewVM(m, WithCanonicalNaN(tc.canonical))
+		if err != nil {
+			t.Fatalf("could not create VM: %v", err)
+		}
+		for i, want := range tc.want {
+			res, err := vm.ExecCode(int64(i))
+			if err != nil {
+				t.Fatalf("canonical %v, function %d: %v", tc.canonical, i, err)
+			}
+			var got uint64
+			switch v := res.(type) {
+			case float32:
+				got = uint64(math.Float32bits(v))
+			case float64:
+				got = math.Float64bits(v)
+			}
+			if got != want {
+				t.Errorf("canonical %v, function %d: got NaN %#x, want %#x", tc.canonical, i, got, want)
+			}
+		}
+	}
+}
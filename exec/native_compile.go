@@ -91,11 +91,15 @@ func (vm *VM) tryNativeCompile() error {
 	}
 
 	for i := range vm.funcs {
-		if _, isGoFunc := vm.funcs[i].(*goFunction); isGoFunc {
+		// The host functions, and those left to compile lazily, are skipped
+		fn, ok := vm.funcs[i].(compiledFunction)
+		if !ok {
+			continue
+		}
+		if fn.codeMeta != nil && fn.codeMeta.MemoryIndexes {
+			// The native code only accesses the first memory
 			continue
 		}
-
-		fn := vm.funcs[i].(compiledFunction)
 		candidates, err := vm.nativeBackend.Scanner.ScanFunc(fn.code, fn.codeMeta)
 		if err != nil {
 			return fmt.Errorf("exec: AOT scan failed on vm.funcs[%d]: %v", i, err)
@@ -124,8 +128,9 @@ func (vm *VM) tryNativeCompile() error {
 				return fmt.Errorf("exec: allocator.AllocateExec() failed: %v", err)
 			}
 			fn.asm = append(fn.asm, asmBlock{
-				nativeUnit: unit,
-				resumePC:   upper,
+				nativeUnit:  unit,
+				resumePC:    upper,
+				stackWrites: candidate.Metrics.StackWrites,
 			})
 
 			// Patch the wasm opcode stream to call into the native section.
@@ -155,7 +160,27 @@ func (vm *VM) tryNativeCompile() error {
 // [fp:fp+pointerSize]: sliceHeader for the stack.
 // [fp+pointerSize:fp+pointerSize*2]: sliceHeader for locals variables.
 func (vm *VM) nativeCodeInvocation(asmIndex uint32) {
+	// The native code divides without checking its operands, so the Go
+	// runtime turns division traps into panics of its own
+	defer func() {
+		if r := recover(); r != nil {
+			if err, ok := r.(runtime.Error); ok {
+				switch err.Error() {
+				case "runtime error: integer divide by zero":
+					panic(ErrIntegerDivideByZero)
+				case "runtime error: integer overflow":
+					panic(ErrIntegerOverflow)
+				}
+			}
+			panic(r)
+		}
+	}()
+
+	// The native code pushes onto the stack without growing it
 	block := vm.ctx.asm[asmIndex]
+	if free := cap(vm.ctx.stack) - len(vm.ctx.stack); free < int(block.stackWrites) {
+		vm.growStack(int(block.stackWrites))
+	}
 	finishSignal := block.nativeUnit.Invoke(&vm.ctx.stack, &vm.ctx.locals, &vm.globals, &vm.memory)
 
 	switch finishSignal.CompletionStatus() {
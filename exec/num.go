@@ -9,6 +9,16 @@ import (
 	"math/bits"
 )
 
+var (
+	// ErrIntegerDivideByZero is the error value used while trapping the VM
+	// when an integer division or remainder operator has a divisor of zero.
+	ErrIntegerDivideByZero = newTrap(TrapIntegerDivideByZero, "exec: integer divide by zero")
+	// ErrIntegerOverflow is the error value used while trapping the VM when
+	// the result of a signed integer division doesn't fit, which is the
+	// case when dividing the minimum integer by -1.
+	ErrIntegerOverflow = newTrap(TrapIntegerOverflow, "exec: integer overflow")
+)
+
 // int32 operators
 
 func (vm *VM) i32Clz() {
@@ -20,8 +30,10 @@ func (vm *VM) i32Clz() {
 	vm.pushUint64(val)
 
 	// Log this operation
-	opLog(vm, 0x67, "i32 Count leading zero bits", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x67, "i32 Count leading zero bits", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Ctz() {
@@ -33,8 +45,10 @@ func (vm *VM) i32Ctz() {
 	vm.pushUint64(val)
 
 	// Log this operation
-	opLog(vm, 0x68, "i32 Count trailing zero bits", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x68, "i32 Count trailing zero bits", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Popcnt() {
@@ -46,8 +60,10 @@ func (vm *VM) i32Popcnt() {
 	vm.pushUint64(val)
 
 	// Log this operation
-	opLog(vm, 0x69, "i32 Count number of one bits", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x69, "i32 Count number of one bits", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Add() {
@@ -60,8 +76,14 @@ func (vm *VM) i32Add() {
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x6A, "i32 Add", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		fields := binaryOpFields
+		data := logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack)
+		if vm.overflowLogging {
+			fields, data = append(fields, "overflowed"), append(data, addOverflows32(v1, v2))
+		}
+		opLog(vm, 0x6A, "i32 Add", fields, data)
+	}
 }
 
 func (vm *VM) i32Sub() {
@@ -74,8 +96,14 @@ func (vm *VM) i32Sub() {
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x6B, "i32 Sub", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		fields := binaryOpFields
+		data := logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack)
+		if vm.overflowLogging {
+			fields, data = append(fields, "overflowed"), append(data, subOverflows32(v1, v2))
+		}
+		opLog(vm, 0x6B, "i32 Sub", fields, data)
+	}
 }
 
 func (vm *VM) i32Mul() {
@@ -88,8 +116,14 @@ func (vm *VM) i32Mul() {
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x6C, "i32 Multiply", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		fields := binaryOpFields
+		data := logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack)
+		if vm.overflowLogging {
+			fields, data = append(fields, "overflowed"), append(data, mulOverflows32(v1, v2))
+		}
+		opLog(vm, 0x6C, "i32 Multiply", fields, data)
+	}
 }
 
 func (vm *VM) i32DivS() {
@@ -98,12 +132,20 @@ func (vm *VM) i32DivS() {
 	// The operation we're logging
 	v2 := vm.popInt32()
 	v1 := vm.popInt32()
+	if v2 == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
+	if v1 == math.MinInt32 && v2 == -1 {
+		panic(ErrIntegerOverflow)
+	}
 	val := v1 / v2
 	vm.pushInt32(val)
 
 	// Log this operation
-	opLog(vm, 0x6D, "i32 Divide signed", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x6D, "i32 Divide signed", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32DivU() {
@@ -112,12 +154,17 @@ func (vm *VM) i32DivU() {
 	// The operation we're logging
 	v2 := vm.popUint32()
 	v1 := vm.popUint32()
+	if v2 == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
 	val := v1 / v2
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x6E, "i32 Divide unsigned", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x6E, "i32 Divide unsigned", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32RemS() {
@@ -126,12 +173,20 @@ func (vm *VM) i32RemS() {
 	// The operation we're logging
 	v2 := vm.popInt32()
 	v1 := vm.popInt32()
-	val := v1 % v2
+	if v2 == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
+	var val int32
+	if v2 != -1 { // The minimum integer % -1 can't be computed, but is defined as 0
+		val = v1 % v2
+	}
 	vm.pushInt32(val)
 
 	// Log this operation
-	opLog(vm, 0x6F, "i32 Remainder signed", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x6F, "i32 Remainder signed", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32RemU() {
@@ -140,12 +195,17 @@ func (vm *VM) i32RemU() {
 	// The operation we're logging
 	v2 := vm.popUint32()
 	v1 := vm.popUint32()
+	if v2 == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
 	val := v1 % v2
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x70, "i32 Remainder unsigned", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x70, "i32 Remainder unsigned", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32And() {
@@ -158,8 +218,10 @@ func (vm *VM) i32And() {
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x71, "i32 And", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x71, "i32 And", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Or() {
@@ -172,8 +234,10 @@ func (vm *VM) i32Or() {
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x72, "i32 Or", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x72, "i32 Or", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Xor() {
@@ -186,8 +250,10 @@ func (vm *VM) i32Xor() {
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x73, "i32 Xor", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x73, "i32 Xor", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Shl() {
@@ -196,12 +262,14 @@ func (vm *VM) i32Shl() {
 	// The operation we're logging
 	v2 := vm.popUint32()
 	v1 := vm.popUint32()
-	val := v1 << v2
+	val := v1 << (v2 & 31) // Shift counts are modulo the bit width
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x74, "i32 Shift left", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x74, "i32 Shift left", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32ShrS() {
@@ -210,12 +278,14 @@ func (vm *VM) i32ShrS() {
 	// The operation we're logging
 	v2 := vm.popUint32()
 	v1 := vm.popInt32()
-	val := v1 >> v2
+	val := v1 >> (v2 & 31)
 	vm.pushInt32(val)
 
 	// Log this operation
-	opLog(vm, 0x75, "i32 Shift right signed", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x75, "i32 Shift right signed", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32ShrU() {
@@ -224,12 +294,14 @@ func (vm *VM) i32ShrU() {
 	// The operation we're logging
 	v2 := vm.popUint32()
 	v1 := vm.popUint32()
-	val := v1 >> v2
+	val := v1 >> (v2 & 31)
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x76, "i32 Shift right unsigned", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x76, "i32 Shift right unsigned", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Rotl() {
@@ -242,8 +314,10 @@ func (vm *VM) i32Rotl() {
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x77, "i32 Rotate left", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x77, "i32 Rotate left", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Rotr() {
@@ -256,8 +330,10 @@ func (vm *VM) i32Rotr() {
 	vm.pushUint32(val)
 
 	// Log this operation
-	opLog(vm, 0x78, "i32 Rotate right", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x78, "i32 Rotate right", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32LeS() {
@@ -270,8 +346,10 @@ func (vm *VM) i32LeS() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x4C, "i32 Less than or equal signed", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x4C, "i32 Less than or equal signed", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32LeU() {
@@ -284,8 +362,10 @@ func (vm *VM) i32LeU() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x4D, "i32 Less than or equal unsigned", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x4D, "i32 Less than or equal unsigned", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32LtS() {
@@ -298,8 +378,10 @@ func (vm *VM) i32LtS() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x48, "i32 Less than signed", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x48, "i32 Less than signed", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32LtU() {
@@ -312,8 +394,10 @@ func (vm *VM) i32LtU() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x49, "i32 Less than unsigned", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x49, "i32 Less than unsigned", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32GtS() {
@@ -326,8 +410,10 @@ func (vm *VM) i32GtS() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x4A, "i32 Greater than signed", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x4A, "i32 Greater than signed", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32GtU() {
@@ -340,8 +426,10 @@ func (vm *VM) i32GtU() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x4B, "i32 Greater than unsigned", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x4B, "i32 Greater than unsigned", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32GeS() {
@@ -354,8 +442,10 @@ func (vm *VM) i32GeS() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x4E, "i32 Greater than or equal signed", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x4E, "i32 Greater than or equal signed", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32GeU() {
@@ -368,8 +458,10 @@ func (vm *VM) i32GeU() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x4F, "i32 Greater than or equal unsigned", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x4F, "i32 Greater than or equal unsigned", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Eqz() {
@@ -381,8 +473,10 @@ func (vm *VM) i32Eqz() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x45, "i32 Equal to zero", []string{"program_counter", "value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, val, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x45, "i32 Equal to zero", testOpFields,
+			logData(vm.ctx.pc, val, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Eq() {
@@ -395,8 +489,10 @@ func (vm *VM) i32Eq() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x46, "i32 Equal", []string{"program_counter", "arg_1", "arg_2", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, arg1, arg2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x46, "i32 Equal", []string{"program_counter", "arg_1", "arg_2", "condition_met", "stack_start", "stack_finish"},
+			logData(vm.ctx.pc, arg1, arg2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i32Ne() {
@@ -409,162 +505,502 @@ func (vm *VM) i32Ne() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x47, "i32 Not equal", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x47, "i32 Not equal", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 // int64 operators
 
 func (vm *VM) i64Clz() {
-	vm.pushUint64(uint64(bits.LeadingZeros64(vm.popUint64())))
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popUint64()
+	val := uint64(bits.LeadingZeros64(v1))
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x79, "i64 Count leading zero bits", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Ctz() {
-	vm.pushUint64(uint64(bits.TrailingZeros64(vm.popUint64())))
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popUint64()
+	val := uint64(bits.TrailingZeros64(v1))
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x7A, "i64 Count trailing zero bits", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Popcnt() {
-	vm.pushUint64(uint64(bits.OnesCount64(vm.popUint64())))
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popUint64()
+	val := uint64(bits.OnesCount64(v1))
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x7B, "i64 Count number of one bits", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Add() {
-	vm.pushUint64(vm.popUint64() + vm.popUint64())
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popUint64()
+	v1 := vm.popUint64()
+	val := v1 + v2
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		fields := binaryOpFields
+		data := logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack)
+		if vm.overflowLogging {
+			fields, data = append(fields, "overflowed"), append(data, addOverflows64(v1, v2))
+		}
+		opLog(vm, 0x7C, "i64 Add", fields, data)
+	}
 }
 
 func (vm *VM) i64Sub() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popUint64()
 	v1 := vm.popUint64()
-	vm.pushUint64(v1 - v2)
+	val := v1 - v2
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		fields := binaryOpFields
+		data := logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack)
+		if vm.overflowLogging {
+			fields, data = append(fields, "overflowed"), append(data, subOverflows64(v1, v2))
+		}
+		opLog(vm, 0x7D, "i64 Sub", fields, data)
+	}
 }
 
 func (vm *VM) i64Mul() {
-	vm.pushUint64(vm.popUint64() * vm.popUint64())
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popUint64()
+	v1 := vm.popUint64()
+	val := v1 * v2
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		fields := binaryOpFields
+		data := logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack)
+		if vm.overflowLogging {
+			fields, data = append(fields, "overflowed"), append(data, mulOverflows64(v1, v2))
+		}
+		opLog(vm, 0x7E, "i64 Multiply", fields, data)
+	}
 }
 
 func (vm *VM) i64DivS() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popInt64()
 	v1 := vm.popInt64()
-	vm.pushInt64(v1 / v2)
+	if v2 == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
+	if v1 == math.MinInt64 && v2 == -1 {
+		panic(ErrIntegerOverflow)
+	}
+	val := v1 / v2
+	vm.pushInt64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x7F, "i64 Divide signed", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64DivU() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popUint64()
 	v1 := vm.popUint64()
-	vm.pushUint64(v1 / v2)
+	if v2 == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
+	val := v1 / v2
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x80, "i64 Divide unsigned", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64RemS() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popInt64()
 	v1 := vm.popInt64()
-	vm.pushInt64(v1 % v2)
+	if v2 == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
+	var val int64
+	if v2 != -1 { // The minimum integer % -1 can't be computed, but is defined as 0
+		val = v1 % v2
+	}
+	vm.pushInt64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x81, "i64 Remainder signed", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64RemU() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popUint64()
 	v1 := vm.popUint64()
-	vm.pushUint64(v1 % v2)
+	if v2 == 0 {
+		panic(ErrIntegerDivideByZero)
+	}
+	val := v1 % v2
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x82, "i64 Remainder unsigned", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64And() {
-	vm.pushUint64(vm.popUint64() & vm.popUint64())
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popUint64()
+	v1 := vm.popUint64()
+	val := v1 & v2
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x83, "i64 And", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Or() {
-	vm.pushUint64(vm.popUint64() | vm.popUint64())
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popUint64()
+	v1 := vm.popUint64()
+	val := v1 | v2
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x84, "i64 Or", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Xor() {
-	vm.pushUint64(vm.popUint64() ^ vm.popUint64())
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popUint64()
+	v1 := vm.popUint64()
+	val := v1 ^ v2
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x85, "i64 Xor", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Shl() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popUint64()
 	v1 := vm.popUint64()
-	vm.pushUint64(v1 << v2)
+	val := v1 << (v2 & 63)
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x86, "i64 Shift left", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64ShrS() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popUint64()
 	v1 := vm.popInt64()
-	vm.pushInt64(v1 >> v2)
+	val := v1 >> (v2 & 63)
+	vm.pushInt64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x87, "i64 Shift right signed", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64ShrU() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popUint64()
 	v1 := vm.popUint64()
-	vm.pushUint64(v1 >> v2)
+	val := v1 >> (v2 & 63)
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x88, "i64 Shift right unsigned", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Rotl() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popInt64()
 	v1 := vm.popUint64()
-	vm.pushUint64(bits.RotateLeft64(v1, int(v2)))
+	val := bits.RotateLeft64(v1, int(v2))
+	vm.pushUint64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x89, "i64 Rotate left", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Rotr() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popInt64()
 	v1 := vm.popUint64()
-	vm.pushUint64(bits.RotateLeft64(v1, -int(v2)))
-}
+	val := bits.RotateLeft64(v1, -int(v2))
+	vm.pushUint64(val)
 
-func (vm *VM) i64Eq() {
-	vm.pushBool(vm.popUint64() == vm.popUint64())
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x8A, "i64 Rotate right", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Eqz() {
-	vm.pushBool(vm.popUint64() == 0)
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	val := vm.popUint64()
+	cond := val == 0
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x50, "i64 Equal to zero", testOpFields,
+			logData(vm.ctx.pc, val, cond, stackStart, vm.ctx.stack))
+	}
+}
+
+func (vm *VM) i64Eq() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popUint64()
+	v1 := vm.popUint64()
+	cond := v1 == v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x51, "i64 Equal", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64Ne() {
-	vm.pushBool(vm.popUint64() != vm.popUint64())
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popUint64()
+	v1 := vm.popUint64()
+	cond := v1 != v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x52, "i64 Not equal", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64LtS() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popInt64()
 	v1 := vm.popInt64()
-	vm.pushBool(v1 < v2)
+	cond := v1 < v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x53, "i64 Less than signed", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64LtU() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popUint64()
 	v1 := vm.popUint64()
-	vm.pushBool(v1 < v2)
+	cond := v1 < v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x54, "i64 Less than unsigned", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64GtS() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popInt64()
 	v1 := vm.popInt64()
-	vm.pushBool(v1 > v2)
+	cond := v1 > v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x55, "i64 Greater than signed", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64GtU() {
-	v2 := vm.popUint64()
-	v1 := vm.popUint64()
-	vm.pushBool(v1 > v2)
-}
+	stackStart := vm.ctx.stack
 
-func (vm *VM) i64LeU() {
+	// The operation we're logging
 	v2 := vm.popUint64()
 	v1 := vm.popUint64()
-	vm.pushBool(v1 <= v2)
+	cond := v1 > v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x56, "i64 Greater than unsigned", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64LeS() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popInt64()
 	v1 := vm.popInt64()
-	vm.pushBool(v1 <= v2)
+	cond := v1 <= v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x57, "i64 Less than or equal signed", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
+}
+
+func (vm *VM) i64LeU() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popUint64()
+	v1 := vm.popUint64()
+	cond := v1 <= v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x58, "i64 Less than or equal unsigned", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64GeS() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popInt64()
 	v1 := vm.popInt64()
-	vm.pushBool(v1 >= v2)
+	cond := v1 >= v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x59, "i64 Greater than or equal signed", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) i64GeU() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popUint64()
 	v1 := vm.popUint64()
-	vm.pushBool(v1 >= v2)
+	cond := v1 >= v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x5A, "i64 Greater than or equal unsigned", compareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack))
+	}
 }
 
 // float32 operators
@@ -578,8 +1014,10 @@ func (vm *VM) f32Abs() {
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x8B, "f32 Absolute", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x8B, "f32 Absolute", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Neg() {
@@ -591,8 +1029,10 @@ func (vm *VM) f32Neg() {
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x8C, "f32 Negative", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x8C, "f32 Negative", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Ceil() {
@@ -601,11 +1041,15 @@ func (vm *VM) f32Ceil() {
 	// The operation we're logging
 	v1 := vm.popFloat32()
 	val := float32(math.Ceil(float64(v1)))
+	vm.trackRound(float64(v1), float64(val))
+	val = vm.canonicalF32(val)
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x8D, "f32 Ceiling", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x8D, "f32 Ceiling", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Floor() {
@@ -614,11 +1058,15 @@ func (vm *VM) f32Floor() {
 	// The operation we're logging
 	v1 := vm.popFloat32()
 	val := float32(math.Floor(float64(v1)))
+	vm.trackRound(float64(v1), float64(val))
+	val = vm.canonicalF32(val)
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x8E, "f32 Floor", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x8E, "f32 Floor", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Trunc() {
@@ -627,11 +1075,15 @@ func (vm *VM) f32Trunc() {
 	// The operation we're logging
 	v1 := vm.popFloat32()
 	val := float32(math.Trunc(float64(v1)))
+	vm.trackRound(float64(v1), float64(val))
+	val = vm.canonicalF32(val)
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x8F, "f32 Trunc", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x8F, "f32 Trunc", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Nearest() {
@@ -639,12 +1091,16 @@ func (vm *VM) f32Nearest() {
 
 	// The operation we're logging
 	f := vm.popFloat32()
-	val := float32(int32(f + float32(math.Copysign(0.5, float64(f)))))
+	val := float32(math.RoundToEven(float64(f)))
+	vm.trackRound(float64(f), float64(val))
+	val = vm.canonicalF32(val)
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x90, "f32 Nearest", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, f, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x90, "f32 Nearest", unaryOpFields,
+			logData(vm.ctx.pc, f, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Sqrt() {
@@ -653,11 +1109,15 @@ func (vm *VM) f32Sqrt() {
 	// The operation we're logging
 	v1 := vm.popFloat32()
 	val := float32(math.Sqrt(float64(v1)))
+	vm.trackF32(floatSqrt, v1, 0, val)
+	val = vm.canonicalF32(val)
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x91, "f32 Square root", []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x91, "f32 Square root", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Add() {
@@ -667,11 +1127,15 @@ func (vm *VM) f32Add() {
 	v2 := vm.popFloat32()
 	v1 := vm.popFloat32()
 	val := v1 + v2
+	vm.trackF32(floatAdd, v1, v2, val)
+	val = vm.canonicalF32(val)
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x92, "f32 Add", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x92, "f32 Add", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Sub() {
@@ -681,11 +1145,15 @@ func (vm *VM) f32Sub() {
 	v2 := vm.popFloat32()
 	v1 := vm.popFloat32()
 	val := v1 - v2
+	vm.trackF32(floatSub, v1, v2, val)
+	val = vm.canonicalF32(val)
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x93, "f32 Sub", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x93, "f32 Sub", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Mul() {
@@ -695,11 +1163,15 @@ func (vm *VM) f32Mul() {
 	v2 := vm.popFloat32()
 	v1 := vm.popFloat32()
 	val := v1 * v2
+	vm.trackF32(floatMul, v1, v2, val)
+	val = vm.canonicalF32(val)
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x94, "f32 Multiply", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x94, "f32 Multiply", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Div() {
@@ -709,11 +1181,15 @@ func (vm *VM) f32Div() {
 	v2 := vm.popFloat32()
 	v1 := vm.popFloat32()
 	val := v1 / v2
+	vm.trackF32(floatDiv, v1, v2, val)
+	val = vm.canonicalF32(val)
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x95, "f32 Divide", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x95, "f32 Divide", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Min() {
@@ -722,12 +1198,16 @@ func (vm *VM) f32Min() {
 	// The operation we're logging
 	v2 := vm.popFloat32()
 	v1 := vm.popFloat32()
-	val := float32(math.Min(float64(v1), float64(v2)))
+	val := fmin32(v1, v2)
+	vm.trackSignaling(isSignaling32(v1) || isSignaling32(v2))
+	val = vm.canonicalF32(val)
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x96, "f32 Min", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x96, "f32 Min", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Max() {
@@ -736,12 +1216,16 @@ func (vm *VM) f32Max() {
 	// The operation we're logging
 	v2 := vm.popFloat32()
 	v1 := vm.popFloat32()
-	val := float32(math.Max(float64(v1), float64(v2)))
+	val := fmax32(v1, v2)
+	vm.trackSignaling(isSignaling32(v1) || isSignaling32(v2))
+	val = vm.canonicalF32(val)
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x97, "f32 Max", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x97, "f32 Max", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Copysign() {
@@ -754,8 +1238,10 @@ func (vm *VM) f32Copysign() {
 	vm.pushFloat32(val)
 
 	// Log this operation
-	opLog(vm, 0x98, "f32 Copy sign", []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x98, "f32 Copy sign", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Eq() {
@@ -768,8 +1254,10 @@ func (vm *VM) f32Eq() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x5B, "f32 Equal", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x5B, "f32 Equal", floatCompareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, math.IsNaN(float64(v1)) || math.IsNaN(float64(v2)), stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Ne() {
@@ -782,8 +1270,10 @@ func (vm *VM) f32Ne() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x5C, "f32 Not equal", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x5C, "f32 Not equal", floatCompareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, math.IsNaN(float64(v1)) || math.IsNaN(float64(v2)), stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Lt() {
@@ -796,8 +1286,10 @@ func (vm *VM) f32Lt() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x5D, "f32 Less than", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x5D, "f32 Less than", floatCompareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, math.IsNaN(float64(v1)) || math.IsNaN(float64(v2)), stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Gt() {
@@ -810,8 +1302,10 @@ func (vm *VM) f32Gt() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x5C, "f32 Greater than", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x5E, "f32 Greater than", floatCompareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, math.IsNaN(float64(v1)) || math.IsNaN(float64(v2)), stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Le() {
@@ -824,8 +1318,10 @@ func (vm *VM) f32Le() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x5F, "f32 Less than or equal", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x5F, "f32 Less than or equal", floatCompareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, math.IsNaN(float64(v1)) || math.IsNaN(float64(v2)), stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f32Ge() {
@@ -838,101 +1334,409 @@ func (vm *VM) f32Ge() {
 	vm.pushBool(cond)
 
 	// Log this operation
-	opLog(vm, 0x60, "f32 Greater than or equal", []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, v1, v2, cond, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x60, "f32 Greater than or equal", floatCompareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, math.IsNaN(float64(v1)) || math.IsNaN(float64(v2)), stackStart, vm.ctx.stack))
+	}
 }
 
 // float64 operators
 
 func (vm *VM) f64Abs() {
-	vm.pushFloat64(math.Abs(vm.popFloat64()))
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat64()
+	val := math.Abs(v1)
+	vm.pushFloat64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x99, "f64 Absolute", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Neg() {
-	vm.pushFloat64(-vm.popFloat64())
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat64()
+	val := -v1
+	vm.pushFloat64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x9A, "f64 Negative", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Ceil() {
-	vm.pushFloat64(math.Ceil(vm.popFloat64()))
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat64()
+	val := math.Ceil(v1)
+	vm.trackRound(v1, val)
+	val = vm.canonicalF64(val)
+	vm.pushFloat64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x9B, "f64 Ceiling", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Floor() {
-	vm.pushFloat64(math.Floor(vm.popFloat64()))
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat64()
+	val := math.Floor(v1)
+	vm.trackRound(v1, val)
+	val = vm.canonicalF64(val)
+	vm.pushFloat64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x9C, "f64 Floor", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Trunc() {
-	vm.pushFloat64(math.Trunc(vm.popFloat64()))
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat64()
+	val := math.Trunc(v1)
+	vm.trackRound(v1, val)
+	val = vm.canonicalF64(val)
+	vm.pushFloat64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x9D, "f64 Trunc", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Nearest() {
-	f := vm.popFloat64()
-	vm.pushFloat64(float64(int64(f + math.Copysign(0.5, f))))
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat64()
+	val := math.RoundToEven(v1)
+	vm.trackRound(v1, val)
+	val = vm.canonicalF64(val)
+	vm.pushFloat64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x9E, "f64 Nearest", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Sqrt() {
-	vm.pushFloat64(math.Sqrt(vm.popFloat64()))
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v1 := vm.popFloat64()
+	val := math.Sqrt(v1)
+	vm.trackF64(floatSqrt, v1, 0, val)
+	val = vm.canonicalF64(val)
+	vm.pushFloat64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x9F, "f64 Square root", unaryOpFields,
+			logData(vm.ctx.pc, v1, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Add() {
-	vm.pushFloat64(vm.popFloat64() + vm.popFloat64())
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popFloat64()
+	v1 := vm.popFloat64()
+	val := v1 + v2
+	vm.trackF64(floatAdd, v1, v2, val)
+	val = vm.canonicalF64(val)
+	vm.pushFloat64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xA0, "f64 Add", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Sub() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popFloat64()
 	v1 := vm.popFloat64()
-	vm.pushFloat64(v1 - v2)
+	val := v1 - v2
+	vm.trackF64(floatSub, v1, v2, val)
+	val = vm.canonicalF64(val)
+	vm.pushFloat64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xA1, "f64 Sub", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Mul() {
-	vm.pushFloat64(vm.popFloat64() * vm.popFloat64())
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popFloat64()
+	v1 := vm.popFloat64()
+	val := v1 * v2
+	vm.trackF64(floatMul, v1, v2, val)
+	val = vm.canonicalF64(val)
+	vm.pushFloat64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xA2, "f64 Multiply", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Div() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popFloat64()
 	v1 := vm.popFloat64()
-	vm.pushFloat64(v1 / v2)
+	val := v1 / v2
+	vm.trackF64(floatDiv, v1, v2, val)
+	val = vm.canonicalF64(val)
+	vm.pushFloat64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xA3, "f64 Divide", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Min() {
-	vm.pushFloat64(math.Min(vm.popFloat64(), vm.popFloat64()))
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popFloat64()
+	v1 := vm.popFloat64()
+	val := fmin64(v1, v2)
+	vm.trackSignaling(isSignaling64(v1) || isSignaling64(v2))
+	val = vm.canonicalF64(val)
+	vm.pushFloat64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xA4, "f64 Min", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Max() {
-	vm.pushFloat64(math.Max(vm.popFloat64(), vm.popFloat64()))
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popFloat64()
+	v1 := vm.popFloat64()
+	val := fmax64(v1, v2)
+	vm.trackSignaling(isSignaling64(v1) || isSignaling64(v2))
+	val = vm.canonicalF64(val)
+	vm.pushFloat64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xA5, "f64 Max", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Copysign() {
-	vm.pushFloat64(math.Copysign(vm.popFloat64(), vm.popFloat64()))
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popFloat64()
+	v1 := vm.popFloat64()
+	val := math.Copysign(v1, v2)
+	vm.pushFloat64(val)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0xA6, "f64 Copy sign", binaryOpFields,
+			logData(vm.ctx.pc, v1, v2, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Eq() {
-	vm.pushBool(vm.popFloat64() == vm.popFloat64())
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popFloat64()
+	v1 := vm.popFloat64()
+	cond := v1 == v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x61, "f64 Equal", floatCompareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, math.IsNaN(v1) || math.IsNaN(v2), stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Ne() {
-	vm.pushBool(vm.popFloat64() != vm.popFloat64())
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
+	v2 := vm.popFloat64()
+	v1 := vm.popFloat64()
+	cond := v1 != v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x62, "f64 Not equal", floatCompareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, math.IsNaN(v1) || math.IsNaN(v2), stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Lt() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popFloat64()
 	v1 := vm.popFloat64()
-	vm.pushBool(v1 < v2)
+	cond := v1 < v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x63, "f64 Less than", floatCompareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, math.IsNaN(v1) || math.IsNaN(v2), stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Gt() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popFloat64()
 	v1 := vm.popFloat64()
-	vm.pushBool(v1 > v2)
+	cond := v1 > v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x64, "f64 Greater than", floatCompareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, math.IsNaN(v1) || math.IsNaN(v2), stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Le() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popFloat64()
 	v1 := vm.popFloat64()
-	vm.pushBool(v1 <= v2)
+	cond := v1 <= v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x65, "f64 Less than or equal", floatCompareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, math.IsNaN(v1) || math.IsNaN(v2), stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) f64Ge() {
+	stackStart := vm.ctx.stack
+
+	// The operation we're logging
 	v2 := vm.popFloat64()
 	v1 := vm.popFloat64()
-	vm.pushBool(v1 >= v2)
+	cond := v1 >= v2
+	vm.pushBool(cond)
+
+	// Log this operation
+	if vm.logger != nil {
+		opLog(vm, 0x66, "f64 Greater than or equal", floatCompareOpFields,
+			logData(vm.ctx.pc, v1, v2, cond, math.IsNaN(v1) || math.IsNaN(v2), stackStart, vm.ctx.stack))
+	}
+}
+
+// fmin32, fmax32, fmin64 and fmax64 follow the WebAssembly rules for min and
+// max, rather than those of math.Min and math.Max. A NaN operand gives a NaN
+// result, keeping its payload, and -0 is less than +0.
+
+func fmin32(a, b float32) float32 {
+	switch {
+	case a != a || b != b:
+		return a + b // Propagates the NaN
+	case a == 0 && b == 0:
+		if math.Signbit(float64(a)) {
+			return a
+		}
+		return b
+	case a < b:
+		return a
+	}
+	return b
+}
+
+func fmax32(a, b float32) float32 {
+	switch {
+	case a != a || b != b:
+		return a + b // Propagates the NaN
+	case a == 0 && b == 0:
+		if math.Signbit(float64(a)) {
+			return b
+		}
+		return a
+	case a > b:
+		return a
+	}
+	return b
+}
+
+func fmin64(a, b float64) float64 {
+	switch {
+	case math.IsNaN(a) || math.IsNaN(b):
+		return a + b // Propagates the NaN
+	case a == 0 && b == 0:
+		if math.Signbit(a) {
+			return a
+		}
+		return b
+	case a < b:
+		return a
+	}
+	return b
+}
+
+func fmax64(a, b float64) float64 {
+	switch {
+	case math.IsNaN(a) || math.IsNaN(b):
+		return a + b // Propagates the NaN
+	case a == 0 && b == 0:
+		if math.Signbit(a) {
+			return b
+		}
+		return a
+	case a > b:
+		return a
+	}
+	return b
 }
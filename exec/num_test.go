@@ -0,0 +1,319 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"math"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// sleb128 encodes v as a signed LEB128 number, as used by the const
+// operators.
+func sleb128(v int64) []byte {
+	var b []byte
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+// execBinop runs (const v1) (const v2) (op) with the VM recovering from
+// panics, returning the result. The constants are i64 for the i64
+// operators, and i32 otherwise.
+func execBinop(t *testing.T, op byte, v1, v2 int64) (interface{}, error) {
+	typ, constOp := wasm.ValueTypeI32, ops.I32Const
+	if op >= ops.I64Clz && op <= ops.I64Rotr || op >= ops.I64Eqz && op <= ops.I64GeU {
+		typ, constOp = wasm.ValueTypeI64, ops.I64Const
+	}
+	m := buildTestModule(testFunc{
+		results: []wasm.ValueType{typ},
+		code:    append(append(append(append([]byte{constOp}, sleb128(v1)...), constOp), sleb128(v2)...), op),
+	})
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	return vm.ExecCode(0)
+}
+
+func TestIntegerDivideByZero(t *testing.T) {
+	for _, op := range []byte{
+		ops.I32DivS, ops.I32DivU, ops.I32RemS, ops.I32RemU,
+		ops.I64DivS, ops.I64DivU, ops.I64RemS, ops.I64RemU,
+	} {
+		if _, err := execBinop(t, op, 7, 0); trapCause(err) != ErrIntegerDivideByZero {
+			t.Errorf("opcode %#x: got error %v, want %v", op, err, ErrIntegerDivideByZero)
+		}
+		if _, err := execBinop(t, op, 7, 2); err != nil {
+			t.Errorf("opcode %#x: unexpected error for a non zero divisor: %v", op, err)
+		}
+	}
+}
+
+func TestIntegerOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		op byte
+		v1 int64
+	}{
+		{ops.I32DivS, math.MinInt32},
+		{ops.I64DivS, math.MinInt64},
+	} {
+		if _, err := execBinop(t, tc.op, tc.v1, -1); trapCause(err) != ErrIntegerOverflow {
+			t.Errorf("opcode %#x: got error %v, want %v", tc.op, err, ErrIntegerOverflow)
+		}
+		if _, err := execBinop(t, tc.op, tc.v1+1, -1); err != nil {
+			t.Errorf("opcode %#x: unexpected error dividing %d by -1: %v", tc.op, tc.v1+1, err)
+		}
+	}
+}
+
+func TestSignedRemainderOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		op   byte
+		v1   int64
+		want interface{}
+	}{
+		{ops.I32RemS, math.MinInt32, uint32(0)},
+		{ops.I64RemS, math.MinInt64, uint64(0)},
+		{ops.I32RemS, -7, uint32(0)},
+		{ops.I64RemS, 7, uint64(0)},
+	} {
+		res, err := execBinop(t, tc.op, tc.v1, -1)
+		if err != nil {
+			t.Errorf("opcode %#x: unexpected error for %d %% -1: %v", tc.op, tc.v1, err)
+		}
+		if res != tc.want {
+			t.Errorf("opcode %#x: %d %% -1 = %v, want %v", tc.op, tc.v1, res, tc.want)
+		}
+	}
+}
+
+func TestFloatMinMax(t *testing.T) {
+	nan := math.NaN()
+	negZero := math.Copysign(0, -1)
+	for _, tc := range []struct {
+		name   string
+		v1, v2 float64
+		min    float64
+		max    float64
+	}{
+		{"ordered", 1.5, -2, -2, 1.5},
+		{"equal", 3, 3, 3, 3},
+		{"infinities", math.Inf(-1), math.Inf(1), math.Inf(-1), math.Inf(1)},
+		{"NaN first", nan, 1, nan, nan},
+		{"NaN second", 1, nan, nan, nan},
+		{"NaN infinity", math.Inf(-1), nan, nan, nan},
+		{"-0 +0", negZero, 0, negZero, 0},
+		{"+0 -0", 0, negZero, negZero, 0},
+		{"-0 -0", negZero, negZero, negZero, negZero},
+	} {
+		for _, c := range []struct {
+			op     byte
+			result wasm.ValueType
+			code   []byte
+			want   float64
+		}{
+			{ops.F32Min, wasm.ValueTypeF32, f32Binop(ops.F32Min, float32(tc.v1), float32(tc.v2)), tc.min},
+			{ops.F32Max, wasm.ValueTypeF32, f32Binop(ops.F32Max, float32(tc.v1), float32(tc.v2)), tc.max},
+			{ops.F64Min, wasm.ValueTypeF64, f64Binop(ops.F64Min, tc.v1, tc.v2), tc.min},
+			{ops.F64Max, wasm.ValueTypeF64, f64Binop(ops.F64Max, tc.v1, tc.v2), tc.max},
+		} {
+			vm, err := NewVM(buildTestModule(testFunc{
+				results: []wasm.ValueType{c.result},
+				code:    c.code,
+			}))
+			if err != nil {
+				t.Fatalf("could not create VM: %v", err)
+			}
+			res, err := vm.ExecCode(0)
+			if err != nil {
+				t.Fatalf("%s: opcode %#x: error executing function: %v", tc.name, c.op, err)
+			}
+			var got float64
+			switch v := res.(type) {
+			case float32:
+				got = float64(v)
+			case float64:
+				got = v
+			}
+			if math.IsNaN(c.want) {
+				if !math.IsNaN(got) {
+					t.Errorf("%s: opcode %#x: got %v, want NaN", tc.name, c.op, got)
+				}
+			} else if math.Float64bits(got) != math.Float64bits(c.want) {
+				t.Errorf("%s: opcode %#x: got %v, want %v", tc.name, c.op, got, c.want)
+			}
+		}
+	}
+}
+
+func TestFloatNearest(t *testing.T) {
+	for _, tc := range []struct {
+		v, want float64
+	}{
+		{0.5, 0},
+		{1.5, 2},
+		{2.5, 2},
+		{3.5, 4},
+		{-0.5, math.Copysign(0, -1)},
+		{-2.5, -2},
+		{-2.6, -3},
+		{0.4, 0},
+		{1 << 40, 1 << 40},
+		{1<<52 + 1, 1<<52 + 1},
+		{-1e30, -1e30},
+		{math.Inf(1), math.Inf(1)},
+		{math.Inf(-1), math.Inf(-1)},
+		{math.NaN(), math.NaN()},
+	} {
+		// Drop the first constant, as nearest is unary
+		for _, c := range []struct {
+			result wasm.ValueType
+			code   []byte
+			want   float64
+		}{
+			{wasm.ValueTypeF32, f32Binop(ops.F32Nearest, 0, float32(tc.v))[5:], float64(float32(tc.want))},
+			{wasm.ValueTypeF64, f64Binop(ops.F64Nearest, 0, tc.v)[9:], tc.want},
+		} {
+			vm, err := NewVM(buildTestModule(testFunc{
+				results: []wasm.ValueType{c.result},
+				code:    c.code,
+			}))
+			if err != nil {
+				t.Fatalf("could not create VM: %v", err)
+			}
+			res, err := vm.ExecCode(0)
+			if err != nil {
+				t.Fatalf("nearest(%v): error executing function: %v", tc.v, err)
+			}
+			var got float64
+			switch v := res.(type) {
+			case float32:
+				got = float64(v)
+			case float64:
+				got = v
+			}
+			if math.IsNaN(c.want) {
+				if !math.IsNaN(got) {
+					t.Errorf("%v nearest(%v) = %v, want NaN", c.result, tc.v, got)
+				}
+			} else if math.Float64bits(got) != math.Float64bits(c.want) {
+				t.Errorf("%v nearest(%v) = %v, want %v", c.result, tc.v, got, c.want)
+			}
+		}
+	}
+}
+
+func TestShiftCountMasking(t *testing.T) {
+	for _, tc := range []struct {
+		op     byte
+		v1, v2 int64
+		want   interface{}
+	}{
+		{ops.I32Shl, 1, 32, uint32(1)},
+		{ops.I32Shl, 1, 33, uint32(2)},
+		{ops.I32Shl, 1, 64, uint32(1)},
+		{ops.I32Shl, 1, 65, uint32(2)},
+		{ops.I32ShrU, -1, 33, uint32(0x7fffffff)},
+		{ops.I32ShrU, 8, 65, uint32(4)},
+		{ops.I32ShrS, -8, 33, uint32(0xfffffffc)},
+		{ops.I32ShrS, -8, 32, uint32(0xfffffff8)},
+		{ops.I64Shl, 1, 64, uint64(1)},
+		{ops.I64Shl, 1, 65, uint64(2)},
+		{ops.I64Shl, 1, 32, uint64(1 << 32)},
+		{ops.I64Shl, 1, 33, uint64(1 << 33)},
+		{ops.I64ShrU, -1, 65, uint64(math.MaxInt64)},
+		{ops.I64ShrU, 8, 64, uint64(8)},
+		{ops.I64ShrS, -8, 65, uint64(0xfffffffffffffffc)},
+		{ops.I64ShrS, -8, 64, uint64(0xfffffffffffffff8)},
+	} {
+		res, err := execBinop(t, tc.op, tc.v1, tc.v2)
+		if err != nil {
+			t.Errorf("opcode %#x: unexpected error shifting %d by %d: %v", tc.op, tc.v1, tc.v2, err)
+		}
+		if res != tc.want {
+			t.Errorf("opcode %#x: shifting %d by %d gave %#x, want %#x", tc.op, tc.v1, tc.v2, res, tc.want)
+		}
+	}
+}
+
+func TestTruncSaturating(t *testing.T) {
+	nan := math.NaN()
+	for _, tc := range []struct {
+		v    float64
+		want [8]interface{} // results of i32.trunc_sat_f32_s through i64.trunc_sat_f64_u
+	}{
+		{nan, [8]interface{}{uint32(0), uint32(0), uint32(0), uint32(0), uint64(0), uint64(0), uint64(0), uint64(0)}},
+		{math.Inf(1), [8]interface{}{
+			uint32(math.MaxInt32), uint32(math.MaxUint32), uint32(math.MaxInt32), uint32(math.MaxUint32),
+			uint64(math.MaxInt64), uint64(math.MaxUint64), uint64(math.MaxInt64), uint64(math.MaxUint64),
+		}},
+		{math.Inf(-1), [8]interface{}{
+			uint32(0x80000000), uint32(0), uint32(0x80000000), uint32(0),
+			uint64(1 << 63), uint64(0), uint64(1 << 63), uint64(0),
+		}},
+		{1e30, [8]interface{}{
+			uint32(math.MaxInt32), uint32(math.MaxUint32), uint32(math.MaxInt32), uint32(math.MaxUint32),
+			uint64(math.MaxInt64), uint64(math.MaxUint64), uint64(math.MaxInt64), uint64(math.MaxUint64),
+		}},
+		{-1e30, [8]interface{}{
+			uint32(0x80000000), uint32(0), uint32(0x80000000), uint32(0),
+			uint64(1 << 63), uint64(0), uint64(1 << 63), uint64(0),
+		}},
+		{4294967296, [8]interface{}{
+			uint32(math.MaxInt32), uint32(math.MaxUint32), uint32(math.MaxInt32), uint32(math.MaxUint32),
+			uint64(4294967296), uint64(4294967296), uint64(4294967296), uint64(4294967296),
+		}},
+		{-2147483648, [8]interface{}{
+			uint32(0x80000000), uint32(0), uint32(0x80000000), uint32(0),
+			uint64(0xffffffff80000000), uint64(0), uint64(0xffffffff80000000), uint64(0),
+		}},
+		{-1.5, [8]interface{}{
+			uint32(0xffffffff), uint32(0), uint32(0xffffffff), uint32(0),
+			uint64(math.MaxUint64), uint64(0), uint64(math.MaxUint64), uint64(0),
+		}},
+		{-0.75, [8]interface{}{uint32(0), uint32(0), uint32(0), uint32(0), uint64(0), uint64(0), uint64(0), uint64(0)}},
+		{42.9, [8]interface{}{uint32(42), uint32(42), uint32(42), uint32(42), uint64(42), uint64(42), uint64(42), uint64(42)}},
+	} {
+		for i, op := range []byte{
+			ops.I32TruncSatF32S, ops.I32TruncSatF32U, ops.I32TruncSatF64S, ops.I32TruncSatF64U,
+			ops.I64TruncSatF32S, ops.I64TruncSatF32U, ops.I64TruncSatF64S, ops.I64TruncSatF64U,
+		} {
+			// Drop the first constant, as the conversions are unary
+			var code []byte
+			if op%4 < 2 {
+				code = f32Binop(ops.MiscPrefix, 0, float32(tc.v))[5:]
+			} else {
+				code = f64Binop(ops.MiscPrefix, 0, tc.v)[9:]
+			}
+			result := wasm.ValueTypeI32
+			if op >= ops.I64TruncSatF32S {
+				result = wasm.ValueTypeI64
+			}
+			vm, err := NewVM(buildTestModule(testFunc{
+				results: []wasm.ValueType{result},
+				code:    append(code, op),
+			}))
+			if err != nil {
+				t.Fatalf("could not create VM: %v", err)
+			}
+			res, err := vm.ExecCode(0)
+			if err != nil {
+				t.Fatalf("opcode %#x %#x: error converting %v: %v", ops.MiscPrefix, op, tc.v, err)
+			}
+			if res != tc.want[i] {
+				t.Errorf("opcode %#x %#x: converting %v gave %#x, want %#x", ops.MiscPrefix, op, tc.v, res, tc.want[i])
+			}
+		}
+	}
+}
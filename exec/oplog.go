@@ -0,0 +1,529 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/go-interpreter/wagon/exec/internal/compile"
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+	"github.com/jackc/pgx"
+)
+
+// OpLogger is the destination of the operation logging records, sent for
+// each executed operation when operation logging is enabled.
+type OpLogger interface {
+	// LogOp records a single executed operation. The record's Data slice
+	// is reused once LogOp returns, so it has to be copied to be kept.
+	LogOp(rec OpRecord) error
+	// Commit makes the records logged so far durable. It is called
+	// periodically during execution, and at the end of each ExecCode.
+	Commit() error
+}
+
+// OpRecord holds the logged details of a single executed operation.
+type OpRecord struct {
+	OpNum  int    // Sequence number of the operation, counted per VM
+	RunNum int    // Execution run number, as given with PGDBRun
+	OpCode byte   // Opcode of the operation
+	OpName string // Human readable name of the operation, or its mnemonic with WithMnemonicOpNames
+
+	// Fields and Data hold the operation specific details, with Fields[i]
+	// naming the value in Data[i]
+	Fields []string
+	Data   []interface{}
+}
+
+// OpLogSource provides the operation records of a previously logged run,
+// such as for replaying it.
+type OpLogSource interface {
+	// Ops returns the logged operations, in execution order.
+	Ops() ([]OpRecord, error)
+}
+
+// OpInitialState is the opcode of the record logged at the start of each
+// ExecCode, ahead of the executed operations, holding the state the run
+// starts from. Its fields are globals, with the values of the globals,
+// memory_size, with the size of the linear memory in bytes, and memory_hash,
+// with the hex encoded SHA-256 hash of the linear memory. 0xff isn't used by
+// any WebAssembly operator.
+const OpInitialState byte = 0xff
+
+// LogCommitFailurePolicy decides what happens when committing the operation
+// log fails during execution.
+type LogCommitFailurePolicy int
+
+const (
+	// LogCommitPanic panics with the commit error. This is the default.
+	LogCommitPanic LogCommitFailurePolicy = iota
+	// LogCommitDisable turns operation logging off for the rest of the
+	// VM's lifetime, and lets execution continue.
+	LogCommitDisable
+	// LogCommitAbort stops execution, with ExecCode returning the commit
+	// error. As with Process.Terminate, the VM needs a Restart before it
+	// can be used again.
+	LogCommitAbort
+)
+
+// pgLogger logs operations to the PostgreSQL table of its VM, inside the
+// VM's transaction.
+//
+// PostgreSQL has no unsigned integer types, so the unsigned 64-bit values,
+// such as i64 values and the stack entries, are stored as their signed
+// reinterpretation: the values from 2^63 up are stored as negative numbers,
+// with the same 64 bits. That way they fit in a bigint column, and nothing
+// is lost, though they need converting back when read, as with
+// PGOpLogSource. In SQL, adding 18446744073709551616 to the negative values
+// gives back the unsigned ones, as a numeric.
+type pgLogger struct {
+	vm *VM
+}
+
+func (l *pgLogger) LogOp(rec OpRecord) error {
+	var s, t string
+	for i, j := range rec.Fields {
+		s += ", " + j
+		t += fmt.Sprintf(", $%d", 5+i)
+	}
+	dbQuery := fmt.Sprintf(`
+		INSERT INTO %s (op_num, run_num, op_code, op_name%s)
+		VALUES ($1, $2, $3, $4%s)`, l.vm.pgTable, s, t)
+	args := []interface{}{rec.OpNum, rec.RunNum, rec.OpCode, rec.OpName}
+	for _, d := range rec.Data {
+		args = append(args, pgValue(d))
+	}
+	commandTag, err := l.vm.PgTx.Exec(dbQuery, args...)
+	if err != nil {
+		return err
+	}
+	if numRows := commandTag.RowsAffected(); numRows != 1 {
+		return fmt.Errorf("wrong number of rows (%v) affected when logging an operation: %v", numRows, rec.OpName)
+	}
+	return nil
+}
+
+// pgValue returns the value to store in PostgreSQL for a logged value,
+// reinterpreting the unsigned 64-bit values as signed, see pgLogger.
+func pgValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case uint64:
+		return int64(v)
+	case []uint64:
+		signed := make([]int64, len(v))
+		for i, u := range v {
+			signed[i] = int64(u)
+		}
+		return signed
+	}
+	return v
+}
+
+// Commit commits the current transaction, and begins a new one for the
+// operations still to come.
+func (l *pgLogger) Commit() error {
+	err := l.vm.PgTx.Commit()
+	if err != nil {
+		return err
+	}
+	l.vm.PgTx, err = l.vm.pg.Begin()
+	return err
+}
+
+// PGOpLogSource reads the operations of a run logged to PostgreSQL back from
+// the database. Only the details needed for replaying the run are read,
+// that is the op_num, op_code, op_name and stack_finish columns.
+type PGOpLogSource struct {
+	Pool   *pgx.ConnPool
+	Table  string // Defaults to "execution_run"
+	RunNum int
+}
+
+// Ops implements OpLogSource.
+func (src PGOpLogSource) Ops() ([]OpRecord, error) {
+	table := src.Table
+	if table == "" {
+		table = defaultPGTable
+	}
+	if !pgTableNameRE.MatchString(table) {
+		return nil, InvalidTableNameError(table)
+	}
+	rows, err := src.Pool.Query(fmt.Sprintf(`
+		SELECT op_num, op_code, op_name, stack_finish
+		FROM %s
+		WHERE run_num = $1
+		ORDER BY op_num`, table), src.RunNum)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var recs []OpRecord
+	for rows.Next() {
+		var (
+			rec   = OpRecord{RunNum: src.RunNum}
+			stack []int64
+		)
+		if err = rows.Scan(&rec.OpNum, &rec.OpCode, &rec.OpName, &stack); err != nil {
+			return nil, err
+		}
+		if stack != nil {
+			// Undo the signed reinterpretation done when logging
+			unsigned := make([]uint64, len(stack))
+			for i, v := range stack {
+				unsigned[i] = uint64(v)
+			}
+			rec.Fields = []string{"stack_finish"}
+			rec.Data = []interface{}{unsigned}
+		}
+		recs = append(recs, rec)
+	}
+	return recs, rows.Err()
+}
+
+// MemoryOpLogger keeps the logged operations in memory. As it's an
+// OpLogSource too, runs logged with it can be replayed. The zero value is
+// ready to use.
+type MemoryOpLogger struct {
+	mu   sync.Mutex
+	recs []OpRecord
+}
+
+// LogOp implements OpLogger. The stack, locals and memory images in the
+// record are copied, as the VM keeps on changing them.
+func (l *MemoryOpLogger) LogOp(rec OpRecord) error {
+	data := make([]interface{}, len(rec.Data))
+	for i, d := range rec.Data {
+		switch v := d.(type) {
+		case []uint64:
+			data[i] = append([]uint64(nil), v...)
+		case []byte:
+			data[i] = append([]byte(nil), v...)
+		default:
+			data[i] = d
+		}
+	}
+	rec.Data = data
+
+	l.mu.Lock()
+	l.recs = append(l.recs, rec)
+	l.mu.Unlock()
+	return nil
+}
+
+// Commit implements OpLogger. There's nothing to do, as the records are
+// kept in memory anyway.
+func (l *MemoryOpLogger) Commit() error {
+	return nil
+}
+
+// Ops implements OpLogSource.
+func (l *MemoryOpLogger) Ops() ([]OpRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]OpRecord(nil), l.recs...), nil
+}
+
+// MultiOpLogger sends each operation record to all of its loggers, such as
+// for logging to PostgreSQL while also keeping the records in memory. A
+// logger failing doesn't stop the others from getting the record, with the
+// errors returned together as a MultiOpLogError.
+type MultiOpLogger []OpLogger
+
+// LogOp implements OpLogger.
+func (m MultiOpLogger) LogOp(rec OpRecord) error {
+	var errs MultiOpLogError
+	for _, l := range m {
+		if err := l.LogOp(rec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.orNil()
+}
+
+// Commit implements OpLogger.
+func (m MultiOpLogger) Commit() error {
+	var errs MultiOpLogError
+	for _, l := range m {
+		if err := l.Commit(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.orNil()
+}
+
+// MultiOpLogError holds the errors returned by the loggers of a
+// MultiOpLogger.
+type MultiOpLogError []error
+
+func (e MultiOpLogError) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// orNil returns e as an error, or nil when it's empty.
+func (e MultiOpLogError) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Field returns the value logged for the named field, and whether the
+// record has it.
+func (rec OpRecord) Field(name string) (interface{}, bool) {
+	for i, f := range rec.Fields {
+		if f == name {
+			return rec.Data[i], true
+		}
+	}
+	return nil, false
+}
+
+// The fields logged by many of the operations, shared by them rather than
+// allocated for each logged operation.
+var (
+	controlOpFields      = []string{"program_counter", "stack_start"}
+	valueOpFields        = []string{"program_counter", "value", "stack_start", "stack_finish"}
+	unaryOpFields        = []string{"program_counter", "base_value", "result_value", "stack_start", "stack_finish"}
+	binaryOpFields       = []string{"program_counter", "base_value", "modifier_value", "result_value", "stack_start", "stack_finish"}
+	testOpFields         = []string{"program_counter", "value", "condition_met", "stack_start", "stack_finish"}
+	compareOpFields      = []string{"program_counter", "base_value", "modifier_value", "condition_met", "stack_start", "stack_finish"}
+	floatCompareOpFields = []string{"program_counter", "base_value", "modifier_value", "condition_met", "nan_operand", "stack_start", "stack_finish"}
+	memoryOpFields       = []string{"program_counter", "memory_address", "value", "stack_start", "stack_finish"}
+	localWriteOpFields   = []string{"program_counter", "local_id", "value", "locals_start", "locals_finish", "stack_start", "stack_finish"}
+	discardOpFields      = []string{"program_counter", "discarded_values", "stack_start", "stack_finish"}
+)
+
+// logDataPool holds the slices the logged values are passed to opLog in,
+// for reusing them from one logged operation to the next.
+var logDataPool = sync.Pool{
+	New: func() interface{} {
+		data := make([]interface{}, 0, 8)
+		return &data
+	},
+}
+
+// logData returns the given values in a slice from logDataPool, for passing
+// to opLog, which puts it back once the operation is logged.
+func logData(vals ...interface{}) []interface{} {
+	data := logDataPool.Get().(*[]interface{})
+	return append((*data)[:0], vals...)
+}
+
+// putLogData puts a slice returned by logData back into logDataPool,
+// dropping the values so they can be garbage collected.
+func putLogData(data []interface{}) {
+	for i := range data {
+		data[i] = nil
+	}
+	data = data[:0]
+	logDataPool.Put(&data)
+}
+
+// Send the opcode data to the operation logger for post-run analysis.  For now we don't return any error code, just to
+// keep the likely bulk code changes somewhat simple
+//
+// The callers check vm.logger is set before building the fields and data, so runs without operation logging don't
+// allocate them only for them to be thrown away. The data is passed in a slice from logData, which is reused once the
+// operation is logged
+func opLog(vm *VM, opCode byte, opName string, fields []string, data []interface{}) {
+	if vm.logger == nil {
+		// Operating logging isn't enabled
+		return
+	}
+	defer putLogData(data)
+	if len(fields) != len(data) {
+		log.Print("Mismatching field and data count to opLog()")
+		return
+	}
+	if vm.logSampleRate > 1 && (vm.opNum%vm.logSampleRate) != 0 {
+		// Not part of the sample, but still counts towards the operation numbering
+		vm.opNum++
+		return
+	}
+	if vm.stackDepthLogging {
+		fields, data = stackDepthFields(fields, data)
+	}
+	if vm.valueTypeLogging {
+		fields, data = vm.valueTypeFields(opCode, fields, data)
+	}
+	if vm.mnemonicOpNames {
+		opName = vm.mnemonicOpName(opCode, opName)
+	}
+	vm.lockLog()
+	err := vm.logger.LogOp(OpRecord{
+		OpNum:  vm.opNum,
+		RunNum: vm.PgRunNum,
+		OpCode: opCode,
+		OpName: opName,
+		Fields: fields,
+		Data:   data,
+	})
+	vm.unlockLog()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	// Commit every 100 inserts, so quitting via Ctrl+C keeps the majority of info thus far
+	if (vm.opNum % 100) == 0 {
+		if err = vm.commitLog(); err != nil {
+			panic(err)
+		}
+	}
+	vm.opNum++
+	return
+}
+
+// compiledOpNames holds the mnemonics of the instructions the interpreter
+// compiles blocks and branches to, named after the internal operators of
+// the operators package. Their opcodes are those of the block and branch
+// operators, which are compiled away, so they take precedence over them.
+var compiledOpNames = map[byte]string{
+	compile.OpJmp:                "wagon.jmp",
+	compile.OpJmpZ:               "wagon.jmpz",
+	compile.OpJmpNz:              "wagon.jmpnz",
+	compile.OpDiscard:            "wagon.discard",
+	compile.OpDiscardPreserveTop: "wagon.discardPreserveTop",
+}
+
+// mnemonicOpName returns the mnemonic of the operator with the given
+// opcode, as in the text format, such as "i32.ge_s". The given name is
+// returned for the records which aren't of an operator, such as that of
+// OpInitialState.
+func (vm *VM) mnemonicOpName(opCode byte, name string) string {
+	if mnemonic, ok := compiledOpNames[opCode]; ok {
+		return mnemonic
+	}
+	op, err := vm.loggedOp(opCode)
+	if err != nil {
+		return name
+	}
+	return op.Name
+}
+
+// loggedOp returns the operator of the operation being logged, with the
+// given opcode, which isn't one of compiledOpNames.
+func (vm *VM) loggedOp(opCode byte) (ops.Op, error) {
+	if opCode == ops.MiscPrefix {
+		return ops.NewMisc(uint32(vm.miscOpCode))
+	}
+	return ops.New(opCode)
+}
+
+// valueTypeFields adds the value_type field, holding the type of the values
+// the operator with the given opcode works on, as set out for
+// WithValueTypeLogging. It's left out when the operator has no such type.
+func (vm *VM) valueTypeFields(opCode byte, fields []string, data []interface{}) ([]string, []interface{}) {
+	if _, ok := compiledOpNames[opCode]; ok {
+		return fields, data
+	}
+	op, err := vm.loggedOp(opCode)
+	if err != nil || op.Polymorphic {
+		return fields, data
+	}
+	var typ wasm.ValueType
+	switch {
+	case opCode >= ops.I32Load && opCode <= ops.I64Load32u, len(op.Args) == 0:
+		typ = op.Returns
+	default:
+		// The stored value comes first for the stores
+		typ = op.Args[0]
+	}
+	name, ok := valueTypeNames[typ]
+	if !ok {
+		return fields, data
+	}
+
+	// The fields are shared between the records, so are copied to add to
+	return append(fields[:len(fields):len(fields)], "value_type"), append(data, name)
+}
+
+// valueTypeNames holds the names of the value types, as logged in the
+// value_type field.
+var valueTypeNames = map[wasm.ValueType]string{
+	wasm.ValueTypeI32: "i32",
+	wasm.ValueTypeI64: "i64",
+	wasm.ValueTypeF32: "f32",
+	wasm.ValueTypeF64: "f64",
+}
+
+// logInitialState logs the OpInitialState record for a run about to start.
+// Unlike the operations, it's logged regardless of the sampling rate.
+func (vm *VM) logInitialState() {
+	if vm.logger == nil {
+		return
+	}
+	hash := sha256.Sum256(vm.memory)
+	vm.lockLog()
+	err := vm.logger.LogOp(OpRecord{
+		OpNum:  vm.opNum,
+		RunNum: vm.PgRunNum,
+		OpCode: OpInitialState,
+		OpName: "Initial state",
+		Fields: []string{"globals", "memory_size", "memory_hash"},
+		Data:   []interface{}{vm.globals, len(vm.memory), hex.EncodeToString(hash[:])},
+	})
+	vm.unlockLog()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	vm.opNum++
+}
+
+// stackDepthFields replaces the stack_start and stack_finish fields with
+// stack_depth_start and stack_depth_finish, holding just the stack lengths.
+func stackDepthFields(fields []string, data []interface{}) ([]string, []interface{}) {
+	newFields := make([]string, len(fields))
+	newData := make([]interface{}, len(data))
+	for i, f := range fields {
+		newFields[i], newData[i] = f, data[i]
+		switch f {
+		case "stack_start", "stack_finish":
+			newFields[i] = "stack_depth" + strings.TrimPrefix(f, "stack")
+			stack, _ := data[i].([]uint64)
+			newData[i] = len(stack)
+		}
+	}
+	return newFields, newData
+}
+
+// commitLog commits the operation log, applying the configured failure
+// policy when that doesn't work out. The commit error is only returned for
+// the policies that don't deal with it themselves.
+func (vm *VM) commitLog() error {
+	if vm.logger == nil {
+		return nil
+	}
+	vm.lockLog()
+	defer vm.unlockLog()
+	err := vm.logger.Commit()
+	if err == nil {
+		return nil
+	}
+	switch vm.logCommitPolicy {
+	case LogCommitDisable:
+		log.Printf("Disabling operation logging, as committing the log failed: %v", err)
+		vm.logger = nil
+		return nil
+	case LogCommitAbort:
+		vm.abort = true
+		if vm.logErr == nil {
+			vm.logErr = err
+		}
+		return nil
+	}
+	return err
+}
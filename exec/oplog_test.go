@@ -0,0 +1,937 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/exec/internal/compile"
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+	"github.com/jackc/pgx"
+)
+
+// pgTestEnv names the environment variable holding the connection string of
+// the PostgreSQL database used by the operation logging tests. Those tests
+// are skipped when it isn't set.
+const pgTestEnv = "WAGON_TEST_DATABASE"
+
+// pgTestSchema creates an operation logging table, with the table name
+// filled in through fmt.Sprintf.
+const pgTestSchema = `
+	CREATE TABLE IF NOT EXISTS %s (
+		op_num             bigint NOT NULL,
+		run_num            bigint NOT NULL,
+		op_code            smallint NOT NULL,
+		op_name            text NOT NULL,
+		program_counter    bigint,
+		stack_start        bigint[],
+		stack_finish       bigint[],
+		locals_start       bigint[],
+		locals_finish      bigint[],
+		function_id        bigint,
+		function_name      text,
+		mem_image          bytea,
+		memory_address     bigint,
+		source_address     bigint,
+		length             bigint,
+		local_id           bigint,
+		from_global        bigint,
+		to_global          bigint,
+		target             bigint,
+		discard            bigint,
+		discarded_value    numeric,
+		discarded_values   bigint[],
+		preserve_top       boolean,
+		condition          numeric,
+		condition_met      boolean,
+		nan_operand        boolean,
+		overflowed         boolean,
+		value              numeric,
+		base_value         numeric,
+		modifier_value     numeric,
+		result_value       numeric,
+		arg_1              numeric,
+		arg_2              numeric,
+		globals            bigint[],
+		memory_size        bigint,
+		memory_hash        text,
+		stack_depth_start  bigint,
+		stack_depth_finish bigint,
+		value_type         text
+	)`
+
+// pgTestPool connects to the test database, making sure the logging table
+// exists.
+func pgTestPool(t *testing.T, table string) *pgx.ConnPool {
+	connStr := os.Getenv(pgTestEnv)
+	if connStr == "" {
+		t.Skipf("%s not set, skipping PostgreSQL operation logging test", pgTestEnv)
+	}
+	connCfg, err := pgx.ParseConnectionString(connStr)
+	if err != nil {
+		t.Fatalf("could not parse %s: %v", pgTestEnv, err)
+	}
+	pool, err := pgx.NewConnPool(pgx.ConnPoolConfig{ConnConfig: connCfg, MaxConnections: 2})
+	if err != nil {
+		t.Fatalf("could not connect to the test database: %v", err)
+	}
+	if _, err = pool.Exec(fmt.Sprintf(pgTestSchema, table)); err != nil {
+		pool.Close()
+		t.Fatalf("could not create the %s table: %v", table, err)
+	}
+	return pool
+}
+
+// pgTestRunNum returns a run number unlikely to clash with earlier test runs
+// logged to the same database.
+func pgTestRunNum() int {
+	return int(rand.Int31())
+}
+
+func TestCloseRollsBack(t *testing.T) {
+	pool := pgTestPool(t, "execution_run")
+	defer pool.Close()
+
+	m := buildTestModule(testFunc{
+		name:   "countdown",
+		params: []wasm.ValueType{wasm.ValueTypeI32},
+		code:   countdownLoop,
+	})
+	vm, err := NewVM(m, PGConnPool(pool), PGDBRun(pgTestRunNum()))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	// Abandon a run part way through
+	if err = vm.Enter(0, 10); err != nil {
+		t.Fatalf("Enter: unexpected error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err = vm.Step(); err != nil {
+			t.Fatalf("Step: unexpected error: %v", err)
+		}
+	}
+	if err = vm.Close(); err != nil {
+		t.Fatalf("Close: unexpected error: %v", err)
+	}
+	if status := vm.PgTx.Status(); status != pgx.TxStatusRollbackSuccess {
+		t.Errorf("got transaction status %d after closing, want %d", status, pgx.TxStatusRollbackSuccess)
+	}
+	if stat := pool.Stat(); stat.AvailableConnections != stat.CurrentConnections {
+		t.Errorf("%d of %d connections still in use after closing", stat.CurrentConnections-stat.AvailableConnections, stat.CurrentConnections)
+	}
+}
+
+func TestLogSampleRate(t *testing.T) {
+	pool := pgTestPool(t, "execution_run")
+	defer pool.Close()
+
+	m := buildTestModule(testFunc{
+		name:   "countdown",
+		params: []wasm.ValueType{wasm.ValueTypeI32},
+		code:   countdownLoop,
+	})
+	runNum := pgTestRunNum()
+	vm, err := NewVM(m, PGConnPool(pool), PGDBRun(runNum), LogSampleRate(10))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	// 200 iterations of 5 operations each, following the initial state
+	first := vm.opNum + 1
+	if _, err = vm.ExecCode(0, 200); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	var rows int
+	err = pool.QueryRow(`
+		SELECT count(*)
+		FROM execution_run
+		WHERE run_num = $1
+			AND op_num >= $2
+			AND op_num < $3
+			AND op_code <> $4`, runNum, first, first+1000, OpInitialState).Scan(&rows)
+	if err != nil {
+		t.Fatalf("could not count the logged rows: %v", err)
+	}
+	if rows != 100 {
+		t.Errorf("got %d logged rows for 1000 operations, want 100", rows)
+	}
+
+	// The sampled rows keep their real operation number
+	var gaps int
+	err = pool.QueryRow(`
+		SELECT count(*)
+		FROM execution_run
+		WHERE run_num = $1
+			AND op_num % 10 <> 0
+			AND op_code <> $2`, runNum, OpInitialState).Scan(&gaps)
+	if err != nil {
+		t.Fatalf("could not check the logged operation numbers: %v", err)
+	}
+	if gaps != 0 {
+		t.Errorf("found %d logged rows with an op_num outside the sample", gaps)
+	}
+}
+
+func TestPGUnsigned64(t *testing.T) {
+	for _, v := range []uint64{0, 1, math.MaxInt64, 1 << 63, math.MaxUint64} {
+		signed, ok := pgValue(v).(int64)
+		if !ok || uint64(signed) != v {
+			t.Errorf("%#x: got %v for PostgreSQL, want an int64 with the same bits", v, pgValue(v))
+		}
+	}
+	stack, ok := pgValue([]uint64{math.MaxUint64, 2}).([]int64)
+	if !ok || !reflect.DeepEqual(stack, []int64{-1, 2}) {
+		t.Errorf("got stack %v for PostgreSQL, want [-1 2]", stack)
+	}
+
+	pool := pgTestPool(t, "execution_run")
+	defer pool.Close()
+
+	m := buildTestModule(testFunc{
+		results: []wasm.ValueType{wasm.ValueTypeI64},
+		code:    []byte{ops.I64Const, 0x7f}, // -1, so 0xffffffffffffffff
+	})
+	runNum := pgTestRunNum()
+	vm, err := NewVM(m, PGConnPool(pool), PGDBRun(runNum))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	var value string
+	err = pool.QueryRow(`
+		SELECT value::text
+		FROM execution_run
+		WHERE run_num = $1
+			AND op_code = $2`, runNum, ops.I64Const).Scan(&value)
+	if err != nil {
+		t.Fatalf("could not read the logged value: %v", err)
+	}
+	if value != "-1" {
+		t.Errorf("got logged value %s, want -1", value)
+	}
+
+	// The stack is read back as it was
+	recs, err := PGOpLogSource{Pool: pool, RunNum: runNum}.Ops()
+	if err != nil {
+		t.Fatalf("could not read the logged operations: %v", err)
+	}
+	var found bool
+	for _, rec := range recs {
+		if rec.OpCode != ops.I64Const {
+			continue
+		}
+		found = true
+		if stack, _ := rec.Field("stack_finish"); !reflect.DeepEqual(stack, []uint64{math.MaxUint64}) {
+			t.Errorf("got logged stack %v, want [%d]", stack, uint64(math.MaxUint64))
+		}
+	}
+	if !found {
+		t.Error("i64.const wasn't logged")
+	}
+}
+
+func TestPGTableNameInvalid(t *testing.T) {
+	m := buildTestModule(testFunc{name: "nop", code: []byte{ops.Nop}})
+	for _, name := range []string{
+		"execution_run; DROP TABLE execution_run",
+		"run-2024",
+		"1run",
+		`"quoted"`,
+		"public.execution_run",
+	} {
+		_, err := NewVM(m, PGTableName(name))
+		if _, ok := err.(InvalidTableNameError); !ok {
+			t.Errorf("table name %q: got error %v, want an InvalidTableNameError", name, err)
+		}
+	}
+	if _, err := NewVM(m, PGTableName("run_2024_expA")); err != nil {
+		t.Errorf("unexpected error for a valid table name: %v", err)
+	}
+}
+
+func TestPGTableName(t *testing.T) {
+	const table = "run_2024_expA"
+
+	pool := pgTestPool(t, table)
+	defer pool.Close()
+	if _, err := pool.Exec(fmt.Sprintf(pgTestSchema, defaultPGTable)); err != nil {
+		t.Fatalf("could not create the %s table: %v", defaultPGTable, err)
+	}
+
+	m := buildTestModule(testFunc{
+		name:   "countdown",
+		params: []wasm.ValueType{wasm.ValueTypeI32},
+		code:   countdownLoop,
+	})
+	runNum := pgTestRunNum()
+	vm, err := NewVM(m, PGConnPool(pool), PGDBRun(runNum), PGTableName(table))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0, 10); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	for _, tc := range []struct {
+		table string
+		want  bool
+	}{
+		{table, true},
+		{defaultPGTable, false},
+	} {
+		var rows int
+		err = pool.QueryRow(fmt.Sprintf(`
+			SELECT count(*)
+			FROM %s
+			WHERE run_num = $1`, tc.table), runNum).Scan(&rows)
+		if err != nil {
+			t.Fatalf("could not count the rows logged to %s: %v", tc.table, err)
+		}
+		if got := rows != 0; got != tc.want {
+			t.Errorf("%d rows logged to %s", rows, tc.table)
+		}
+	}
+}
+
+var errCommitFailed = errors.New("commit failed")
+
+// failingCommitLogger accepts every operation, but fails to commit them.
+type failingCommitLogger struct {
+	logged int
+}
+
+func (l *failingCommitLogger) LogOp(rec OpRecord) error {
+	l.logged++
+	return nil
+}
+
+func (l *failingCommitLogger) Commit() error {
+	return errCommitFailed
+}
+
+func TestLogCommitFailurePolicy(t *testing.T) {
+	m := buildTestModule(testFunc{
+		name:   "countdown",
+		params: []wasm.ValueType{wasm.ValueTypeI32},
+		code:   countdownLoop,
+	})
+
+	for _, tc := range []struct {
+		name      string
+		policy    LogCommitFailurePolicy
+		err       error
+		completed bool
+		logging   bool
+	}{
+		{"panic", LogCommitPanic, errCommitFailed, false, true},
+		{"disable", LogCommitDisable, nil, true, false},
+		{"abort", LogCommitAbort, errCommitFailed, false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := &failingCommitLogger{}
+			vm, err := NewVM(m, WithOpLogger(logger), WithLogCommitFailurePolicy(tc.policy))
+			if err != nil {
+				t.Fatalf("could not create VM: %v", err)
+			}
+			vm.RecoverPanic = true
+
+			// 200 iterations of 5 operations each, so a periodic commit
+			// happens before the loop is done
+			_, err = vm.ExecCode(0, 200)
+			if trapCause(err) != tc.err {
+				t.Errorf("got error %v, want %v", err, tc.err)
+			}
+			if completed := vm.ctx.locals[0] == 0; completed != tc.completed {
+				t.Errorf("execution completed: %v, want %v", completed, tc.completed)
+			}
+			if logging := vm.logger != nil; logging != tc.logging {
+				t.Errorf("logging enabled: %v, want %v", logging, tc.logging)
+			}
+			if logger.logged == 0 || logger.logged >= 1000 {
+				t.Errorf("%d operations logged, want logging to stop at the failed commit", logger.logged)
+			}
+		})
+	}
+}
+
+func TestOpLogI64(t *testing.T) {
+	var code []byte
+	for _, op := range []byte{ops.I64Clz, ops.I64Ctz, ops.I64Popcnt, ops.I64Eqz} {
+		code = append(code, ops.I64Const, 0x07, op, ops.Drop)
+	}
+	for op := ops.I64Eq; op <= ops.I64GeU; op++ {
+		code = append(code, ops.I64Const, 0x07, ops.I64Const, 0x03, op, ops.Drop)
+	}
+	for op := ops.I64Add; op <= ops.I64Rotr; op++ {
+		code = append(code, ops.I64Const, 0x07, ops.I64Const, 0x03, op, ops.Drop)
+	}
+	m := buildTestModule(testFunc{name: "i64", code: code})
+
+	var executed []byte
+	logger := &MemoryOpLogger{}
+	vm, err := NewVM(m, WithOpLogger(logger), WithOpHook(func(op byte, pc int64, stack []uint64) {
+		executed = append(executed, op)
+	}))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	recs, _ := logger.Ops()
+	var logged []byte
+	for _, rec := range recs {
+		if rec.OpCode == OpInitialState {
+			continue
+		}
+		logged = append(logged, rec.OpCode)
+		if rec.OpCode == ops.Nop {
+			continue
+		}
+		if _, ok := rec.Field("stack_finish"); !ok {
+			t.Errorf("no stack_finish logged for %q", rec.OpName)
+		}
+	}
+	if !bytes.Equal(logged, executed) {
+		t.Errorf("logged opcodes %#v, want %#v", logged, executed)
+	}
+}
+
+func TestOpLogF64(t *testing.T) {
+	// f64Binop gives (f64.const v1) (f64.const v2) (op), of which the
+	// unary operators only use the second constant
+	var code []byte
+	for op := ops.F64Abs; op <= ops.F64Sqrt; op++ {
+		c := f64Binop(op, 0, 2.25)
+		code = append(append(code, c[9:]...), ops.Drop)
+	}
+	for op := ops.F64Add; op <= ops.F64Copysign; op++ {
+		code = append(append(code, f64Binop(op, 1.5, -2)...), ops.Drop)
+	}
+	for op := ops.F64Eq; op <= ops.F64Ge; op++ {
+		code = append(append(code, f64Binop(op, 1.5, -2)...), ops.Drop)
+	}
+	m := buildTestModule(testFunc{name: "f64", code: code})
+
+	var executed []byte
+	logger := &MemoryOpLogger{}
+	vm, err := NewVM(m, WithOpLogger(logger), WithOpHook(func(op byte, pc int64, stack []uint64) {
+		executed = append(executed, op)
+	}))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	recs, _ := logger.Ops()
+	var logged []byte
+	for _, rec := range recs {
+		if rec.OpCode == OpInitialState {
+			continue
+		}
+		logged = append(logged, rec.OpCode)
+		if rec.OpCode < ops.F64Eq || rec.OpCode > ops.F64Copysign || (rec.OpCode > ops.F64Ge && rec.OpCode < ops.F64Abs) {
+			continue
+		}
+		if v, _ := rec.Field("base_value"); reflect.TypeOf(v) != reflect.TypeOf(float64(0)) {
+			t.Errorf("%q logged base_value %#v, want a float64", rec.OpName, v)
+		}
+		if rec.OpCode == ops.F64Copysign {
+			if v, _ := rec.Field("result_value"); v != float64(-1.5) {
+				t.Errorf("%q logged result_value %v, want -1.5", rec.OpName, v)
+			}
+		}
+	}
+	if !bytes.Equal(logged, executed) {
+		t.Errorf("logged opcodes %#v, want %#v", logged, executed)
+	}
+}
+
+func TestOpLogFloatStores(t *testing.T) {
+	code := []byte{ops.I32Const, 0x00, ops.F32Const, 0, 0, 0, 0, ops.F32Store, 0x02, 0x00}
+	endianess.PutUint32(code[3:], math.Float32bits(3.14))
+	f64 := []byte{ops.I32Const, 0x08, ops.F64Const, 0, 0, 0, 0, 0, 0, 0, 0, ops.F64Store, 0x03, 0x00}
+	endianess.PutUint64(f64[3:], math.Float64bits(3.14))
+	code = append(code, f64...)
+
+	logger := &MemoryOpLogger{}
+	vm, err := NewVM(memoryTestModule(testFunc{code: code}), WithOpLogger(logger))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	// The values stored are logged as floats, as they are when loaded,
+	// rather than as their bits
+	want := map[byte]interface{}{ops.F32Store: float32(3.14), ops.F64Store: float64(3.14)}
+	recs, _ := logger.Ops()
+	for _, rec := range recs {
+		w, ok := want[rec.OpCode]
+		if !ok {
+			continue
+		}
+		if v, _ := rec.Field("value"); v != w {
+			t.Errorf("%q logged value %#v, want %#v", rec.OpName, v, w)
+		}
+		delete(want, rec.OpCode)
+	}
+	if len(want) != 0 {
+		t.Errorf("stores not logged: %v", want)
+	}
+}
+
+func TestValueTypeLogging(t *testing.T) {
+	code := []byte{
+		ops.I64Const, 0x01,
+		ops.I64Const, 0x02,
+		ops.I64Eq,
+		ops.F32ConvertUI32,
+		ops.F64PromoteF32,
+		ops.SetLocal, 0x00,
+		ops.I32Const, 0x00,
+		ops.GetLocal, 0x00,
+		ops.F64Store, 0x03, 0x00,
+		ops.I32Const, 0x00,
+		ops.F32Load, 0x02, 0x00,
+		ops.Drop,
+	}
+	m := memoryTestModule(testFunc{
+		locals: []wasm.LocalEntry{{Count: 1, Type: wasm.ValueTypeF64}},
+		code:   code,
+	})
+	logger := &MemoryOpLogger{}
+	vm, err := NewVM(m, WithOpLogger(logger), WithValueTypeLogging(true))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	// The type of the operands, or of the value loaded, stored or pushed,
+	// and none for the operators taking any type
+	want := []interface{}{"i64", "i64", "i64", "i32", "f32", nil, "i32", nil, "f64", "i32", "f32", nil}
+	recs, _ := logger.Ops()
+	var got []interface{}
+	for _, rec := range recs {
+		if rec.OpCode == OpInitialState || rec.OpCode == ops.Nop {
+			continue
+		}
+		typ, _ := rec.Field("value_type")
+		got = append(got, typ)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got value types %v, want %v", got, want)
+	}
+}
+
+func TestOpLogDiscardedValues(t *testing.T) {
+	m := buildTestModule(testFunc{
+		name: "discard",
+		code: []byte{
+			ops.Block, 0x7f,
+			ops.I32Const, 0x07,
+			ops.I32Const, 0x09,
+			ops.Br, 0x00,
+			ops.End,
+			ops.Drop,
+		},
+	})
+	logger := &MemoryOpLogger{}
+	vm, err := NewVM(m, WithOpLogger(logger))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	// Branching out of the block keeps the 9 as its result, discarding
+	// the 7, with the 9 then dropped
+	want := map[byte]struct {
+		field string
+		value interface{}
+	}{
+		compile.OpDiscardPreserveTop: {"discarded_values", []uint64{7}},
+		ops.Drop:                     {"discarded_value", uint64(9)},
+	}
+	recs, _ := logger.Ops()
+	for _, rec := range recs {
+		w, ok := want[rec.OpCode]
+		if !ok {
+			continue
+		}
+		delete(want, rec.OpCode)
+		if v, _ := rec.Field(w.field); !reflect.DeepEqual(v, w.value) {
+			t.Errorf("%q logged %s %v, want %v", rec.OpName, w.field, v, w.value)
+		}
+	}
+	for op := range want {
+		t.Errorf("opcode %#x wasn't logged", op)
+	}
+}
+
+func TestOpLogInitialState(t *testing.T) {
+	m := memoryTestModule(testFunc{
+		name: "set",
+		code: []byte{ops.I32Const, 0x05, ops.SetGlobal, 0x00},
+	})
+	m.GlobalIndexSpace = []wasm.GlobalEntry{{
+		Type: wasm.GlobalVar{Type: wasm.ValueTypeI32, Mutable: true},
+		Init: []byte{ops.I32Const, 0x03, ops.End},
+	}}
+	logger := &MemoryOpLogger{}
+	vm, err := NewVM(m, WithOpLogger(logger))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.Memory()[10] = 0x2a
+	for run := 0; run < 2; run++ {
+		if _, err = vm.ExecCode(0); err != nil {
+			t.Fatalf("error executing function: %v", err)
+		}
+	}
+
+	recs, _ := logger.Ops()
+	var initial []OpRecord
+	for i, rec := range recs {
+		if rec.OpCode != OpInitialState {
+			continue
+		}
+		if i+1 == len(recs) || recs[i+1].OpCode != ops.I32Const {
+			t.Errorf("initial state record %d isn't followed by the first operation", i)
+		}
+		initial = append(initial, rec)
+	}
+	if len(initial) != 2 || recs[0].OpCode != OpInitialState {
+		t.Fatalf("got %d initial state records, want one leading each of the 2 runs", len(initial))
+	}
+
+	hash := sha256.Sum256(vm.Memory())
+	for i, want := range []uint64{3, 5} {
+		if g, _ := initial[i].Field("globals"); !reflect.DeepEqual(g, []uint64{want}) {
+			t.Errorf("run %d: logged globals %v, want [%d]", i, g, want)
+		}
+		if size, _ := initial[i].Field("memory_size"); size != wasmPageSize {
+			t.Errorf("run %d: logged memory_size %v, want %d", i, size, wasmPageSize)
+		}
+		if h, _ := initial[i].Field("memory_hash"); h != hex.EncodeToString(hash[:]) {
+			t.Errorf("run %d: logged memory_hash %v, want %x", i, h, hash)
+		}
+	}
+}
+
+func TestOpLogF32Comparisons(t *testing.T) {
+	var code []byte
+	for op := ops.F32Eq; op <= ops.F32Ge; op++ {
+		code = append(append(code, f32Binop(op, 1.5, -2)...), ops.Drop)
+	}
+	m := buildTestModule(testFunc{name: "f32", code: code})
+
+	var executed []byte
+	logger := &MemoryOpLogger{}
+	vm, err := NewVM(m, WithOpLogger(logger), WithOpHook(func(op byte, pc int64, stack []uint64) {
+		executed = append(executed, op)
+	}))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	recs, _ := logger.Ops()
+	var logged []byte
+	sawGt := false
+	for _, rec := range recs {
+		if rec.OpCode == OpInitialState {
+			continue
+		}
+		logged = append(logged, rec.OpCode)
+		if rec.OpName == "f32 Greater than" {
+			sawGt = true
+			if rec.OpCode != 0x5e {
+				t.Errorf("f32.gt logged with op_code %#x, want 0x5e", rec.OpCode)
+			}
+		}
+	}
+	if !sawGt {
+		t.Error("f32.gt wasn't logged")
+	}
+	if !bytes.Equal(logged, executed) {
+		t.Errorf("logged opcodes %#v, want %#v", logged, executed)
+	}
+}
+
+func TestOpLogNaNOperand(t *testing.T) {
+	nan := math.NaN()
+	for _, tc := range []struct {
+		v1, v2 float64
+		nan    bool
+	}{
+		{nan, 1, true},
+		{1, nan, true},
+		{nan, nan, true},
+		{1, 1, false},
+		{1, 2, false},
+	} {
+		var code []byte
+		for op := ops.F32Eq; op <= ops.F32Ge; op++ {
+			code = append(append(code, f32Binop(op, float32(tc.v1), float32(tc.v2))...), ops.Drop)
+		}
+		for op := ops.F64Eq; op <= ops.F64Ge; op++ {
+			code = append(append(code, f64Binop(op, tc.v1, tc.v2)...), ops.Drop)
+		}
+		logger := &MemoryOpLogger{}
+		vm, err := NewVM(buildTestModule(testFunc{code: code}), WithOpLogger(logger))
+		if err != nil {
+			t.Fatalf("could not create VM: %v", err)
+		}
+		if _, err = vm.ExecCode(0); err != nil {
+			t.Fatalf("error executing function: %v", err)
+		}
+
+		recs, _ := logger.Ops()
+		var compared int
+		for _, rec := range recs {
+			nanOperand, ok := rec.Field("nan_operand")
+			if !ok {
+				continue
+			}
+			compared++
+			if nanOperand != tc.nan {
+				t.Errorf("%s(%v, %v): got nan_operand %v, want %v", rec.OpName, tc.v1, tc.v2, nanOperand, tc.nan)
+			}
+			if tc.nan {
+				// Only ne is true with a NaN operand
+				cond, _ := rec.Field("condition_met")
+				want := rec.OpCode == ops.F32Ne || rec.OpCode == ops.F64Ne
+				if cond != want {
+					t.Errorf("%s(%v, %v): got condition_met %v, want %v", rec.OpName, tc.v1, tc.v2, cond, want)
+				}
+			}
+		}
+		if compared != 12 {
+			t.Errorf("got %d records with nan_operand, want 12", compared)
+		}
+	}
+}
+
+// countingLogger counts the operations logged, optionally failing each one.
+type countingLogger struct {
+	logged int
+	err    error
+}
+
+func (l *countingLogger) LogOp(rec OpRecord) error {
+	l.logged++
+	return l.err
+}
+
+func (l *countingLogger) Commit() error {
+	return nil
+}
+
+func TestMultiOpLogger(t *testing.T) {
+	mem, counter := &MemoryOpLogger{}, &countingLogger{}
+	vm := replayTestVM(t, WithOpLoggers(mem, counter))
+	if _, err := vm.ExecCode(0, 20); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	recs, _ := mem.Ops()
+	if len(recs) == 0 || len(recs) != counter.logged {
+		t.Errorf("memory logger got %d operations and counting logger %d, want the same non zero number", len(recs), counter.logged)
+	}
+
+	// A failing logger doesn't keep the record from the others
+	errFirst, errSecond := errors.New("first failed"), errors.New("second failed")
+	counter = &countingLogger{}
+	multi := MultiOpLogger{&countingLogger{err: errFirst}, counter, &countingLogger{err: errSecond}}
+	err := multi.LogOp(OpRecord{OpCode: ops.Nop})
+	if !reflect.DeepEqual(err, MultiOpLogError{errFirst, errSecond}) {
+		t.Errorf("got error %#v, want both loggers' errors", err)
+	}
+	if counter.logged != 1 {
+		t.Errorf("working logger got %d operations, want 1", counter.logged)
+	}
+	if err = multi.Commit(); err != nil {
+		t.Errorf("unexpected error committing: %v", err)
+	}
+}
+
+func TestStackDepthLogging(t *testing.T) {
+	logger := &MemoryOpLogger{}
+	vm := replayTestVM(t, WithOpLogger(logger), WithStackDepthLogging(true))
+	if _, err := vm.ExecCode(0, 3); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	recs, _ := logger.Ops()
+	var depths int
+	for _, rec := range recs {
+		for _, f := range []string{"stack_start", "stack_finish"} {
+			if _, ok := rec.Field(f); ok {
+				t.Errorf("%q logged %s with stack depth logging", rec.OpName, f)
+			}
+		}
+		start, okStart := rec.Field("stack_depth_start")
+		finish, okFinish := rec.Field("stack_depth_finish")
+		if !okStart || !okFinish {
+			continue
+		}
+		depths++
+		if rec.OpCode == ops.I32Const && finish.(int) != start.(int)+1 {
+			t.Errorf("%q logged stack depths %v and %v, want the depth to go up by one", rec.OpName, start, finish)
+		}
+	}
+	if depths == 0 {
+		t.Error("no stack depths logged")
+	}
+}
+
+func TestMnemonicOpNames(t *testing.T) {
+	code := []byte{
+		ops.I32Const, 0x01,
+		ops.I32Const, 0x02,
+		ops.I32GeS,
+		ops.Drop,
+		ops.F32Const, 0x00, 0x00, 0x00, 0x00,
+		ops.MiscPrefix, 0x00, // i32.trunc_sat_f32_s
+		ops.Drop,
+	}
+	names := func(opts ...VMOption) map[byte]string {
+		t.Helper()
+		logger := &MemoryOpLogger{}
+		vm, err := NewVM(buildTestModule(testFunc{code: code}), append(opts, WithOpLogger(logger))...)
+		if err != nil {
+			t.Fatalf("could not create VM: %v", err)
+		}
+		if _, err = vm.ExecCode(0); err != nil {
+			t.Fatalf("error executing function: %v", err)
+		}
+		recs, _ := logger.Ops()
+		names := make(map[byte]string)
+		for _, rec := range recs {
+			names[rec.OpCode] = rec.OpName
+		}
+		return names
+	}
+
+	got := names()
+	if want := "i32 Greater than or equal signed"; got[ops.I32GeS] != want {
+		t.Errorf("got op name %q for 0x4E, want %q", got[ops.I32GeS], want)
+	}
+	got = names(WithMnemonicOpNames(true))
+	for op, want := range map[byte]string{
+		ops.I32GeS:     "i32.ge_s",
+		ops.MiscPrefix: "i32.trunc_sat_f32_s",
+		OpInitialState: "Initial state",
+	} {
+		if got[op] != want {
+			t.Errorf("got op name %q for %#x, want %q", got[op], op, want)
+		}
+	}
+}
+
+func TestOverflowLogging(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		code       []byte
+		overflowed bool
+	}{
+		{"i32.add max uint32 + 1", []byte{ops.I32Const, 0x7f, ops.I32Const, 0x01, ops.I32Add}, true},
+		{"i32.add max int32 + 1", append(append([]byte{ops.I32Const}, sleb128(math.MaxInt32)...), ops.I32Const, 0x01, ops.I32Add), true},
+		{"i32.add 1 + 2", []byte{ops.I32Const, 0x01, ops.I32Const, 0x02, ops.I32Add}, false},
+		{"i32.sub 1 - 2", []byte{ops.I32Const, 0x01, ops.I32Const, 0x02, ops.I32Sub}, true},
+		{"i32.mul 3 * 4", []byte{ops.I32Const, 0x03, ops.I32Const, 0x04, ops.I32Mul}, false},
+		{"i64.mul 2^32 * 2^32", append(append(append([]byte{ops.I64Const}, sleb128(1<<32)...), ops.I64Const), append(sleb128(1<<32), ops.I64Mul)...), true},
+		{"i64.sub 5 - 3", []byte{ops.I64Const, 0x05, ops.I64Const, 0x03, ops.I64Sub}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, enabled := range []bool{false, true} {
+				logger := &MemoryOpLogger{}
+				m := buildTestModule(testFunc{code: append(tc.code, ops.Drop)})
+				vm, err := NewVM(m, WithOpLogger(logger), WithOverflowLogging(enabled))
+				if err != nil {
+					t.Fatalf("could not create VM: %v", err)
+				}
+				if _, err = vm.ExecCode(0); err != nil {
+					t.Fatalf("error executing function: %v", err)
+				}
+
+				recs, _ := logger.Ops()
+				var rec OpRecord
+				for _, rec = range recs {
+					if rec.OpCode == tc.code[len(tc.code)-1] {
+						break
+					}
+				}
+				overflowed, ok := rec.Field("overflowed")
+				if ok != enabled {
+					t.Errorf("overflowed logged %v with the option set to %v", ok, enabled)
+				}
+				if ok && overflowed != tc.overflowed {
+					t.Errorf("%s: got overflowed %v, want %v", rec.OpName, overflowed, tc.overflowed)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkOpLogDisabled(b *testing.B) {
+	m := buildTestModule(testFunc{
+		params: []wasm.ValueType{wasm.ValueTypeI32},
+		code:   countdownLoop,
+	})
+	vm, err := NewVM(m)
+	if err != nil {
+		b.Fatalf("could not create VM: %v", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = vm.ExecCode(0, 1000); err != nil {
+			b.Fatalf("error executing function: %v", err)
+		}
+	}
+}
+
+func BenchmarkOpLogEnabled(b *testing.B) {
+	m := buildTestModule(testFunc{
+		params: []wasm.ValueType{wasm.ValueTypeI32},
+		code:   countdownLoop,
+	})
+	vm, err := NewVM(m, WithOpLogger(&countingLogger{}))
+	if err != nil {
+		b.Fatalf("could not create VM: %v", err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = vm.ExecCode(0, 1000); err != nil {
+			b.Fatalf("error executing function: %v", err)
+		}
+	}
+}
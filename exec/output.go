@@ -0,0 +1,49 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Stdout returns where host functions write the module's standard output
+// to, such as a WASI fd_write to file descriptor 1 does. It's os.Stdout,
+// except while the output is captured by ExecCapturingOutput.
+func (proc *Process) Stdout() io.Writer {
+	if proc.vm.stdout != nil {
+		return proc.vm.stdout
+	}
+	return os.Stdout
+}
+
+// Stderr returns where host functions write the module's standard error
+// to, such as a WASI fd_write to file descriptor 2 does. It's os.Stderr,
+// except while the output is captured by ExecCapturingOutput.
+func (proc *Process) Stderr() io.Writer {
+	if proc.vm.stderr != nil {
+		return proc.vm.stderr
+	}
+	return os.Stderr
+}
+
+// ExecCapturingOutput calls the function with the given index and
+// arguments, as ExecCode does, returning what the host functions it calls
+// write to the standard output and error returned by Process.Stdout and
+// Process.Stderr, rather than letting them write it out. This is handy for
+// running WASI style programs, with host functions writing their output as
+// such.
+func (vm *VM) ExecCapturingOutput(fnIndex int64, args ...uint64) (stdout, stderr []byte, result interface{}, err error) {
+	var outBuf, errBuf bytes.Buffer
+	prevOut, prevErr := vm.stdout, vm.stderr
+	vm.stdout, vm.stderr = &outBuf, &errBuf
+	defer func() {
+		vm.stdout, vm.stderr = prevOut, prevErr
+	}()
+
+	result, err = vm.ExecCode(fnIndex, args...)
+	return outBuf.Bytes(), errBuf.Bytes(), result, err
+}
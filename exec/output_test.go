@@ -0,0 +1,87 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// fdWrite is a cut down WASI fd_write, writing the buffers described by the
+// iovecs at iovs to the standard output or error.
+func fdWrite(proc *Process, fd, iovs, iovsLen, nwritten int32) int32 {
+	const errBadF = 8
+	w := proc.Stdout()
+	switch fd {
+	case 1:
+	case 2:
+		w = proc.Stderr()
+	default:
+		return errBadF
+	}
+
+	var total uint32
+	for i := int32(0); i < iovsLen; i++ {
+		iov := make([]byte, 8)
+		proc.ReadAt(iov, int64(iovs+8*i))
+		buf := make([]byte, endianess.Uint32(iov[4:]))
+		proc.ReadAt(buf, int64(endianess.Uint32(iov)))
+		n, _ := w.Write(buf)
+		total += uint32(n)
+	}
+	out := make([]byte, 4)
+	endianess.PutUint32(out, total)
+	proc.WriteAt(out, int64(nwritten))
+	return 0
+}
+
+func TestExecCapturingOutput(t *testing.T) {
+	i32 := wasm.ValueTypeI32
+	m := buildTestModule(
+		testFunc{
+			results: []wasm.ValueType{i32},
+			code: []byte{
+				// fd_write(1, 8, 1, 24), with an iovec at 8 for the
+				// "hi" at 16
+				ops.I32Const, 0x01,
+				ops.I32Const, 0x08,
+				ops.I32Const, 0x01,
+				ops.I32Const, 0x18,
+				ops.Call, 0x01,
+			},
+		},
+		testFunc{
+			params:  []wasm.ValueType{i32, i32, i32, i32},
+			results: []wasm.ValueType{i32},
+			host:    fdWrite,
+		},
+	)
+	m.Memory = &wasm.SectionMemories{Entries: []wasm.Memory{{Limits: wasm.ResizableLimits{Initial: 1}}}}
+	m.LinearMemoryIndexSpace = [][]byte{{
+		0, 0, 0, 0, 0, 0, 0, 0,
+		16, 0, 0, 0, 2, 0, 0, 0,
+		'h', 'i',
+	}}
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	stdout, stderr, res, err := vm.ExecCapturingOutput(0)
+	if err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if res != uint32(0) {
+		t.Errorf("fd_write returned %v, want 0", res)
+	}
+	if string(stdout) != "hi" || len(stderr) != 0 {
+		t.Errorf("captured stdout %q and stderr %q, want stdout \"hi\"", stdout, stderr)
+	}
+	if vm.stdout != nil || vm.stderr != nil {
+		t.Error("the output is still captured after the call")
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"math"
+	"math/bits"
+)
+
+// The integer add, sub and mul operators are the same for signed and
+// unsigned integers, so they're taken to overflow when the result wraps
+// around for either reading of the operands, as logged in the overflowed
+// field with WithOverflowLogging.
+
+func addOverflows32(v1, v2 uint32) bool {
+	s1, s2 := int32(v1), int32(v2)
+	return v1+v2 < v1 || (s1 >= 0) == (s2 >= 0) && (s1+s2 >= 0) != (s1 >= 0)
+}
+
+func subOverflows32(v1, v2 uint32) bool {
+	s1, s2 := int32(v1), int32(v2)
+	return v1 < v2 || (s1^s2)&(s1^(s1-s2)) < 0
+}
+
+func mulOverflows32(v1, v2 uint32) bool {
+	s := int64(int32(v1)) * int64(int32(v2))
+	return uint64(v1)*uint64(v2) > math.MaxUint32 || s < math.MinInt32 || s > math.MaxInt32
+}
+
+func addOverflows64(v1, v2 uint64) bool {
+	s1, s2 := int64(v1), int64(v2)
+	return v1+v2 < v1 || (s1 >= 0) == (s2 >= 0) && (s1+s2 >= 0) != (s1 >= 0)
+}
+
+func subOverflows64(v1, v2 uint64) bool {
+	s1, s2 := int64(v1), int64(v2)
+	return v1 < v2 || (s1^s2)&(s1^(s1-s2)) < 0
+}
+
+func mulOverflows64(v1, v2 uint64) bool {
+	if hi, _ := bits.Mul64(v1, v2); hi != 0 {
+		return true
+	}
+	s1, s2 := int64(v1), int64(v2)
+	if s1 == 0 || s2 == 0 {
+		return false
+	}
+	s := s1 * s2
+	return s/s2 != s1 || (s1 == -1 && s2 == math.MinInt64) || (s2 == -1 && s1 == math.MinInt64)
+}
@@ -8,11 +8,13 @@ func (vm *VM) drop() {
 	stackStart := vm.ctx.stack
 
 	// The operation we're logging
-	vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-1]
+	discarded := vm.popUint64()
 
 	// Log this operation
-	opLog(vm, 0x1A, "Drop", []string{"program_counter", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x1A, "Drop", []string{"program_counter", "discarded_value", "stack_start", "stack_finish"},
+			logData(vm.ctx.pc, discarded, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) selectOp() {
@@ -32,6 +34,8 @@ func (vm *VM) selectOp() {
 	vm.pushUint64(val)
 
 	// Log this operation
-	opLog(vm, 0x1B, "Select", []string{"program_counter", "condition", "arg_1", "arg_2", "condition_met", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, c, val1, val2, cond, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x1B, "Select", []string{"program_counter", "condition", "arg_1", "arg_2", "condition_met", "value", "stack_start", "stack_finish"},
+			logData(vm.ctx.pc, c, val1, val2, cond, val, stackStart, vm.ctx.stack))
+	}
 }
@@ -0,0 +1,280 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// trapTests provoke different kinds of traps, from calling the function at
+// index fn.
+var trapTests = []struct {
+	name string
+	fn   int64
+	args []uint64
+	opts []VMOption
+
+	noReplay bool // Whether the trap doesn't happen when replaying, or asserting determinism
+}{
+	{name: "unreachable", fn: 0},
+	{name: "integer divide by zero", fn: 1},
+	{name: "out of bounds memory access", fn: 2},
+	{name: "host function panic", fn: 6},
+	{name: "host function runtime error", fn: 7},
+	{name: "calling a host function directly", fn: 3},
+	{name: "function index out of range", fn: 100},
+	{name: "negative function index", fn: -1},
+	{
+		name:     "log commit failure",
+		fn:       5,
+		args:     []uint64{200},
+		opts:     []VMOption{WithOpLogger(&failingCommitLogger{})},
+		noReplay: true, // Replay and AssertDeterministic suspend the VM's logging
+	},
+}
+
+func trapTestVM(t *testing.T, opts ...VMOption) *VM {
+	m := buildTestModule(
+		testFunc{name: "f0", code: []byte{ops.Unreachable}},
+		testFunc{name: "f1", code: []byte{ops.I32Const, 0x01, ops.I32Const, 0x00, ops.I32DivS, ops.Drop}},
+		testFunc{name: "f2", code: []byte{ops.I32Const, 0x00, ops.I32Load, 0x02, 0x00, ops.Drop}},
+		testFunc{name: "f3", host: func(proc *Process) { panic("host function failure") }},
+		testFunc{name: "f4", host: func(proc *Process) {
+			var m map[string]int
+			m["boom"]++
+		}},
+		testFunc{name: "f5", code: countdownLoop, params: []wasm.ValueType{wasm.ValueTypeI32}},
+		testFunc{name: "f6", code: []byte{ops.Call, 0x03}},
+		testFunc{name: "f7", code: []byte{ops.Call, 0x04}},
+	)
+	vm, err := NewVM(m, opts...)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	return vm
+}
+
+// noPanic fails the test if f panics.
+func noPanic(t *testing.T, f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Errorf("panic escaped: %v", r)
+		}
+	}()
+	return f()
+}
+
+func TestRecoverPanic(t *testing.T) {
+	for _, tc := range trapTests {
+		t.Run(tc.name, func(t *testing.T) {
+			args := tc.args
+			entryPoints := []struct {
+				name string
+				call func(vm *VM) error
+			}{
+				{"ExecCode", func(vm *VM) error {
+					_, err := vm.ExecCode(tc.fn, args...)
+					return err
+				}},
+				{"ExecCodeMulti", func(vm *VM) error {
+					_, err := vm.ExecCodeMulti(tc.fn, args...)
+					return err
+				}},
+				{"ExecCodeTyped", func(vm *VM) error {
+					typed := make([]WasmValue, len(args))
+					for i, arg := range args {
+						typed[i] = int32(arg) // The arguments are all i32s
+					}
+					_, err := vm.ExecCodeTyped(tc.fn, typed...)
+					return err
+				}},
+				{"ExecCodeByName", func(vm *VM) error {
+					_, err := vm.ExecCodeByName(fmt.Sprintf("f%d", tc.fn), args...)
+					return err
+				}},
+				{"RunInitSequence", func(vm *VM) error {
+					return vm.RunInitSequence([]string{fmt.Sprintf("f%d", tc.fn)})
+				}},
+				{"ExecNOps", func(vm *VM) error {
+					return vm.ExecNOps(tc.fn, 1000, args...)
+				}},
+				{"ExecWithMemProfile", func(vm *VM) error {
+					_, _, _, err := vm.ExecWithMemProfile(tc.fn, args...)
+					return err
+				}},
+				{"ExecCapturingOutput", func(vm *VM) error {
+					_, _, _, err := vm.ExecCapturingOutput(tc.fn, args...)
+					return err
+				}},
+				{"AssertDeterministic", func(vm *VM) error {
+					return vm.AssertDeterministic(tc.fn, args...)
+				}},
+				{"Step", func(vm *VM) error {
+					if err := vm.Enter(tc.fn, args...); err != nil {
+						return err
+					}
+					for {
+						done, err := vm.Step()
+						if done || err != nil {
+							return err
+						}
+					}
+				}},
+				{"Resume", func(vm *VM) error {
+					// Stop at the first instruction, to carry on from there
+					if err := vm.SetBreakpoint(tc.fn, 0); err != nil {
+						return err
+					}
+					if _, err := vm.ExecCode(tc.fn, args...); err != ErrBreakpointHit {
+						return fmt.Errorf("breakpoint not hit: %v", err)
+					}
+					vm.ClearBreakpoint(tc.fn, 0)
+					_, err := vm.Resume()
+					return err
+				}},
+				{"Replay", func(vm *VM) error {
+					// Record the operations up to the trap first
+					logger := &MemoryOpLogger{}
+					rec := trapTestVM(t, WithOpLogger(logger))
+					rec.ExecCode(tc.fn, args...)
+					return vm.Replay(logger, tc.fn, args...)
+				}},
+			}
+			for _, ep := range entryPoints {
+				vm := trapTestVM(t, tc.opts...)
+				err := noPanic(t, func() error { return ep.call(vm) })
+				replays := ep.name == "Replay" || ep.name == "AssertDeterministic"
+				if err == nil && !(replays && tc.noReplay) {
+					t.Errorf("%s: no error returned", ep.name)
+				}
+
+				// The VM is still usable afterwards
+				vm.Restart()
+				err = noPanic(t, func() error {
+					_, err := vm.ExecCode(5, 3)
+					return err
+				})
+				if err != nil && tc.opts == nil {
+					t.Errorf("%s: error running the VM again: %v", ep.name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestTrapKind(t *testing.T) {
+	for _, tc := range []struct {
+		fn   int64
+		kind TrapKind // 0 for errors which aren't traps
+	}{
+		{0, TrapUnreachable},
+		{1, TrapIntegerDivideByZero},
+		{2, TrapOutOfBoundsMemoryAccess},
+		{6, 0},
+		{100, 0}, // Passed to ExecCode, rather than trapped on
+	} {
+		vm := trapTestVM(t)
+		_, err := vm.ExecCode(tc.fn)
+		if err == nil {
+			t.Fatalf("function %d: no error returned", tc.fn)
+		}
+		trap, ok := err.(Trap)
+		switch {
+		case tc.kind == 0 && ok:
+			t.Errorf("function %d: got trap %v (%v) for an error which isn't one", tc.fn, trap, trap.TrapKind())
+		case tc.kind != 0 && !ok:
+			t.Errorf("function %d: error %v isn't a trap", tc.fn, err)
+		case ok && trap.TrapKind() != tc.kind:
+			t.Errorf("function %d: got trap kind %v, want %v", tc.fn, trap.TrapKind(), tc.kind)
+		}
+	}
+}
+
+func TestTrapError(t *testing.T) {
+	m := buildTestModule(
+		testFunc{name: "main", code: []byte{ops.Call, 0x01}},
+		testFunc{
+			name: "store",
+			code: []byte{
+				ops.I32Const, 0x00, ops.Drop, // At 0 and 5, once compiled
+				ops.I32Const, 0x7f, // -1, at 6
+				ops.I32Const, 0x01, // At 11
+				ops.I32Store, 0x02, 0x00, // At 16
+			},
+		},
+	)
+	m.Memory = &wasm.SectionMemories{Entries: []wasm.Memory{{Limits: wasm.ResizableLimits{Initial: 1}}}}
+	m.LinearMemoryIndexSpace = [][]byte{nil}
+
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	_, err = vm.ExecCode(0)
+	te, ok := err.(*TrapError)
+	if !ok {
+		t.Fatalf("got error %v (%T), want a *TrapError", err, err)
+	}
+	want := TrapError{
+		Err:       OutOfBoundsError{Addr: 0xffffffff, Size: 4, MemSize: wasmPageSize},
+		FuncIndex: 1,
+		FuncName:  "store",
+		PC:        16,
+		Op:        ops.I32Store,
+		OpName:    "i32.store",
+	}
+	got := *te
+	got.Frames = nil
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	// The call to store is at the end of main's call instruction, taking the
+	// opcode and a 4 byte function index
+	if len(te.Frames) != 2 || te.Frames[0] != (Frame{FuncIndex: 0, PC: 5}) || te.Frames[1].FuncIndex != 1 {
+		t.Errorf("got call stack %v, want store called from main", te.Frames)
+	}
+	if trapCause(err) != ErrOutOfBoundsMemoryAccess || te.TrapKind() != TrapOutOfBoundsMemoryAccess {
+		t.Errorf("got trap %v of kind %v, want %v", te.Unwrap(), te.TrapKind(), ErrOutOfBoundsMemoryAccess)
+	}
+	if got, want := te.Error(), "exec: out of bounds memory access, of 4 bytes at 0xffffffff in 65536 bytes of memory, at i32.store (pc 0x10) in store"; got != want {
+		t.Errorf("got message %q, want %q", got, want)
+	}
+
+	// The panics which aren't traps record the calls active too, in a
+	// PanicError, the host functions being called from past the call
+	// instruction
+	msgs := map[string]string{
+		"host function panic":         "exec: host function failure",
+		"host function runtime error": "assignment to entry in nil map",
+	}
+	for _, tc := range trapTests {
+		msg, ok := msgs[tc.name]
+		if !ok {
+			continue
+		}
+		_, err := trapTestVM(t).ExecCode(tc.fn)
+		pe, ok := err.(*PanicError)
+		if !ok {
+			t.Errorf("%s: got error %v (%T), want a *PanicError", tc.name, err, err)
+			continue
+		}
+		if got := pe.Error(); got != msg {
+			t.Errorf("%s: got message %q, want %q", tc.name, got, msg)
+		}
+		if frames := []Frame{{FuncIndex: tc.fn, PC: 5}}; !reflect.DeepEqual(pe.Frames, frames) {
+			t.Errorf("%s: got call stack %v, want %v", tc.name, pe.Frames, frames)
+		}
+		if _, ok := err.(Trap); ok {
+			t.Errorf("%s: got a trap for a panic which isn't one", tc.name)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+)
+
+// ReplayDivergenceError is returned by Replay when the replayed execution
+// doesn't match the logged run.
+type ReplayDivergenceError struct {
+	OpNum  int    // Operation number (op_num) of the logged operation
+	OpCode byte   // Opcode of the logged operation
+	Reason string // What differs
+
+	// Want and Got hold the logged and the recomputed stack_finish values,
+	// when those are what differ
+	Want, Got []uint64
+}
+
+func (e ReplayDivergenceError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("exec: replay diverged at op_num %d (opcode 0x%02x): %s", e.OpNum, e.OpCode, e.Reason)
+	}
+	return fmt.Sprintf("exec: replay diverged at op_num %d (opcode 0x%02x): stack_finish is %v, logged %v", e.OpNum, e.OpCode, e.Got, e.Want)
+}
+
+// replayLogger checks each operation executed during a replay against the
+// next record of the logged run.
+type replayLogger struct {
+	vm   *VM
+	recs []OpRecord
+	next int
+	err  *ReplayDivergenceError
+}
+
+func (l *replayLogger) LogOp(rec OpRecord) error {
+	if l.err != nil {
+		return nil
+	}
+	if l.next >= len(l.recs) {
+		l.diverged(&ReplayDivergenceError{
+			OpNum:  rec.OpNum,
+			OpCode: rec.OpCode,
+			Reason: "operation executed past the end of the logged run",
+		})
+		return nil
+	}
+	want := l.recs[l.next]
+	l.next++
+
+	if rec.OpCode != want.OpCode {
+		l.diverged(&ReplayDivergenceError{
+			OpNum:  want.OpNum,
+			OpCode: want.OpCode,
+			Reason: fmt.Sprintf("executed opcode 0x%02x instead", rec.OpCode),
+		})
+		return nil
+	}
+	wantStack, ok := want.Field("stack_finish")
+	if !ok || wantStack == nil {
+		// Nothing to compare against
+		return nil
+	}
+	gotStack, _ := rec.Field("stack_finish")
+	w, _ := wantStack.([]uint64)
+	g, _ := gotStack.([]uint64)
+	if !equalStacks(w, g) {
+		l.diverged(&ReplayDivergenceError{
+			OpNum:  want.OpNum,
+			OpCode: want.OpCode,
+			Want:   w,
+			Got:    append([]uint64(nil), g...),
+		})
+	}
+	return nil
+}
+
+func (l *replayLogger) Commit() error {
+	return nil
+}
+
+// diverged records the divergence, and stops the replay.
+func (l *replayLogger) diverged(err *ReplayDivergenceError) {
+	l.err = err
+	l.vm.abort = true
+}
+
+func equalStacks(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Replay executes the function at index fnIndex with the given arguments,
+// checking that each executed operation matches the one logged for a
+// previous run of the same function. The opcodes have to match, and when
+// the logged record has a stack_finish value, so does the stack after
+// executing the operation.
+//
+// The source should hold a single run of ExecCode, logged without sampling
+// (see LogSampleRate). The first difference found is returned as a
+// ReplayDivergenceError. Operation logging configured for the VM is
+// suspended during the replay.
+func (vm *VM) Replay(src OpLogSource, fnIndex int64, args ...uint64) error {
+	recs, err := src.Ops()
+	if err != nil {
+		return err
+	}
+	if len(recs) == 0 {
+		return fmt.Errorf("exec: no logged operations to replay")
+	}
+
+	rl := &replayLogger{vm: vm, recs: recs}
+	logger, sampleRate := vm.logger, vm.logSampleRate
+	vm.logger, vm.logSampleRate = rl, 0
+	defer func() {
+		vm.logger, vm.logSampleRate = logger, sampleRate
+	}()
+
+	_, err = vm.ExecCode(fnIndex, args...)
+	if rl.err != nil {
+		// Let the VM be used again, as it was only stopped by the replay
+		vm.abort = false
+		return *rl.err
+	}
+	if err != nil {
+		return err
+	}
+	if rl.next < len(recs) {
+		rec := recs[rl.next]
+		return ReplayDivergenceError{
+			OpNum:  rec.OpNum,
+			OpCode: rec.OpCode,
+			Reason: "execution finished before the end of the logged run",
+		}
+	}
+	return nil
+}
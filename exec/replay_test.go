@@ -0,0 +1,81 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+func replayTestVM(t *testing.T, opts ...VMOption) *VM {
+	m := buildTestModule(testFunc{
+		name:   "countdown",
+		params: []wasm.ValueType{wasm.ValueTypeI32},
+		code:   countdownLoop,
+	})
+	vm, err := NewVM(m, opts...)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	return vm
+}
+
+func TestReplay(t *testing.T) {
+	logger := &MemoryOpLogger{}
+	vm := replayTestVM(t, WithOpLogger(logger))
+	if _, err := vm.ExecCode(0, 20); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	recs, _ := logger.Ops()
+	if len(recs) == 0 {
+		t.Fatal("nothing logged")
+	}
+
+	if err := replayTestVM(t).Replay(logger, 0, 20); err != nil {
+		t.Errorf("unexpected error replaying the run: %v", err)
+	}
+}
+
+func TestReplayDivergence(t *testing.T) {
+	logger := &MemoryOpLogger{}
+	vm := replayTestVM(t, WithOpLogger(logger))
+	if _, err := vm.ExecCode(0, 20); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	// Tamper with the logged stack of an operation part way through
+	recs, _ := logger.Ops()
+	var tampered *OpRecord
+	for i := len(recs) / 2; i < len(recs); i++ {
+		if s, ok := recs[i].Field("stack_finish"); ok && len(s.([]uint64)) != 0 {
+			tampered = &recs[i]
+			break
+		}
+	}
+	if tampered == nil {
+		t.Fatal("no logged operation with a non empty stack_finish")
+	}
+	s, _ := tampered.Field("stack_finish")
+	s.([]uint64)[0]++
+
+	vm = replayTestVM(t)
+	err := vm.Replay(&MemoryOpLogger{recs: recs}, 0, 20)
+	div, ok := err.(ReplayDivergenceError)
+	if !ok {
+		t.Fatalf("got error %v, want a ReplayDivergenceError", err)
+	}
+	if div.OpNum != tampered.OpNum {
+		t.Errorf("divergence reported at op_num %d, want %d", div.OpNum, tampered.OpNum)
+	}
+
+	// A replay with different arguments diverges too, without breaking the VM
+	if err = vm.Replay(logger, 0, 19); err == nil {
+		t.Error("replay with different arguments didn't diverge")
+	}
+	if _, err = vm.ExecCode(0, 3); err != nil {
+		t.Errorf("error executing function after a diverged replay: %v", err)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/go-interpreter/wagon/wasm"
+	"github.com/go-interpreter/wagon/wasm/leb128"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// WithImportResolver makes NewVM resolve the functions, globals and memories
+// the module imports with resolve, which is called with the names of each
+// of them, returning what it's resolved to, and whether it's resolved. A
+// function is resolved to a Go function, called as a host function, as for
+// RegisterHostFunc, a global to an int32 or uint32 for an i32, an int64 or
+// uint64 for an i64, a float32 for an f32 or a float64 for an f64, and a
+// memory to a []byte, copied into the VM's memory, which starts out at least
+// as large, before the module's data segments. Imported globals can't be
+// mutable.
+//
+// The module itself is left as it is, and may have been read with its
+// imports resolved already, or without, being read with a nil resolve
+// function. An UnresolvedImportsError listing them is returned when some of
+// the imports aren't resolved.
+func WithImportResolver(resolve func(module, field string) (interface{}, bool)) VMOption {
+	return func(c *config) {
+		c.ImportResolver = resolve
+	}
+}
+
+// UnresolvedImportsError is returned by NewVM, with WithImportResolver, when
+// some of the module's imports aren't resolved.
+type UnresolvedImportsError struct {
+	Names []string // Names of the imports, as module.field
+}
+
+func (e UnresolvedImportsError) Error() string {
+	return fmt.Sprintf("exec: unresolved imports: %s", strings.Join(e.Names, ", "))
+}
+
+// ImportValueError is returned by NewVM, with WithImportResolver, when an
+// imported global or memory is resolved to a value of the wrong type.
+type ImportValueError struct {
+	Module, Field string        // Names the global or memory is imported with
+	Kind          wasm.External // Whether it's a global or a memory
+	Value         interface{}   // Value it was resolved to
+}
+
+func (e ImportValueError) Error() string {
+	return fmt.Sprintf("exec: %v %s.%s can't be imported as a %T", e.Kind, e.Module, e.Field, e.Value)
+}
+
+// resolveImports returns a copy of the module m, with the functions, globals
+// and memories it imports resolved with resolve, as for WithImportResolver.
+func resolveImports(m *wasm.Module, resolve func(module, field string) (interface{}, bool)) (*wasm.Module, error) {
+	if m.Import == nil || len(m.Import.Entries) == 0 {
+		return m, nil
+	}
+
+	// Whether or not the imports were resolved when the module was read,
+	// the module's own functions and globals come last
+	funcs, globals := m.FunctionIndexSpace, m.GlobalIndexSpace
+	var definedFuncs, definedGlobals int
+	if m.Function != nil {
+		definedFuncs = len(m.Function.Types)
+	}
+	if m.Global != nil {
+		definedGlobals = len(m.Global.Globals)
+	}
+	if definedFuncs < len(funcs) {
+		funcs = funcs[len(funcs)-definedFuncs:]
+	}
+	if definedGlobals < len(globals) {
+		globals = globals[len(globals)-definedGlobals:]
+	}
+
+	r := *m
+	r.FunctionIndexSpace = nil
+	r.GlobalIndexSpace = nil
+	var (
+		memory     []byte
+		unresolved []string
+	)
+	for _, entry := range m.Import.Entries {
+		if entry.Type.Kind() == wasm.ExternalTable {
+			continue
+		}
+		val, ok := resolve(entry.ModuleName, entry.FieldName)
+		if !ok {
+			unresolved = append(unresolved, entry.ModuleName+"."+entry.FieldName)
+			continue
+		}
+
+		switch imp := entry.Type.(type) {
+		case wasm.FuncImport:
+			if m.Types == nil || int(imp.Type) >= len(m.Types.Entries) {
+				return nil, InvalidTypeIndexError(imp.Type)
+			}
+			sig := &m.Types.Entries[imp.Type]
+			fn := reflect.ValueOf(val)
+			if fn.Kind() != reflect.Func || fn.IsNil() || !hostFuncMatches(fn.Type(), sig) {
+				return nil, HostFuncTypeError{Module: entry.ModuleName, Field: entry.FieldName, Sig: sig, Type: reflect.TypeOf(val)}
+			}
+			r.FunctionIndexSpace = append(r.FunctionIndexSpace, wasm.Function{Sig: sig, Host: fn})
+		case wasm.GlobalVarImport:
+			if imp.Type.Mutable {
+				return nil, wasm.ErrImportMutGlobal
+			}
+			init, ok := globalInit(imp.Type.Type, val)
+			if !ok {
+				return nil, ImportValueError{Module: entry.ModuleName, Field: entry.FieldName, Kind: wasm.ExternalGlobal, Value: val}
+			}
+			r.GlobalIndexSpace = append(r.GlobalIndexSpace, wasm.GlobalEntry{Type: imp.Type, Init: init})
+		case wasm.MemoryImport:
+			mem, ok := val.([]byte)
+			if !ok {
+				return nil, ImportValueError{Module: entry.ModuleName, Field: entry.FieldName, Kind: wasm.ExternalMemory, Value: val}
+			}
+			memory = mem
+			entries := []wasm.Memory{imp.Type}
+			if m.Memory != nil {
+				entries = append(entries, m.Memory.Entries...)
+			}
+			r.Memory = &wasm.SectionMemories{Entries: entries}
+		}
+	}
+	if len(unresolved) != 0 {
+		return nil, UnresolvedImportsError{Names: unresolved}
+	}
+	r.FunctionIndexSpace = append(r.FunctionIndexSpace, funcs...)
+	r.GlobalIndexSpace = append(r.GlobalIndexSpace, globals...)
+
+	if r.Memory != m.Memory {
+		r.LinearMemoryIndexSpace = make([][]byte, len(r.Memory.Entries))
+		copy(r.LinearMemoryIndexSpace, m.LinearMemoryIndexSpace)
+		image, err := importedMemoryImage(&r, memory)
+		if err != nil {
+			return nil, err
+		}
+		r.LinearMemoryIndexSpace[0] = image
+		// The memory starts out no smaller than the one it's resolved to
+		if pages := uint32((len(memory) + wasmPageSize - 1) / wasmPageSize); pages > r.Memory.Entries[0].Limits.Initial {
+			r.Memory.Entries[0].Limits.Initial = pages
+		}
+	}
+	return &r, nil
+}
+
+// globalInit returns the init expression of an imported global of type t
+// resolved to val, or false when val isn't of that type.
+func globalInit(t wasm.ValueType, val interface{}) ([]byte, bool) {
+	var (
+		want wasm.ValueType
+		op   byte
+		imm  int64
+		bits []byte
+	)
+	switch v := val.(type) {
+	case int32:
+		want, op, imm = wasm.ValueTypeI32, ops.I32Const, int64(v)
+	case uint32:
+		want, op, imm = wasm.ValueTypeI32, ops.I32Const, int64(int32(v))
+	case int64:
+		want, op, imm = wasm.ValueTypeI64, ops.I64Const, v
+	case uint64:
+		want, op, imm = wasm.ValueTypeI64, ops.I64Const, int64(v)
+	case float32:
+		want, op, bits = wasm.ValueTypeF32, ops.F32Const, make([]byte, 4)
+		endianess.PutUint32(bits, math.Float32bits(v))
+	case float64:
+		want, op, bits = wasm.ValueTypeF64, ops.F64Const, make([]byte, 8)
+		endianess.PutUint64(bits, math.Float64bits(v))
+	default:
+		return nil, false
+	}
+	if t != want {
+		return nil, false
+	}
+
+	buf := bytes.NewBuffer([]byte{op})
+	if bits != nil {
+		buf.Write(bits)
+	} else {
+		leb128.WriteVarint64(buf, imm)
+	}
+	buf.WriteByte(ops.End)
+	return buf.Bytes(), true
+}
+
+// importedMemoryImage returns the initial contents of the imported memory
+// of the module m, the first in its linear memory index space, from the
+// contents mem it's resolved to and the module's data segments.
+func importedMemoryImage(m *wasm.Module, mem []byte) ([]byte, error) {
+	image := append([]byte(nil), mem...)
+	if m.Data == nil {
+		return image, nil
+	}
+	for _, entry := range m.Data.Entries {
+		if entry.Index != 0 {
+			continue
+		}
+		val, err := m.ExecInitExpr(entry.Offset)
+		if err != nil {
+			return nil, err
+		}
+		offset, ok := val.(int32)
+		if !ok {
+			return nil, wasm.InvalidValueTypeInitExprError{Wanted: reflect.Int32, Got: reflect.TypeOf(val).Kind()}
+		}
+		if end := int(uint32(offset)) + len(entry.Data); end > len(image) {
+			image = append(image, make([]byte, end-len(image))...)
+		}
+		copy(image[uint32(offset):], entry.Data)
+	}
+	return image, nil
+}
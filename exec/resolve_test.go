@@ -0,0 +1,129 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestImportResolver(t *testing.T) {
+	binary := []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32}
+	m := buildTestModule(
+		testFunc{params: binary, results: []wasm.ValueType{wasm.ValueTypeI32}, host: (func(*Process, int32, int32) int32)(nil)},
+		testFunc{params: binary, results: []wasm.ValueType{wasm.ValueTypeI32}, host: (func(*Process, int32, int32) int32)(nil)},
+		testFunc{
+			name:    "main",
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code: []byte{
+				ops.I32Const, 0x02,
+				ops.I32Const, 0x03,
+				ops.I32Const, 0x04,
+				ops.Call, 0x01, // mul
+				ops.Call, 0x00, // add
+			},
+		},
+	)
+	m.Import = &wasm.SectionImports{Entries: []wasm.ImportEntry{
+		{ModuleName: "env", FieldName: "add", Type: wasm.FuncImport{Type: 0}},
+		{ModuleName: "env", FieldName: "mul", Type: wasm.FuncImport{Type: 1}},
+	}}
+	// As read without resolving its imports
+	unresolved := *m
+	unresolved.FunctionIndexSpace = m.FunctionIndexSpace[2:]
+
+	imports := map[string]interface{}{
+		"env.add": func(proc *Process, a, b int32) int32 { return a + b },
+		"env.mul": func(proc *Process, a, b int32) int32 { return a * b },
+	}
+	resolve := func(module, field string) (interface{}, bool) {
+		fn, ok := imports[module+"."+field]
+		return fn, ok
+	}
+
+	for _, tc := range []struct {
+		name   string
+		module *wasm.Module
+	}{
+		{"resolved", m},
+		{"unresolved", &unresolved},
+	} {
+		vm, err := NewVM(tc.module, WithImportResolver(resolve))
+		if err != nil {
+			t.Fatalf("%s: could not create VM: %v", tc.name, err)
+		}
+		res, err := vm.ExecCode(2)
+		if err != nil {
+			t.Fatalf("%s: error executing function: %v", tc.name, err)
+		}
+		if res != uint32(14) {
+			t.Errorf("%s: got %v, want 14", tc.name, res)
+		}
+	}
+	if len(unresolved.FunctionIndexSpace) != 1 {
+		t.Errorf("the module was changed, having %d functions", len(unresolved.FunctionIndexSpace))
+	}
+
+	delete(imports, "env.add")
+	imports["env.mul"] = func(proc *Process, a int32) int32 { return a }
+	if _, err := NewVM(m, WithImportResolver(resolve)); !reflect.DeepEqual(err, HostFuncTypeError{Module: "env", Field: "mul", Sig: &m.Types.Entries[1], Type: reflect.TypeOf(imports["env.mul"])}) {
+		t.Errorf("got error %v resolving to a function of the wrong type", err)
+	}
+	delete(imports, "env.mul")
+	want := UnresolvedImportsError{Names: []string{"env.add", "env.mul"}}
+	if _, err := NewVM(m, WithImportResolver(resolve)); !reflect.DeepEqual(err, want) {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+	if got := want.Error(); got != "exec: unresolved imports: env.add, env.mul" {
+		t.Errorf("got message %q", got)
+	}
+}
+
+func TestImportResolverGlobalAndMemory(t *testing.T) {
+	m := buildTestModule(testFunc{
+		results: []wasm.ValueType{wasm.ValueTypeI32},
+		code:    []byte{ops.GetGlobal, 0x00, ops.I32Load, 0x02, 0x00},
+	})
+	m.Import = &wasm.SectionImports{Entries: []wasm.ImportEntry{
+		{ModuleName: "env", FieldName: "base", Type: wasm.GlobalVarImport{Type: wasm.GlobalVar{Type: wasm.ValueTypeI32}}},
+		{ModuleName: "env", FieldName: "memory", Type: wasm.MemoryImport{Type: wasm.Memory{Limits: wasm.ResizableLimits{Initial: 1}}}},
+	}}
+	m.Data = &wasm.SectionData{Entries: []wasm.DataSegment{
+		{Offset: []byte{ops.I32Const, 0x06, ops.End}, Data: []byte{0x33, 0x44}},
+	}}
+	m.LinearMemoryIndexSpace = [][]byte{nil}
+
+	imports := map[string]interface{}{
+		"env.base":   int32(4),
+		"env.memory": []byte{0, 0, 0, 0, 0x11, 0x22, 0xff, 0xff},
+	}
+	resolve := func(module, field string) (interface{}, bool) {
+		val, ok := imports[module+"."+field]
+		return val, ok
+	}
+	vm, err := NewVM(m, WithImportResolver(resolve))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	res, err := vm.ExecCode(0)
+	if err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if res != uint32(0x44332211) {
+		t.Errorf("got %#x, want 0x44332211", res)
+	}
+	if len(vm.Memory()) != wasmPageSize {
+		t.Errorf("got %d bytes of memory, want %d", len(vm.Memory()), wasmPageSize)
+	}
+
+	imports["env.base"] = int64(4)
+	want := ImportValueError{Module: "env", Field: "base", Kind: wasm.ExternalGlobal, Value: int64(4)}
+	if _, err = NewVM(m, WithImportResolver(resolve)); err != want {
+		t.Errorf("got error %v, want %v", err, want)
+	}
+}
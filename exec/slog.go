@@ -0,0 +1,62 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package exec
+
+import (
+	gocontext "context"
+	"log/slog"
+)
+
+// slogLogger logs operations as structured records of an slog.Logger, one
+// for each operation, with the fields logged as attributes.
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (l slogLogger) LogOp(rec OpRecord) error {
+	attrs := make([]slog.Attr, 0, 3+len(rec.Fields))
+	attrs = append(attrs,
+		slog.Int("op_num", rec.OpNum),
+		slog.Int("run_num", rec.RunNum),
+		slog.Int("op_code", int(rec.OpCode)),
+	)
+	for i, f := range rec.Fields {
+		attrs = append(attrs, slogAttr(f, rec.Data[i]))
+	}
+	l.l.LogAttrs(gocontext.Background(), slog.LevelInfo, rec.OpName, attrs...)
+	return nil
+}
+
+// Commit implements OpLogger. There's nothing to do, as the records are
+// handed to the slog.Logger as they're logged.
+func (l slogLogger) Commit() error {
+	return nil
+}
+
+// slogAttr returns the attribute for a logged value. The floats are logged
+// as their values, and the stacks and locals, which are copied, as they're
+// changed as execution carries on.
+func slogAttr(key string, v interface{}) slog.Attr {
+	switch v := v.(type) {
+	case float32:
+		return slog.Float64(key, float64(v))
+	case float64:
+		return slog.Float64(key, v)
+	case []uint64:
+		return slog.Any(key, append([]uint64(nil), v...))
+	}
+	return slog.Any(key, v)
+}
+
+// WithSlog logs the operations executed by the VM to l, without needing a
+// database, as structured records at the info level. Each record has the
+// operation's name as its message, and its number, opcode and logged fields,
+// such as program_counter, as attributes.
+func WithSlog(l *slog.Logger) VMOption {
+	return WithOpLogger(slogLogger{l: l})
+}
@@ -0,0 +1,78 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.21
+// +build go1.21
+
+package exec
+
+import (
+	gocontext "context"
+	"log/slog"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// capturingHandler keeps the slog records handled.
+type capturingHandler struct {
+	recs []slog.Record
+}
+
+func (h *capturingHandler) Enabled(gocontext.Context, slog.Level) bool { return true }
+func (h *capturingHandler) WithAttrs([]slog.Attr) slog.Handler         { return h }
+func (h *capturingHandler) WithGroup(string) slog.Handler              { return h }
+
+func (h *capturingHandler) Handle(_ gocontext.Context, rec slog.Record) error {
+	h.recs = append(h.recs, rec)
+	return nil
+}
+
+func TestSlog(t *testing.T) {
+	m := buildTestModule(testFunc{
+		results: []wasm.ValueType{wasm.ValueTypeF32},
+		code:    f32Binop(ops.F32Add, 1.5, 2.25),
+	})
+	h := &capturingHandler{}
+	vm, err := NewVM(m, WithSlog(slog.New(h)))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	var add *slog.Record
+	for i := range h.recs {
+		if h.recs[i].Message == "f32 Add" {
+			add = &h.recs[i]
+		}
+	}
+	if add == nil {
+		t.Fatalf("no record logged for f32.add, among %d records", len(h.recs))
+	}
+	attrs := map[string]slog.Value{}
+	add.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value
+		return true
+	})
+
+	if v := attrs["op_code"]; v.Kind() != slog.KindInt64 || v.Int64() != int64(ops.F32Add) {
+		t.Errorf("got op_code %v, want %d", v, ops.F32Add)
+	}
+	if _, ok := attrs["program_counter"]; !ok {
+		t.Error("no program_counter attribute")
+	}
+	for key, want := range map[string]float64{"base_value": 1.5, "modifier_value": 2.25, "result_value": 3.75} {
+		if v := attrs[key]; v.Kind() != slog.KindFloat64 || v.Float64() != want {
+			t.Errorf("got %s %v, want the float %v", key, v, want)
+		}
+	}
+	if v := attrs["stack_finish"]; v.Kind() != slog.KindAny {
+		t.Errorf("got stack_finish %v, want the stack", v)
+	} else if stack, ok := v.Any().([]uint64); !ok || len(stack) != 1 {
+		t.Errorf("got stack_finish %v, want the stack holding the result", v)
+	}
+}
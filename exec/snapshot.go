@@ -0,0 +1,73 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+// VMState is the execution state of a VM, as saved by Snapshot.
+type VMState struct {
+	ctx     context
+	callers []context
+	globals []uint64
+	memory  []byte
+	// The linear memories after the first, of modules with more than one
+	memories [][]byte
+
+	stepping      bool
+	breakpointHit bool
+}
+
+// copyContext returns a copy of ctx, with its own stack and locals.
+func copyContext(ctx context) context {
+	stack := make([]uint64, len(ctx.stack), cap(ctx.stack))
+	copy(stack, ctx.stack)
+	ctx.stack = stack
+	ctx.locals = append([]uint64(nil), ctx.locals...)
+	return ctx
+}
+
+// Snapshot saves the execution state of the VM: the operand stacks, locals
+// and program counters of the active function calls, the globals and the
+// linear memories. Restoring the state with Restore carries on from the same
+// point, such as for backtracking over different paths of execution.
+//
+// A snapshot is taken while the VM is stopped during a run: between calls
+// to Step, at a breakpoint (see SetBreakpoint), or from a host function or
+// op hook. The run is carried on after restoring with Step or Resume, as it
+// would have been after the snapshot. The state of the host functions, and
+// of operation logging, isn't part of the snapshot.
+func (vm *VM) Snapshot() *VMState {
+	s := &VMState{
+		ctx:           copyContext(vm.ctx),
+		callers:       make([]context, len(vm.callers)),
+		globals:       append([]uint64(nil), vm.globals...),
+		memory:        append([]byte(nil), vm.memory...),
+		memories:      copyMemories(vm.memories),
+		stepping:      vm.stepping,
+		breakpointHit: vm.breakpointHit,
+	}
+	for i, ctx := range vm.callers {
+		s.callers[i] = copyContext(ctx)
+	}
+	return s
+}
+
+// Restore puts the VM back in the execution state saved by Snapshot. The
+// state has to be from a VM of the same module. It's copied, rather than
+// used as is, so it can be restored any number of times.
+func (vm *VM) Restore(s *VMState) {
+	vm.ctx = copyContext(s.ctx)
+	vm.callers = vm.callers[:0]
+	for _, ctx := range s.callers {
+		vm.callers = append(vm.callers, copyContext(ctx))
+	}
+	vm.globals = append(vm.globals[:0], s.globals...)
+	vm.memory = append(vm.memory[:0], s.memory...)
+	vm.recordMemoryPeak()
+	vm.syncMemory()
+	vm.memories = copyMemories(s.memories)
+	vm.stepping = s.stepping
+	vm.breakpointHit = s.breakpointHit
+	vm.resuming = false
+	vm.abort = false
+}
@@ -0,0 +1,82 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestSnapshot(t *testing.T) {
+	// Counts its argument down to zero, storing each count in memory
+	m := memoryTestModule(testFunc{
+		params: []wasm.ValueType{wasm.ValueTypeI32},
+		code: []byte{
+			ops.Loop, 0x40,
+			ops.GetLocal, 0x00,
+			ops.GetLocal, 0x00,
+			ops.I32Store8, 0x00, 0x00,
+			ops.GetLocal, 0x00,
+			ops.I32Const, 0x01,
+			ops.I32Sub,
+			ops.TeeLocal, 0x00,
+			ops.BrIf, 0x00,
+			ops.End,
+		},
+	})
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	type step struct {
+		stack  []uint64
+		memory []byte
+	}
+	// run steps through to the end of the function, recording the stack
+	// and the start of the memory after each step
+	run := func() []step {
+		t.Helper()
+		var steps []step
+		for {
+			done, err := vm.Step()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			steps = append(steps, step{
+				stack:  vm.Stack(),
+				memory: append([]byte(nil), vm.Memory()[:8]...),
+			})
+			if done {
+				return steps
+			}
+		}
+	}
+
+	if err = vm.Enter(0, 5); err != nil {
+		t.Fatalf("Enter: unexpected error: %v", err)
+	}
+	for i := 0; i < 9; i++ {
+		if _, err = vm.Step(); err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		}
+	}
+	s := vm.Snapshot()
+	first := run()
+	if got, want := first[len(first)-1].memory, []byte{0, 1, 2, 3, 4, 5, 0, 0}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got memory %v, want %v", got, want)
+	}
+
+	vm.Restore(s)
+	if got := vm.Memory()[:8]; !reflect.DeepEqual(got, []byte{0, 0, 0, 0, 0, 5, 0, 0}) {
+		t.Errorf("got memory %v after restoring, want the memory at the snapshot", got)
+	}
+	if second := run(); !reflect.DeepEqual(second, first) {
+		t.Errorf("carried on after restoring with %v, want %v", second, first)
+	}
+}
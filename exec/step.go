@@ -0,0 +1,88 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ExecNOps calls the function at index fnIndex with the given arguments,
+// like ExecCode, but stops after executing at most n instructions, such as
+// for bisecting where a run diverges from a logged one. When stopped early,
+// the VM is left as it was before the next instruction, to be inspected
+// with CallStack, Stack, Memory and the like, up until the next run.
+//
+// The instructions counted are those of the code compiled for the
+// interpreter, including the jumps and discards it's made up of, the same
+// as the operations recorded by the operation log. A block of instructions
+// run as native code (see EnableAOT) counts as one instruction.
+func (vm *VM) ExecNOps(fnIndex int64, n int, args ...uint64) (err error) {
+	defer vm.beginRun()()
+	if n < 0 {
+		return fmt.Errorf("exec: invalid number of instructions to execute: %d", n)
+	}
+	if vm.RecoverPanic {
+		defer vm.recoverPanic(&err)
+	}
+	if vm.timeout > 0 {
+		defer vm.startTimeout()()
+	}
+	compiled, err := vm.enterFunc(fnIndex, args)
+	if err != nil {
+		return err
+	}
+
+	vm.limitOps, vm.opsLeft = true, n
+	defer func() {
+		vm.limitOps = false
+		if vm.paused {
+			// Let the VM be used again, as it was only stopped by the limit
+			vm.paused, vm.abort = false, false
+		}
+	}()
+	vm.execCode(compiled)
+	return vm.finishRun()
+}
+
+// Enter readies the VM for calling the function at index fnIndex with the
+// given arguments one instruction at a time, with Step, such as for a
+// debugger. Nothing is executed until the first call to Step.
+func (vm *VM) Enter(fnIndex int64, args ...uint64) error {
+	_, err := vm.enterFunc(fnIndex, args)
+	vm.stepping = err == nil
+	return err
+}
+
+// Step executes the next instruction of the function readied with Enter,
+// returning whether the function is done, having returned or trapped, or
+// the VM having been terminated. Once it's done, its result, if any, is at
+// the top of the stack returned by Stack, and Step returns an error until
+// the next call to Enter. Calls to other functions are executed in a single
+// step, as are the instructions run as native code (see EnableAOT).
+//
+// As with ExecCode, traps are returned as errors only with RecoverPanic set.
+func (vm *VM) Step() (done bool, err error) {
+	defer vm.beginRun()()
+	if !vm.stepping {
+		return true, errors.New("exec: no function entered to step through")
+	}
+	defer func() {
+		if err != nil {
+			done, vm.stepping = true, false
+		}
+	}()
+	if vm.RecoverPanic {
+		defer vm.recoverPanic(&err)
+	}
+
+	if int(vm.ctx.pc) < len(vm.ctx.code) && !vm.abort {
+		if !vm.execOp() && int(vm.ctx.pc) < len(vm.ctx.code) && !vm.abort {
+			return false, nil
+		}
+	}
+	vm.stepping = false
+	return true, vm.finishRun()
+}
@@ -0,0 +1,112 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestExecNOps(t *testing.T) {
+	m := buildTestModule(
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code: []byte{
+				ops.I32Const, 0x01,
+				ops.I32Const, 0x02,
+				ops.I32Const, 0x03,
+				ops.I32Add,
+				ops.I32Const, 0x04,
+				ops.I32Mul,
+				ops.I32Add,
+			},
+		},
+		testFunc{code: []byte{ops.Call, 0x02}},
+		testFunc{code: []byte{ops.I32Const, 0x07, ops.I32Const, 0x08, ops.Drop, ops.Drop}},
+	)
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	if err = vm.ExecNOps(0, 5); err != nil {
+		t.Fatalf("ExecNOps(0, 5): unexpected error: %v", err)
+	}
+	if got, want := vm.Stack(), []uint64{1, 5, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got stack %v after 5 instructions, want %v", got, want)
+	}
+
+	// Stopping in a called function leaves the call in place
+	if err = vm.ExecNOps(1, 2); err != nil {
+		t.Fatalf("ExecNOps(1, 2): unexpected error: %v", err)
+	}
+	if got, want := vm.Stack(), []uint64{7}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got stack %v after 2 instructions, want %v", got, want)
+	}
+	if frames := vm.CallStack(); len(frames) != 2 || frames[1].FuncIndex != 2 {
+		t.Errorf("got call stack %v after 2 instructions, want it to end in function 2", frames)
+	}
+
+	// The VM can run again after stopping early
+	res, err := vm.ExecCode(0)
+	if err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if res != uint32(21) {
+		t.Errorf("got result %v, want 21", res)
+	}
+}
+
+func TestStep(t *testing.T) {
+	m := buildTestModule(testFunc{
+		params:  []wasm.ValueType{wasm.ValueTypeI32},
+		results: []wasm.ValueType{wasm.ValueTypeI32},
+		code: []byte{
+			ops.I32Const, 0x02,
+			ops.I32Const, 0x03,
+			ops.I32Add,
+			ops.GetLocal, 0x00,
+			ops.I32Mul,
+		},
+	})
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	if _, err = vm.Step(); err == nil {
+		t.Error("Step: expected an error without entering a function first")
+	}
+	if err = vm.Enter(0, 7); err != nil {
+		t.Fatalf("Enter: unexpected error: %v", err)
+	}
+	want := [][]uint64{{2}, {2, 3}, {5}, {5, 7}, {35}}
+	for i := 0; ; i++ {
+		done, err := vm.Step()
+		if err != nil {
+			t.Fatalf("step %d: unexpected error: %v", i, err)
+		}
+		if i < len(want) {
+			if got := vm.Stack(); !reflect.DeepEqual(got, want[i]) {
+				t.Errorf("step %d: got stack %v, want %v", i, got, want[i])
+			}
+		}
+		if done {
+			if i < len(want)-1 {
+				t.Errorf("done after %d steps, want at least %d", i+1, len(want))
+			}
+			break
+		}
+		if i > len(want)+1 {
+			t.Fatalf("not done after %d steps", i+1)
+		}
+	}
+	if _, err = vm.Step(); err == nil {
+		t.Error("Step: expected an error once the function is done")
+	}
+}
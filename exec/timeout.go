@@ -0,0 +1,51 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ErrExecTimeout is the error value used while trapping the VM when a run
+// takes longer than the timeout set with WithTimeout.
+type ErrExecTimeout struct {
+	Timeout time.Duration
+}
+
+func (e ErrExecTimeout) Error() string {
+	return fmt.Sprintf("exec: execution timed out after %v", e.Timeout)
+}
+
+// TrapKind returns TrapExecTimeout.
+func (e ErrExecTimeout) TrapKind() TrapKind {
+	return TrapExecTimeout
+}
+
+// startTimeout starts timing the run, against the timeout set with
+// WithTimeout, returning the function stopping the timer once the run is
+// over.
+func (vm *VM) startTimeout() (stop func()) {
+	// The timer goes off on another goroutine, so each run gets its own
+	// flag, which a timer going off late can't mix up with another run's
+	expired, prev := new(int32), vm.expired
+	vm.expired = expired
+	timer := time.AfterFunc(vm.timeout, func() {
+		atomic.StoreInt32(expired, 1)
+	})
+	return func() {
+		timer.Stop()
+		// Back to the timer of the run this one is nested in, if any
+		vm.expired = prev
+	}
+}
+
+// checkTimeout traps the VM when the run has timed out.
+func (vm *VM) checkTimeout() {
+	if atomic.LoadInt32(vm.expired) != 0 {
+		panic(ErrExecTimeout{Timeout: vm.timeout})
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	m := buildTestModule(
+		testFunc{code: []byte{ops.Loop, 0x40, ops.Br, 0x00, ops.End}},
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code:    []byte{ops.I32Const, 0x02, ops.I32Const, 0x03, ops.I32Add},
+		},
+	)
+	vm, err := NewVM(m, WithTimeout(timeout))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+
+	start := time.Now()
+	_, err = vm.ExecCode(0)
+	te, ok := err.(*TrapError)
+	if !ok || te.Err != (ErrExecTimeout{Timeout: timeout}) {
+		t.Fatalf("got error %v running the endless loop, want a timeout", err)
+	}
+	if te.TrapKind() != TrapExecTimeout || te.FuncIndex != 0 || len(te.Frames) != 1 {
+		t.Errorf("got trap of kind %v in function %d, with call stack %v, want a timeout in the loop", te.TrapKind(), te.FuncIndex, te.Frames)
+	}
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Errorf("timed out after %v, before the %v timeout", elapsed, timeout)
+	}
+
+	// The timeout is per run, so the VM can carry on running
+	res, err := vm.ExecCode(1)
+	if err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if res != uint32(5) {
+		t.Errorf("got result %v, want 5", res)
+	}
+	if vm.expired != nil {
+		t.Error("the timer is still set after the run")
+	}
+}
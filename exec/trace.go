@@ -0,0 +1,115 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	gocontext "context"
+	"fmt"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// funcIndexKey is the attribute of the spans started with WithTracer holding
+// the index of the function called.
+const funcIndexKey = attribute.Key("wasm.function_index")
+
+// SetTraceContext sets the context the spans of the function calls made
+// from outside the VM are started in, with WithTracer, so they're children
+// of the span in ctx, such as that of the request the VM is run for.
+// Without it, they're root spans.
+func (vm *VM) SetTraceContext(ctx gocontext.Context) {
+	vm.traceCtx = ctx
+}
+
+// traceMark holds how many function calls are being traced, for ending
+// those of a run nested in another once it's over.
+type traceMark struct {
+	events, spans int
+}
+
+// funcName returns the name of the function with the given index, for
+// naming it in traces.
+func (vm *VM) funcName(index int64) string {
+	if name := vm.module.FunctionIndexSpace[index].Name; name != "" {
+		return name
+	}
+	return fmt.Sprintf("function %d", index)
+}
+
+// traceEnter records entering the function with the given index, when
+// tracing.
+func (vm *VM) traceEnter(index int64) {
+	if vm.tracer != nil {
+		cat := "wasm"
+		if _, ok := vm.funcs[index].(goFunction); ok {
+			cat = "host"
+		}
+		vm.tracer.begin(vm.funcName(index), cat)
+	}
+	if vm.spanTracer != nil {
+		ctx := vm.traceCtx
+		if ctx == nil {
+			ctx = gocontext.Background()
+		}
+		if len(vm.spans) > 0 {
+			ctx = trace.ContextWithSpan(ctx, vm.spans[len(vm.spans)-1])
+		}
+		_, span := vm.spanTracer.Start(ctx, vm.funcName(index), trace.WithAttributes(funcIndexKey.Int64(index)))
+		vm.spans = append(vm.spans, span)
+	}
+}
+
+// traceReturn records returning from the innermost function entered, when
+// tracing. A call paused in the middle is only returned from once resumed.
+func (vm *VM) traceReturn() {
+	if vm.paused {
+		return
+	}
+	if vm.tracer != nil {
+		vm.tracer.end()
+	}
+	if len(vm.spans) > 0 {
+		vm.spans[len(vm.spans)-1].End()
+		vm.spans = vm.spans[:len(vm.spans)-1]
+	}
+}
+
+// traceDepth returns the number of functions entered and not yet returned
+// from, when tracing.
+func (vm *VM) traceDepth() traceMark {
+	var mark traceMark
+	if vm.tracer != nil {
+		mark.events = len(vm.tracer.open)
+	}
+	mark.spans = len(vm.spans)
+	return mark
+}
+
+// traceTrap marks the spans of the calls of the current run which are cut
+// short by the trap err as failed.
+func (vm *VM) traceTrap(err error) {
+	for _, span := range vm.spans[vm.spanBase:] {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// traceRunEnd records returning from the functions the ending run entered,
+// until those of mark are left, unless it's only paused, such as at a
+// breakpoint.
+func (vm *VM) traceRunEnd(mark traceMark) {
+	if vm.paused || vm.stepping || vm.breakpointHit {
+		return
+	}
+	if vm.tracer != nil {
+		vm.tracer.endTo(mark.events)
+	}
+	for len(vm.spans) > mark.spans {
+		vm.spans[len(vm.spans)-1].End()
+		vm.spans = vm.spans[:len(vm.spans)-1]
+	}
+}
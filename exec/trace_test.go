@@ -0,0 +1,133 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	gocontext "context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanTree returns the tree of spans from s, such as main(double(check)),
+// out of the spans ended, with those with the error status marked with a !.
+func spanTree(s tracetest.SpanStub, spans tracetest.SpanStubs) string {
+	var b strings.Builder
+	b.WriteString(s.Name)
+	if s.Status.Code == codes.Error {
+		b.WriteString("!")
+	}
+	// The spans are ended by the time their parents are, in the order
+	// they're started in
+	var children []string
+	for _, c := range spans {
+		if c.Parent.SpanID() == s.SpanContext.SpanID() {
+			children = append(children, spanTree(c, spans))
+		}
+	}
+	if len(children) > 0 {
+		fmt.Fprintf(&b, "(%s)", strings.Join(children, " "))
+	}
+	return b.String()
+}
+
+func TestTracer(t *testing.T) {
+	i32 := []wasm.ValueType{wasm.ValueTypeI32}
+	m := buildTestModule(
+		testFunc{
+			name:    "main",
+			params:  i32,
+			results: i32,
+			code: []byte{
+				ops.GetLocal, 0x00, ops.Call, 0x01,
+				ops.GetLocal, 0x00, ops.Call, 0x01,
+				ops.I32Add,
+			},
+		},
+		testFunc{
+			name:    "double",
+			params:  i32,
+			results: i32,
+			code:    []byte{ops.GetLocal, 0x00, ops.Call, 0x02, ops.I32Const, 0x02, ops.I32Mul},
+		},
+		testFunc{
+			name:    "check",
+			params:  i32,
+			results: i32,
+			host: func(proc *Process, v int32) int32 {
+				return v
+			},
+		},
+		testFunc{
+			name:   "fail",
+			params: i32,
+			code:   []byte{ops.GetLocal, 0x00, ops.Call, 0x01, ops.Drop, ops.Call, 0x04},
+		},
+		testFunc{code: []byte{ops.Unreachable}},
+	)
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := tp.Tracer("wagon")
+	vm, err := NewVM(m, WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	ctx, request := tracer.Start(gocontext.Background(), "request")
+	vm.SetTraceContext(ctx)
+	vm.RecoverPanic = true
+	if res, err := vm.ExecCode(0, 3); err != nil || res != uint32(12) {
+		t.Fatalf("got %v and error %v, want 12", res, err)
+	}
+	if _, err = vm.ExecCode(3, 1); trapCause(err) != ErrUnreachable {
+		t.Fatalf("got error %v, want %v", err, ErrUnreachable)
+	}
+
+	// The request span isn't ended, so only the spans of the calls are
+	// exported, once they're ended
+	spans := exporter.GetSpans()
+	if len(spans) != 9 {
+		t.Fatalf("got %d spans ended, want 9", len(spans))
+	}
+	var roots []string
+	for _, s := range spans {
+		if s.Parent.SpanID() == request.SpanContext().SpanID() {
+			roots = append(roots, spanTree(s, spans))
+		}
+	}
+	want := []string{
+		"main(double(check) double(check))",
+		"fail!(double(check) function 4!)",
+	}
+	if len(roots) != len(want) {
+		t.Fatalf("got %d spans of calls from outside the VM, want %d", len(roots), len(want))
+	}
+	for i, root := range roots {
+		if root != want[i] {
+			t.Errorf("got span tree %s, want %s", root, want[i])
+		}
+	}
+
+	for _, s := range spans {
+		if s.Status.Code == codes.Error && !strings.Contains(s.Status.Description, ErrUnreachable.Error()) {
+			t.Errorf("span of %s failed with %q, want %v", s.Name, s.Status.Description, ErrUnreachable)
+		}
+		if s.Name != "function 4" {
+			continue
+		}
+		if len(s.Attributes) != 1 || s.Attributes[0] != funcIndexKey.Int64(4) {
+			t.Errorf("got attributes %v for the trapping call, want the function index 4", s.Attributes)
+		}
+		if len(s.Events) != 1 || s.Events[0].Name != "exception" {
+			t.Errorf("got events %v for the trapping call, want the trap's error recorded", s.Events)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TrapKind identifies the reason for a trap.
+type TrapKind int
+
+// The kinds of traps, one for each of the trap error values.
+const (
+	TrapUnreachable TrapKind = iota + 1
+	TrapOutOfBoundsMemoryAccess
+	TrapReservedByteNotZero
+	TrapIntegerDivideByZero
+	TrapIntegerOverflow
+	TrapSignatureMismatch
+	TrapUndefinedElementIndex
+	TrapCallStackExhausted
+	TrapCallLimitExceeded
+	TrapInvalidLocalIndex
+	TrapInvalidGlobalIndex
+	TrapStackUnderflow
+	TrapInvalidFunctionIndex
+	TrapInvalidTypeIndex
+	TrapExecTimeout
+	TrapUnboundImport
+	TrapHostReplayMismatch
+	TrapInvalidMemoryIndex
+)
+
+var trapKindNames = map[TrapKind]string{
+	TrapUnreachable:             "unreachable",
+	TrapOutOfBoundsMemoryAccess: "out of bounds memory access",
+	TrapReservedByteNotZero:     "reserved byte not zero",
+	TrapIntegerDivideByZero:     "integer divide by zero",
+	TrapIntegerOverflow:         "integer overflow",
+	TrapSignatureMismatch:       "signature mismatch",
+	TrapUndefinedElementIndex:   "undefined element index",
+	TrapCallStackExhausted:      "call stack exhausted",
+	TrapCallLimitExceeded:       "call limit exceeded",
+	TrapInvalidLocalIndex:       "invalid local index",
+	TrapInvalidGlobalIndex:      "invalid global index",
+	TrapStackUnderflow:          "stack underflow",
+	TrapInvalidFunctionIndex:    "invalid function index",
+	TrapInvalidTypeIndex:        "invalid type index",
+	TrapExecTimeout:             "execution timed out",
+	TrapUnboundImport:           "unbound import",
+	TrapHostReplayMismatch:      "host replay mismatch",
+	TrapInvalidMemoryIndex:      "invalid memory index",
+}
+
+func (k TrapKind) String() string {
+	if name, ok := trapKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("TrapKind(%d)", int(k))
+}
+
+// Trap is implemented by the errors the VM traps with when the running code
+// does something the spec doesn't allow, such as ErrUnreachable or
+// ErrOutOfBoundsMemoryAccess, so they can be told apart from the other
+// errors, and from each other by their kind:
+//
+//	if t, ok := err.(Trap); ok && t.TrapKind() == TrapIntegerDivideByZero {
+//		...
+//	}
+//
+// The traps recovered from with RecoverPanic are returned as a *TrapError,
+// which is a Trap of the same kind as the trap error value it wraps. When
+// the error may have been wrapped otherwise, such as by a host function, use
+// errors.As to find the Trap instead.
+type Trap interface {
+	error
+	TrapKind() TrapKind
+}
+
+// trapError is the error type of the trap error values.
+type trapError struct {
+	kind TrapKind
+	msg  string
+}
+
+func newTrap(kind TrapKind, msg string) error {
+	return &trapError{kind: kind, msg: msg}
+}
+
+func (e *trapError) Error() string {
+	return e.msg
+}
+
+func (e *trapError) TrapKind() TrapKind {
+	return e.kind
+}
+
+// indexTrap is the error value used while trapping the VM when the code
+// gives an invalid index, wrapping the error for the index, such as an
+// InvalidFunctionIndexError. The same error is returned as is by the methods
+// given an invalid index, for which it isn't a trap.
+type indexTrap struct {
+	kind TrapKind
+	err  error
+}
+
+func (e indexTrap) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the error for the index.
+func (e indexTrap) Unwrap() error {
+	return e.err
+}
+
+func (e indexTrap) TrapKind() TrapKind {
+	return e.kind
+}
+
+// TrapError is the error returned by a run, with RecoverPanic set, when the
+// VM traps, recording where it trapped. It wraps the trap error value, such
+// as ErrUnreachable, which Unwrap returns, for comparing against:
+//
+//	if te, ok := err.(*TrapError); ok && te.Err == ErrUnreachable {
+//		...
+//	}
+//
+// Out of bounds loads and stores trap with an OutOfBoundsError, giving the
+// access that faulted, which in turn wraps ErrOutOfBoundsMemoryAccess.
+type TrapError struct {
+	Err       error  // Trap error value the VM trapped with
+	FuncIndex int64  // Index of the trapping function in the function index space
+	FuncName  string // Name of the trapping function, when the module names it
+	PC        int64  // Position of the trapping instruction in the function's compiled code
+	Op        byte   // Opcode of the trapping instruction
+	OpName    string // Mnemonic of the trapping instruction, such as "i32.store"
+
+	// Frames are the active function calls when the VM trapped, from the
+	// outermost to the trapping one, as CallStack returns them, so the
+	// calls leading up to the trap can be looked into once the run is over
+	Frames []Frame
+}
+
+func (e *TrapError) Error() string {
+	name := e.FuncName
+	if name == "" {
+		name = fmt.Sprintf("function %d", e.FuncIndex)
+	}
+	return fmt.Sprintf("%v, at %s (pc %#x) in %s", e.Err, e.OpName, e.PC, name)
+}
+
+// Unwrap returns the trap error value the VM trapped with.
+func (e *TrapError) Unwrap() error {
+	return e.Err
+}
+
+// TrapKind returns the kind of the trap error value the VM trapped with.
+func (e *TrapError) TrapKind() TrapKind {
+	if t, ok := e.Err.(Trap); ok {
+		return t.TrapKind()
+	}
+	return 0
+}
+
+// PanicError is the error returned by a run, with RecoverPanic set, when
+// the VM panics other than by trapping, such as when a host function panics,
+// recording the calls active when it did, as a TrapError does for traps. It
+// wraps the error panicked with, which Unwrap returns, or one describing the
+// value panicked with, when that isn't an error.
+type PanicError struct {
+	Err error // Error panicked with
+
+	// Frames are the active function calls when the VM panicked, from the
+	// outermost to the innermost, as CallStack returns them. The host
+	// functions called aren't included, so a host function panicking is
+	// called from the innermost one.
+	Frames []Frame
+}
+
+func (e *PanicError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the error the VM panicked with.
+func (e *PanicError) Unwrap() error {
+	return e.Err
+}
+
+// wrapPanic returns the error for the value r the VM panicked with, wrapped
+// in a TrapError when it's a trap, or a PanicError otherwise, recording
+// where the VM is at. An error wrapped already, by a nested run, is returned
+// as it is.
+func (vm *VM) wrapPanic(r interface{}) error {
+	err, ok := r.(error)
+	if !ok {
+		err = fmt.Errorf("exec: %v", r)
+	}
+	switch err.(type) {
+	case *TrapError, *PanicError:
+		return err
+	case Trap:
+		return vm.wrapTrap(err)
+	}
+	// The calls are still in place, as the panic skipped returning from them
+	return &PanicError{Err: err, Frames: vm.CallStack()}
+}
+
+// wrapTrap returns the trap err wrapped in a TrapError recording where the
+// VM is at.
+func (vm *VM) wrapTrap(err error) error {
+	e := &TrapError{Err: err, FuncIndex: vm.ctx.curFunc, PC: vm.ctx.pc, Frames: vm.CallStack()}
+	if vm.ctx.curFunc < 0 || vm.ctx.curFunc >= int64(len(vm.funcs)) {
+		return e
+	}
+	if vm.ctx.curFunc < int64(len(vm.module.FunctionIndexSpace)) {
+		e.FuncName = vm.module.FunctionIndexSpace[vm.ctx.curFunc].Name
+	}
+	if fn, ok := vm.funcs[vm.ctx.curFunc].(compiledFunction); ok && fn.codeMeta != nil {
+		// The program counter is past the trapping instruction's opcode, and
+		// maybe some of its immediates, so it's the last one starting
+		// before it
+		instrs := fn.codeMeta.Instructions
+		i := sort.Search(len(instrs), func(i int) bool { return int64(instrs[i].Start) >= vm.ctx.pc })
+		if i > 0 {
+			e.PC = int64(instrs[i-1].Start)
+		}
+	}
+	if e.PC >= 0 && e.PC < int64(len(vm.ctx.code)) {
+		e.Op = vm.ctx.code[e.PC]
+	}
+	e.OpName = vm.mnemonicOpName(e.Op, fmt.Sprintf("opcode %#x", e.Op))
+	return e
+}
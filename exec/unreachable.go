@@ -0,0 +1,64 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import "github.com/go-interpreter/wagon/disasm"
+
+// CodeRegion is a range of instructions in the body of a function.
+type CodeRegion struct {
+	FuncIndex int64 // Index of the function in the function index space
+
+	// Start and End are the offsets of the first instruction of the range,
+	// and of the one following it, in the function body's code.
+	Start, End int
+}
+
+// UnreachableCode returns the regions of code which can never be executed
+// in the functions defined by the module, such as the instructions following
+// a br or a return in the same block, in function index order. Dead code
+// like this points to a bug in the toolchain which produced the module, or
+// in the source it was compiled from.
+//
+// The compiler leaves unreachable code out of the code functions are
+// compiled to for the VM, so it's found by the disassembler, which follows
+// the branches out of each block from the start of the function, and
+// reported as offsets into the module's bytecode.
+func (vm *VM) UnreachableCode() []CodeRegion {
+	var regions []CodeRegion
+	for i, fn := range vm.funcs {
+		if _, ok := fn.(compiledFunction); !ok {
+			continue
+		}
+		// The function was disassembled without errors when compiled
+		d, err := disasm.NewDisassembly(vm.module.FunctionIndexSpace[i], vm.module)
+		if err != nil {
+			continue
+		}
+
+		offset := 0
+		var region *CodeRegion
+		for _, instr := range d.Code {
+			// Unlike the compiled code, the disassembly doesn't hold
+			// the offsets, so they're worked out from the encoded sizes
+			code, err := disasm.Assemble([]disasm.Instr{instr})
+			if err != nil {
+				break
+			}
+			switch {
+			case instr.Unreachable && region == nil:
+				regions = append(regions, CodeRegion{FuncIndex: int64(i), Start: offset})
+				region = &regions[len(regions)-1]
+			case !instr.Unreachable && region != nil:
+				region.End = offset
+				region = nil
+			}
+			offset += len(code)
+		}
+		if region != nil {
+			region.End = offset
+		}
+	}
+	return regions
+}
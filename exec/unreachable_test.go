@@ -0,0 +1,51 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestUnreachableCode(t *testing.T) {
+	m := buildTestModule(
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code: []byte{
+				ops.I32Const, 0x01,
+				ops.Return,
+				ops.I32Const, 0x02, // dead from offset 3
+				ops.Drop,
+			},
+		},
+		testFunc{
+			code: []byte{
+				ops.Block, 0x40,
+				ops.Br, 0x00,
+				ops.I32Const, 0x03, // dead from offset 4
+				ops.Drop,
+				ops.End, // the end of the block at 7 is reached by the br
+				ops.Nop,
+			},
+		},
+		testFunc{code: []byte{ops.I32Const, 0x04, ops.Drop}},
+		testFunc{host: func(proc *Process) {}},
+	)
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	want := []CodeRegion{
+		{FuncIndex: 0, Start: 3, End: 6},
+		{FuncIndex: 1, Start: 4, End: 7},
+	}
+	if got := vm.UnreachableCode(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got unreachable code %+v, want %+v", got, want)
+	}
+}
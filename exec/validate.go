@@ -0,0 +1,92 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+var (
+	// ErrInvalidMemoryLimits is the problem reported by ValidateModule for
+	// a linear memory with an initial size past its maximum, or past the
+	// 4 GiB a linear memory can hold.
+	ErrInvalidMemoryLimits = errors.New("exec: invalid memory limits")
+	// ErrMissingSignature is the problem reported by ValidateModule for a
+	// function without a signature.
+	ErrMissingSignature = errors.New("exec: function has no signature")
+	// ErrMissingBody is the problem reported by ValidateModule for a
+	// function, other than a host function, without a body.
+	ErrMissingBody = errors.New("exec: function has no body")
+)
+
+// ModuleError is returned by ValidateModule for a problem with a module,
+// giving the part of the module it was found in.
+type ModuleError struct {
+	Where string // Part of the module with the problem, such as "memory 0"
+	Err   error  // The problem
+}
+
+func (e ModuleError) Error() string {
+	return fmt.Sprintf("exec: invalid module, in %s: %v", e.Where, e.Err)
+}
+
+// Unwrap returns the problem found.
+func (e ModuleError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateModule checks the given module can be run, without compiling or
+// running any of it, such as before running a module that can't be trusted.
+// The first problem found is returned, as a ModuleError for those with the
+// memories, the function signatures and bodies, the start function and the
+// tables, and as a validate.Error, giving the function and the offset in its
+// body, for those with the bytecode, such as a branch to a missing label, or
+// an operand of the wrong type, as found by validate.VerifyModule. NewVM may
+// still fail on a valid module, such as when the start function traps.
+func ValidateModule(m *wasm.Module) error {
+	if m.Memory != nil {
+		for i, mem := range m.Memory.Entries {
+			where := fmt.Sprintf("memory %d", i)
+			limits := mem.Limits
+			if limits.Initial > maxMemoryPages || (limits.Flags&0x1 != 0 && limits.Initial > limits.Maximum) {
+				return ModuleError{Where: where, Err: ErrInvalidMemoryLimits}
+			}
+			if i < len(m.LinearMemoryIndexSpace) {
+				size := int(limits.Initial) * wasmPageSize
+				if image := m.LinearMemoryIndexSpace[i]; len(image) > size {
+					return ModuleError{Where: where, Err: MemoryImageSizeError{ImageSize: len(image), MemorySize: size}}
+				}
+			}
+		}
+	}
+
+	for i, fn := range m.FunctionIndexSpace {
+		where := fmt.Sprintf("function %d", i)
+		if fn.Sig == nil {
+			return ModuleError{Where: where, Err: ErrMissingSignature}
+		}
+		if !fn.IsHost() && fn.Body == nil {
+			return ModuleError{Where: where, Err: ErrMissingBody}
+		}
+	}
+
+	if m.Start != nil && uint64(m.Start.Index) >= uint64(len(m.FunctionIndexSpace)) {
+		return ModuleError{Where: "start function", Err: InvalidFunctionIndexError(m.Start.Index)}
+	}
+
+	for i, table := range m.TableIndexSpace {
+		for j, index := range table {
+			if uint64(index) >= uint64(len(m.FunctionIndexSpace)) {
+				return ModuleError{Where: fmt.Sprintf("table %d, element %d", i, j), Err: InvalidFunctionIndexError(index)}
+			}
+		}
+	}
+
+	return validate.VerifyModule(m)
+}
@@ -0,0 +1,100 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-interpreter/wagon/validate"
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestValidateModule(t *testing.T) {
+	i32 := []wasm.ValueType{wasm.ValueTypeI32}
+	// validModule gives a module with a memory, a table and a start
+	// function, calling a host function
+	validModule := func() *wasm.Module {
+		m := memoryTestModule(testFunc{
+			results: i32,
+			code:    []byte{ops.I32Const, 0x00, ops.I32Load, 0x02, 0x00},
+		})
+		host := buildTestModule(testFunc{host: func(proc *Process) {}})
+		m.FunctionIndexSpace = append(m.FunctionIndexSpace, host.FunctionIndexSpace[0])
+		m.Memory.Entries[0].Limits = wasm.ResizableLimits{Flags: 0x1, Initial: 1, Maximum: 2}
+		m.TableIndexSpace = [][]uint32{{0, 1}}
+		m.Start = &wasm.SectionStartFunction{Index: 1}
+		return m
+	}
+
+	for _, tc := range []struct {
+		name   string
+		change func(m *wasm.Module)
+		want   error
+	}{
+		{"valid", func(m *wasm.Module) {}, nil},
+		{
+			"initial memory past its maximum",
+			func(m *wasm.Module) { m.Memory.Entries[0].Limits.Initial = 3 },
+			ModuleError{Where: "memory 0", Err: ErrInvalidMemoryLimits},
+		},
+		{
+			"memory past 4 GiB",
+			func(m *wasm.Module) { m.Memory.Entries[0].Limits = wasm.ResizableLimits{Initial: maxMemoryPages + 1} },
+			ModuleError{Where: "memory 0", Err: ErrInvalidMemoryLimits},
+		},
+		{
+			"memory image larger than the memory",
+			func(m *wasm.Module) { m.LinearMemoryIndexSpace[0] = make([]byte, wasmPageSize+1) },
+			ModuleError{Where: "memory 0", Err: MemoryImageSizeError{ImageSize: wasmPageSize + 1, MemorySize: wasmPageSize}},
+		},
+		{
+			"function without a signature",
+			func(m *wasm.Module) { m.FunctionIndexSpace[1].Sig = nil },
+			ModuleError{Where: "function 1", Err: ErrMissingSignature},
+		},
+		{
+			"function without a body",
+			func(m *wasm.Module) { m.FunctionIndexSpace[0].Body = nil },
+			ModuleError{Where: "function 0", Err: ErrMissingBody},
+		},
+		{
+			"start function past the functions",
+			func(m *wasm.Module) { m.Start.Index = 2 },
+			ModuleError{Where: "start function", Err: InvalidFunctionIndexError(2)},
+		},
+		{
+			"table element past the functions",
+			func(m *wasm.Module) { m.TableIndexSpace[0][1] = 5 },
+			ModuleError{Where: "table 0, element 1", Err: InvalidFunctionIndexError(5)},
+		},
+		{
+			"branch to a missing label",
+			func(m *wasm.Module) { m.FunctionIndexSpace[0].Body.Code = []byte{ops.Br, 0x01} },
+			validate.Error{Offset: 2, Function: 0, Err: validate.InvalidLabelError(1)},
+		},
+		{
+			"operand of the wrong type",
+			func(m *wasm.Module) { m.FunctionIndexSpace[0].Body.Code = []byte{ops.I64Const, 0x00, ops.I32Eqz} },
+			validate.Error{Offset: 3, Function: 0, Err: validate.InvalidTypeError{Wanted: wasm.ValueTypeI32, Got: wasm.ValueTypeI64}},
+		},
+		{
+			"load from a missing memory",
+			func(m *wasm.Module) {
+				m.FunctionIndexSpace[0].Body.Code = []byte{ops.I32Const, 0x00, ops.I32Load, 0x42, 0x01, 0x00}
+			},
+			validate.Error{Offset: 5, Function: 0, Err: wasm.InvalidLinearMemoryIndexError(1)},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := validModule()
+			tc.change(m)
+			if err := ValidateModule(m); !reflect.DeepEqual(err, tc.want) {
+				t.Fatalf("got error %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
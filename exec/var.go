@@ -4,47 +4,86 @@
 
 package exec
 
+var (
+	// ErrInvalidLocalIndex is the error value used while trapping the VM when
+	// a function accesses a local beyond its parameters and locals.
+	ErrInvalidLocalIndex = newTrap(TrapInvalidLocalIndex, "exec: invalid local index")
+	// ErrInvalidGlobalIndex is the error value used while trapping the VM when
+	// a function accesses a global beyond the module's globals.
+	ErrInvalidGlobalIndex = newTrap(TrapInvalidGlobalIndex, "exec: invalid global index")
+)
+
+// checkLocalIndex panics with ErrInvalidLocalIndex when index is out of the
+// range of the current function's locals.
+func (vm *VM) checkLocalIndex(index uint32) {
+	if uint64(index) >= uint64(len(vm.ctx.locals)) {
+		panic(ErrInvalidLocalIndex)
+	}
+}
+
+// checkGlobalIndex panics with ErrInvalidGlobalIndex when index is out of
+// the range of the module's globals.
+func (vm *VM) checkGlobalIndex(index uint32) {
+	if uint64(index) >= uint64(len(vm.globals)) {
+		panic(ErrInvalidGlobalIndex)
+	}
+}
+
 func (vm *VM) getLocal() {
 	stackStart := vm.ctx.stack
 
 	// The operation we're logging
 	index := vm.fetchUint32()
+	vm.checkLocalIndex(index)
 	val := vm.ctx.locals[int(index)]
 	vm.pushUint64(val)
 
 	// Log this operation
-	opLog(vm, 0x20, "Get local", []string{"program_counter", "local_id", "value", "locals_start", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, index, val, vm.ctx.locals, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x20, "Get local", []string{"program_counter", "local_id", "value", "locals_start", "stack_start", "stack_finish"},
+			logData(vm.ctx.pc, index, val, vm.ctx.locals, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) setLocal() {
 	stackStart := vm.ctx.stack
 	var localsStart []uint64
-	localsStart = append(localsStart, vm.ctx.locals...)
+	if vm.logger != nil {
+		localsStart = append(localsStart, vm.ctx.locals...)
+	}
 
 	// The operation we're logging
 	index := vm.fetchUint32()
+	vm.checkLocalIndex(index)
 	val := vm.popUint64()
 	vm.ctx.locals[int(index)] = val
 
 	// Log this operation
-	opLog(vm, 0x21, "Set local", []string{"program_counter", "local_id", "value", "locals_start", "locals_finish", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, index, val, localsStart, vm.ctx.locals, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x21, "Set local", localWriteOpFields,
+			logData(vm.ctx.pc, index, val, localsStart, vm.ctx.locals, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) teeLocal() {
 	stackStart := vm.ctx.stack
-	localsStart := make([]uint64, len(vm.ctx.locals))
-	copy(localsStart, vm.ctx.locals)
+	var localsStart []uint64
+	if vm.logger != nil {
+		localsStart = make([]uint64, len(vm.ctx.locals))
+		copy(localsStart, vm.ctx.locals)
+	}
 
 	// The operation we're logging
 	index := vm.fetchUint32()
+	vm.checkLocalIndex(index)
 	val := vm.ctx.stack[len(vm.ctx.stack)-1]
 	vm.ctx.locals[int(index)] = val
 
 	// Log this operation
-	opLog(vm, 0x22, "Tee local", []string{"program_counter", "local_id", "value", "locals_start", "locals_finish", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, index, val, localsStart, vm.ctx.locals, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x22, "Tee local", localWriteOpFields,
+			logData(vm.ctx.pc, index, val, localsStart, vm.ctx.locals, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) getGlobal() {
@@ -52,12 +91,15 @@ func (vm *VM) getGlobal() {
 
 	// The operation we're logging
 	index := vm.fetchUint32()
+	vm.checkGlobalIndex(index)
 	val := vm.globals[int(index)]
 	vm.pushUint64(val)
 
 	// Log this operation
-	opLog(vm, 0x23, "Get global", []string{"program_counter", "from_global", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, index, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x23, "Get global", []string{"program_counter", "from_global", "value", "stack_start", "stack_finish"},
+			logData(vm.ctx.pc, index, val, stackStart, vm.ctx.stack))
+	}
 }
 
 func (vm *VM) setGlobal() {
@@ -65,10 +107,13 @@ func (vm *VM) setGlobal() {
 
 	// The operation we're logging
 	index := vm.fetchUint32()
+	vm.checkGlobalIndex(index)
 	val := vm.popUint64()
 	vm.globals[int(index)] = val
 
 	// Log this operation
-	opLog(vm, 0x24, "Set global", []string{"program_counter", "to_global", "value", "stack_start", "stack_finish"},
-		[]interface{}{vm.ctx.pc, index, val, stackStart, vm.ctx.stack})
+	if vm.logger != nil {
+		opLog(vm, 0x24, "Set global", []string{"program_counter", "to_global", "value", "stack_start", "stack_finish"},
+			logData(vm.ctx.pc, index, val, stackStart, vm.ctx.stack))
+	}
 }
@@ -0,0 +1,48 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package exec
+
+import (
+	"testing"
+
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestInvalidVarIndex(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		code []byte
+		want error
+	}{
+		{"get_local", []byte{ops.GetLocal, 0x02, ops.Drop}, ErrInvalidLocalIndex},
+		{"set_local", []byte{ops.I32Const, 0x00, ops.SetLocal, 0x02}, ErrInvalidLocalIndex},
+		{"tee_local", []byte{ops.I32Const, 0x00, ops.TeeLocal, 0x7f, ops.Drop}, ErrInvalidLocalIndex},
+		{"get_global", []byte{ops.GetGlobal, 0x00, ops.Drop}, ErrInvalidGlobalIndex},
+		{"set_global", []byte{ops.I32Const, 0x00, ops.SetGlobal, 0x00}, ErrInvalidGlobalIndex},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			// One parameter and one local, so locals 0 and 1 are valid, and
+			// no globals
+			vm, err := NewVM(buildTestModule(testFunc{
+				params: []wasm.ValueType{wasm.ValueTypeI32},
+				locals: []wasm.LocalEntry{{Count: 1, Type: wasm.ValueTypeI32}},
+				code:   tc.code,
+			}))
+			if err != nil {
+				t.Fatalf("could not create VM: %v", err)
+			}
+			vm.RecoverPanic = true
+			_, err = vm.ExecCode(0, 0)
+			if trapCause(err) != tc.want {
+				t.Fatalf("got error %v, want %v", err, tc.want)
+			}
+			// The trap records the instruction with the invalid index
+			if te, ok := err.(*TrapError); !ok || te.OpName != tc.name {
+				t.Errorf("got error %v, want a trap at %s", err, tc.name)
+			}
+		})
+	}
+}
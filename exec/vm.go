@@ -6,29 +6,73 @@
 package exec
 
 import (
+	gocontext "context"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/go-interpreter/wagon/disasm"
 	"github.com/go-interpreter/wagon/exec/internal/compile"
 	"github.com/go-interpreter/wagon/wasm"
 	ops "github.com/go-interpreter/wagon/wasm/operators"
 	"github.com/jackc/pgx"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
-	// ErrMultipleLinearMemories is returned by (*VM).NewVM when the module
-	// has more then one entries in the linear memory space.
+	// ErrMultipleLinearMemories was returned by NewVM and Compile when the
+	// module has more then one entries in the linear memory space.
+	//
+	// Deprecated: modules with more than one linear memory are supported,
+	// as with the multi-memory proposal, so it's no longer returned.
 	ErrMultipleLinearMemories = errors.New("exec: more than one linear memories in module")
 	// ErrInvalidArgumentCount is returned by (*VM).ExecCode when an invalid
 	// number of arguments to the WebAssembly function are passed to it.
 	ErrInvalidArgumentCount = errors.New("exec: invalid number of arguments to function")
+	// ErrStackUnderflow is the error value used while trapping the VM when
+	// malformed bytecode pops a value off an empty stack. It's only checked
+	// for when built with the debugstack tag.
+	ErrStackUnderflow = newTrap(TrapStackUnderflow, "exec: stack underflow")
+
+	errNegativeOffset = errors.New("exec: negative memory offset")
 )
 
+// InvalidTableNameError is returned by NewVM when the table name given
+// for operation logging isn't a safe SQL identifier.
+type InvalidTableNameError string
+
+func (e InvalidTableNameError) Error() string {
+	return fmt.Sprintf("exec: invalid operation logging table name: %q", string(e))
+}
+
+// MemoryImageSizeError is returned by NewVM and Compile when the module's
+// initial linear memory image doesn't fit in the memory the module declares,
+// which points to a malformed or badly decoded module.
+type MemoryImageSizeError struct {
+	ImageSize  int // Size of the initial memory image, in bytes
+	MemorySize int // Size of the declared initial memory, in bytes
+}
+
+func (e MemoryImageSizeError) Error() string {
+	return fmt.Sprintf("exec: initial memory image of %d bytes is larger than the %d bytes of memory", e.ImageSize, e.MemorySize)
+}
+
+// ModuleSizeError is returned by NewVMFromReader when the module read is
+// larger than the limit set with WithMaxModuleBytes.
+type ModuleSizeError int64
+
+func (e ModuleSizeError) Error() string {
+	return fmt.Sprintf("exec: module larger than the maximum of %d bytes", int64(e))
+}
+
 // InvalidReturnTypeError is returned by (*VM).ExecCode when the module
 // specifies an invalid return type value for the executed function.
 type InvalidReturnTypeError int8
@@ -38,61 +82,245 @@ func (e InvalidReturnTypeError) Error() string {
 }
 
 // InvalidFunctionIndexError is returned by (*VM).ExecCode when the function
-// index provided is invalid.
+// index provided is invalid. When a call gives an index past the module's
+// functions, the VM traps with an error of kind TrapInvalidFunctionIndex
+// wrapping it instead, which errors.As finds it in.
 type InvalidFunctionIndexError int64
 
 func (e InvalidFunctionIndexError) Error() string {
 	return fmt.Sprintf("Invalid index to function index space: %d", int64(e))
 }
 
+// InvalidTypeIndexError is returned by NewVM, with WithImportResolver, for a
+// function imported with a missing type. When a call_indirect gives a type
+// index past the module's types, the VM traps with an error of kind
+// TrapInvalidTypeIndex wrapping it instead, which errors.As finds it in.
+type InvalidTypeIndexError uint32
+
+func (e InvalidTypeIndexError) Error() string {
+	return fmt.Sprintf("Invalid index to type section: %d", uint32(e))
+}
+
+// Frame is an active function call.
+type Frame struct {
+	FuncIndex int64 // Index of the function in the function index space
+
+	// PC is the position in the function's compiled code. For the
+	// innermost frame, it's that of the instruction about to be executed,
+	// and for the others, that of the instruction following the call.
+	PC int64
+
+	// SP is the base stack pointer of the call, where its operands start
+	// on the operand stack of the run, taken as a whole: the number of
+	// values on the operand stacks of the calling functions when it was
+	// called, its arguments having been popped off.
+	SP int
+}
+
+// FuncMeta describes the shape of a function, as compiled for the VM.
+type FuncMeta struct {
+	MaxDepth       int  // Maximum operand stack depth reached while executing the function body
+	Args           int  // Number of arguments the function accepts
+	Returns        bool // Whether the function returns a value
+	TotalLocalVars int  // Number of local variables used by the function, including the arguments
+}
+
 type context struct {
-	stack   []uint64
-	locals  []uint64
-	code    []byte
-	asm     []asmBlock
-	pc      int64
-	curFunc int64
+	stack         []uint64
+	locals        []uint64
+	code          []byte
+	asm           []asmBlock
+	branchTables  []*compile.BranchTable
+	memoryIndexes bool // Whether the loads and stores give the index of the memory accessed
+	pc            int64
+	curFunc       int64
+	stackBase     int // Number of values on the operand stacks of the calling functions, for Frame.SP
 }
 
 // VM is the execution context for executing WebAssembly bytecode.
 type VM struct {
-	ctx context
+	ctx     context
+	callers []context // Execution contexts of the calling functions, outermost first
+
+	module      *wasm.Module
+	globals     []uint64
+	globalInits []uint64 // Initial values of the globals, worked out on the first reset
+	memory      []byte
+	memories    [][]byte // Linear memories after the first, of modules with more than one
+	funcs       []function
 
-	module  *wasm.Module
-	globals []uint64
-	memory  []byte
-	funcs   []function
+	memMaxPages int64        // Size in pages the linear memory can't grow past
+	memCap      int64        // Size in pages set with WithMaxMemoryPages, or 0
+	memPeak     int          // Largest size in pages the linear memory has had
+	memShare    *memoryShare // VMs sharing the linear memory, when linked by a Linker
 
-	funcTable [256]func()
+	funcTable     [256]func()
+	miscFuncTable [256]func() // The operators following ops.MiscPrefix
+	miscOpCode    byte        // Operator following ops.MiscPrefix being executed
 
 	// RecoverPanic controls whether the `ExecCode` method
 	// recovers from a panic and returns it as an error
 	// instead.
 	// A panic can occur either when executing an invalid VM
 	// or encountering an invalid instruction, e.g. `unreachable`.
+	//
+	// With RecoverPanic set, no panic escapes the methods running
+	// code in the VM (ExecCode and the methods built on it, such as
+	// ExecCodeTyped and ExecCodeByName, ExecCodeMulti, ExecNOps,
+	// Step, Resume and Replay), whether
+	// it's a trap, a Go runtime error in the interpreter, or a panic
+	// in a host function. Traps are returned as a *TrapError, and
+	// the other panics as a *PanicError, both recording the calls
+	// active at the time. The other exported methods don't panic in
+	// the first place. The exception is a Go stack overflow from
+	// runaway recursion past WithMaxCallDepth, which the Go runtime
+	// doesn't let be recovered from.
+	//
+	// Set it with WithRecoverPanic for it to cover the module's start
+	// function, run by NewVM, too.
 	RecoverPanic bool
 
-	abort bool // Flag for host functions to terminate execution
+	abort   bool  // Flag for host functions to terminate execution
+	hostErr error // Error a host function failed the run with, set with Process.Fail
+
+	limitOps bool // Whether to stop once opsLeft instructions have been executed
+	opsLeft  int  // Instructions left to execute, with limitOps set
+	paused   bool // Whether the run was stopped by running out of opsLeft
+
+	stepping bool // Whether a function entered with Enter is being stepped through
+	running  bool // Whether code is being run, such that a call to ExecCode is nested
+	nested   int  // Number of runs nested in the outermost one
+
+	stackSlab []uint64 // Preallocated space for the stacks of the function calls, set with WithStackCapacity
+	stackTop  int      // Start of the space left in stackSlab
+
+	breakpoints   map[Frame]bool // Where to stop execution, set with SetBreakpoint
+	breakpointHit bool           // Whether execution is stopped at a breakpoint
+	resuming      bool           // Whether execution is carrying on past a breakpoint
+
+	hostRecorder *HostCallRecorder // Where host calls are recorded to, if anywhere
+	hostReplay   *HostCallRecorder // Host calls replayed in place of calling the host functions
+
+	maxCalls uint64 // Calls allowed in a run, or zero for no limit
+	calls    uint64 // Calls made by the current run
+
+	maxCallDepth int // How deep calls can nest, or zero or less for no limit
+	outerDepth   int // Calls active in the runs the current one is nested in
 
 	nativeBackend *nativeCompiler
 
+	tracer     *chromeTracer     // Where the calls are traced to, set with WithChromeTrace
+	spanTracer trace.Tracer      // What starts the spans of the calls, set with WithTracer
+	spans      []trace.Span      // Spans of the calls not yet returned from, outermost first
+	spanBase   int               // Number of the spans belonging to the runs the current one is nested in
+	traceCtx   gocontext.Context // Context the spans of the calls from outside the VM are started in
+
+	metrics opCounter // Counters updated as the VM runs, set with WithMetrics
+
 	// PostgreSQL pieces, for Operating Logging
 	pg       *pgx.ConnPool
 	PgTx     *pgx.Tx
 	PgRunNum int
+	pgTable  string
+
+	logger          OpLogger // Where operations are logged to, nil when not logging
+	logSampleRate   int      // Only log every Nth operation, when greater than 1
+	opNum           int      // Number of the next operation logged
+	logCommitPolicy LogCommitFailurePolicy
+	logErr          error // Operation log commit failure aborting execution
+
+	logMu        *sync.Mutex    // Guards the logger against flushing on a signal, when set with WithFlushOnSignal
+	flushSignals chan os.Signal // Signals the operation log is flushed on
+	flushStop    chan struct{}  // Closed to stop flushing the operation log on signals
+
+	opHook func(op byte, pc int64, stack []uint64)
+
+	trackFloatFlags bool
+	canonicalNaN    bool // Whether to canonicalize the NaNs produced, as with WithCanonicalNaN
+	floatFlags      FloatFlagSet
+
+	stackDepthLogging bool // Whether to log the stack depths rather than the stacks
+	mnemonicOpNames   bool // Whether to log the operator mnemonics as the op names
+	overflowLogging   bool // Whether to log whether integer arithmetic overflowed
+	valueTypeLogging  bool // Whether to log the types of the values operated on
+
+	memProfile *memProfile // Memory accesses of the current run, when profiling them
+
+	strictReserved bool // Whether to trap on reserved bytes that aren't zero
+	strictArgs     bool // Whether to check the arguments given to ExecCode are packed values of their types
+
+	coverage [][]bool // Executed instruction offsets, per function index, when recording coverage
+
+	highWater []int // Deepest stack reached, per function index, when tracking it
+
+	stdout, stderr io.Writer // Where host functions write output to, when not os.Stdout and os.Stderr
+
+	timeout time.Duration // How long a run can take, or zero for no limit
+	expired *int32        // Set once the current run times out, nil when not timing it
 }
 
 // As per the WebAssembly spec: https://github.com/WebAssembly/design/blob/27ac254c854994103c24834a994be16f74f54186/Semantics.md#linear-memory
 const wasmPageSize = 65536 // (64 KB)
 
+// maxMemoryPages is the most pages a 32-bit linear memory can address.
+const maxMemoryPages = 65536 // (4 GB)
+
 var endianess = binary.LittleEndian
 
-var opNum int // Simple counter for operation logging
+// defaultPGTable is the table operations are logged to, unless changed with
+// the PGTableName option.
+const defaultPGTable = "execution_run"
+
+// Table names are interpolated into the logging SQL, so only plain
+// (unquoted) PostgreSQL identifiers are accepted
+var pgTableNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
 
 type config struct {
-	EnableAOT  bool
-	PGConnPool *pgx.ConnPool
-	PGDBRun    int
+	EnableAOT     bool
+	PGConnPool    *pgx.ConnPool
+	PGDBRun       int
+	PGTableName   string
+	LogSampleRate int
+	OpLogger      OpLogger
+
+	LogCommitFailurePolicy LogCommitFailurePolicy
+	OpHook                 func(op byte, pc int64, stack []uint64)
+	FloatFlagTracking      bool
+	StrictReservedBytes    bool
+	Coverage               bool
+	MaxMemoryPages         uint32
+	StackDepthLogging      bool
+	MaxModuleBytes         int64
+	HostCallRecorder       *HostCallRecorder
+	HostReplay             *HostCallRecorder
+	MaxCalls               uint64
+	MaxCallDepth           int
+	StrictImports          bool
+	StrictArgs             bool
+	LazyCompile            bool
+	ImportResolver         func(module, field string) (interface{}, bool)
+	SharedMemory           *VM
+	StackHighWater         bool
+	Timeout                time.Duration
+	MnemonicOpNames        bool
+	OverflowLogging        bool
+	ValueTypeLogging       bool
+	StackCapacity          int
+	FlushOnSignal          bool
+	FlushSignals           []os.Signal
+	ChromeTrace            io.Writer
+	Tracer                 trace.Tracer
+	RecoverPanic           bool
+	Metrics                func() (opCounter, error)
+	CanonicalNaN           bool
+}
+
+// opCounter counts the operations executed by the VM, and the traps ending
+// its runs, as set up with WithMetrics.
+type opCounter interface {
+	countOp(op byte)
+	countTrap(err error)
+	clone() opCounter
 }
 
 // VMOption describes a customization that can be applied to the VM.
@@ -108,7 +336,10 @@ func EnableAOT(v bool) VMOption {
 }
 
 // PGConnPool passes a pre-established PostgreSQL connection pool, for
-// logging all operations through.
+// logging all operations through. As PostgreSQL has no unsigned integers,
+// unsigned 64-bit values, such as i64 values and stack entries, are logged
+// as their signed reinterpretation, so the ones from 2^63 up are stored as
+// negative numbers, with the same bits, and still fit in a bigint column.
 func PGConnPool(p *pgx.ConnPool) VMOption {
 	return func(c *config) {
 		c.PGConnPool = p
@@ -123,47 +354,396 @@ func PGDBRun(i int) VMOption {
 	}
 }
 
+// PGTableName sets the name of the table operations are logged to, so
+// different experiments can share a database. It must be a plain SQL
+// identifier (letters, digits and underscores, not starting with a digit),
+// which PostgreSQL folds to lower case as usual. Defaults to "execution_run".
+func PGTableName(name string) VMOption {
+	return func(c *config) {
+		c.PGTableName = name
+	}
+}
+
+// LogSampleRate sets the operation logging to only record one in every n
+// operations, for statistical profiling where a full trace is overkill.
+// Sampled rows keep their real op_num, so the gaps between them are visible.
+// A rate of 1 (or less) logs every operation.
+func LogSampleRate(n int) VMOption {
+	return func(c *config) {
+		c.LogSampleRate = n
+	}
+}
+
+// WithOpLogger sends the operation logging records to the given logger,
+// in place of the PostgreSQL logging set up with PGConnPool.
+func WithOpLogger(l OpLogger) VMOption {
+	return func(c *config) {
+		c.OpLogger = l
+	}
+}
+
+// WithOpLoggers sends the operation logging records to all of the given
+// loggers, through a MultiOpLogger, in place of the PostgreSQL logging set
+// up with PGConnPool. A failing commit of any of them is handled as set with
+// WithLogCommitFailurePolicy, for all of them.
+func WithOpLoggers(loggers ...OpLogger) VMOption {
+	return func(c *config) {
+		c.OpLogger = MultiOpLogger(loggers)
+	}
+}
+
+// WithStackDepthLogging makes operation logging record just the depth of
+// the stack before and after each operation, in the stack_depth_start and
+// stack_depth_finish fields, in place of the stack contents usually logged
+// in stack_start and stack_finish. That's much cheaper, while still showing
+// up unbalanced stack use. Runs logged this way can't be checked by Replay.
+func WithStackDepthLogging(v bool) VMOption {
+	return func(c *config) {
+		c.StackDepthLogging = v
+	}
+}
+
+// WithMnemonicOpNames makes operation logging record the mnemonics of the
+// operators as the op_name, as in the WebAssembly text format, such as
+// "i32.ge_s", rather than the longer descriptions, such as "i32 Greater than
+// or equal signed". The mnemonics come from the operators package, so match
+// those of disassemblers. The jumps and discards the interpreter compiles
+// blocks and branches to are named "wagon.jmp", "wagon.discard" and so on.
+func WithMnemonicOpNames(v bool) VMOption {
+	return func(c *config) {
+		c.MnemonicOpNames = v
+	}
+}
+
+// WithOverflowLogging adds an overflowed field to the operation logging
+// records of the i32 and i64 add, sub and mul operators, set when the
+// result didn't fit and wrapped around, as integer arithmetic does in
+// WebAssembly. The operators are the same for signed and unsigned integers,
+// so a result wrapping around for either reading of the operands counts,
+// such as for both 0xffffffff + 1 and 0x7fffffff + 1 in i32. Working it out
+// takes a little time, so it's left out unless set.
+func WithOverflowLogging(v bool) VMOption {
+	return func(c *config) {
+		c.OverflowLogging = v
+	}
+}
+
+// WithValueTypeLogging adds a value_type field to the operation logging
+// records, holding the type of the values the operator works on, as "i32",
+// "i64", "f32" or "f64", so the records can be told apart without knowing
+// the opcodes. That's the type of the operands, in base_value and
+// modifier_value, or value, of the numeric operators, including the
+// comparisons and conversions, and that of the value loaded, stored or
+// pushed by the memory operators and constants. It's left out for the
+// operators working on values of any type, such as drop and get_local.
+func WithValueTypeLogging(v bool) VMOption {
+	return func(c *config) {
+		c.ValueTypeLogging = v
+	}
+}
+
+// WithFlushOnSignal makes the VM commit the operation log when the process
+// receives one of the given signals, or os.Interrupt or SIGTERM when none
+// are given, so a run interrupted with Ctrl+C keeps all of the operations
+// logged up until then, rather than losing those logged since the last
+// periodic commit. Once the log is committed, the signal is raised again,
+// to be handled as it would have been without the option, which usually
+// ends the process. The signals stop being watched for once the VM is
+// closed with Close.
+//
+// Handling the signals is left to the embedder unless this option is set,
+// and the option has no effect without operation logging.
+func WithFlushOnSignal(sigs ...os.Signal) VMOption {
+	return func(c *config) {
+		c.FlushOnSignal = true
+		c.FlushSignals = sigs
+	}
+}
+
+// WithLogCommitFailurePolicy sets what happens when committing the operation
+// log fails mid-run. Defaults to LogCommitPanic.
+func WithLogCommitFailurePolicy(p LogCommitFailurePolicy) VMOption {
+	return func(c *config) {
+		c.LogCommitFailurePolicy = p
+	}
+}
+
+// WithOpHook sets a function called before each opcode the interpreter
+// dispatches, with the position of the opcode in the compiled code and the
+// current stack. The stack is only valid during the call, and must not be
+// modified. This allows for custom tracing, coverage or assertions, without
+// needing operation logging.
+func WithOpHook(hook func(op byte, pc int64, stack []uint64)) VMOption {
+	return func(c *config) {
+		c.OpHook = hook
+	}
+}
+
+// WithFloatFlagTracking enables tracking the floating point exception flags,
+// as returned by FloatFlags.
+func WithFloatFlagTracking(v bool) VMOption {
+	return func(c *config) {
+		c.FloatFlagTracking = v
+	}
+}
+
+// StrictReservedBytes makes the VM trap with ErrReservedByteNotZero when a
+// reserved byte of a current_memory, grow_memory, memory.copy or memory.fill
+// operator isn't zero, as required by the spec. Such a byte is otherwise ignored, though it points
+// to either a corrupted module, or one using a future feature.
+func StrictReservedBytes(v bool) VMOption {
+	return func(c *config) {
+		c.StrictReservedBytes = v
+	}
+}
+
+// WithCoverage enables recording which instructions of each function are
+// executed, for writing out with ExportCoverage.
+func WithCoverage(v bool) VMOption {
+	return func(c *config) {
+		c.Coverage = v
+	}
+}
+
+// WithMaxMemoryPages caps the size the linear memories can grow to, in
+// pages, on top of any maximum declared by the module. A grow_memory which would
+// take the memory past the cap fails, returning -1, rather than allocating
+// whatever amount of memory the module asks for. The cap doesn't apply to
+// the module's initial memory. Zero, the default, leaves the memory to grow
+// up to the 4 GB a 32-bit memory can address.
+func WithMaxMemoryPages(n uint32) VMOption {
+	return func(c *config) {
+		c.MaxMemoryPages = n
+	}
+}
+
+// WithMaxModuleBytes caps the size of the module read by NewVMFromReader,
+// which fails with a ModuleSizeError as soon as it reads past the cap,
+// rather than reading in the rest of the module. Zero, the default, leaves
+// the size unlimited. The option has no effect on NewVM, which is given a
+// module already read.
+func WithMaxModuleBytes(n int64) VMOption {
+	return func(c *config) {
+		c.MaxModuleBytes = n
+	}
+}
+
+// WithHostCallRecorder records the calls to host functions in rec, for
+// replaying with WithHostReplay.
+func WithHostCallRecorder(rec *HostCallRecorder) VMOption {
+	return func(c *config) {
+		c.HostCallRecorder = rec
+	}
+}
+
+// WithHostReplay replays the host function calls recorded in recording,
+// rather than calling the host functions. Each call made by the VM has to
+// match the next call recorded, being to the same function with the same
+// arguments, and returns the recorded results. A call which doesn't match
+// traps the VM with a HostReplayError.
+//
+// Only the results are replayed, anything else a host function did when
+// recorded, such as writing to the linear memory, isn't.
+func WithHostReplay(recording *HostCallRecorder) VMOption {
+	return func(c *config) {
+		c.HostReplay = recording
+	}
+}
+
+// WithMaxCalls caps the number of calls, through call and call_indirect,
+// each run of the VM can make, such as ExecCode, trapping with
+// ErrCallLimitExceeded on the call past the cap. Zero, the default, leaves
+// the number of calls unlimited.
+func WithMaxCalls(n uint64) VMOption {
+	return func(c *config) {
+		c.MaxCalls = n
+	}
+}
+
+// WithMaxCallDepth caps how deep calls, through call and call_indirect,
+// can nest, trapping with ErrCallStackExhausted on the call past the cap,
+// rather than letting runaway recursion exhaust the Go stack. Calls made by
+// runs nested in others, from host functions, count towards the cap
+// together with those of the runs they're nested in. Zero keeps the
+// default of 10000, and a negative n leaves the depth unlimited.
+func WithMaxCallDepth(n int) VMOption {
+	return func(c *config) {
+		c.MaxCallDepth = n
+	}
+}
+
+// WithChromeTrace traces the function calls made by the VM to w, as
+// begin and end events of the Trace Event Format, for loading into
+// chrome://tracing. The calls cut short by a trap end along with the run.
+// The events are written as a JSON array, which is only complete once the
+// VM is closed.
+func WithChromeTrace(w io.Writer) VMOption {
+	return func(c *config) {
+		c.ChromeTrace = w
+	}
+}
+
+// WithTracer starts a span with t for each function call made by the VM,
+// named after the function, as a child of the span of the calling function,
+// or of the span in the context set with SetTraceContext. The index of the
+// function is recorded as the wasm.function_index attribute. With
+// RecoverPanic set, the spans of the calls cut short by a trap record the
+// trap's error, and get the error status.
+func WithTracer(t trace.Tracer) VMOption {
+	return func(c *config) {
+		c.Tracer = t
+	}
+}
+
+// WithRecoverPanic sets the VM's RecoverPanic field, before the module's
+// start function is run, so a trap in the start function is returned as an
+// error from NewVM, rather than panicking.
+func WithRecoverPanic(v bool) VMOption {
+	return func(c *config) {
+		c.RecoverPanic = v
+	}
+}
+
+// WithStrictImports makes NewVM fail with ErrUnboundImport when an imported
+// host function isn't bound to a Go function, rather than the VM trapping
+// when the function is called.
+func WithStrictImports(v bool) VMOption {
+	return func(c *config) {
+		c.StrictImports = v
+	}
+}
+
+// WithStackHighWater enables tracking the deepest each function's operand
+// stack gets, across all runs of the VM, for comparing against the depth
+// estimated when compiling it with DepthEstimateReport.
+func WithStackHighWater(v bool) VMOption {
+	return func(c *config) {
+		c.StackHighWater = v
+	}
+}
+
+// WithStackCapacity preallocates space for n values on the operand stacks
+// of the function calls made by each run, which is then reused from run to
+// run, rather than allocating a stack for each call. The stacks of calls
+// which don't fit in the space left, such as for deeply nested calls, are
+// allocated as usual, as without the option.
+func WithStackCapacity(n int) VMOption {
+	return func(c *config) {
+		c.StackCapacity = n
+	}
+}
+
+// WithTimeout caps how long each run of the VM, such as ExecCode, can take,
+// trapping with ErrExecTimeout once it takes longer. The timeout is checked
+// between instructions, so a run blocked in a host function, or in an
+// instruction run as native code (see EnableAOT), only traps once that
+// returns. Functions stepped through with Step aren't timed. Zero, the
+// default, leaves runs to take as long as they take.
+func WithTimeout(d time.Duration) VMOption {
+	return func(c *config) {
+		c.Timeout = d
+	}
+}
+
 // NewVM creates a new VM from a given module and options. If the module defines
 // a start function, it will be executed.
 func NewVM(module *wasm.Module, opts ...VMOption) (*VM, error) {
-	var (
-		vm      VM
-		options config
-		err     error
-	)
+	var options config
 	for _, opt := range opts {
 		opt(&options)
 	}
+	if options.ImportResolver != nil {
+		var err error
+		if module, err = resolveImports(module, options.ImportResolver); err != nil {
+			return nil, err
+		}
+	}
+	cm, err := compileModule(module, options.LazyCompile)
+	if err != nil {
+		return nil, err
+	}
+	return cm.Instantiate(opts...)
+}
 
-	// If a PostgreSQL Connection Pool was passed, set up the needed Operation Logging pieces
-	if options.PGConnPool != nil {
-		// Set the execution run number
-		vm.PgRunNum = options.PGDBRun
+// NewVMFromReader reads a module from r, as wasm.ReadModule does with the
+// given resolve function, and creates a new VM from it, as NewVM does. The
+// module is decoded as it's read, so with WithMaxModuleBytes, an oversized
+// module is rejected without reading it in whole.
+func NewVMFromReader(r io.Reader, resolve wasm.ResolveFunc, opts ...VMOption) (*VM, error) {
+	var options config
+	for _, opt := range opts {
+		opt(&options)
+	}
+	var limited *moduleSizeLimiter
+	if options.MaxModuleBytes > 0 {
+		limited = &moduleSizeLimiter{r: r, left: options.MaxModuleBytes, max: options.MaxModuleBytes}
+		r = limited
+	}
 
-		// Begin a PostgreSQL transaction
-		// TODO: Find out if pgx.BeginBatch() would be useful here, as opposed to changing this to an in-memory
-		//       structure, suitable for using with COPY FROM
-		vm.pg = options.PGConnPool
-		vm.PgTx, err = vm.pg.Begin()
-		if err != nil {
-			panic(err)
-		}
+	module, err := wasm.ReadModule(r, resolve)
+	if limited != nil && limited.left < 0 {
+		// The decoding error may hide the one returned by the limiter
+		return nil, ModuleSizeError(options.MaxModuleBytes)
+	}
+	if err != nil {
+		return nil, err
 	}
+	return NewVM(module, opts...)
+}
 
-	if module.Memory != nil && len(module.Memory.Entries) != 0 {
-		if len(module.Memory.Entries) > 1 {
-			return nil, ErrMultipleLinearMemories
-		}
-		vm.memory = make([]byte, uint(module.Memory.Entries[0].Limits.Initial)*wasmPageSize)
-		copy(vm.memory, module.LinearMemoryIndexSpace[0])
+// moduleSizeLimiter reads from r, failing once more than the bytes left are
+// read.
+type moduleSizeLimiter struct {
+	r    io.Reader
+	left int64 // Bytes left to read, negative once past the limit
+	max  int64
+}
+
+func (l *moduleSizeLimiter) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, ModuleSizeError(l.max)
+	}
+	// Read a byte more than left, to find whether the limit is exceeded
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
 	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n, ModuleSizeError(l.max)
+	}
+	return n, err
+}
 
-	vm.funcs = make([]function, len(module.FunctionIndexSpace)) // Holds the compiled functions
-	vm.globals = make([]uint64, len(module.GlobalIndexSpace))
-	vm.newFuncTable()
-	vm.module = module
+// CompiledModule is a module compiled for execution by the VM. A compiled
+// module isn't changed by the VMs instantiated from it, so it can be
+// compiled once and then shared across goroutines.
+type CompiledModule struct {
+	module *wasm.Module
+	funcs  []function
+}
+
+// Compile compiles the functions of the given module, ready for
+// instantiating VMs from.
+func Compile(module *wasm.Module) (*CompiledModule, error) {
+	return compileModule(module, false)
+}
 
-	nNatives := 0
+// compileModule compiles the functions of the given module, or, when lazy,
+// leaves them to be compiled when first called, as with WithLazyCompile.
+func compileModule(module *wasm.Module, lazy bool) (*CompiledModule, error) {
+	memories := memoryCount(module)
+	for i := 0; i < memories && i < len(module.LinearMemoryIndexSpace); i++ {
+		size := int(module.Memory.Entries[i].Limits.Initial) * wasmPageSize
+		if image := module.LinearMemoryIndexSpace[i]; len(image) > size {
+			return nil, MemoryImageSizeError{ImageSize: len(image), MemorySize: size}
+		}
+	}
+
+	cm := &CompiledModule{
+		module: module,
+		funcs:  make([]function, len(module.FunctionIndexSpace)), // Holds the compiled functions
+	}
 	for i, fn := range module.FunctionIndexSpace {
 		// Skip native methods as they need not be
 		// disassembled; simply add them at the end
@@ -172,33 +752,184 @@ func NewVM(module *wasm.Module, opts ...VMOption) (*VM, error) {
 		// section of:
 		// https://webassembly.github.io/spec/core/exec/modules.html#allocation
 		if fn.IsHost() {
-			vm.funcs[i] = goFunction{
+			cm.funcs[i] = goFunction{
 				typ: fn.Host.Type(),
 				val: fn.Host,
 			}
-			nNatives++
+			continue
+		}
+		if lazy {
+			cm.funcs[i] = lazyFunction{}
 			continue
 		}
 
-		disassembly, err := disasm.NewDisassembly(fn, module)
+		compiled, err := compileFunction(module, fn, memories)
 		if err != nil {
 			return nil, err
 		}
+		cm.funcs[i] = compiled
+	}
+	return cm, nil
+}
+
+// memoryCount returns the number of linear memories of the given module.
+func memoryCount(module *wasm.Module) int {
+	if module.Memory == nil {
+		return 0
+	}
+	return len(module.Memory.Entries)
+}
+
+// compileFunction compiles the given function of module, which has the
+// given number of linear memories.
+func compileFunction(module *wasm.Module, fn wasm.Function, memories int) (compiledFunction, error) {
+	disassembly, err := disasm.NewDisassembly(fn, module)
+	if err != nil {
+		return compiledFunction{}, err
+	}
+	if err := checkMemoryIndexes(disassembly.Code, memories); err != nil {
+		return compiledFunction{}, err
+	}
+
+	totalLocalVars := 0
+	totalLocalVars += len(fn.Sig.ParamTypes)
+	for _, entry := range fn.Body.Locals {
+		totalLocalVars += int(entry.Count)
+	}
+	code, meta := compile.Compile(disassembly.Code)
+	return compiledFunction{
+		codeMeta:       meta,
+		code:           code,
+		branchTables:   meta.BranchTables,
+		memoryIndexes:  meta.MemoryIndexes,
+		maxDepth:       disassembly.MaxDepth,
+		totalLocalVars: totalLocalVars,
+		args:           len(fn.Sig.ParamTypes),
+		returns:        len(fn.Sig.ReturnTypes) != 0,
+	}, nil
+}
+
+// checkMemoryIndexes returns an error when a load or store of code accesses
+// a linear memory past the given number of them.
+func checkMemoryIndexes(code []disasm.Instr, memories int) error {
+	for _, instr := range code {
+		if op := instr.Op.Code; op >= ops.I32Load && op <= ops.I64Store32 && len(instr.Immediates) > 2 {
+			if index := instr.Immediates[2].(uint32); uint64(index) >= uint64(memories) {
+				return wasm.InvalidLinearMemoryIndexError(index)
+			}
+		}
+	}
+	return nil
+}
+
+// Instantiate creates a new VM running the compiled module, with its own
+// memory, globals and stack. If the module defines a start function, it will
+// be executed.
+func (cm *CompiledModule) Instantiate(opts ...VMOption) (*VM, error) {
+	var (
+		vm      VM
+		options config
+		err     error
+	)
+	for _, opt := range opts {
+		opt(&options)
+	}
+	module := cm.module
+
+	// Check the table name before it gets anywhere near the logging SQL
+	if options.PGTableName == "" {
+		options.PGTableName = defaultPGTable
+	}
+	if !pgTableNameRE.MatchString(options.PGTableName) {
+		return nil, InvalidTableNameError(options.PGTableName)
+	}
+
+	// Set the execution run number, and how operations are logged
+	vm.PgRunNum = options.PGDBRun
+	vm.logSampleRate = options.LogSampleRate
+	vm.logCommitPolicy = options.LogCommitFailurePolicy
+	vm.opHook = options.OpHook
+	vm.trackFloatFlags = options.FloatFlagTracking
+	vm.canonicalNaN = options.CanonicalNaN
+	vm.strictReserved = options.StrictReservedBytes
+	vm.strictArgs = options.StrictArgs
+	vm.stackDepthLogging = options.StackDepthLogging
+	vm.mnemonicOpNames = options.MnemonicOpNames
+	vm.overflowLogging = options.OverflowLogging
+	vm.valueTypeLogging = options.ValueTypeLogging
+	vm.hostRecorder = options.HostCallRecorder
+	vm.hostReplay = options.HostReplay
+	vm.maxCalls = options.MaxCalls
+	vm.maxCallDepth = options.MaxCallDepth
+	if vm.maxCallDepth == 0 {
+		vm.maxCallDepth = defaultMaxCallDepth
+	}
+	vm.timeout = options.Timeout
+	if options.StackCapacity > 0 {
+		vm.stackSlab = make([]uint64, options.StackCapacity)
+	}
+	if options.ChromeTrace != nil {
+		vm.tracer = newChromeTracer(options.ChromeTrace)
+	}
+	vm.spanTracer = options.Tracer
+	vm.RecoverPanic = options.RecoverPanic
+	if options.Metrics != nil {
+		if vm.metrics, err = options.Metrics(); err != nil {
+			return nil, err
+		}
+	}
+
+	// If a PostgreSQL Connection Pool was passed, set up the needed Operation Logging pieces
+	if options.PGConnPool != nil {
+		vm.pgTable = options.PGTableName
+
+		// Begin a PostgreSQL transaction
+		// TODO: Find out if pgx.BeginBatch() would be useful here, as opposed to changing this to an in-memory
+		//       structure, suitable for using with COPY FROM
+		vm.pg = options.PGConnPool
+		vm.PgTx, err = vm.pg.Begin()
+		if err != nil {
+			return nil, err
+		}
+		vm.logger = &pgLogger{vm: &vm}
+	}
+	if options.OpLogger != nil {
+		vm.logger = options.OpLogger
+	}
+
+	vm.module = module
+	if module.Memory != nil && len(module.Memory.Entries) != 0 {
+		vm.resetMemory()
+		vm.memMaxPages = maxMemoryPages
+		if limits := module.Memory.Entries[0].Limits; limits.Flags&0x1 != 0 && int64(limits.Maximum) < vm.memMaxPages {
+			vm.memMaxPages = int64(limits.Maximum)
+		}
+		if options.MaxMemoryPages != 0 && int64(options.MaxMemoryPages) < vm.memMaxPages {
+			vm.memMaxPages = int64(options.MaxMemoryPages)
+		}
+		vm.memCap = int64(options.MaxMemoryPages)
+		vm.recordMemoryPeak()
+	}
+	if options.SharedMemory != nil && vm.memory != nil {
+		vm.shareMemory(options.SharedMemory)
+	}
+
+	vm.funcs = make([]function, len(cm.funcs))
+	copy(vm.funcs, cm.funcs)
+	if options.Coverage {
+		vm.coverage = make([][]bool, len(vm.funcs))
+	}
+	if options.StackHighWater {
+		vm.highWater = make([]int, len(vm.funcs))
+	}
+	vm.globals = make([]uint64, len(module.GlobalIndexSpace))
+	vm.newFuncTable()
 
-		totalLocalVars := 0
-		totalLocalVars += len(fn.Sig.ParamTypes)
-		for _, entry := range fn.Body.Locals {
-			totalLocalVars += int(entry.Count)
-		}
-		code, meta := compile.Compile(disassembly.Code)
-		vm.funcs[i] = compiledFunction{
-			codeMeta:       meta,
-			code:           code,
-			branchTables:   meta.BranchTables,
-			maxDepth:       disassembly.MaxDepth,
-			totalLocalVars: totalLocalVars,
-			args:           len(fn.Sig.ParamTypes),
-			returns:        len(fn.Sig.ReturnTypes) != 0,
+	if options.StrictImports {
+		for i, fn := range vm.funcs {
+			if fn, ok := fn.(goFunction); ok && !fn.bound() {
+				return nil, vm.unboundImport(int64(i))
+			}
 		}
 	}
 
@@ -209,6 +940,7 @@ func NewVM(module *wasm.Module, opts ...VMOption) (*VM, error) {
 	if module.Start != nil {
 		_, err := vm.ExecCode(int64(module.Start.Index))
 		if err != nil {
+			vm.unshareMemory()
 			return nil, err
 		}
 	}
@@ -216,42 +948,186 @@ func NewVM(module *wasm.Module, opts ...VMOption) (*VM, error) {
 	if options.EnableAOT {
 		supportedBackend, backend := nativeBackend()
 		if supportedBackend {
+			// Native compilation patches the code, which is shared
+			// with the other instances of the module
+			for i, fn := range vm.funcs {
+				if fn, ok := fn.(compiledFunction); ok {
+					fn.code = append([]byte(nil), fn.code...)
+					vm.funcs[i] = fn
+				}
+			}
 			vm.nativeBackend = backend
 			if err := vm.tryNativeCompile(); err != nil {
+				vm.unshareMemory()
 				return nil, err
 			}
 		}
 	}
 
+	if options.FlushOnSignal && vm.logger != nil {
+		sigs := options.FlushSignals
+		if len(sigs) == 0 {
+			sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+		}
+		vm.watchSignals(sigs)
+	}
+
 	return &vm, nil
 }
 
-func (vm *VM) resetGlobals() error {
-	for i, global := range vm.module.GlobalIndexSpace {
-		val, err := vm.module.ExecInitExpr(global.Init)
-		if err != nil {
-			return err
+// resetMemory sets the linear memories back to the module's initial
+// memories, at their initial sizes.
+func (vm *VM) resetMemory() {
+	if vm.module.Memory == nil || len(vm.module.Memory.Entries) == 0 {
+		return
+	}
+	vm.memory = vm.initialMemory(0)
+	vm.syncMemory()
+	if n := len(vm.module.Memory.Entries); n > 1 {
+		vm.memories = make([][]byte, n-1)
+		for i := range vm.memories {
+			vm.memories[i] = vm.initialMemory(i + 1)
 		}
-		switch v := val.(type) {
-		case int32:
-			vm.globals[i] = uint64(v)
-		case int64:
-			vm.globals[i] = uint64(v)
-		case float32:
-			vm.globals[i] = uint64(math.Float32bits(v))
-		case float64:
-			vm.globals[i] = uint64(math.Float64bits(v))
+	}
+}
+
+// initialMemory returns the initial contents of the linear memory with the
+// given index.
+func (vm *VM) initialMemory(index int) []byte {
+	mem := make([]byte, uint(vm.module.Memory.Entries[index].Limits.Initial)*wasmPageSize)
+	if index < len(vm.module.LinearMemoryIndexSpace) {
+		copy(mem, vm.module.LinearMemoryIndexSpace[index])
+	}
+	return mem
+}
+
+// resetGlobals sets the globals back to their initial values.
+func (vm *VM) resetGlobals() error {
+	if vm.globalInits == nil {
+		// The init expressions only depend on the module, so they're only
+		// worked out once, for the first reset
+		inits := make([]uint64, len(vm.module.GlobalIndexSpace))
+		for i, global := range vm.module.GlobalIndexSpace {
+			val, err := vm.module.ExecInitExpr(global.Init)
+			if err != nil {
+				return err
+			}
+			switch v := val.(type) {
+			case int32:
+				inits[i] = uint64(v)
+			case int64:
+				inits[i] = uint64(v)
+			case float32:
+				inits[i] = uint64(math.Float32bits(v))
+			case float64:
+				inits[i] = uint64(math.Float64bits(v))
+			}
 		}
+		vm.globalInits = inits
 	}
+	copy(vm.globals, vm.globalInits)
 
 	return nil
 }
 
-// Memory returns the linear memory space for the VM.
+// CallStack returns the active function calls, from the outermost to the
+// innermost, such as for a debugger. It's meant to be called while the VM is
+// paused during execution, such as from an op hook (see WithOpHook) or a
+// host function. The calls into host functions themselves aren't included.
+func (vm *VM) CallStack() []Frame {
+	frames := make([]Frame, 0, len(vm.callers)+1)
+	for _, ctx := range vm.callers {
+		frames = append(frames, Frame{FuncIndex: ctx.curFunc, PC: ctx.pc, SP: ctx.stackBase})
+	}
+	return append(frames, Frame{FuncIndex: vm.ctx.curFunc, PC: vm.ctx.pc, SP: vm.ctx.stackBase})
+}
+
+// Stack returns a copy of the operand stack of the innermost function call,
+// from the bottom to the top. Like CallStack, it's meant to be called while
+// the VM is paused during execution, between calls to Step, or after
+// ExecNOps.
+func (vm *VM) Stack() []uint64 {
+	return append([]uint64(nil), vm.ctx.stack...)
+}
+
+// FuncMeta returns the compiled metadata of the function at the given index
+// in the function index space. Host functions have no such metadata, and
+// an error is returned for them.
+func (vm *VM) FuncMeta(index int64) (FuncMeta, error) {
+	if index < 0 || index >= int64(len(vm.funcs)) {
+		return FuncMeta{}, InvalidFunctionIndexError(index)
+	}
+	if err := vm.compileLazy(index); err != nil {
+		return FuncMeta{}, err
+	}
+	compiled, ok := vm.funcs[index].(compiledFunction)
+	if !ok {
+		return FuncMeta{}, fmt.Errorf("exec: function at index %d is a host function", index)
+	}
+	return FuncMeta{
+		MaxDepth:       compiled.maxDepth,
+		Args:           compiled.args,
+		Returns:        compiled.returns,
+		TotalLocalVars: compiled.totalLocalVars,
+	}, nil
+}
+
+// Memory returns the linear memory space for the VM, the first one of modules
+// with more than one.
 func (vm *VM) Memory() []byte {
 	return vm.memory
 }
 
+// MaxMemoryPages returns the largest size in pages the linear memory has
+// had since the VM was created, the first one of modules with more than
+// one, such as for working out the limit to set with WithMaxMemoryPages.
+// It's kept across runs, and by Restart, which shrinks the memory back to
+// its initial size.
+func (vm *VM) MaxMemoryPages() int {
+	return vm.memPeak
+}
+
+// recordMemoryPeak updates the largest size the linear memory has had, for
+// MaxMemoryPages, once its size changes.
+func (vm *VM) recordMemoryPeak() {
+	if pages := len(vm.memory) / wasmPageSize; pages > vm.memPeak {
+		vm.memPeak = pages
+	}
+}
+
+// SwapMemory replaces the linear memory of the VM with newMem, returning the
+// previous one, so the buffers for different runs can be prepared up front
+// and recycled rather than copied into the memory. The new memory has to be
+// a whole number of pages, no smaller than the module's initial memory size
+// and no larger than the memory can grow to, going by the module's maximum
+// and the one set with WithMaxMemoryPages. The VM uses newMem directly from
+// then on, so it shouldn't be touched by the caller while the VM is
+// executing.
+//
+// As with the rest of the VM, SwapMemory isn't safe to call while ExecCode
+// is running.
+func (vm *VM) SwapMemory(newMem []byte) (old []byte, err error) {
+	if vm.module.Memory == nil || len(vm.module.Memory.Entries) == 0 {
+		return nil, errors.New("exec: module has no linear memory to swap")
+	}
+	if len(newMem)%wasmPageSize != 0 {
+		return nil, fmt.Errorf("exec: new memory size %d is not a multiple of the page size (%d)", len(newMem), wasmPageSize)
+	}
+	limits := vm.module.Memory.Entries[0].Limits
+	pages := uint64(len(newMem) / wasmPageSize)
+	if pages < uint64(limits.Initial) {
+		return nil, fmt.Errorf("exec: new memory has %d pages, less than the initial %d", pages, limits.Initial)
+	}
+	if pages > uint64(vm.memMaxPages) {
+		return nil, fmt.Errorf("exec: new memory has %d pages, more than the maximum %d", pages, vm.memMaxPages)
+	}
+
+	old, vm.memory = vm.memory, newMem
+	vm.recordMemoryPeak()
+	vm.syncMemory()
+	return old, nil
+}
+
 func (vm *VM) pushBool(v bool) {
 	if v {
 		vm.pushUint64(1)
@@ -301,6 +1177,11 @@ func (vm *VM) fetchFloat64() float64 {
 }
 
 func (vm *VM) popUint64() uint64 {
+	if debugStackDepth {
+		if len(vm.ctx.stack) == 0 {
+			panic(ErrStackUnderflow)
+		}
+	}
 	i := vm.ctx.stack[len(vm.ctx.stack)-1]
 	vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-1]
 	return i
@@ -327,12 +1208,13 @@ func (vm *VM) popFloat32() float32 {
 }
 
 func (vm *VM) pushUint64(i uint64) {
-	if debugStackDepth {
-		if len(vm.ctx.stack) >= cap(vm.ctx.stack) {
-			panic("stack exceeding max depth: " + fmt.Sprintf("len=%d,cap=%d", len(vm.ctx.stack), cap(vm.ctx.stack)))
-		}
+	if len(vm.ctx.stack) == cap(vm.ctx.stack) {
+		vm.growStack(1)
 	}
 	vm.ctx.stack = append(vm.ctx.stack, i)
+	if vm.highWater != nil && len(vm.ctx.stack) > vm.highWater[vm.ctx.curFunc] {
+		vm.highWater[vm.ctx.curFunc] = len(vm.ctx.stack)
+	}
 }
 
 func (vm *VM) pushInt64(i int64) {
@@ -358,34 +1240,212 @@ func (vm *VM) pushFloat32(f float32) {
 // ExecCode calls the function with the given index and arguments.
 // fnIndex should be a valid index into the function index space of
 // the VM's module.
+//
+// A host function can call ExecCode to call back into the module, in the
+// middle of the run calling the host function. The call is run with its
+// own stack and call stack, and the run carries on as before once it
+// returns.
 func (vm *VM) ExecCode(fnIndex int64, args ...uint64) (rtrn interface{}, err error) {
+	defer vm.beginRun()()
 	// If used as a library, client code should set vm.RecoverPanic to true
 	// in order to have an error returned.
 	if vm.RecoverPanic {
-		defer func() {
-			if r := recover(); r != nil {
-				switch e := r.(type) {
-				case error:
-					err = e
-				default:
-					err = fmt.Errorf("exec: %v", e)
-				}
-			}
-		}()
+		defer vm.recoverPanic(&err)
+	}
+	if vm.timeout > 0 {
+		defer vm.startTimeout()()
+	}
+	compiled, err := vm.enterFunc(fnIndex, args)
+	if err != nil {
+		return nil, err
+	}
+
+	res := vm.execCode(compiled)
+	if err = vm.finishRun(); err != nil {
+		return nil, err
 	}
-	if int(fnIndex) > len(vm.funcs) {
-		return nil, InvalidFunctionIndexError(fnIndex)
+
+	if compiled.returns {
+		rtrnType := vm.module.GetFunction(int(fnIndex)).Sig.ReturnTypes[0]
+		return returnValue(rtrnType, res)
+	}
+
+	return nil, nil
+}
+
+// ExecCodeMulti calls the function with the given index and arguments, like
+// ExecCode, but returns all of the function's results in the order they
+// are declared in its signature.
+func (vm *VM) ExecCodeMulti(fnIndex int64, args ...uint64) (rtrns []interface{}, err error) {
+	defer vm.beginRun()()
+	if vm.RecoverPanic {
+		defer vm.recoverPanic(&err)
+	}
+	if vm.timeout > 0 {
+		defer vm.startTimeout()()
+	}
+	compiled, err := vm.enterFunc(fnIndex, args)
+	if err != nil {
+		return nil, err
+	}
+
+	vm.execCode(compiled)
+	if err = vm.finishRun(); err != nil {
+		return nil, err
+	}
+	if vm.abort {
+		// The run was stopped before the results were pushed, such as by
+		// a host function calling Process.Terminate
+		return nil, nil
+	}
+
+	// The results were pushed in order, so the last one is on the top
+	// of the stack
+	rtrnTypes := vm.module.GetFunction(int(fnIndex)).Sig.ReturnTypes
+	rtrns = make([]interface{}, len(rtrnTypes))
+	for i := len(rtrnTypes) - 1; i >= 0; i-- {
+		rtrns[i], err = returnValue(rtrnTypes[i], vm.popUint64())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rtrns, nil
+}
+
+// ExecCodeByName calls the function exported with the given name, with the
+// given arguments, as ExecCode does, returning an error when the module
+// exports no function of that name.
+func (vm *VM) ExecCodeByName(name string, args ...uint64) (interface{}, error) {
+	index, err := vm.exportedFunc(name)
+	if err != nil {
+		return nil, err
+	}
+	return vm.ExecCode(index, args...)
+}
+
+// exportedFunc returns the index of the function exported with the given
+// name.
+func (vm *VM) exportedFunc(name string) (int64, error) {
+	var export wasm.ExportEntry
+	ok := false
+	if vm.module.Export != nil {
+		export, ok = vm.module.Export.Entries[name]
+	}
+	if !ok || export.Kind != wasm.ExternalFunction {
+		return 0, fmt.Errorf("exec: no exported function named %q", name)
+	}
+	if vm.module.GetFunction(int(export.Index)) == nil {
+		return 0, InvalidFunctionIndexError(export.Index)
 	}
-	if len(vm.module.GetFunction(int(fnIndex)).Sig.ParamTypes) != len(args) {
-		return nil, ErrInvalidArgumentCount
+	return int64(export.Index), nil
+}
+
+// ExportInfo describes a function exported by the VM's module.
+type ExportInfo struct {
+	Name        string           // Name the function is exported under
+	Index       int64            // Index of the function, as for ExecCode
+	ParamTypes  []wasm.ValueType // Types of the function's parameters
+	ReturnTypes []wasm.ValueType // Types of the function's results
+}
+
+// Exports returns the functions exported by the VM's module, which can be
+// called with ExecCodeByName, sorted by name. A function exported under
+// more than one name is listed under each.
+func (vm *VM) Exports() []ExportInfo {
+	if vm.module.Export == nil {
+		return nil
+	}
+	var exports []ExportInfo
+	for name, export := range vm.module.Export.Entries {
+		if export.Kind != wasm.ExternalFunction {
+			continue
+		}
+		fn := vm.module.GetFunction(int(export.Index))
+		if fn == nil {
+			continue
+		}
+		exports = append(exports, ExportInfo{
+			Name:        name,
+			Index:       int64(export.Index),
+			ParamTypes:  fn.Sig.ParamTypes,
+			ReturnTypes: fn.Sig.ReturnTypes,
+		})
+	}
+	sort.Slice(exports, func(i, j int) bool {
+		return exports[i].Name < exports[j].Name
+	})
+	return exports
+}
+
+// RunInitSequence calls the exported functions with the given names in
+// order, for modules expecting the embedder to run a series of setup
+// functions before their main one. The functions have to take no arguments
+// and return nothing. The sequence stops at the first function which fails
+// or traps, returning its error, or which terminates the VM. As with
+// ExecCode, traps are only returned as errors with RecoverPanic set.
+func (vm *VM) RunInitSequence(names []string) error {
+	for _, name := range names {
+		index, err := vm.exportedFunc(name)
+		if err != nil {
+			return err
+		}
+		fn := vm.module.GetFunction(int(index))
+		if len(fn.Sig.ParamTypes) != 0 || len(fn.Sig.ReturnTypes) != 0 {
+			return fmt.Errorf("exec: init function %q has to take no arguments and return nothing", name)
+		}
+		if _, err := vm.ExecCode(index); err != nil {
+			return err
+		}
+		if vm.abort {
+			return fmt.Errorf("exec: init function %q terminated the VM", name)
+		}
+	}
+	return nil
+}
+
+// recoverPanic turns a panic into an error stored in err. It needs to be
+// deferred directly.
+func (vm *VM) recoverPanic(err *error) {
+	if r := recover(); r != nil {
+		*err = vm.wrapPanic(r)
+		vm.traceTrap(*err)
+		if vm.metrics != nil {
+			vm.metrics.countTrap(*err)
+		}
+	}
+}
+
+// enterFunc readies the execution context for calling the function with the
+// given index and arguments from outside the VM.
+func (vm *VM) enterFunc(fnIndex int64, args []uint64) (compiledFunction, error) {
+	if fnIndex < 0 || fnIndex >= int64(len(vm.funcs)) {
+		return compiledFunction{}, InvalidFunctionIndexError(fnIndex)
+	}
+	params := vm.module.GetFunction(int(fnIndex)).Sig.ParamTypes
+	if len(params) != len(args) {
+		return compiledFunction{}, ErrInvalidArgumentCount
+	}
+	if vm.strictArgs {
+		if err := checkArgs(params, args); err != nil {
+			return compiledFunction{}, err
+		}
+	}
+	if err := vm.compileLazy(fnIndex); err != nil {
+		return compiledFunction{}, err
 	}
 	compiled, ok := vm.funcs[fnIndex].(compiledFunction)
 	if !ok {
-		panic(fmt.Sprintf("exec: function at index %d is not a compiled function", fnIndex))
+		return compiledFunction{}, fmt.Errorf("exec: function at index %d is a host function", fnIndex)
 	}
 
 	depth := compiled.maxDepth + 1
-	if cap(vm.ctx.stack) < depth {
+	if vm.stackSlab != nil {
+		if vm.nested == 0 {
+			vm.stackTop = 0
+		}
+		vm.ctx.stack = vm.allocStack(depth)
+	} else if cap(vm.ctx.stack) < depth {
 		vm.ctx.stack = make([]uint64, 0, depth)
 	} else {
 		vm.ctx.stack = vm.ctx.stack[:0]
@@ -395,176 +1455,358 @@ func (vm *VM) ExecCode(fnIndex int64, args ...uint64) (rtrn interface{}, err err
 	vm.ctx.pc = 0
 	vm.ctx.code = compiled.code
 	vm.ctx.asm = compiled.asm
+	vm.ctx.branchTables = compiled.branchTables
+	vm.ctx.memoryIndexes = compiled.memoryIndexes
 	vm.ctx.curFunc = fnIndex
+	vm.ctx.stackBase = 0
+	vm.callers = vm.callers[:0]
+	vm.breakpointHit, vm.resuming = false, false
+	vm.calls = 0
+	vm.traceEnter(fnIndex)
 
 	for i, arg := range args {
 		vm.ctx.locals[i] = arg
 	}
 
-	res := vm.execCode(compiled)
-	if compiled.returns {
-		rtrnType := vm.module.GetFunction(int(fnIndex)).Sig.ReturnTypes[0]
-		switch rtrnType {
-		case wasm.ValueTypeI32:
-			rtrn = uint32(res)
-		case wasm.ValueTypeI64:
-			rtrn = uint64(res)
-		case wasm.ValueTypeF32:
-			rtrn = math.Float32frombits(uint32(res))
-		case wasm.ValueTypeF64:
-			rtrn = math.Float64frombits(res)
-		default:
-			return nil, InvalidReturnTypeError(rtrnType)
-		}
-	}
-
-	// Set up an automatic transaction commit for the opLogging
-	defer func() {
-		err = vm.PgTx.Commit()
-		if err != nil {
-			panic(err)
+	vm.logInitialState()
+	return compiled, nil
+}
+
+// growStack makes room on the operand stack for n more values, doubling its
+// capacity, or more when that's not enough. The stacks are sized for the
+// maximum depth the disassembler works out for each function, so that's
+// only needed when it got that wrong.
+func (vm *VM) growStack(n int) {
+	size := 2 * cap(vm.ctx.stack)
+	if size < len(vm.ctx.stack)+n {
+		size = len(vm.ctx.stack) + n
+	}
+	stack := make([]uint64, len(vm.ctx.stack), size)
+	copy(stack, vm.ctx.stack)
+	vm.ctx.stack = stack
+}
+
+// allocStack returns an empty operand stack with room for depth values,
+// from the space set aside with WithStackCapacity when there's enough left.
+// The space is given back by resetting stackTop.
+func (vm *VM) allocStack(depth int) []uint64 {
+	if vm.stackTop+depth > len(vm.stackSlab) {
+		return make([]uint64, 0, depth)
+	}
+	stack := vm.stackSlab[vm.stackTop : vm.stackTop : vm.stackTop+depth]
+	vm.stackTop += depth
+	return stack
+}
+
+// beginRun marks the start of a run from outside the VM, returning the
+// function marking its end, to be deferred ahead of recoverPanic, so the
+// trap's call stack is recorded first. A run started by a host function in
+// the middle of another run gets an execution context of its own, and the
+// one of the run it's nested in is put back at its end.
+func (vm *VM) beginRun() (end func()) {
+	if !vm.running {
+		vm.running = true
+		return func() {
+			vm.running = false
+			vm.traceRunEnd(traceMark{})
 		}
-	}()
+	}
+	traceMark, spanBase := vm.traceDepth(), vm.spanBase
+	vm.spanBase = traceMark.spans
+	ctx, callers, calls, stackTop := vm.ctx, vm.callers, vm.calls, vm.stackTop
+	outerDepth := vm.outerDepth
+	vm.outerDepth += len(callers) + 1
+	// Leave enterFunc to allocate the nested run's stacks, rather than
+	// reusing those of the run it's nested in
+	vm.ctx, vm.callers = context{}, nil
+	vm.nested++
+	return func() {
+		vm.ctx, vm.callers, vm.calls, vm.stackTop = ctx, callers, calls, stackTop
+		vm.outerDepth = outerDepth
+		vm.nested--
+		vm.traceRunEnd(traceMark)
+		vm.spanBase = spanBase
+	}
+}
+
+// finishRun wraps up a run started from outside the VM, returning the error
+// which aborted it, if any.
+func (vm *VM) finishRun() error {
+	// Commit the operation log for this run
+	if err := vm.commitLog(); err != nil {
+		panic(err)
+	}
+	if vm.hostErr != nil {
+		err := vm.hostErr
+		vm.hostErr = nil
+		return err
+	}
+	if vm.logErr != nil {
+		err := vm.logErr
+		vm.logErr = nil
+		return err
+	}
+	if vm.breakpointHit {
+		// Let the VM be resumed, as it was only stopped by the breakpoint
+		vm.paused, vm.abort = false, false
+		return ErrBreakpointHit
+	}
+	return nil
+}
 
-	return rtrn, nil
+// returnValue converts a raw stack value to the Go type matching the
+// WebAssembly type it is returned as.
+func returnValue(typ wasm.ValueType, v uint64) (interface{}, error) {
+	switch typ {
+	case wasm.ValueTypeI32:
+		return uint32(v), nil
+	case wasm.ValueTypeI64:
+		return uint64(v), nil
+	case wasm.ValueTypeF32:
+		return math.Float32frombits(uint32(v)), nil
+	case wasm.ValueTypeF64:
+		return math.Float64frombits(v), nil
+	}
+	return nil, InvalidReturnTypeError(typ)
 }
 
 func (vm *VM) execCode(compiled compiledFunction) uint64 {
-outer:
 	for int(vm.ctx.pc) < len(vm.ctx.code) && !vm.abort {
-		op := vm.ctx.code[vm.ctx.pc]
-		vm.ctx.pc++
-		switch op {
-		case ops.Return:
+		if vm.limitOps {
+			if vm.opsLeft == 0 {
+				vm.paused, vm.abort = true, true
+				break
+			}
+			vm.opsLeft--
+		}
+		if vm.expired != nil {
+			vm.checkTimeout()
+		}
+		if vm.breakpoints != nil && vm.atBreakpoint() {
+			vm.breakpointHit, vm.paused, vm.abort = true, true, true
+			break
+		}
+		if vm.execOp() {
+			break
+		}
+	}
+
+	if compiled.returns && !vm.abort {
+		return vm.ctx.stack[len(vm.ctx.stack)-1]
+	}
+	return 0
+}
+
+// execOp executes the instruction at the program counter of the current
+// function, returning true when it returns from the function.
+func (vm *VM) execOp() bool {
+	op := vm.ctx.code[vm.ctx.pc]
+	if vm.opHook != nil {
+		vm.opHook(op, vm.ctx.pc, vm.ctx.stack[:len(vm.ctx.stack):len(vm.ctx.stack)])
+	}
+	if vm.coverage != nil {
+		vm.markCovered()
+	}
+	if vm.metrics != nil {
+		vm.metrics.countOp(op)
+	}
+	vm.ctx.pc++
+	switch op {
+	case ops.Return:
 
-			// Log this operation
-			opLog(vm, op, "Return", []string{"program_counter", "stack_start"}, []interface{}{vm.ctx.pc, vm.ctx.stack})
+		// Log this operation
+		if vm.logger != nil {
+			opLog(vm, op, "Return", controlOpFields, logData(vm.ctx.pc, vm.ctx.stack))
+		}
 
-			break outer
-		case compile.OpJmp:
-			origPC := vm.ctx.pc
-			vm.ctx.pc = vm.fetchInt64()
+		return true
+	case compile.OpJmp:
+		origPC := vm.ctx.pc
+		vm.ctx.pc = vm.fetchInt64()
 
-			// Log this operation
+		// Log this operation
+		if vm.logger != nil {
 			opLog(vm, op, "Jmp unconditional", []string{"program_counter", "stack_start", "target"},
-				[]interface{}{origPC, vm.ctx.stack, vm.ctx.pc})
-		case compile.OpJmpZ:
-			origPC := vm.ctx.pc
-			stackStart := vm.ctx.stack
-
-			// The operation we're logging
-			target := vm.fetchInt64()
-			cond := vm.popUint32() == 0
-			if cond {
-				vm.ctx.pc = target
-			}
+				logData(origPC, vm.ctx.stack, vm.ctx.pc))
+		}
+	case compile.OpJmpZ:
+		origPC := vm.ctx.pc
+		stackStart := vm.ctx.stack
+
+		// The operation we're logging
+		target := vm.fetchInt64()
+		cond := vm.popUint32() == 0
+		if cond {
+			vm.ctx.pc = target
+		}
 
-			// Log this operation
+		// Log this operation
+		if vm.logger != nil {
 			opLog(vm, op, "Jmp if zero", []string{"program_counter", "stack_start", "stack_finish", "condition_met", "target"},
-				[]interface{}{origPC, stackStart, vm.ctx.stack, cond, target})
-		case compile.OpJmpNz:
-			origPC := vm.ctx.pc
-			stackStart := vm.ctx.stack
-
-			// The operation we're logging
-			target := vm.fetchInt64()
-			preserveTop := vm.fetchBool()
-			discard := vm.fetchInt64()
-			cond := vm.popUint32() != 0
-			if cond {
-				vm.ctx.pc = target
-				var top uint64
-				if preserveTop {
-					top = vm.ctx.stack[len(vm.ctx.stack)-1]
-				}
-				vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-int(discard)]
-				if preserveTop {
-					vm.pushUint64(top)
-				}
-			}
-
-			// Log this operation
-			opLog(vm, op, "Jmp if Not Zero / branch if", []string{"program_counter", "stack_start", "stack_finish", "target", "preserve_top", "discard", "condition_met"},
-				[]interface{}{origPC, stackStart, vm.ctx.stack, target, preserveTop, discard, cond})
-		case ops.BrTable:
-			index := vm.fetchInt64()
-			label := vm.popInt32()
-			cf, ok := vm.funcs[vm.ctx.curFunc].(compiledFunction)
-			if !ok {
-				panic(fmt.Sprintf("exec: function at index %d is not a compiled function", vm.ctx.curFunc))
-			}
-			table := cf.branchTables[index]
-			var target compile.Target
-			if label >= 0 && label < int32(len(table.Targets)) {
-				target = table.Targets[int32(label)]
-			} else {
-				target = table.DefaultTarget
-			}
-
-			if target.Return {
-				break outer
-			}
-			vm.ctx.pc = target.Addr
+				logData(origPC, stackStart, vm.ctx.stack, cond, target))
+		}
+	case compile.OpJmpNz:
+		origPC := vm.ctx.pc
+		stackStart := vm.ctx.stack
+
+		// The operation we're logging
+		target := vm.fetchInt64()
+		preserveTop := vm.fetchBool()
+		discard := vm.fetchInt64()
+		cond := vm.popUint32() != 0
+		if cond {
+			vm.ctx.pc = target
 			var top uint64
-			if target.PreserveTop {
+			if preserveTop {
 				top = vm.ctx.stack[len(vm.ctx.stack)-1]
 			}
-			vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-int(target.Discard)]
-			if target.PreserveTop {
+			vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-int(discard)]
+			if preserveTop {
 				vm.pushUint64(top)
 			}
-			continue
-		case compile.OpDiscard:
-			stackStart := append(make([]uint64, len(vm.ctx.stack)), vm.ctx.stack...) // Create a separate copy, to be safe
-
-			// The operation we're logging
-			place := vm.fetchInt64()
-			vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-int(place)]
-
-			// Log this operation
-			opLog(vm, op, "Discard", []string{"program_counter", "stack_start", "stack_finish"},
-				[]interface{}{vm.ctx.pc, stackStart, vm.ctx.stack})
-		case compile.OpDiscardPreserveTop:
-			stackStart := append(make([]uint64, len(vm.ctx.stack)), vm.ctx.stack...) // Create a separate copy, to be safe
-
-			// The operation we're logging
-			top := vm.ctx.stack[len(vm.ctx.stack)-1]
-			place := vm.fetchInt64()
-			vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-int(place)]
+		}
+
+		// Log this operation
+		if vm.logger != nil {
+			opLog(vm, op, "Jmp if Not Zero / branch if", []string{"program_counter", "stack_start", "stack_finish", "target", "preserve_top", "discard", "condition_met"},
+				logData(origPC, stackStart, vm.ctx.stack, target, preserveTop, discard, cond))
+		}
+	case ops.BrTable:
+		index := vm.fetchInt64()
+		label := vm.popInt32()
+		table := vm.ctx.branchTables[index]
+		var target compile.Target
+		if label >= 0 && label < int32(len(table.Targets)) {
+			target = table.Targets[int32(label)]
+		} else {
+			target = table.DefaultTarget
+		}
+
+		if target.Return {
+			return true
+		}
+		vm.ctx.pc = target.Addr
+		var top uint64
+		if target.PreserveTop {
+			top = vm.ctx.stack[len(vm.ctx.stack)-1]
+		}
+		vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-int(target.Discard)]
+		if target.PreserveTop {
 			vm.pushUint64(top)
+		}
+		return false
+	case compile.OpDiscard:
+		var stackStart []uint64
+		if vm.logger != nil {
+			stackStart = append(make([]uint64, 0, len(vm.ctx.stack)), vm.ctx.stack...) // Create a separate copy, to be safe
+		}
 
-			// Log this operation
-			opLog(vm, op, "Discard preserving top stack value", []string{"program_counter", "stack_start", "stack_finish"},
-				[]interface{}{vm.ctx.pc, stackStart, vm.ctx.stack})
-		case ops.WagonNativeExec:
-			// Log this operation
-			opLog(vm, op, "Wagon native execution op - shouldn't happen", []string{"program_counter", "stack_start"},
-				[]interface{}{vm.ctx.pc, vm.ctx.stack})
+		// The operation we're logging
+		place := vm.fetchInt64()
+		vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-int(place)]
 
-			// The operation we're logging
-			i := vm.fetchUint32()
-			vm.nativeCodeInvocation(i)
-		default:
-			vm.funcTable[op]()
+		// Log this operation
+		if vm.logger != nil {
+			discarded := stackStart[len(stackStart)-int(place):]
+			opLog(vm, op, "Discard", discardOpFields,
+				logData(vm.ctx.pc, discarded, stackStart, vm.ctx.stack))
+		}
+	case compile.OpDiscardPreserveTop:
+		var stackStart []uint64
+		if vm.logger != nil {
+			stackStart = append(make([]uint64, 0, len(vm.ctx.stack)), vm.ctx.stack...) // Create a separate copy, to be safe
 		}
-	}
 
-	if compiled.returns {
-		return vm.ctx.stack[len(vm.ctx.stack)-1]
+		// The operation we're logging
+		top := vm.ctx.stack[len(vm.ctx.stack)-1]
+		place := vm.fetchInt64()
+		vm.ctx.stack = vm.ctx.stack[:len(vm.ctx.stack)-int(place)]
+		vm.pushUint64(top)
+
+		// Log this operation
+		if vm.logger != nil {
+			discarded := stackStart[len(stackStart)-int(place) : len(stackStart)-1] // All but the preserved top value
+			opLog(vm, op, "Discard preserving top stack value", discardOpFields,
+				logData(vm.ctx.pc, discarded, stackStart, vm.ctx.stack))
+		}
+	case ops.WagonNativeExec:
+		// Log this operation
+		if vm.logger != nil {
+			opLog(vm, op, "Wagon native execution op - shouldn't happen", controlOpFields,
+				logData(vm.ctx.pc, vm.ctx.stack))
+		}
+
+		// The operation we're logging
+		i := vm.fetchUint32()
+		vm.nativeCodeInvocation(i)
+	default:
+		vm.funcTable[op]()
 	}
-	return 0
+	return false
 }
 
-// Restart readies the VM for another run.
-func (vm *VM) Restart() {
-	vm.resetGlobals()
+// Restart readies the VM for another run, setting the globals and the
+// linear memory back to their initial values, as when the VM was created, so
+// runs are reproducible. The module's start function isn't run again, so
+// whatever it set up in memory is lost, see RestartWithOptions for that. An
+// error from evaluating the initial values of the globals is returned.
+func (vm *VM) Restart() error {
+	vm.resetMemory()
+	if err := vm.resetGlobals(); err != nil {
+		return err
+	}
 	vm.ctx.locals = make([]uint64, 0)
 	vm.abort = false
+	vm.hostErr = nil
+	vm.logErr = nil
+	vm.breakpointHit = false
+	return nil
+}
+
+// RestartOptions controls what RestartWithOptions does, on top of what
+// Restart does.
+type RestartOptions struct {
+	// RerunStart runs the module's start function again, as when the VM
+	// was created. It's off by default, as the start function can have
+	// side effects, such as setting up memory through host functions,
+	// which shouldn't be repeated.
+	RerunStart bool
+}
+
+// RestartWithOptions readies the VM for another run, as Restart does, with
+// the given options. An error from rerunning the start function, or from
+// Restart, is returned.
+func (vm *VM) RestartWithOptions(opts RestartOptions) error {
+	if err := vm.Restart(); err != nil {
+		return err
+	}
+	if opts.RerunStart && vm.module.Start != nil {
+		if _, err := vm.ExecCode(int64(vm.module.Start.Index)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Close frees any resources managed by the VM.
+// Close frees any resources managed by the VM. The operation logging
+// transaction is rolled back, dropping the operations logged since the last
+// commit, such as those of a run abandoned part way through, and handing
+// its connection back to the pool.
 func (vm *VM) Close() error {
 	vm.abort = true // prevents further use.
+	vm.stopWatchingSignals()
+	if vm.PgTx != nil && vm.PgTx.Status() == pgx.TxStatusInProgress {
+		if err := vm.PgTx.Rollback(); err != nil {
+			return err
+		}
+	}
+	if vm.tracer != nil {
+		err := vm.tracer.close()
+		vm.tracer = nil
+		if err != nil {
+			return err
+		}
+	}
 	if vm.nativeBackend != nil {
 		if err := vm.nativeBackend.Close(); err != nil {
 			return err
@@ -588,10 +1830,17 @@ func NewProcess(vm *VM) *Process {
 // the content of memory at offset off.
 func (proc *Process) ReadAt(p []byte, off int64) (int, error) {
 	mem := proc.vm.Memory()
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
 
 	var length int
-	if len(mem) < len(p)+int(off) {
+	if int64(len(mem)) < int64(len(p))+off {
 		length = len(mem) - int(off)
+		if length < 0 {
+			length = 0
+			off = 0
+		}
 	} else {
 		length = len(p)
 	}
@@ -610,10 +1859,17 @@ func (proc *Process) ReadAt(p []byte, off int64) (int, error) {
 // into the VM memory at offset off.
 func (proc *Process) WriteAt(p []byte, off int64) (int, error) {
 	mem := proc.vm.Memory()
+	if off < 0 {
+		return 0, errNegativeOffset
+	}
 
 	var length int
-	if len(mem) < len(p)+int(off) {
+	if int64(len(mem)) < int64(len(p))+off {
 		length = len(mem) - int(off)
+		if length < 0 {
+			length = 0
+			off = 0
+		}
 	} else {
 		length = len(p)
 	}
@@ -628,75 +1884,58 @@ func (proc *Process) WriteAt(p []byte, off int64) (int, error) {
 	return length, err
 }
 
+// ReadString returns the length bytes of memory at ptr as a string, such as
+// for a string passed to a host function by its pointer and length. As in
+// the running code, ptr and length are taken as unsigned, and a string
+// running past the end of memory isn't read at all, returning
+// ErrOutOfBoundsMemoryAccess.
+func (proc *Process) ReadString(ptr, length int32) (string, error) {
+	mem := proc.vm.Memory()
+	start, end := uint64(uint32(ptr)), uint64(uint32(ptr))+uint64(uint32(length))
+	if end > uint64(len(mem)) {
+		return "", ErrOutOfBoundsMemoryAccess
+	}
+	return string(mem[start:end]), nil
+}
+
+// WriteString writes s into memory at ptr, returning the number of bytes
+// written. As in the running code, ptr is taken as unsigned, and a string
+// which would run past the end of memory isn't written at all, returning
+// ErrOutOfBoundsMemoryAccess.
+func (proc *Process) WriteString(ptr int32, s string) (int, error) {
+	mem := proc.vm.Memory()
+	start := uint64(uint32(ptr))
+	if start+uint64(len(s)) > uint64(len(mem)) {
+		return 0, ErrOutOfBoundsMemoryAccess
+	}
+	return copy(mem[start:], s), nil
+}
+
+// MemSize returns the size of the linear memory in bytes, a multiple of the
+// 64KiB page size, as the current_memory instruction sees it.
+func (proc *Process) MemSize() int {
+	return len(proc.vm.Memory())
+}
+
+// Grow grows the linear memory by the given number of pages, as the
+// grow_memory instruction does, returning its previous size in pages. When
+// growing would take the memory past its maximum size, or pages is
+// negative, the memory is left as it is and -1 is returned.
+func (proc *Process) Grow(pages int32) int32 {
+	return proc.vm.growMemoryBy(pages)
+}
+
 // Terminate stops the execution of the current module.
 func (proc *Process) Terminate() {
 	proc.vm.abort = true
 }
 
-// Send the opcode data to the database for post-run analysis.  For now we don't return any error code, just to keep
-// the likely bulk code changes somewhat simple
-func opLog(vm *VM, opCode byte, opName string, fields []string, data []interface{}) {
-	if vm.pg == nil {
-		// Operating logging isn't enabled
-		return
-	}
-	if len(fields) != len(data) {
-		log.Print("Mismatching field and data count to opLog()")
-		return
-	}
-	var s, t string
-	for i, j := range fields {
-		s += ", " + j
-		t += fmt.Sprintf(", $%d", 5+i)
-	}
-	dbQuery := fmt.Sprintf(`
-		INSERT INTO execution_run (op_num, run_num, op_code, op_name%s)
-		VALUES ($1, $2, $3, $4%s)`, s, t)
-	var err error
-	var commandTag pgx.CommandTag
-	// TODO: Surely there's a better way than this?
-	switch len(fields) {
-	case 0:
-		commandTag, err = vm.PgTx.Exec(dbQuery, opNum, vm.PgRunNum, opCode, opName)
-	case 1:
-		commandTag, err = vm.PgTx.Exec(dbQuery, opNum, vm.PgRunNum, opCode, opName, data[0])
-	case 2:
-		commandTag, err = vm.PgTx.Exec(dbQuery, opNum, vm.PgRunNum, opCode, opName, data[0], data[1])
-	case 3:
-		commandTag, err = vm.PgTx.Exec(dbQuery, opNum, vm.PgRunNum, opCode, opName, data[0], data[1], data[2])
-	case 4:
-		commandTag, err = vm.PgTx.Exec(dbQuery, opNum, vm.PgRunNum, opCode, opName, data[0], data[1], data[2], data[3])
-	case 5:
-		commandTag, err = vm.PgTx.Exec(dbQuery, opNum, vm.PgRunNum, opCode, opName, data[0], data[1], data[2], data[3], data[4])
-	case 6:
-		commandTag, err = vm.PgTx.Exec(dbQuery, opNum, vm.PgRunNum, opCode, opName, data[0], data[1], data[2], data[3], data[4], data[5])
-	case 7:
-		commandTag, err = vm.PgTx.Exec(dbQuery, opNum, vm.PgRunNum, opCode, opName, data[0], data[1], data[2], data[3], data[4], data[5], data[6])
-	case 8:
-		commandTag, err = vm.PgTx.Exec(dbQuery, opNum, vm.PgRunNum, opCode, opName, data[0], data[1], data[2], data[3], data[4], data[5], data[6], data[7])
-	default:
-		log.Printf("Need to add a case for %d to the opLog() function", len(fields))
-		return
-	}
-	if err != nil {
-		log.Print(err)
-		return
-	}
-	if numRows := commandTag.RowsAffected(); numRows != 1 {
-		log.Printf("Wrong number of rows (%v) affected when logging an operation: %v\n", numRows, opName)
-	}
-
-	// Commit every 100 inserts, so quitting via Ctrl+C keeps the majority of info thus far
-	if (opNum % 100) == 0 {
-		err = vm.PgTx.Commit()
-		if err != nil {
-			panic(err)
-		}
-		vm.PgTx, err = vm.pg.Begin()
-		if err != nil {
-			panic(err)
-		}
+// Fail stops the execution of the current module, as Terminate does, with
+// err returned by the run, such as ExecCode, once the host function calling
+// it returns. Only the first error is kept, when called more than once.
+func (proc *Process) Fail(err error) {
+	proc.vm.abort = true
+	if proc.vm.hostErr == nil {
+		proc.vm.hostErr = err
 	}
-	opNum++
-	return
 }
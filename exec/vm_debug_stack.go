@@ -7,6 +7,6 @@
 package exec
 
 // debugStackDepth enables runtime checks of the stack depth. If
-// the stack every would exceed or underflow its expected bounds,
-// a panic is thrown.
+// the stack ever would underflow, or exceed its bounds in native
+// code, a panic is thrown.
 const debugStackDepth = true
@@ -0,0 +1,29 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build debugstack
+// +build debugstack
+
+package exec
+
+import (
+	"testing"
+
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+func TestStackUnderflow(t *testing.T) {
+	vm, err := NewVM(buildTestModule(testFunc{code: []byte{ops.Drop}}))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	_, err = vm.ExecCode(0)
+	if trapCause(err) != ErrStackUnderflow {
+		t.Fatalf("got error %v, want %v", err, ErrStackUnderflow)
+	}
+	if te, ok := err.(*TrapError); !ok || te.TrapKind() != TrapStackUnderflow || te.OpName != "drop" {
+		t.Errorf("got error %v, want a trap of kind %v at drop", err, TrapStackUnderflow)
+	}
+}
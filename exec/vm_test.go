@@ -5,7 +5,16 @@
 package exec
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
 	"testing"
+
+	"github.com/go-interpreter/wagon/exec/internal/compile"
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
 )
 
 var (
@@ -110,3 +119,731 @@ func TestWriteOffset(t *testing.T) {
 		t.Fatal("Writing at offset didn't work")
 	}
 }
+
+func TestReadWriteString(t *testing.T) {
+	vm := &VM{memory: make([]byte, 16)}
+	proc := &Process{vm: vm}
+
+	n, err := proc.WriteString(4, "hello")
+	if err != nil || n != 5 {
+		t.Fatalf("WriteString wrote %d bytes with error %v, want 5 bytes", n, err)
+	}
+	s, err := proc.ReadString(4, 5)
+	if err != nil || s != "hello" {
+		t.Fatalf("ReadString got %q with error %v, want %q", s, err, "hello")
+	}
+	if s, err = proc.ReadString(16, 0); err != nil || s != "" {
+		t.Errorf("ReadString at the end of memory got %q with error %v, want an empty string", s, err)
+	}
+
+	// Running off the end of memory, or starting past it
+	for _, ptr := range []int32{12, 17, -1} {
+		if n, err := proc.WriteString(ptr, "hello"); err != ErrOutOfBoundsMemoryAccess || n != 0 {
+			t.Errorf("WriteString at %d wrote %d bytes with error %v, want %v", ptr, n, err, ErrOutOfBoundsMemoryAccess)
+		}
+		if s, err := proc.ReadString(ptr, 5); err != ErrOutOfBoundsMemoryAccess || s != "" {
+			t.Errorf("ReadString at %d got %q with error %v, want %v", ptr, s, err, ErrOutOfBoundsMemoryAccess)
+		}
+	}
+	if s, err := proc.ReadString(0, -1); err != ErrOutOfBoundsMemoryAccess {
+		t.Errorf("ReadString with a length of -1 got %q with error %v, want %v", s, err, ErrOutOfBoundsMemoryAccess)
+	}
+	if !bytes.Equal(vm.memory[12:], make([]byte, 4)) {
+		t.Errorf("WriteString running off the end of memory changed it: %v", vm.memory[12:])
+	}
+}
+
+func TestReadWriteOutOfRange(t *testing.T) {
+	buf := make([]byte, 2)
+	for _, off := range []int64{-1, 4, 1 << 40} {
+		if n, err := smallMemoryProcess.ReadAt(buf, off); err == nil || n != 0 {
+			t.Errorf("reading at offset %d: got %d bytes and error %v", off, n, err)
+		}
+		if n, err := smallMemoryProcess.WriteAt(buf, off); err == nil || n != 0 {
+			t.Errorf("writing at offset %d: got %d bytes and error %v", off, n, err)
+		}
+	}
+}
+
+func TestExecCodeMulti(t *testing.T) {
+	m := buildTestModule(
+		testFunc{
+			name:    "pair",
+			results: []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32},
+			code:    []byte{ops.I32Const, 0x01, ops.I32Const, 0x02},
+		},
+		testFunc{
+			name:    "mixed",
+			results: []wasm.ValueType{wasm.ValueTypeI64, wasm.ValueTypeF32, wasm.ValueTypeI32},
+			code: []byte{
+				ops.I64Const, 0x07,
+				ops.F32Const, 0x00, 0x00, 0xc0, 0x3f, // 1.5
+				ops.I32Const, 0x03,
+			},
+		},
+		testFunc{
+			name: "none",
+			code: []byte{ops.Nop},
+		},
+	)
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	for i, want := range [][]interface{}{
+		{uint32(1), uint32(2)},
+		{uint64(7), float32(1.5), uint32(3)},
+		{},
+	} {
+		got, err := vm.ExecCodeMulti(int64(i))
+		if err != nil {
+			t.Fatalf("function %d: unexpected error: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("function %d: got results %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestExecCodeMultiStopped(t *testing.T) {
+	errStop := errors.New("stopped")
+	m := buildTestModule(
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32},
+			code:    []byte{ops.Call, 0x01, ops.I32Const, 0x01, ops.I32Const, 0x02},
+		},
+		testFunc{host: func(proc *Process) { proc.Terminate() }},
+		testFunc{
+			results: []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32},
+			code:    []byte{ops.Call, 0x03, ops.I32Const, 0x01, ops.I32Const, 0x02},
+		},
+		testFunc{host: func(proc *Process) { proc.Fail(errStop) }},
+	)
+	for _, recoverPanic := range []bool{false, true} {
+		for _, tc := range []struct {
+			name  string
+			index int64
+			err   error
+		}{
+			{"Terminate", 0, nil},
+			{"Fail", 2, errStop},
+		} {
+			// A VM stays stopped once terminated, so each run gets its own
+			vm, err := NewVM(m, WithRecoverPanic(recoverPanic))
+			if err != nil {
+				t.Fatalf("could not create VM: %v", err)
+			}
+			if got, err := vm.ExecCodeMulti(tc.index); got != nil || err != tc.err {
+				t.Errorf("recovering panics %v: got results %v with error %v after %s, want none with error %v", recoverPanic, got, err, tc.name, tc.err)
+			}
+		}
+	}
+}
+
+func TestOpHook(t *testing.T) {
+	m := buildTestModule(
+		testFunc{
+			name:   "countdown",
+			params: []wasm.ValueType{wasm.ValueTypeI32},
+			code:   countdownLoop,
+		},
+		testFunc{
+			name:    "branches",
+			params:  []wasm.ValueType{wasm.ValueTypeI32},
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code: []byte{
+				ops.Block, 0x40,
+				ops.I32Const, 0x09,
+				ops.GetLocal, 0x00,
+				ops.BrTable, 0x01, 0x00, 0x00,
+				ops.End,
+				ops.I32Const, 0x05,
+				ops.If, 0x7f,
+				ops.I32Const, 0x01,
+				ops.Else,
+				ops.I32Const, 0x02,
+				ops.End,
+			},
+		},
+	)
+
+	var got []byte
+	vm, err := NewVM(m, WithOpHook(func(op byte, pc int64, stack []uint64) {
+		got = append(got, op)
+	}))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	// The compiled code ends in a nop, in place of the final end
+	iteration := []byte{ops.GetLocal, ops.I32Const, ops.I32Sub, ops.TeeLocal, compile.OpJmpNz}
+	for _, tc := range []struct {
+		name string
+		fn   int64
+		arg  uint64
+		want []byte
+	}{
+		{"countdown", 0, 3, append(bytes.Repeat(iteration, 3), ops.Nop)},
+		{"branches", 1, 1, []byte{
+			ops.I32Const, ops.GetLocal, ops.BrTable,
+			ops.I32Const, compile.OpJmpZ,
+			ops.I32Const, compile.OpDiscardPreserveTop, compile.OpJmp,
+			ops.Nop,
+		}},
+	} {
+		got = nil
+		if _, err = vm.ExecCode(tc.fn, tc.arg); err != nil {
+			t.Fatalf("%s: error executing function: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: hook called for opcodes %#v, want %#v", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestCompiledModuleInstantiate runs many instances of one compiled module in
+// parallel. Run it with -race, to check the instances don't share any state.
+func TestCompiledModuleInstantiate(t *testing.T) {
+	// (func (result i32)) incrementing a global counter, storing it to
+	// memory and returning it
+	m := buildTestModule(testFunc{
+		name:    "inc",
+		results: []wasm.ValueType{wasm.ValueTypeI32},
+		code: []byte{
+			ops.GetGlobal, 0x00,
+			ops.I32Const, 0x01,
+			ops.I32Add,
+			ops.SetGlobal, 0x00,
+			ops.I32Const, 0x00,
+			ops.GetGlobal, 0x00,
+			ops.I32Store, 0x02, 0x00,
+			ops.GetGlobal, 0x00,
+		},
+	})
+	m.GlobalIndexSpace = []wasm.GlobalEntry{{
+		Type: wasm.GlobalVar{Type: wasm.ValueTypeI32, Mutable: true},
+		Init: []byte{ops.I32Const, 0x00, ops.End},
+	}}
+	m.Memory = &wasm.SectionMemories{Entries: []wasm.Memory{{Limits: wasm.ResizableLimits{Initial: 1}}}}
+	m.LinearMemoryIndexSpace = [][]byte{nil}
+
+	cm, err := Compile(m)
+	if err != nil {
+		t.Fatalf("could not compile module: %v", err)
+	}
+
+	const (
+		instances = 16
+		calls     = 100
+	)
+	var wg sync.WaitGroup
+	errs := make(chan error, instances)
+	for i := 0; i < instances; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Each instance logs to its own logger, numbering its own
+			// operations
+			logger := &MemoryOpLogger{}
+			vm, err := cm.Instantiate(WithOpLogger(logger))
+			if err != nil {
+				errs <- err
+				return
+			}
+			for j := 1; j <= calls; j++ {
+				res, err := vm.ExecCode(0)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if res != uint32(j) {
+					errs <- fmt.Errorf("call %d returned %v, want %d", j, res, j)
+					return
+				}
+			}
+			if got := endianess.Uint32(vm.Memory()); got != calls {
+				errs <- fmt.Errorf("memory holds %d, want %d", got, calls)
+			}
+			recs, _ := logger.Ops()
+			for i, rec := range recs {
+				if rec.OpNum != i {
+					errs <- fmt.Errorf("logged operation %d is numbered %d", i, rec.OpNum)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	// add adds its argument to a global, storing it in memory and
+	// returning it
+	m := buildTestModule(testFunc{
+		name:    "add",
+		params:  []wasm.ValueType{wasm.ValueTypeI32},
+		results: []wasm.ValueType{wasm.ValueTypeI32},
+		code: []byte{
+			ops.GetGlobal, 0x00,
+			ops.GetLocal, 0x00,
+			ops.I32Add,
+			ops.SetGlobal, 0x00,
+			ops.I32Const, 0x00,
+			ops.GetGlobal, 0x00,
+			ops.I32Store, 0x02, 0x00,
+			ops.GetGlobal, 0x00,
+		},
+	})
+	m.GlobalIndexSpace = []wasm.GlobalEntry{{
+		Type: wasm.GlobalVar{Type: wasm.ValueTypeI32, Mutable: true},
+		Init: []byte{ops.I32Const, 0x00, ops.End},
+	}}
+	m.Memory = &wasm.SectionMemories{Entries: []wasm.Memory{{Limits: wasm.ResizableLimits{Initial: 1}}}}
+	m.LinearMemoryIndexSpace = [][]byte{nil}
+
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	const start = 1000
+	if _, err = vm.ExecCode(0, start); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	const (
+		clones = 16
+		calls  = 100
+	)
+	var wg sync.WaitGroup
+	errs := make(chan error, clones)
+	for i := 1; i <= clones; i++ {
+		wg.Add(1)
+		clone := vm.Clone()
+		go func(step uint64) {
+			defer wg.Done()
+			// Each clone starts from the global and memory of the VM,
+			// and adds a different step
+			for j := uint64(1); j <= calls; j++ {
+				res, err := clone.ExecCode(0, step)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if want := uint32(start + j*step); res != want {
+					errs <- fmt.Errorf("clone %d: call %d returned %v, want %d", step, j, res, want)
+					return
+				}
+			}
+			if got, want := endianess.Uint32(clone.Memory()), uint32(start+calls*step); got != want {
+				errs <- fmt.Errorf("clone %d: memory holds %d, want %d", step, got, want)
+			}
+		}(uint64(i))
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if got := endianess.Uint32(vm.Memory()); got != start {
+		t.Errorf("memory of the cloned VM holds %d, want %d", got, start)
+	}
+	if res, _ := vm.ExecCode(0, 0); res != uint32(start) {
+		t.Errorf("global of the cloned VM is %v, want %d", res, start)
+	}
+}
+
+func TestExecCodeByName(t *testing.T) {
+	m := buildTestModule(
+		testFunc{
+			name:    "add",
+			params:  []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32},
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code:    []byte{ops.GetLocal, 0x00, ops.GetLocal, 0x01, ops.I32Add},
+		},
+	)
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	res, err := vm.ExecCodeByName("add", 2, 3)
+	if err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if res != uint32(5) {
+		t.Errorf("got result %v, want 5", res)
+	}
+	if _, err = vm.ExecCodeByName("sub", 2, 3); err == nil {
+		t.Error("expected an error calling a function which isn't exported")
+	}
+}
+
+func TestExports(t *testing.T) {
+	i32, f64 := []wasm.ValueType{wasm.ValueTypeI32}, []wasm.ValueType{wasm.ValueTypeF64}
+	m := buildTestModule(
+		testFunc{name: "run"},
+		testFunc{params: i32},
+		testFunc{name: "half", params: f64, results: f64, code: []byte{ops.GetLocal, 0x00, ops.F64Const, 0, 0, 0, 0, 0, 0, 0, 0x40, ops.F64Div}},
+		testFunc{name: "answer", results: i32, code: []byte{ops.I32Const, 42}},
+	)
+	// A memory export, which isn't listed
+	m.Export.Entries["memory"] = wasm.ExportEntry{FieldStr: "memory", Kind: wasm.ExternalMemory}
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	want := []ExportInfo{
+		{Name: "answer", Index: 3, ReturnTypes: i32},
+		{Name: "half", Index: 2, ParamTypes: f64, ReturnTypes: f64},
+		{Name: "run", Index: 0},
+	}
+	if got := vm.Exports(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got exports %+v, want %+v", got, want)
+	}
+}
+
+func TestRunInitSequence(t *testing.T) {
+	m := buildTestModule(
+		testFunc{
+			name: "init",
+			code: []byte{ops.I32Const, 0x05, ops.SetGlobal, 0x00},
+		},
+		testFunc{
+			name: "setup",
+			code: []byte{
+				ops.GetGlobal, 0x00,
+				ops.I32Const, 0x02,
+				ops.I32Mul,
+				ops.SetGlobal, 0x00,
+			},
+		},
+		testFunc{
+			name: "fail",
+			code: []byte{ops.Unreachable},
+		},
+		testFunc{
+			name:    "get",
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			code:    []byte{ops.GetGlobal, 0x00},
+		},
+	)
+	m.GlobalIndexSpace = []wasm.GlobalEntry{{
+		Type: wasm.GlobalVar{Type: wasm.ValueTypeI32, Mutable: true},
+		Init: []byte{ops.I32Const, 0x00, ops.End},
+	}}
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+
+	// setup doubles the value set by init, so only gives 10 after it
+	if err = vm.RunInitSequence([]string{"init", "setup"}); err != nil {
+		t.Fatalf("unexpected error running the init sequence: %v", err)
+	}
+	if res, _ := vm.ExecCode(3); res != uint32(10) {
+		t.Errorf("global is %v after the init sequence, want 10", res)
+	}
+
+	// The sequence stops at the trap
+	if err = vm.RunInitSequence([]string{"init", "fail", "setup"}); trapCause(err) != ErrUnreachable {
+		t.Errorf("got error %v, want %v", err, ErrUnreachable)
+	}
+	if res, _ := vm.ExecCode(3); res != uint32(5) {
+		t.Errorf("global is %v after the failed init sequence, want 5", res)
+	}
+
+	for _, names := range [][]string{{"missing"}, {"get"}} {
+		if err = vm.RunInitSequence(names); err == nil {
+			t.Errorf("init sequence %q didn't fail", names)
+		}
+	}
+}
+
+func TestExecCodeInvalidIndex(t *testing.T) {
+	vm, err := NewVM(buildTestModule(testFunc{name: "nop", code: []byte{ops.Nop}}))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	for _, index := range []int64{1, 2, -1} {
+		if _, err = vm.ExecCode(index); err != InvalidFunctionIndexError(index) {
+			t.Errorf("ExecCode(%d): got error %v, want %v", index, err, InvalidFunctionIndexError(index))
+		}
+		if _, err = vm.ExecCodeMulti(index); err != InvalidFunctionIndexError(index) {
+			t.Errorf("ExecCodeMulti(%d): got error %v, want %v", index, err, InvalidFunctionIndexError(index))
+		}
+	}
+}
+
+func TestCallStack(t *testing.T) {
+	var (
+		vm     *VM
+		paused []Frame
+		inHost []Frame
+	)
+	m := buildTestModule(
+		testFunc{code: []byte{ops.Call, 0x01}},
+		testFunc{code: []byte{ops.Nop, ops.Call, 0x02}},
+		testFunc{code: []byte{ops.I32Const, 0x07, ops.Drop, ops.Call, 0x03}},
+		testFunc{host: func(proc *Process) {
+			inHost = proc.vm.CallStack()
+		}},
+	)
+	vm, err := NewVM(m, WithOpHook(func(op byte, pc int64, stack []uint64) {
+		// Pause at the first instruction of the innermost function
+		if op == ops.I32Const {
+			paused = vm.CallStack()
+		}
+	}))
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+
+	// The calling frames are at the end of the call instructions, each
+	// taking the opcode and a 4 byte function index
+	want := []Frame{{FuncIndex: 0, PC: 5}, {FuncIndex: 1, PC: 6}, {FuncIndex: 2, PC: 0}}
+	if !reflect.DeepEqual(paused, want) {
+		t.Errorf("got call stack %v paused in the innermost function, want %v", paused, want)
+	}
+	want[2].PC = 11 // After the i32.const (with a 4 byte immediate), the drop and the call
+	if !reflect.DeepEqual(inHost, want) {
+		t.Errorf("got call stack %v in the host function, want %v", inHost, want)
+	}
+}
+
+func TestTrapCallStack(t *testing.T) {
+	i32 := []wasm.ValueType{wasm.ValueTypeI32}
+	m := buildTestModule(
+		testFunc{results: i32, code: []byte{ops.I32Const, 0x07, ops.I32Const, 0x08, ops.Call, 0x01, ops.I32Add}},
+		testFunc{params: i32, results: i32, code: []byte{ops.I32Const, 0x09, ops.I32Const, 0x0a, ops.Call, 0x02, ops.I32Add}},
+		testFunc{params: i32, results: i32, code: []byte{ops.Nop, ops.Nop, ops.Unreachable}},
+	)
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	_, err = vm.ExecCode(0)
+	te, ok := err.(*TrapError)
+	if !ok || te.Err != ErrUnreachable {
+		t.Fatalf("got error %v, want a trap with %v", err, ErrUnreachable)
+	}
+
+	// The innermost frame is past the unreachable operator. Each call's
+	// operands start above those left by its callers, once its argument is
+	// popped off.
+	want := []Frame{{FuncIndex: 0, PC: 15, SP: 0}, {FuncIndex: 1, PC: 15, SP: 1}, {FuncIndex: 2, PC: 3, SP: 2}}
+	if !reflect.DeepEqual(te.Frames, want) {
+		t.Errorf("got call stack %v at the trap, want %v", te.Frames, want)
+	}
+}
+
+func TestFuncMeta(t *testing.T) {
+	m := buildTestModule(
+		testFunc{
+			params:  []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32},
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			locals:  []wasm.LocalEntry{{Count: 3, Type: wasm.ValueTypeI64}},
+			code: []byte{
+				ops.GetLocal, 0x00,
+				ops.GetLocal, 0x01,
+				ops.GetLocal, 0x00,
+				ops.I32Add,
+				ops.I32Add,
+			},
+		},
+		testFunc{host: func(proc *Process) {}},
+	)
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+
+	meta, err := vm.FuncMeta(0)
+	if err != nil {
+		t.Fatalf("FuncMeta(0): unexpected error: %v", err)
+	}
+	want := FuncMeta{MaxDepth: 3, Args: 2, Returns: true, TotalLocalVars: 5}
+	if meta != want {
+		t.Errorf("FuncMeta(0) = %+v, want %+v", meta, want)
+	}
+
+	if _, err = vm.FuncMeta(1); err == nil {
+		t.Error("FuncMeta(1): expected an error for a host function")
+	}
+	if _, err = vm.FuncMeta(2); err != InvalidFunctionIndexError(2) {
+		t.Errorf("FuncMeta(2): got error %v, want %v", err, InvalidFunctionIndexError(2))
+	}
+}
+
+func TestRestartResetsMemory(t *testing.T) {
+	m := memoryTestModule(testFunc{
+		code: []byte{
+			ops.I32Const, 0x00,
+			ops.I32Const, 0x7f,
+			ops.I32Store8, 0x00, 0x00,
+			ops.I32Const, 0xe4, 0x00, // 100
+			ops.I32Const, 0x7f,
+			ops.I32Store8, 0x00, 0x00,
+			ops.I32Const, 0x01,
+			ops.GrowMemory, 0x00,
+			ops.Drop,
+		},
+	})
+	m.LinearMemoryIndexSpace = [][]byte{{1, 2, 3}}
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if _, err = vm.ExecCode(0); err != nil {
+		t.Fatalf("error executing function: %v", err)
+	}
+	if mem := vm.Memory(); len(mem) != 2*wasmPageSize || mem[0] != 0xff || mem[100] != 0xff {
+		t.Fatalf("function didn't write to and grow the memory")
+	}
+
+	if err = vm.Restart(); err != nil {
+		t.Fatalf("Restart: unexpected error: %v", err)
+	}
+	mem := vm.Memory()
+	if len(mem) != wasmPageSize {
+		t.Errorf("got %d bytes of memory after restarting, want %d", len(mem), wasmPageSize)
+	}
+	if !bytes.Equal(mem[:3], []byte{1, 2, 3}) || mem[100] != 0 {
+		t.Errorf("got memory starting with %v and %d at 100 after restarting, want the initial image", mem[:3], mem[100])
+	}
+}
+
+func TestRestartRerunStart(t *testing.T) {
+	var starts int
+	m := buildTestModule(
+		testFunc{host: func(proc *Process) {
+			starts++
+		}},
+		testFunc{code: []byte{ops.Call, 0x00}},
+	)
+	m.Start = &wasm.SectionStartFunction{Index: 1}
+	vm, err := NewVM(m)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	if starts != 1 {
+		t.Fatalf("start function ran %d times creating the VM, want 1", starts)
+	}
+
+	vm.Restart()
+	if err = vm.RestartWithOptions(RestartOptions{}); err != nil {
+		t.Fatalf("unexpected error restarting: %v", err)
+	}
+	if starts != 1 {
+		t.Errorf("start function ran %d times after restarting by default, want 1", starts)
+	}
+
+	if err = vm.RestartWithOptions(RestartOptions{RerunStart: true}); err != nil {
+		t.Fatalf("unexpected error restarting: %v", err)
+	}
+	if starts != 2 {
+		t.Errorf("start function ran %d times after restarting with RerunStart, want 2", starts)
+	}
+}
+
+func TestStartTrapRecovered(t *testing.T) {
+	m := buildTestModule(testFunc{code: []byte{ops.Unreachable}})
+	m.Start = &wasm.SectionStartFunction{Index: 0}
+
+	vm, err := NewVM(m, WithRecoverPanic(true))
+	if trapCause(err) != ErrUnreachable || vm != nil {
+		t.Errorf("got VM %v and error %v, want no VM and %v", vm, err, ErrUnreachable)
+	}
+
+	// Without the option, the trap still panics
+	defer func() {
+		if r := recover(); r != ErrUnreachable {
+			t.Errorf("got panic %v, want %v", r, ErrUnreachable)
+		}
+	}()
+	NewVM(m)
+	t.Error("NewVM returned from a trapping start function")
+}
+
+// endlessModule reads as a module header followed by a custom section
+// declared to be 4 GB long, the data of which never ends, counting the
+// bytes read.
+type endlessModule struct {
+	read int64
+}
+
+func (r *endlessModule) Read(p []byte) (int, error) {
+	header := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff, 0xff, 0x0f}
+	for i := range p {
+		if r.read < int64(len(header)) {
+			p[i] = header[r.read]
+		} else {
+			p[i] = 0
+		}
+		r.read++
+	}
+	return len(p), nil
+}
+
+func TestMaxModuleBytes(t *testing.T) {
+	const max = 1 << 16
+	r := &endlessModule{}
+	_, err := NewVMFromReader(r, nil, WithMaxModuleBytes(max))
+	if err != ModuleSizeError(max) {
+		t.Errorf("got error %v, want %v", err, ModuleSizeError(max))
+	}
+	if r.read > max+1 {
+		t.Errorf("read %d bytes of the module, past the maximum of %d", r.read, max)
+	}
+
+	// A module just fitting is read fine
+	empty := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	if _, err = NewVMFromReader(bytes.NewReader(empty), nil, WithMaxModuleBytes(int64(len(empty)))); err != nil {
+		t.Errorf("unexpected error reading a module of the maximum size: %v", err)
+	}
+	if _, err = NewVMFromReader(bytes.NewReader(empty), nil, WithMaxModuleBytes(int64(len(empty)-1))); err != ModuleSizeError(len(empty)-1) {
+		t.Errorf("got error %v, want %v", err, ModuleSizeError(len(empty)-1))
+	}
+}
+
+func BenchmarkBrTable(b *testing.B) {
+	m := buildTestModule(testFunc{
+		params: []wasm.ValueType{wasm.ValueTypeI32},
+		code: []byte{
+			ops.Loop, 0x40,
+			ops.Block, 0x40,
+			ops.GetLocal, 0x00,
+			ops.I32Const, 0x03,
+			ops.I32And,
+			ops.BrTable, 0x03, 0x00, 0x00, 0x00, 0x00,
+			ops.End,
+			ops.GetLocal, 0x00,
+			ops.I32Const, 0x01,
+			ops.I32Sub,
+			ops.TeeLocal, 0x00,
+			ops.BrIf, 0x00,
+			ops.End,
+		},
+	})
+	vm, err := NewVM(m)
+	if err != nil {
+		b.Fatalf("could not create VM: %v", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = vm.ExecCode(0, 1000); err != nil {
+			b.Fatalf("error executing function: %v", err)
+		}
+	}
+}
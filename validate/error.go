@@ -61,6 +61,12 @@ func (e InvalidTypeError) Error() string {
 	return fmt.Sprintf("invalid type, got: %v, wanted: %v", e.Got, e.Wanted)
 }
 
+type InvalidTypeIndexError uint32
+
+func (e InvalidTypeIndexError) Error() string {
+	return fmt.Sprintf("invalid index to type section %d", uint32(e))
+}
+
 type InvalidElementIndexError uint32
 
 func (e InvalidElementIndexError) Error() string {
@@ -13,6 +13,11 @@ import (
 	ops "github.com/go-interpreter/wagon/wasm/operators"
 )
 
+// memoryIndexFlag is set in the flags of the memory immediates of the loads
+// and stores giving the index of the memory they access, which follows the
+// flags, as with the multi-memory proposal.
+const memoryIndexFlag = 0x40
+
 // vibhavp: TODO: We do not verify whether blocks don't access for the parent block, do that.
 func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Module) (*mockVM, error) {
 	vm := &mockVM{
@@ -52,7 +57,17 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 			return vm, err
 		}
 
-		opStruct, err := ops.New(op)
+		var opStruct ops.Op
+		var miscCode uint32
+		if op == ops.MiscPrefix {
+			miscCode, err = vm.fetchVarUint()
+			if err != nil {
+				return vm, err
+			}
+			opStruct, err = ops.NewMisc(miscCode)
+		} else {
+			opStruct, err = ops.New(op)
+		}
 		if err != nil {
 			return vm, err
 		}
@@ -239,10 +254,20 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 		case ops.I32Load, ops.I64Load, ops.F32Load, ops.F64Load, ops.I32Load8s, ops.I32Load8u, ops.I32Load16s, ops.I32Load16u, ops.I64Load8s, ops.I64Load8u, ops.I64Load16s, ops.I64Load16u, ops.I64Load32s, ops.I64Load32u, ops.I32Store, ops.I64Store, ops.F32Store, ops.F64Store, ops.I32Store8, ops.I32Store16, ops.I64Store8, ops.I64Store16, ops.I64Store32:
 			// read memory_immediate
 			// flags
-			_, err := vm.fetchVarUint()
+			flags, err := vm.fetchVarUint()
 			if err != nil {
 				return vm, err
 			}
+			// the index of the memory accessed, with the multi-memory proposal
+			if flags&memoryIndexFlag != 0 {
+				index, err := vm.fetchVarUint()
+				if err != nil {
+					return vm, err
+				}
+				if module.Memory == nil || int(index) >= len(module.Memory.Entries) {
+					return vm, wasm.InvalidLinearMemoryIndexError(index)
+				}
+			}
 			// offset
 			_, err = vm.fetchVarUint()
 			if err != nil {
@@ -253,6 +278,21 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 			if err != nil {
 				return vm, err
 			}
+		case ops.MiscPrefix:
+			// memory.copy and memory.fill have reserved bytes for
+			// the memory indices
+			reserved := 0
+			switch byte(miscCode) {
+			case ops.MemoryCopy:
+				reserved = 2
+			case ops.MemoryFill:
+				reserved = 1
+			}
+			for i := 0; i < reserved; i++ {
+				if _, err := vm.fetchVarUint(); err != nil {
+					return vm, err
+				}
+			}
 
 		case ops.Call:
 			index, err := vm.fetchVarUint()
@@ -299,6 +339,9 @@ func verifyBody(fn *wasm.FunctionSig, body *wasm.FunctionBody, module *wasm.Modu
 				return vm, err
 			}
 
+			if int(index) >= len(module.Types.Entries) {
+				return vm, InvalidTypeIndexError(index)
+			}
 			fnExpectSig := module.Types.Entries[index]
 
 			if operand, under := vm.popOperand(); !vm.isPolymorphic() && (under || operand.Type != wasm.ValueTypeI32) {
@@ -364,6 +407,10 @@ func VerifyModule(module *wasm.Module) error {
 
 	logger.Printf("There are %d functions", len(module.Function.Types))
 	for i, fn := range module.FunctionIndexSpace {
+		if fn.IsHost() {
+			// Host functions have no bytecode to verify
+			continue
+		}
 		if vm, err := verifyBody(fn.Sig, fn.Body, module); err != nil {
 			return Error{vm.pc(), i, err}
 		}
@@ -0,0 +1,235 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package wasi provides a minimal implementation of the WASI preview1 host
+// functions, for running modules built against WASI with exec.
+//
+// Only the functions most programs need to print out their results are
+// implemented: fd_write to the standard output and error, proc_exit, and
+// the args_* and environ_* functions. Modules importing anything else from
+// WASI fail to resolve.
+//
+// The host functions are supplied by resolving the modules' imports with
+// an Env, before creating the VM:
+//
+//	env := wasi.NewEnv([]string{"prog"}, os.Environ())
+//	vm, err := exec.NewVMFromReader(r, env.Resolve)
+//	...
+//	_, err = vm.ExecCodeByName("_start")
+//	if code, exited := env.ExitCode(); exited {
+//		...
+//	}
+package wasi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// ModuleName is the name of the module WASI preview1 functions are
+// imported from.
+const ModuleName = "wasi_snapshot_preview1"
+
+// Errno values returned by the host functions, from the WASI preview1
+// specification.
+const (
+	ErrnoSuccess = 0
+	ErrnoBadf    = 8
+	ErrnoFault   = 21
+	ErrnoInval   = 28
+)
+
+// The file descriptors of the standard output and error, the only ones
+// which can be written to.
+const (
+	fdStdout = 1
+	fdStderr = 2
+)
+
+// Env holds the arguments and environment variables passed to the module,
+// and the exit code it gives proc_exit. The exit code is kept in the Env,
+// so an Env should back a single VM at a time.
+type Env struct {
+	args    []string
+	environ []string
+
+	mu       sync.Mutex
+	exitCode int32
+	exited   bool
+}
+
+// NewEnv returns an Env passing the given arguments, the first of which is
+// conventionally the program name, and the given environment variables, in
+// the "key=value" form returned by os.Environ.
+func NewEnv(args, environ []string) *Env {
+	return &Env{args: args, environ: environ}
+}
+
+// Resolve resolves the imports of ModuleName to the Env's host functions,
+// so it can be passed to wasm.ReadModule or exec.NewVMFromReader. The
+// imports of other modules fail to resolve.
+func (e *Env) Resolve(name string) (*wasm.Module, error) {
+	if name != ModuleName {
+		return nil, fmt.Errorf("wasi: module %q unknown", name)
+	}
+	return e.Module(), nil
+}
+
+// Module returns a module exporting the Env's host functions, for resolvers
+// which handle more modules than ModuleName.
+func (e *Env) Module() *wasm.Module {
+	funcs := []struct {
+		name    string
+		fn      interface{}
+		params  int
+		results int
+	}{
+		{"fd_write", e.fdWrite, 4, 1},
+		{"proc_exit", e.procExit, 1, 0},
+		{"args_sizes_get", e.argsSizesGet, 2, 1},
+		{"args_get", e.argsGet, 2, 1},
+		{"environ_sizes_get", e.environSizesGet, 2, 1},
+		{"environ_get", e.environGet, 2, 1},
+	}
+
+	m := wasm.NewModule()
+	m.Types = &wasm.SectionTypes{Entries: make([]wasm.FunctionSig, len(funcs))}
+	m.FunctionIndexSpace = make([]wasm.Function, len(funcs))
+	m.Export = &wasm.SectionExports{Entries: make(map[string]wasm.ExportEntry, len(funcs))}
+	for i, f := range funcs {
+		// All the parameters and results are i32s
+		sig := &m.Types.Entries[i]
+		sig.Form = 0x60
+		sig.ParamTypes = make([]wasm.ValueType, f.params)
+		sig.ReturnTypes = make([]wasm.ValueType, f.results)
+		for j := range sig.ParamTypes {
+			sig.ParamTypes[j] = wasm.ValueTypeI32
+		}
+		for j := range sig.ReturnTypes {
+			sig.ReturnTypes[j] = wasm.ValueTypeI32
+		}
+
+		m.FunctionIndexSpace[i] = wasm.Function{
+			Sig:  sig,
+			Name: f.name,
+			Host: reflect.ValueOf(f.fn),
+			Body: &wasm.FunctionBody{},
+		}
+		m.Export.Entries[f.name] = wasm.ExportEntry{
+			FieldStr: f.name,
+			Kind:     wasm.ExternalFunction,
+			Index:    uint32(i),
+		}
+	}
+	return m
+}
+
+// ExitCode returns the exit code the module gave proc_exit, and whether it
+// called it at all.
+func (e *Env) ExitCode() (code int32, exited bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.exitCode, e.exited
+}
+
+// fdWrite writes the iovsLen buffers described by the iovec array at iovs
+// to the file descriptor fd, storing the number of bytes written at
+// nwritten.
+func (e *Env) fdWrite(proc *exec.Process, fd, iovs, iovsLen, nwritten int32) int32 {
+	w := proc.Stdout()
+	switch fd {
+	case fdStdout:
+	case fdStderr:
+		w = proc.Stderr()
+	default:
+		return ErrnoBadf
+	}
+	if iovsLen < 0 {
+		return ErrnoInval
+	}
+
+	var total uint32
+	iov := make([]byte, 8)
+	for i := int32(0); i < iovsLen; i++ {
+		if _, err := proc.ReadAt(iov, int64(uint32(iovs))+int64(i)*8); err != nil {
+			return ErrnoFault
+		}
+		ptr, n := binary.LittleEndian.Uint32(iov), binary.LittleEndian.Uint32(iov[4:])
+		buf, err := proc.ReadString(int32(ptr), int32(n))
+		if err != nil {
+			return ErrnoFault
+		}
+		written, _ := w.Write([]byte(buf))
+		total += uint32(written)
+	}
+	return putUint32(proc, nwritten, total)
+}
+
+// procExit terminates the module with the given exit code.
+func (e *Env) procExit(proc *exec.Process, code int32) {
+	e.mu.Lock()
+	e.exitCode, e.exited = code, true
+	e.mu.Unlock()
+	proc.Terminate()
+}
+
+func (e *Env) argsSizesGet(proc *exec.Process, argc, argvBufSize int32) int32 {
+	return sizesGet(proc, e.args, argc, argvBufSize)
+}
+
+func (e *Env) argsGet(proc *exec.Process, argv, argvBuf int32) int32 {
+	return stringsGet(proc, e.args, argv, argvBuf)
+}
+
+func (e *Env) environSizesGet(proc *exec.Process, count, bufSize int32) int32 {
+	return sizesGet(proc, e.environ, count, bufSize)
+}
+
+func (e *Env) environGet(proc *exec.Process, environ, environBuf int32) int32 {
+	return stringsGet(proc, e.environ, environ, environBuf)
+}
+
+// sizesGet stores the number of strings at countPtr, and the size of the
+// buffer holding them, each NUL terminated, at bufSizePtr.
+func sizesGet(proc *exec.Process, strs []string, countPtr, bufSizePtr int32) int32 {
+	var size uint32
+	for _, s := range strs {
+		size += uint32(len(s)) + 1
+	}
+	if errno := putUint32(proc, countPtr, uint32(len(strs))); errno != ErrnoSuccess {
+		return errno
+	}
+	return putUint32(proc, bufSizePtr, size)
+}
+
+// stringsGet stores the strings one after the other at buf, each NUL
+// terminated, and an array of pointers to them at ptrs.
+func stringsGet(proc *exec.Process, strs []string, ptrs, buf int32) int32 {
+	for i, s := range strs {
+		if errno := putUint32(proc, ptrs+int32(i)*4, uint32(buf)); errno != ErrnoSuccess {
+			return errno
+		}
+		if _, err := proc.WriteString(buf, s+"\x00"); err != nil {
+			return ErrnoFault
+		}
+		buf += int32(len(s)) + 1
+	}
+	return ErrnoSuccess
+}
+
+// putUint32 stores v in memory at ptr, returning the errno to return for
+// it.
+func putUint32(proc *exec.Process, ptr int32, v uint32) int32 {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	if _, err := proc.WriteString(ptr, string(b)); err != nil {
+		return ErrnoFault
+	}
+	return ErrnoSuccess
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package wasi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/go-interpreter/wagon/exec"
+	"github.com/go-interpreter/wagon/wasm"
+	ops "github.com/go-interpreter/wagon/wasm/operators"
+)
+
+// wasmSection encodes a module section with the given id and contents.
+func wasmSection(id wasm.SectionID, contents ...byte) []byte {
+	return append([]byte{byte(id), byte(len(contents))}, contents...)
+}
+
+// wasmName encodes a name as a length prefixed string.
+func wasmName(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+// helloModule returns a module writing "hello world\n" to the standard
+// output with fd_write, before exiting with code 3 with proc_exit.
+func helloModule() []byte {
+	const msg = "hello world\n"
+
+	var imports []byte
+	imports = append(imports, 2)
+	imports = append(imports, wasmName(ModuleName)...)
+	imports = append(imports, wasmName("fd_write")...)
+	imports = append(imports, 0x00, 0x00) // function of type 0
+	imports = append(imports, wasmName(ModuleName)...)
+	imports = append(imports, wasmName("proc_exit")...)
+	imports = append(imports, 0x00, 0x01) // function of type 1
+
+	var exports []byte
+	exports = append(exports, 1)
+	exports = append(exports, wasmName("_start")...)
+	exports = append(exports, 0x00, 0x02) // function 2
+
+	body := []byte{
+		0x00,               // no locals
+		ops.I32Const, 0x01, // standard output
+		ops.I32Const, 0x00, // iovec array
+		ops.I32Const, 0x01, // one iovec
+		ops.I32Const, 0x20, // where to store the bytes written
+		ops.Call, 0x00,
+		ops.Drop,
+		ops.I32Const, 0x03,
+		ops.Call, 0x01,
+		ops.End,
+	}
+
+	// The iovec at 0 points at the message at 8
+	data := []byte{1, 0x00, ops.I32Const, 0x00, ops.End, byte(8 + len(msg))}
+	data = append(data, 8, 0, 0, 0, byte(len(msg)), 0, 0, 0)
+	data = append(data, msg...)
+
+	var m []byte
+	m = append(m, 0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00)
+	m = append(m, wasmSection(wasm.SectionIDType,
+		3,
+		0x60, 4, 0x7f, 0x7f, 0x7f, 0x7f, 1, 0x7f, // fd_write
+		0x60, 1, 0x7f, 0, // proc_exit
+		0x60, 0, 0, // _start
+	)...)
+	m = append(m, wasmSection(wasm.SectionIDImport, imports...)...)
+	m = append(m, wasmSection(wasm.SectionIDFunction, 1, 2)...)
+	m = append(m, wasmSection(wasm.SectionIDMemory, 1, 0x00, 1)...)
+	m = append(m, wasmSection(wasm.SectionIDExport, exports...)...)
+	m = append(m, wasmSection(wasm.SectionIDCode, append([]byte{1, byte(len(body))}, body...)...)...)
+	m = append(m, wasmSection(wasm.SectionIDData, data...)...)
+	return m
+}
+
+func TestHelloWorld(t *testing.T) {
+	env := NewEnv([]string{"hello"}, nil)
+	vm, err := exec.NewVMFromReader(bytes.NewReader(helloModule()), env.Resolve)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	vm.RecoverPanic = true
+	stdout, stderr, _, err := vm.ExecCapturingOutput(2)
+	if err != nil {
+		t.Fatalf("error executing _start: %v", err)
+	}
+	if string(stdout) != "hello world\n" || len(stderr) != 0 {
+		t.Errorf("got stdout %q and stderr %q, want stdout %q", stdout, stderr, "hello world\n")
+	}
+	if n := binary.LittleEndian.Uint32(vm.Memory()[0x20:]); n != 12 {
+		t.Errorf("fd_write stored %d bytes written, want 12", n)
+	}
+	if code, exited := env.ExitCode(); !exited || code != 3 {
+		t.Errorf("got exit code %d, exited %v, want exit code 3", code, exited)
+	}
+}
+
+func TestUnknownImport(t *testing.T) {
+	env := NewEnv(nil, nil)
+	if _, err := env.Resolve("env"); err == nil {
+		t.Error("resolving a module other than WASI succeeded")
+	}
+	m := env.Module()
+	if _, ok := m.Export.Entries["path_open"]; ok {
+		t.Error("unimplemented path_open is exported")
+	}
+}
+
+func TestArgsAndEnviron(t *testing.T) {
+	env := NewEnv([]string{"prog", "-v"}, []string{"HOME=/root"})
+	vm, err := exec.NewVMFromReader(bytes.NewReader(helloModule()), env.Resolve)
+	if err != nil {
+		t.Fatalf("could not create VM: %v", err)
+	}
+	proc := exec.NewProcess(vm)
+	mem := vm.Memory()
+
+	if errno := env.argsSizesGet(proc, 0x100, 0x104); errno != ErrnoSuccess {
+		t.Fatalf("args_sizes_get returned errno %d", errno)
+	}
+	if argc, size := binary.LittleEndian.Uint32(mem[0x100:]), binary.LittleEndian.Uint32(mem[0x104:]); argc != 2 || size != 8 {
+		t.Errorf("args_sizes_get stored %d arguments in %d bytes, want 2 in 8", argc, size)
+	}
+	if errno := env.argsGet(proc, 0x100, 0x200); errno != ErrnoSuccess {
+		t.Fatalf("args_get returned errno %d", errno)
+	}
+	if ptr := binary.LittleEndian.Uint32(mem[0x104:]); ptr != 0x205 {
+		t.Errorf("args_get pointed the second argument at %#x, want 0x205", ptr)
+	}
+	if got := string(mem[0x200:0x208]); got != "prog\x00-v\x00" {
+		t.Errorf("args_get stored %q", got)
+	}
+
+	if errno := env.environSizesGet(proc, 0x100, 0x104); errno != ErrnoSuccess {
+		t.Fatalf("environ_sizes_get returned errno %d", errno)
+	}
+	if count, size := binary.LittleEndian.Uint32(mem[0x100:]), binary.LittleEndian.Uint32(mem[0x104:]); count != 1 || size != 11 {
+		t.Errorf("environ_sizes_get stored %d variables in %d bytes, want 1 in 11", count, size)
+	}
+	if errno := env.environGet(proc, 0x100, 0x300); errno != ErrnoSuccess {
+		t.Fatalf("environ_get returned errno %d", errno)
+	}
+	if got := string(mem[0x300:0x30b]); got != "HOME=/root\x00" {
+		t.Errorf("environ_get stored %q", got)
+	}
+
+	// Running off the end of memory
+	if errno := env.argsGet(proc, int32(len(mem)-2), 0x200); errno != ErrnoFault {
+		t.Errorf("args_get past the end of memory returned errno %d, want %d", errno, ErrnoFault)
+	}
+	if errno := env.fdWrite(proc, 3, 0, 1, 0x20); errno != ErrnoBadf {
+		t.Errorf("fd_write to file descriptor 3 returned errno %d, want %d", errno, ErrnoBadf)
+	}
+}
@@ -169,10 +169,11 @@ func (m *Module) populateLinearMemory() error {
 	if m.Data == nil || len(m.Data.Entries) == 0 {
 		return nil
 	}
-	// each module can only have a single linear memory in the MVP
+	// each module can only have a single linear memory in the MVP, but
+	// more with the multi-memory proposal
 
 	for _, entry := range m.Data.Entries {
-		if entry.Index != 0 {
+		if int(entry.Index) >= len(m.LinearMemoryIndexSpace) {
 			return InvalidLinearMemoryIndexError(entry.Index)
 		}
 
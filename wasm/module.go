@@ -151,7 +151,11 @@ func ReadModule(r io.Reader, resolvePath ResolveFunc) (*Module, error) {
 		return nil, err
 	}
 
-	m.LinearMemoryIndexSpace = make([][]byte, 1)
+	memories := 1
+	if m.Memory != nil && len(m.Memory.Entries) > memories {
+		memories = len(m.Memory.Entries)
+	}
+	m.LinearMemoryIndexSpace = make([][]byte, memories)
 	if m.Table != nil {
 		m.TableIndexSpace = make([][]uint32, int(len(m.Table.Entries)))
 	}
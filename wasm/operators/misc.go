@@ -0,0 +1,70 @@
+// Copyright 2019 The go-interpreter Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package operators
+
+import (
+	"fmt"
+
+	"github.com/go-interpreter/wagon/wasm"
+)
+
+// MiscPrefix is the opcode prefixing the miscellaneous operators, which are
+// identified by a second, varuint32 opcode following the prefix.
+const MiscPrefix byte = 0xfc
+
+var miscOps [256]Op // an array of Op values mapped by the opcodes following MiscPrefix, used by NewMisc().
+
+func newMiscOp(code byte, name string, args []wasm.ValueType, returns wasm.ValueType) byte {
+	if miscOps[code].IsValid() {
+		panic(fmt.Errorf("Opcode %#x %#x is already assigned to %s", MiscPrefix, code, miscOps[code].Name))
+	}
+
+	miscOps[code] = Op{
+		Code:    MiscPrefix,
+		Name:    name,
+		Args:    args,
+		Returns: returns,
+	}
+	return code
+}
+
+// The saturating truncation operators. Unlike the trunc operators, these
+// don't trap: NaN is converted to 0, and values out of range to the
+// smallest or largest integer of the result type.
+var (
+	I32TruncSatF32S = newMiscOp(0x00, "i32.trunc_sat_f32_s", []wasm.ValueType{wasm.ValueTypeF32}, wasm.ValueTypeI32)
+	I32TruncSatF32U = newMiscOp(0x01, "i32.trunc_sat_f32_u", []wasm.ValueType{wasm.ValueTypeF32}, wasm.ValueTypeI32)
+	I32TruncSatF64S = newMiscOp(0x02, "i32.trunc_sat_f64_s", []wasm.ValueType{wasm.ValueTypeF64}, wasm.ValueTypeI32)
+	I32TruncSatF64U = newMiscOp(0x03, "i32.trunc_sat_f64_u", []wasm.ValueType{wasm.ValueTypeF64}, wasm.ValueTypeI32)
+	I64TruncSatF32S = newMiscOp(0x04, "i64.trunc_sat_f32_s", []wasm.ValueType{wasm.ValueTypeF32}, wasm.ValueTypeI64)
+	I64TruncSatF32U = newMiscOp(0x05, "i64.trunc_sat_f32_u", []wasm.ValueType{wasm.ValueTypeF32}, wasm.ValueTypeI64)
+	I64TruncSatF64S = newMiscOp(0x06, "i64.trunc_sat_f64_s", []wasm.ValueType{wasm.ValueTypeF64}, wasm.ValueTypeI64)
+	I64TruncSatF64U = newMiscOp(0x07, "i64.trunc_sat_f64_u", []wasm.ValueType{wasm.ValueTypeF64}, wasm.ValueTypeI64)
+)
+
+// InvalidMiscOpcodeError is returned by NewMisc for an opcode following
+// MiscPrefix that doesn't name an operator.
+type InvalidMiscOpcodeError uint32
+
+func (e InvalidMiscOpcodeError) Error() string {
+	return fmt.Sprintf("Invalid opcode: %#x %#x", MiscPrefix, uint32(e))
+}
+
+// NewMisc returns the Op object for the operator given by MiscPrefix
+// followed by code. The returned Op has MiscPrefix as its Code.
+// If code is invalid, an InvalidMiscOpcodeError is returned.
+func NewMisc(code uint32) (Op, error) {
+	if code >= uint32(len(miscOps)) || !miscOps[code].IsValid() {
+		return Op{}, InvalidMiscOpcodeError(code)
+	}
+	return miscOps[code], nil
+}
+
+// The bulk memory operators. Each is followed by a reserved byte for every
+// memory it accesses, which must be zero for now.
+var (
+	MemoryCopy = newMiscOp(0x0a, "memory.copy", []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32, wasm.ValueTypeI32}, noReturn)
+	MemoryFill = newMiscOp(0x0b, "memory.fill", []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32, wasm.ValueTypeI32}, noReturn)
+)
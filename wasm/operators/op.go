@@ -19,7 +19,7 @@ var (
 
 // Op describes a WASM operator.
 type Op struct {
-	Code byte   // The single-byte opcode
+	Code byte   // The single-byte opcode, or MiscPrefix for the operators following it
 	Name string // The name of the operator
 
 	// Whether this operator is polymorphic.
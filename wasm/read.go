@@ -5,13 +5,29 @@
 package wasm
 
 import (
+	"bytes"
 	"encoding/binary"
 	"io"
 
 	"github.com/go-interpreter/wagon/wasm/leb128"
 )
 
+// maxPrealloc is the largest buffer allocated up front for reading data
+// whose length is given by the module. Longer data is read into a buffer
+// growing with it, so a corrupted or malicious length doesn't allocate much
+// more memory than the module really holds.
+const maxPrealloc = 1 << 20
+
 func readBytes(r io.Reader, n int) ([]byte, error) {
+	if n > maxPrealloc {
+		buf := bytes.NewBuffer(make([]byte, 0, maxPrealloc))
+		_, err := io.CopyN(buf, r, int64(n))
+		if err == io.EOF && buf.Len() != 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return buf.Bytes(), err
+	}
+
 	bytes := make([]byte, n)
 	_, err := io.ReadFull(r, bytes)
 	if err != nil {
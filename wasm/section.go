@@ -132,7 +132,11 @@ func (m *Module) readSection(r *readpos.ReadPos) (bool, error) {
 	s.Start = r.CurPos
 
 	sectionBytes := new(bytes.Buffer)
-	sectionBytes.Grow(int(payloadDataLen))
+	if payloadDataLen <= maxPrealloc {
+		sectionBytes.Grow(int(payloadDataLen))
+	} else {
+		sectionBytes.Grow(maxPrealloc)
+	}
 	sectionReader := io.LimitReader(io.TeeReader(r, sectionBytes), int64(payloadDataLen))
 
 	var sec Section
@@ -628,7 +632,7 @@ func (s *SectionExports) WritePayload(w io.Writer) error {
 type DuplicateExportError string
 
 func (e DuplicateExportError) Error() string {
-	return fmt.Sprintf("Duplicate export entry: %s", e)
+	return fmt.Sprintf("Duplicate export entry: %s", string(e))
 }
 
 // ExportEntry represents an exported entry by the module
@@ -832,9 +836,8 @@ func (f *FunctionBody) UnmarshalWASM(r io.Reader) error {
 		return err
 	}
 
-	body := make([]byte, bodySize)
-
-	if _, err = io.ReadFull(r, body); err != nil {
+	body, err := readBytes(r, int(bodySize))
+	if err != nil {
 		return err
 	}
 